@@ -0,0 +1,278 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// KHPauseWrites is the environment variable that pauses all khstate and khjob writes on startup when set to true
+const KHPauseWrites = "KH_PAUSE_WRITES"
+
+// ErrWritesPaused is returned by state writes while writes are paused
+var ErrWritesPaused = errors.New("kuberhealthy state writes are paused")
+
+// writesPaused is set to 1 when all state writes are paused.  Reads continue as normal.
+var writesPaused int32
+
+func init() {
+	paused, err := strconv.ParseBool(os.Getenv(KHPauseWrites))
+	if err == nil && paused {
+		pauseWrites()
+	}
+}
+
+// pauseWrites pauses all khstate and khjob writes
+func pauseWrites() {
+	atomic.StoreInt32(&writesPaused, 1)
+	log.Warningln("admin: ALL STATE WRITES ARE PAUSED. Check results will not be recorded until writes are resumed.")
+}
+
+// resumeWrites resumes khstate and khjob writes after they were paused
+func resumeWrites() {
+	atomic.StoreInt32(&writesPaused, 0)
+	log.Warningln("admin: state writes have been resumed")
+}
+
+// writesArePaused indicates if state writes are currently paused
+func writesArePaused() bool {
+	return atomic.LoadInt32(&writesPaused) == 1
+}
+
+// ensureStateResponse is the body returned by the ensure state admin endpoint
+type ensureStateResponse struct {
+	Name      string
+	Namespace string
+	Created   bool // false when the khstate already existed
+}
+
+// rebuildResponse is the body returned by the rebuild from pods admin endpoint
+type rebuildResponse struct {
+	Rebuilt int // the khstates that were written from checker pod results
+}
+
+// readAdminToken reads the token that admin API requests must carry from the admin API token file.  The file is
+// read on every request so that a rotated secret takes effect without a restart.
+func readAdminToken() (string, error) {
+	if len(cfg.AdminAPITokenFile) == 0 {
+		return "", errors.New("no adminAPITokenFile is configured")
+	}
+	b, err := ioutil.ReadFile(cfg.AdminAPITokenFile)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(b))
+	if len(token) == 0 {
+		return "", errors.New("admin API token file " + cfg.AdminAPITokenFile + " is empty")
+	}
+	return token, nil
+}
+
+// requireAdminToken only passes requests to the handler that carry the admin API token as a bearer token in their
+// Authorization header.  All other requests are refused, including every request while the token can not be read.
+func requireAdminToken(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, err := readAdminToken()
+		if err != nil {
+			log.Errorln("admin: refusing request to", r.URL.Path, "from", r.RemoteAddr, "because the admin API token can not be read:", err)
+			http.Error(w, "the admin API token is not available", http.StatusServiceUnavailable)
+			return
+		}
+		authorization := r.Header.Get("Authorization")
+		if !strings.HasPrefix(authorization, "Bearer ") || subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(authorization, "Bearer ")), []byte(token)) != 1 {
+			log.Warningln("admin: refusing request to", r.URL.Path, "from", r.RemoteAddr, "without a valid admin API token")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="kuberhealthy-admin"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// handleAdmin registers an admin API endpoint on the web server that requires the admin API token
+func handleAdmin(pattern string, handler http.HandlerFunc) {
+	http.HandleFunc(pattern, requireAdminToken(handler))
+}
+
+// registerAdminHandlers adds the admin API endpoints to the web server when the admin API is enabled.  The admin
+// API stays disabled when no token file is configured, since its endpoints can change or delete any khstate.
+func (k *Kuberhealthy) registerAdminHandlers() {
+	if !cfg.EnableAdminAPI {
+		log.Infoln("Admin API is disabled")
+		return
+	}
+	if len(cfg.AdminAPITokenFile) == 0 {
+		log.Errorln("Admin API is enabled but no adminAPITokenFile is configured. The admin API is disabled")
+		return
+	}
+	log.Infoln("Admin API is enabled")
+
+	handleAdmin("/admin/pauseWrites", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		log.Infoln("admin: pause writes requested by", r.RemoteAddr)
+		pauseWrites()
+		w.WriteHeader(http.StatusOK)
+	})
+
+	handleAdmin("/admin/resumeWrites", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		log.Infoln("admin: resume writes requested by", r.RemoteAddr)
+		resumeWrites()
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// GET /admin/snapshot?namespace=example returns a snapshot of every khstate in the namespace, or all
+	// namespaces when none is specified
+	handleAdmin("/admin/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		log.Infoln("admin: khstate snapshot requested by", r.RemoteAddr)
+		snapshot, err := snapshotAllStates(r.URL.Query().Get("namespace"))
+		if err != nil {
+			log.Errorln("admin: error creating khstate snapshot:", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		b, err := json.Marshal(snapshot)
+		if err != nil {
+			log.Errorln("admin: error marshaling khstate snapshot:", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(b)
+		if err != nil {
+			log.Warningln("admin: error writing khstate snapshot to caller:", err)
+		}
+	})
+
+	// POST /admin/restore?mode=skip|overwrite restores the snapshot in the request body
+	handleAdmin("/admin/restore", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		mode := restoreMode(r.URL.Query().Get("mode"))
+		if mode == "" {
+			mode = restoreSkipExisting
+		}
+		log.Infoln("admin: khstate restore with mode", mode, "requested by", r.RemoteAddr)
+
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		snapshot := stateSnapshot{}
+		err = json.Unmarshal(b, &snapshot)
+		if err != nil {
+			http.Error(w, "failed to unmarshal snapshot: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = restoreStates(snapshot, mode)
+		if err != nil {
+			log.Errorln("admin: error restoring khstate snapshot:", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// POST /admin/ensureState?name=example&namespace=example creates the khstate of a check if it is missing
+	handleAdmin("/admin/ensureState", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		name := r.URL.Query().Get("name")
+		namespace := r.URL.Query().Get("namespace")
+		log.Infoln("admin: ensure khstate for check", name, "in namespace", namespace, "requested by", r.RemoteAddr)
+
+		c, err := k.getCheck(name, namespace)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		created, err := ensureStateResource(c.Name(), c.CheckNamespace(), health.KHCheck)
+		if err != nil {
+			log.Errorln("admin: error ensuring khstate for check", name, "in namespace", namespace+":", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		b, err := json.Marshal(ensureStateResponse{Name: name, Namespace: namespace, Created: created})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(b)
+		if err != nil {
+			log.Warningln("admin: error writing ensure state response to caller:", err)
+		}
+	})
+
+	// POST /admin/rebuildFromPods?namespace=example recreates khstates from the result annotations of checker pods
+	// in the namespace, or all namespaces when none is specified
+	handleAdmin("/admin/rebuildFromPods", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		namespace := r.URL.Query().Get("namespace")
+		log.Infoln("admin: khstate rebuild from checker pods in namespace", namespace, "requested by", r.RemoteAddr)
+
+		rebuilt, err := rebuildStateFromPods(namespace)
+		if err != nil {
+			log.Errorln("admin: error rebuilding khstates from checker pods:", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		b, err := json.Marshal(rebuildResponse{Rebuilt: rebuilt})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(b)
+		if err != nil {
+			log.Warningln("admin: error writing rebuild response to caller:", err)
+		}
+	})
+
+	// POST /admin/validateCheck?namespace=example validates the khcheck manifest in the request body without
+	// applying it
+	handleAdmin("/admin/validateCheck", validateCheckHandler)
+
+	// POST /admin/reconcile?dryRun=true syncs khstates with the configured checks and returns a summary
+	handleAdmin("/admin/reconcile", k.reconcileHandler)
+}
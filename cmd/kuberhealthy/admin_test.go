@@ -0,0 +1,75 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestRequireAdminToken ensures that admin API requests are only served with the bearer token from the admin API
+// token file and that every request is refused while the token can not be read
+func TestRequireAdminToken(t *testing.T) {
+	original := cfg.AdminAPITokenFile
+	defer func() { cfg.AdminAPITokenFile = original }()
+
+	dir, err := ioutil.TempDir("", "admin-token")
+	if err != nil {
+		t.Fatal("failed to create the token directory:", err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	err = ioutil.WriteFile(tokenFile, []byte("s3cret\n"), 0600)
+	if err != nil {
+		t.Fatal("failed to write the token file:", err)
+	}
+
+	var served int
+	handler := requireAdminToken(func(w http.ResponseWriter, r *http.Request) {
+		served++
+	})
+	request := func(authorization string) int {
+		r := httptest.NewRequest(http.MethodPost, "/admin/pauseWrites", nil)
+		if len(authorization) > 0 {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	tests := []struct {
+		tokenFile     string
+		authorization string
+		code          int
+	}{
+		{tokenFile: tokenFile, authorization: "Bearer s3cret", code: http.StatusOK},
+		{tokenFile: tokenFile, authorization: "", code: http.StatusUnauthorized},
+		{tokenFile: tokenFile, authorization: "Bearer wrong", code: http.StatusUnauthorized},
+		{tokenFile: tokenFile, authorization: "s3cret", code: http.StatusUnauthorized},
+		{tokenFile: "", authorization: "Bearer s3cret", code: http.StatusServiceUnavailable},
+		{tokenFile: filepath.Join(dir, "missing"), authorization: "Bearer ", code: http.StatusServiceUnavailable},
+	}
+	for _, test := range tests {
+		cfg.AdminAPITokenFile = test.tokenFile
+		if code := request(test.authorization); code != test.code {
+			t.Fatalf("expected %d with token file %q and authorization %q, got %d", test.code, test.tokenFile, test.authorization, code)
+		}
+	}
+	if served != 1 {
+		t.Fatalf("expected only the request with the token to be served, served %d", served)
+	}
+}
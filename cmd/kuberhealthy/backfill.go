@@ -0,0 +1,183 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// backfillQuery is the PromQL query for the historical results of every check.  The check metric carries the
+// status and error as labels, so its series are collapsed into one per check.  A value of 1 means the check was OK.
+const backfillQuery = "min by (check, namespace) (kuberhealthy_check)"
+
+// defaultBackfillStep is the resolution of the backfilled run history when none is configured
+const defaultBackfillStep = time.Minute * 5
+
+// backfillTimeout is how long the Prometheus query may take before the backfill is abandoned
+const backfillTimeout = time.Second * 30
+
+// backfillStep returns the configured resolution of the backfilled run history
+func backfillStep() time.Duration {
+	if cfg.PrometheusBackfillStep <= 0 {
+		return defaultBackfillStep
+	}
+	return cfg.PrometheusBackfillStep
+}
+
+// prometheusRangeResponse is the response of the Prometheus range query API
+type prometheusRangeResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Metric map[string]string `json:"metric"`
+			Values [][2]interface{}  `json:"values"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// queryBackfillRuns queries Prometheus for the results of every check between start and end.  The samples of each
+// check are returned as runs keyed by namespace/name, oldest first.
+func queryBackfillRuns(ctx context.Context, client *http.Client, prometheusURL string, start time.Time, end time.Time, step time.Duration) (map[string][]runRecord, error) {
+	params := url.Values{}
+	params.Set("query", backfillQuery)
+	params.Set("start", strconv.FormatInt(start.Unix(), 10))
+	params.Set("end", strconv.FormatInt(end.Unix(), 10))
+	params.Set("step", strconv.FormatFloat(step.Seconds(), 'f', -1, 64))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(prometheusURL, "/")+"/api/v1/query_range?"+params.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating prometheus query: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error querying prometheus: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body prometheusRangeResponse
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding prometheus response with status %d: %w", resp.StatusCode, err)
+	}
+	if body.Status != "success" {
+		return nil, fmt.Errorf("prometheus query failed with status %d: %s", resp.StatusCode, body.Error)
+	}
+	if body.Data.ResultType != "matrix" {
+		return nil, fmt.Errorf("expected a matrix from prometheus, got %s", body.Data.ResultType)
+	}
+
+	runs := make(map[string][]runRecord)
+	for _, series := range body.Data.Result {
+		// the exporter labels checks with their namespace/name key
+		namespace, name := splitCheckKey(series.Metric["check"])
+		if len(namespace) == 0 {
+			namespace = series.Metric["namespace"]
+		}
+		if len(name) == 0 || len(namespace) == 0 {
+			continue
+		}
+		key := stateKey(name, namespace)
+		for _, sample := range series.Values {
+			at, ok := sample[0].(float64)
+			if !ok {
+				continue
+			}
+			value, ok := sample[1].(string)
+			if !ok {
+				continue
+			}
+			runs[key] = append(runs[key], runRecord{Time: time.Unix(0, int64(at*float64(time.Second))), OK: value == "1"})
+		}
+	}
+	for key := range runs {
+		sort.Slice(runs[key], func(i, j int) bool {
+			return runs[key][i].Time.Before(runs[key][j].Time)
+		})
+	}
+	return runs, nil
+}
+
+// seedRunHistory adds backfilled runs to the history of a check.  Only runs from before the oldest run already
+// recorded are added, so results written since startup are never replaced or counted twice.  The history is
+// complete from the oldest backfilled run.
+func seedRunHistory(name string, namespace string, runs []runRecord) {
+	key := stateKey(name, namespace)
+
+	runHistory.Lock()
+	history, exists := runHistory.checks[key]
+	if !exists {
+		history = &checkRunHistory{}
+		runHistory.checks[key] = history
+	}
+	if len(history.runs) > 0 {
+		var keep int
+		for keep < len(runs) && runs[keep].Time.Before(history.runs[0].Time) {
+			keep++
+		}
+		runs = runs[:keep]
+	}
+	if len(runs) == 0 {
+		if !exists {
+			delete(runHistory.checks, key)
+		}
+		runHistory.Unlock()
+		return
+	}
+	seeded := make([]runRecord, 0, len(runs)+len(history.runs))
+	seeded = append(seeded, runs...)
+	history.runs = append(seeded, history.runs...)
+	history.since = history.runs[0].Time
+	if len(history.runs) > maxRunHistory {
+		history.runs = history.runs[len(history.runs)-maxRunHistory:]
+		history.since = history.runs[0].Time
+	}
+	runHistory.Unlock()
+
+	percent, sufficient := computeReliability(name, namespace, reliabilityWindow())
+	if !sufficient {
+		return
+	}
+	checkReliability.Set(percent, name, namespace)
+}
+
+// backfillRunHistory seeds the run history of every check with its results from Prometheus over the reliability
+// window, so reliability, trends and rollups are meaningful right after a restart.  Failures are logged and leave
+// the run history to build up from new runs as usual.
+func backfillRunHistory(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, backfillTimeout)
+	defer cancel()
+
+	end := time.Now()
+	start := end.Add(-reliabilityWindow())
+	runs, err := queryBackfillRuns(ctx, &http.Client{}, cfg.PrometheusURL, start, end, backfillStep())
+	if err != nil {
+		log.Warningln("backfill: failed to backfill run history from prometheus:", err)
+		return
+	}
+	for key, checkRuns := range runs {
+		namespace, name := splitCheckKey(key)
+		seedRunHistory(name, namespace, checkRuns)
+	}
+	log.Infoln("backfill: seeded the run history of", len(runs), "checks from prometheus")
+}
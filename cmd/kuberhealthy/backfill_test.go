@@ -0,0 +1,94 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestBackfillRunHistory ensures that check results queried from prometheus seed the run history without
+// replacing runs recorded since startup
+func TestBackfillRunHistory(t *testing.T) {
+	originalWindow := cfg.ReliabilityWindow
+	defer func() {
+		cfg.ReliabilityWindow = originalWindow
+	}()
+	cfg.ReliabilityWindow = time.Hour
+
+	end := time.Now().Truncate(time.Minute)
+	start := end.Add(-time.Hour)
+
+	// the last run is recorded by this instance before the backfill finishes
+	recordRun("backfill-check", "kuberhealthy", true, end.Add(-time.Minute*5))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query_range" || r.URL.Query().Get("query") != backfillQuery || r.URL.Query().Get("step") != "600" {
+			t.Errorf("unexpected prometheus query %s", r.URL.String())
+		}
+		// one sample every 10 minutes, failing at the top of the hour
+		values := ""
+		for i := 0; i <= 6; i++ {
+			value := "1"
+			if i == 0 {
+				value = "0"
+			}
+			if i > 0 {
+				values += ","
+			}
+			values += fmt.Sprintf(`[%d,"%s"]`, start.Add(time.Minute*10*time.Duration(i)).Unix(), value)
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"check":"kuberhealthy/backfill-check","namespace":"kuberhealthy"},"values":[%s]}]}}`, values)
+	}))
+	defer server.Close()
+
+	runs, err := queryBackfillRuns(context.Background(), server.Client(), server.URL+"/", start, end, time.Minute*10)
+	if err != nil {
+		t.Fatal("unexpected error querying prometheus:", err)
+	}
+	checkRuns := runs[stateKey("backfill-check", "kuberhealthy")]
+	if len(checkRuns) != 7 || checkRuns[0].OK || !checkRuns[6].OK {
+		t.Fatalf("expected seven runs with the first one failing, got %+v", runs)
+	}
+	seedRunHistory("backfill-check", "kuberhealthy", checkRuns)
+
+	// the sample at 60 minutes is newer than the recorded run and is left out
+	runHistory.Lock()
+	history := runHistory.checks[stateKey("backfill-check", "kuberhealthy")]
+	count, since := len(history.runs), history.since
+	runHistory.Unlock()
+	if count != 7 || !since.Equal(start) {
+		t.Fatalf("expected six backfilled runs and the recorded run with history since %s, got %d since %s", start, count, since)
+	}
+	percent, sufficient := computeReliabilityAt("backfill-check", "kuberhealthy", time.Hour, end)
+	if !sufficient || percent < 85 || percent > 86 {
+		t.Fatalf("expected the backfilled history to cover the window with 6 of 7 runs OK, got %f %t", percent, sufficient)
+	}
+}
+
+// TestBackfillRunHistoryFailure ensures that prometheus errors are returned instead of seeding the run history
+func TestBackfillRunHistoryFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"status":"error","errorType":"bad_data","error":"parse error"}`)
+	}))
+	defer server.Close()
+
+	_, err := queryBackfillRuns(context.Background(), server.Client(), server.URL, time.Now().Add(-time.Hour), time.Now(), time.Minute)
+	if err == nil {
+		t.Fatal("expected an error when prometheus rejects the query")
+	}
+}
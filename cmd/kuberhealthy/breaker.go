@@ -0,0 +1,148 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// defaultStateBreakerThreshold is how many khstate writes in a row must fail before the circuit breaker opens when
+// stateBreakerThreshold is not configured
+const defaultStateBreakerThreshold = 5
+
+// defaultStateBreakerCooldown is how long the circuit breaker stays open before a write is let through to probe
+// the API server when stateBreakerCooldown is not configured
+const defaultStateBreakerCooldown = time.Second * 30
+
+// ErrCircuitOpen is returned by khstate writes while the API server is failing persistently and writes are being
+// short-circuited
+var ErrCircuitOpen = errors.New("khstate writes are short-circuited because the API server keeps failing")
+
+// The states of the circuit breaker
+const (
+	breakerClosed   = "closed"    // writes go to the API server
+	breakerOpen     = "open"      // writes are short-circuited until the cooldown has passed
+	breakerHalfOpen = "half-open" // a single write is probing the API server
+)
+
+// circuitBreaker short-circuits khstate writes after the API server failed too many writes in a row, so that checks
+// do not pile up goroutines retrying against an API server that is down.  Once the cooldown has passed, a single
+// write is let through to probe the API server.  The breaker closes when the probe succeeds and opens again when
+// it fails.
+type circuitBreaker struct {
+	sync.Mutex
+	state    string
+	failures int       // consecutive failures while closed
+	since    time.Time // when the breaker opened or the probe started
+}
+
+// stateBreaker is the circuit breaker around khstate writes
+var stateBreaker = &circuitBreaker{state: breakerClosed}
+
+// stateBreakerThreshold returns the configured number of consecutive failures that open the breaker.  Zero or
+// less disables the breaker.
+func stateBreakerThreshold() int {
+	if cfg.StateBreakerThreshold == 0 {
+		return defaultStateBreakerThreshold
+	}
+	return cfg.StateBreakerThreshold
+}
+
+// stateBreakerCooldown returns the configured time the breaker stays open before probing
+func stateBreakerCooldown() time.Duration {
+	if cfg.StateBreakerCooldown <= 0 {
+		return defaultStateBreakerCooldown
+	}
+	return cfg.StateBreakerCooldown
+}
+
+// isAPIServerFailure determines if a failed write says that the API server is unhealthy.  Errors about the khstate
+// itself, such as conflicts or validation failures, mean the API server is working.
+func isAPIServerFailure(err error) bool {
+	if err == nil || k8sErrors.IsConflict(err) {
+		return false
+	}
+	var status k8sErrors.APIStatus
+	if !errors.As(err, &status) {
+		// errors without a status never got an answer from the API server, such as refused connections
+		return true
+	}
+	return isTransientStateError(err)
+}
+
+// allow returns ErrCircuitOpen if a write must be short-circuited.  Every allowed write must be followed by a call
+// to record with its result.
+func (b *circuitBreaker) allow(now time.Time) error {
+	if stateBreakerThreshold() <= 0 {
+		return nil
+	}
+	b.Lock()
+	defer b.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if now.Sub(b.since) < stateBreakerCooldown() {
+			return ErrCircuitOpen
+		}
+		log.Infoln("circuit breaker: probing the API server with a khstate write")
+		b.state = breakerHalfOpen
+		b.since = now
+	case breakerHalfOpen:
+		// a probe that never reported back must not keep the breaker half-open forever
+		if now.Sub(b.since) < stateBreakerCooldown() {
+			return ErrCircuitOpen
+		}
+		b.since = now
+	}
+	return nil
+}
+
+// record updates the breaker with the result of an allowed write
+func (b *circuitBreaker) record(err error, now time.Time) {
+	if stateBreakerThreshold() <= 0 {
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+
+	if !isAPIServerFailure(err) {
+		if b.state != breakerClosed {
+			log.Infoln("circuit breaker: the API server is accepting khstate writes again. Closing the circuit breaker.")
+		}
+		b.state = breakerClosed
+		b.failures = 0
+		stateBreakerOpen.Set(0)
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= stateBreakerThreshold() {
+		if b.state != breakerOpen {
+			log.Errorln("circuit breaker: short-circuiting khstate writes for", stateBreakerCooldown(), "after", b.failures, "failed writes in a row:", err)
+		}
+		b.state = breakerOpen
+		b.since = now
+		stateBreakerOpen.Set(1)
+	}
+}
+
+// isOpen determines if writes are currently being short-circuited
+func (b *circuitBreaker) isOpen() bool {
+	b.Lock()
+	defer b.Unlock()
+	return b.state != breakerClosed
+}
@@ -0,0 +1,86 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// TestCircuitBreaker ensures that the breaker opens after consecutive API server failures, short-circuits writes
+// during the cooldown and closes again when a probe succeeds
+func TestCircuitBreaker(t *testing.T) {
+	originalThreshold, originalCooldown := cfg.StateBreakerThreshold, cfg.StateBreakerCooldown
+	defer func() {
+		cfg.StateBreakerThreshold, cfg.StateBreakerCooldown = originalThreshold, originalCooldown
+	}()
+	cfg.StateBreakerThreshold = 3
+	cfg.StateBreakerCooldown = time.Minute
+
+	b := &circuitBreaker{state: breakerClosed}
+	now := time.Now()
+	unavailable := k8sErrors.NewServiceUnavailable("etcd is down")
+	conflict := k8sErrors.NewConflict(schema.GroupResource{Resource: stateCRDResource}, "check", errors.New("changed"))
+
+	// conflicts mean the API server is working and reset the count
+	for _, err := range []error{unavailable, unavailable, conflict, unavailable, unavailable} {
+		if b.allow(now) != nil {
+			t.Fatal("expected writes to be allowed before the threshold is reached")
+		}
+		b.record(err, now)
+	}
+	if b.isOpen() {
+		t.Fatal("expected the breaker to stay closed when failures were not consecutive")
+	}
+	b.allow(now)
+	b.record(errors.New("connection refused"), now)
+	if !b.isOpen() || !errors.Is(b.allow(now.Add(time.Second)), ErrCircuitOpen) {
+		t.Fatal("expected writes to be short-circuited after 3 failures in a row")
+	}
+
+	// a single probe is let through after the cooldown, and a failed probe opens the breaker again
+	probeTime := now.Add(time.Minute)
+	if b.allow(probeTime) != nil {
+		t.Fatal("expected a probe to be allowed after the cooldown")
+	}
+	if !errors.Is(b.allow(probeTime), ErrCircuitOpen) {
+		t.Fatal("expected only one probe to be allowed at a time")
+	}
+	b.record(unavailable, probeTime)
+	if !errors.Is(b.allow(probeTime.Add(time.Second)), ErrCircuitOpen) {
+		t.Fatal("expected a failed probe to open the breaker for another cooldown")
+	}
+
+	// a successful probe closes the breaker
+	probeTime = probeTime.Add(time.Minute)
+	if b.allow(probeTime) != nil {
+		t.Fatal("expected a probe to be allowed after the second cooldown")
+	}
+	b.record(nil, probeTime)
+	if b.isOpen() || b.allow(probeTime) != nil {
+		t.Fatal("expected a successful probe to close the breaker")
+	}
+
+	// a negative threshold disables the breaker
+	cfg.StateBreakerThreshold = -1
+	disabled := &circuitBreaker{state: breakerClosed}
+	for i := 0; i < 10; i++ {
+		disabled.record(unavailable, now)
+	}
+	if disabled.allow(now) != nil {
+		t.Fatal("expected a disabled breaker to never short-circuit writes")
+	}
+}
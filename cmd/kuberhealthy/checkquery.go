@@ -0,0 +1,146 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// checkNameQuery matches the names of checks against a glob, such as dns-*, or a regular expression.  A query without
+// either matches every check.
+type checkNameQuery struct {
+	glob  string
+	regex *regexp.Regexp
+}
+
+// newCheckNameQuery creates a checkNameQuery from a glob or a regular expression.  Only one of them can be given.
+func newCheckNameQuery(glob string, expression string) (checkNameQuery, error) {
+	query := checkNameQuery{glob: glob}
+	if len(glob) > 0 && len(expression) > 0 {
+		return query, errors.New("a check name glob and regular expression can not both be given")
+	}
+	if len(glob) > 0 {
+		_, err := path.Match(glob, "")
+		if err != nil {
+			return query, fmt.Errorf("invalid check name glob %q: %w", glob, err)
+		}
+	}
+	if len(expression) > 0 {
+		regex, err := regexp.Compile(expression)
+		if err != nil {
+			return query, fmt.Errorf("invalid check name regular expression %q: %w", expression, err)
+		}
+		query.regex = regex
+	}
+	return query, nil
+}
+
+// exactName returns the name the query matches when it is a glob without any wildcards, which can be selected by the
+// API server instead of matched after listing
+func (q checkNameQuery) exactName() (string, bool) {
+	if len(q.glob) == 0 || strings.ContainsAny(q.glob, `*?[\`) {
+		return "", false
+	}
+	return q.glob, true
+}
+
+// matches determines if a check name matches the query
+func (q checkNameQuery) matches(name string) bool {
+	if q.regex != nil {
+		return q.regex.MatchString(name)
+	}
+	if len(q.glob) == 0 {
+		return true
+	}
+	matched, _ := path.Match(q.glob, name)
+	return matched
+}
+
+// queryCheckStates returns the details of the khstates in the supplied namespace, or all namespaces when blank, whose
+// name matches the query and whose labels match the label selector, keyed by namespace/name.  The label selector and
+// exact names are applied by the API server, and globs and regular expressions are matched against the names of
+// the listed khstates.  No matching khstates is an empty result, not an error.  The matches among a partial khstate
+// list are returned with an error that is ErrPartialList.
+func queryCheckStates(namespace string, query checkNameQuery, selector labels.Selector, projection stateProjection) (map[string]health.WorkloadDetails, error) {
+	listOptions := metav1.ListOptions{}
+	if !selector.Empty() {
+		listOptions.LabelSelector = selector.String()
+	}
+	if name, exact := query.exactName(); exact {
+		listOptions.FieldSelector = fields.OneTermEqualSelector("metadata.name", sanitizeResourceName(name)).String()
+	}
+
+	states, err := listCheckStates(namespace, listOptions, projection)
+	if err != nil && !errors.Is(err, ErrPartialList) {
+		return nil, fmt.Errorf("error listing khstates to query: %w", err)
+	}
+	for key := range states {
+		_, name := splitCheckKey(key)
+		if !query.matches(name) {
+			delete(states, key)
+		}
+	}
+	if err != nil {
+		return states, fmt.Errorf("error listing khstates to query: %w", err)
+	}
+	return states, nil
+}
+
+// queryChecksHandler writes the details of the checks whose names match a glob or regular expression as JSON keyed
+// by namespace/name.  The name query parameter is a glob such as dns-* and the regex query parameter a regular
+// expression.  The namespace query parameter limits the query to a single namespace, the labelSelector query
+// parameter to khstates with matching labels and projection=metadata trims each result to its status and
+// timestamps.
+func queryChecksHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return nil
+	}
+	values := r.URL.Query()
+	query, err := newCheckNameQuery(values.Get("name"), values.Get("regex"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return nil
+	}
+	selector, err := labels.Parse(values.Get("labelSelector"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "invalid label selector:", err)
+		return nil
+	}
+	projection := projectionFull
+	if values.Get("projection") == "metadata" {
+		projection = projectionMetadata
+	}
+
+	states, err := queryCheckStates(values.Get("namespace"), query, selector, projection)
+	if err != nil && !errors.Is(err, ErrPartialList) {
+		w.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+	warnListIncomplete(w, err)
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(states)
+}
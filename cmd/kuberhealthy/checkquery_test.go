@@ -0,0 +1,84 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// TestQueryChecks ensures that khstates are matched by name glob and regular expression, that label selectors and
+// exact names are sent to the API server and that a query without matches is an empty result
+func TestQueryChecks(t *testing.T) {
+	var labelSelector, fieldSelector string
+	useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		labelSelector, fieldSelector = r.URL.Query().Get("labelSelector"), r.URL.Query().Get("fieldSelector")
+		list := khstatecrd.KuberhealthyStateList{}
+		list.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+		list.Kind = "KuberhealthyStateList"
+		for _, name := range []string{"dns-internal", "dns-external", "deployment"} {
+			s := khstatecrd.NewKuberhealthyState(name, health.WorkloadDetails{OK: true, Status: health.StatusOK, Errors: []string{"detail"}})
+			s.Namespace = "kuberhealthy"
+			list.Items = append(list.Items, s)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	})
+
+	query := func(rawQuery string) (int, map[string]health.WorkloadDetails) {
+		recorder := httptest.NewRecorder()
+		err := queryChecksHandler(recorder, httptest.NewRequest(http.MethodGet, "/checks?"+rawQuery, nil))
+		if err != nil {
+			t.Fatal("unexpected error querying checks:", err)
+		}
+		states := make(map[string]health.WorkloadDetails)
+		if recorder.Code == http.StatusOK {
+			json.NewDecoder(recorder.Body).Decode(&states)
+		}
+		return recorder.Code, states
+	}
+
+	_, states := query("name=dns-*&labelSelector=team%3Dnetwork")
+	if len(states) != 2 || states["kuberhealthy/dns-internal"].Status != health.StatusOK {
+		t.Fatalf("expected the two dns checks, got %+v", states)
+	}
+	if labelSelector != "team=network" || len(fieldSelector) > 0 {
+		t.Fatalf("expected only the label selector to be sent to the API server, got %q and %q", labelSelector, fieldSelector)
+	}
+
+	_, states = query("regex=^d.*l$&projection=metadata")
+	if len(states) != 2 || len(states["kuberhealthy/dns-external"].Errors) != 0 {
+		t.Fatalf("expected the trimmed checks ending in l, got %+v", states)
+	}
+
+	query("name=deployment")
+	if fieldSelector != "metadata.name=deployment" {
+		t.Fatal("expected an exact name to be selected by the API server, got", fieldSelector)
+	}
+
+	code, states := query("name=ingress-*")
+	if code != http.StatusOK || states == nil || len(states) != 0 {
+		t.Fatalf("expected an empty result when nothing matches, got %d and %+v", code, states)
+	}
+
+	if code, _ := query("name=dns-*&regex=dns"); code != http.StatusBadRequest {
+		t.Fatal("expected a glob and a regular expression together to be refused, got", code)
+	}
+	if code, _ := query("name=dns-[a"); code != http.StatusBadRequest {
+		t.Fatal("expected an invalid glob to be refused, got", code)
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// checkUIDAnnotation gives a khcheck a UID that is kept when the khcheck is renamed.  Renaming a khcheck creates a
+// new resource with a new kubernetes UID, so only an annotated UID can tie the old and new names together.
+const checkUIDAnnotation = "comcast.github.io/check-uid"
+
+// ErrCheckUIDNotFound is returned when no khstate carries the requested check UID
+var ErrCheckUIDNotFound = errors.New("no check state has the requested check uid")
+
+// checkUIDs holds the UID of every loaded khcheck keyed by namespace/name
+var checkUIDs = struct {
+	sync.RWMutex
+	uids map[string]string
+}{uids: make(map[string]string)}
+
+// setCheckUIDs replaces the check UIDs
+func setCheckUIDs(uids map[string]string) {
+	checkUIDs.Lock()
+	defer checkUIDs.Unlock()
+	checkUIDs.uids = uids
+}
+
+// checkUIDFor returns the UID of a loaded check, or blank when the check is not loaded
+func checkUIDFor(checkName string, checkNamespace string) string {
+	checkUIDs.RLock()
+	defer checkUIDs.RUnlock()
+	return checkUIDs.uids[checkNamespace+"/"+checkName]
+}
+
+// khCheckUID returns the UID of a khcheck and whether it came from the check UID annotation.  khchecks without the
+// annotation are identified by their kubernetes UID.
+func khCheckUID(annotations map[string]string, kubernetesUID string) (string, bool) {
+	uid := strings.TrimSpace(annotations[checkUIDAnnotation])
+	if len(uid) > 0 {
+		return uid, true
+	}
+	return kubernetesUID, false
+}
+
+// renamableCheck is a loaded check with an annotated UID that may have had its khstate written under another name
+type renamableCheck struct {
+	name           string
+	namespace      string
+	stateNamespace string
+	uid            string
+}
+
+// migrateRenamedCheckStates carries the khstate and run history of renamed checks over to their new names.  A check
+// was renamed when a khstate that belongs to no loaded check carries its UID.  The old khstate is only carried over
+// when the khstate of the new name has no result of its own yet, and it is deleted once it has been copied.
+func migrateRenamedCheckStates(checks []renamableCheck) {
+	if len(checks) == 0 || cfg.ReadOnly {
+		return
+	}
+
+	states, err := getAllCheckStates("", projectionFull)
+	if err != nil {
+		log.Errorln("check uid: failed to list khstates to migrate renamed checks:", err)
+		return
+	}
+
+	// khstates that belong to a loaded check are never the old khstate of a renamed one
+	current := make(map[string]bool, len(checks))
+	for _, c := range checks {
+		current[stateKey(c.name, c.stateNamespace)] = true
+	}
+	previous := make(map[string]string)
+	for _, key := range sortedCheckKeys(states) {
+		details := states[key]
+		if len(details.CheckUID) == 0 || details.Synthetic || current[key] {
+			continue
+		}
+		if _, exists := previous[details.CheckUID]; !exists {
+			previous[details.CheckUID] = key
+		}
+	}
+
+	for _, c := range checks {
+		oldKey, renamed := previous[c.uid]
+		if !renamed {
+			continue
+		}
+		newKey := stateKey(c.name, c.stateNamespace)
+		if existing, exists := states[newKey]; exists && (!existing.LastRun.IsZero() || existing.GetStatus() != health.StatusUnknown) {
+			log.Warningln("check uid: not migrating khstate", oldKey, "to renamed check", newKey, "because it already has results")
+			continue
+		}
+		err := migrateCheckState(oldKey, states[oldKey], c)
+		if err != nil {
+			log.Errorln("check uid: failed to migrate khstate", oldKey, "to renamed check", newKey+":", err)
+			continue
+		}
+		log.Infoln("check uid: migrated khstate", oldKey, "to renamed check", newKey)
+	}
+}
+
+// migrateCheckState copies the details of the old khstate of a renamed check to its new khstate, moves its run
+// history over and deletes the old khstate
+func migrateCheckState(oldKey string, prior health.WorkloadDetails, c renamableCheck) error {
+	err := ensureStateResourceExists(c.name, c.namespace, health.KHCheck)
+	if err != nil {
+		return err
+	}
+	err = updateCheckStateResource(c.name, c.stateNamespace, func(details *health.WorkloadDetails) bool {
+		*details = prior
+		details.CheckUID = c.uid
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	newKey := stateKey(c.name, c.stateNamespace)
+	runHistory.Lock()
+	if history, exists := runHistory.checks[oldKey]; exists {
+		if _, taken := runHistory.checks[newKey]; !taken {
+			runHistory.checks[newKey] = history
+		}
+		delete(runHistory.checks, oldKey)
+	}
+	runHistory.Unlock()
+
+	oldNamespace, oldName := splitCheckKey(oldKey)
+	_, err = khStateClient.Delete(nil, stateCRDResource, oldName, oldNamespace)
+	stateVersions.invalidate(oldName, oldNamespace, "deleted")
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		return fmt.Errorf("error deleting old khstate: %w", err)
+	}
+	return nil
+}
+
+// getCheckStateByUID returns the namespace/name key and details of the khstate that carries a check UID
+func getCheckStateByUID(uid string) (string, health.WorkloadDetails, error) {
+	states, err := getAllCheckStates("", projectionFull)
+	if err != nil {
+		return "", health.WorkloadDetails{}, fmt.Errorf("error listing khstates to find check uid %s: %w", uid, err)
+	}
+	for _, key := range sortedCheckKeys(states) {
+		if states[key].CheckUID == uid {
+			return key, states[key], nil
+		}
+	}
+	return "", health.WorkloadDetails{}, fmt.Errorf("%w: %s", ErrCheckUIDNotFound, uid)
+}
+
+// checkStateByUID is the khstate of a check found by its UID
+type checkStateByUID struct {
+	Name      string
+	Namespace string
+	State     health.WorkloadDetails
+}
+
+// checkByUIDHandler writes the khstate of the check with the UID in the uid query parameter as JSON
+func checkByUIDHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return nil
+	}
+	uid := r.URL.Query().Get("uid")
+	if len(uid) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil
+	}
+
+	key, details, err := getCheckStateByUID(uid)
+	if errors.Is(err, ErrCheckUIDNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+	namespace, name := splitCheckKey(key)
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(checkStateByUID{Name: name, Namespace: namespace, State: details})
+}
@@ -0,0 +1,169 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// TestMigrateRenamedCheckStates ensures that the khstate and run history of a renamed check are carried over to its
+// new name, that the old khstate is deleted and that the check can be found by its UID afterwards
+func TestMigrateRenamedCheckStates(t *testing.T) {
+	stateVersions.reset()
+	defer stateVersions.reset()
+
+	lastRun := time.Now().Add(-time.Minute).Truncate(time.Second)
+	states := map[string]*khstatecrd.KuberhealthyState{}
+	newState := func(name string, details health.WorkloadDetails) *khstatecrd.KuberhealthyState {
+		s := khstatecrd.NewKuberhealthyState(name, details)
+		s.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+		s.Kind = "KuberhealthyState"
+		s.Namespace = "kuberhealthy"
+		s.ResourceVersion = "1"
+		return &s
+	}
+	states["uid-old-name"] = newState("uid-old-name", health.WorkloadDetails{OK: false, Status: health.StatusNotOK, Errors: []string{"still failing"}, LastRun: lastRun, CheckUID: "dns-check", Namespace: "kuberhealthy"})
+	states["uid-other"] = newState("uid-other", health.WorkloadDetails{OK: true, Status: health.StatusOK, Errors: []string{}, LastRun: lastRun, CheckUID: "other-check", Namespace: "kuberhealthy"})
+
+	useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		name := path.Base(r.URL.Path)
+		switch {
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/khstates"):
+			list := khstatecrd.KuberhealthyStateList{}
+			list.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+			list.Kind = "KuberhealthyStateList"
+			names := make([]string, 0, len(states))
+			for n := range states {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			for _, n := range names {
+				list.Items = append(list.Items, *states[n])
+			}
+			json.NewEncoder(w).Encode(list)
+		case r.Method == http.MethodGet && states[name] == nil:
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodGet:
+			json.NewEncoder(w).Encode(states[name])
+		case r.Method == http.MethodPost:
+			var created khstatecrd.KuberhealthyState
+			json.NewDecoder(r.Body).Decode(&created)
+			created.Namespace = "kuberhealthy"
+			created.ResourceVersion = "1"
+			states[created.Name] = &created
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(created)
+		case r.Method == http.MethodPut:
+			var written khstatecrd.KuberhealthyState
+			json.NewDecoder(r.Body).Decode(&written)
+			written.ResourceVersion += "1"
+			states[name] = &written
+			json.NewEncoder(w).Encode(written)
+		case r.Method == http.MethodDelete:
+			deleted := states[name]
+			delete(states, name)
+			json.NewEncoder(w).Encode(deleted)
+		}
+	})
+
+	runHistory.Lock()
+	runHistory.checks["kuberhealthy/uid-old-name"] = &checkRunHistory{since: lastRun, runs: []runRecord{{Time: lastRun, OK: false}}}
+	runHistory.Unlock()
+	defer func() {
+		runHistory.Lock()
+		delete(runHistory.checks, "kuberhealthy/uid-old-name")
+		delete(runHistory.checks, "kuberhealthy/uid-new-name")
+		runHistory.Unlock()
+	}()
+
+	// the other check is loaded under its own name, so its khstate is not the old khstate of a renamed check
+	migrateRenamedCheckStates([]renamableCheck{
+		{name: "uid-new-name", namespace: "kuberhealthy", stateNamespace: "kuberhealthy", uid: "dns-check"},
+		{name: "uid-other", namespace: "kuberhealthy", stateNamespace: "kuberhealthy", uid: "other-check"},
+	})
+
+	if states["uid-old-name"] != nil {
+		t.Fatal("expected the old khstate to be deleted")
+	}
+	migrated := states["uid-new-name"]
+	if migrated == nil {
+		t.Fatal("expected a khstate for the new name of the check")
+	}
+	if migrated.Spec.OK || migrated.Spec.CheckUID != "dns-check" || !migrated.Spec.LastRun.Equal(lastRun) || len(migrated.Spec.Errors) != 1 {
+		t.Fatalf("expected the old result to be carried over, got %+v", migrated.Spec)
+	}
+	if states["uid-other"] == nil {
+		t.Fatal("expected the khstate of the other check to be kept")
+	}
+	runHistory.Lock()
+	_, oldHistory := runHistory.checks["kuberhealthy/uid-old-name"]
+	newHistory := runHistory.checks["kuberhealthy/uid-new-name"]
+	runHistory.Unlock()
+	if oldHistory || newHistory == nil || len(newHistory.runs) != 1 {
+		t.Fatal("expected the run history to move to the new name")
+	}
+
+	// the renamed check is found by its uid
+	key, details, err := getCheckStateByUID("dns-check")
+	if err != nil {
+		t.Fatal("unexpected error looking up the check by uid:", err)
+	}
+	if key != "kuberhealthy/uid-new-name" || details.CheckUID != "dns-check" {
+		t.Fatalf("expected the renamed check, got %s %+v", key, details)
+	}
+	_, _, err = getCheckStateByUID("missing-check")
+	if !errors.Is(err, ErrCheckUIDNotFound) {
+		t.Fatal("expected a missing uid to not be found, got", err)
+	}
+
+	// the endpoint serves the same lookup
+	recorder := httptest.NewRecorder()
+	err = checkByUIDHandler(recorder, httptest.NewRequest(http.MethodGet, "/checkByUID?uid=dns-check", nil))
+	if err != nil || recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"Name":"uid-new-name"`) {
+		t.Fatal("expected the renamed check from the endpoint, got", recorder.Code, recorder.Body.String(), err)
+	}
+	recorder = httptest.NewRecorder()
+	checkByUIDHandler(recorder, httptest.NewRequest(http.MethodGet, "/checkByUID?uid=missing-check", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Fatal("expected a missing uid to return 404, got", recorder.Code)
+	}
+
+	// a second load finds nothing left to migrate
+	migrateRenamedCheckStates([]renamableCheck{{name: "uid-new-name", namespace: "kuberhealthy", stateNamespace: "kuberhealthy", uid: "dns-check"}})
+	if len(states) != 2 || !states["uid-new-name"].Spec.LastRun.Equal(lastRun) {
+		t.Fatal("expected a second migration to change nothing, got", len(states), "khstates")
+	}
+}
+
+// TestKHCheckUID ensures that an annotated uid is preferred over the kubernetes uid
+func TestKHCheckUID(t *testing.T) {
+	uid, annotated := khCheckUID(map[string]string{checkUIDAnnotation: " dns-check "}, "1234")
+	if uid != "dns-check" || !annotated {
+		t.Fatal("expected the annotated uid, got", uid, annotated)
+	}
+	uid, annotated = khCheckUID(nil, "1234")
+	if uid != "1234" || annotated {
+		t.Fatal("expected the kubernetes uid, got", uid, annotated)
+	}
+}
@@ -0,0 +1,205 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/checks/external"
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khcheckcrd"
+)
+
+// The steps of validating a check before it is applied
+const (
+	validationStepName      = "name"      // the khstate name the check name is sanitized to is a valid resource name
+	validationStepCollision = "collision" // no other khcheck or khjob writes to the same khstate
+	validationStepNamespace = "namespace" // kuberhealthy manages state in the namespace of the khstate
+	validationStepCreate    = "create"    // the API server allows kuberhealthy to create the khstate
+)
+
+// checkValidationStep is the outcome of a single step of validating a check
+type checkValidationStep struct {
+	Step    string
+	OK      bool
+	Message string
+}
+
+// checkValidationReport is the outcome of validating a check before it is applied
+type checkValidationReport struct {
+	Name           string
+	Namespace      string
+	StateName      string // the name of the khstate of the check
+	StateNamespace string // the namespace of the khstate of the check
+	Valid          bool   // set when every step passed
+	Steps          []checkValidationStep
+}
+
+// add adds the outcome of a step to the report
+func (r *checkValidationReport) add(step string, ok bool, message string) {
+	r.Steps = append(r.Steps, checkValidationStep{Step: step, OK: ok, Message: message})
+	r.Valid = r.Valid && ok
+}
+
+// validateCheck makes sure that Kuberhealthy could keep the state of a check without running it or writing
+// anything.  The khstate name is validated, the registered khchecks and khjobs are searched for ones that write to
+// the same khstate, the khstate namespace is checked against the state namespace configuration and the khstate is
+// created with a server-side dry run, which exercises RBAC and admission without persisting anything.
+func validateCheck(check KuberhealthyCheck) checkValidationReport {
+	stateNamespace := stateNamespaceForCheck(check.Name(), check.CheckNamespace())
+	if c, ok := check.(*external.Checker); ok && len(c.StateNamespace) > 0 {
+		stateNamespace = c.StateNamespace
+	}
+	report := checkValidationReport{
+		Name:           check.Name(),
+		Namespace:      check.CheckNamespace(),
+		StateName:      sanitizeResourceName(check.Name()),
+		StateNamespace: stateNamespace,
+		Valid:          true,
+	}
+
+	report.add(validateStateName(check.Name()))
+
+	registrations, err := listCheckRegistrations()
+	if err != nil {
+		report.add(validationStepCollision, false, "unable to list the registered checks: "+err.Error())
+	} else {
+		report.add(validateStateCollisions(checkRegistration{Kind: "khcheck", Name: check.Name(), Namespace: check.CheckNamespace(), StateNamespace: stateNamespace}, registrations))
+	}
+
+	report.add(validateStateNamespace(stateNamespace))
+	report.add(dryRunCreateState(report.StateName, check.CheckNamespace(), stateNamespace))
+	return report
+}
+
+// validateStateName validates the khstate name that a check name is sanitized to
+func validateStateName(checkName string) (string, bool, string) {
+	if len(checkName) == 0 {
+		return validationStepName, false, "the check has no name"
+	}
+	stateName := sanitizeResourceName(checkName)
+	if problems := validation.IsDNS1123Subdomain(stateName); len(problems) > 0 {
+		return validationStepName, false, "khstate name " + stateName + " is not a valid resource name: " + strings.Join(problems, ", ")
+	}
+	if stateName != checkName {
+		return validationStepName, true, "the khstate of the check is named " + stateName
+	}
+	return validationStepName, true, "the khstate of the check is named after the check"
+}
+
+// validateStateCollisions returns a failed step when any registration other than the check itself writes to the
+// khstate of the check.  A registration of the same kind, name and namespace is the check itself being reapplied.
+func validateStateCollisions(candidate checkRegistration, registrations []checkRegistration) (string, bool, string) {
+	var claimants []string
+	for _, r := range registrations {
+		if r.Kind == candidate.Kind && r.Name == candidate.Name && r.Namespace == candidate.Namespace {
+			continue
+		}
+		if r.stateIdentity() == candidate.stateIdentity() {
+			claimants = append(claimants, r.String())
+		}
+	}
+	if len(claimants) > 0 {
+		return validationStepCollision, false, "khstate " + candidate.stateIdentity() + " is already claimed by " + strings.Join(claimants, ", ")
+	}
+	return validationStepCollision, true, "no other check writes to khstate " + candidate.stateIdentity()
+}
+
+// validateStateNamespace returns a failed step when kuberhealthy would not write to the khstate namespace
+func validateStateNamespace(stateNamespace string) (string, bool, string) {
+	if cfg.ReadOnly {
+		return validationStepNamespace, false, "kuberhealthy is read-only and does not write khstates"
+	}
+	if !stateNamespaceManaged(stateNamespace) {
+		return validationStepNamespace, false, "namespace " + stateNamespace + " is excluded from state management"
+	}
+	if namespaceTerminating(stateNamespace, time.Now()) {
+		return validationStepNamespace, false, "namespace " + stateNamespace + " is being deleted or does not exist"
+	}
+	return validationStepNamespace, true, "kuberhealthy manages state in namespace " + stateNamespace
+}
+
+// dryRunCreateState creates the initial khstate of a check with a server-side dry run.  A khstate that already
+// exists is reused by the check, so it passes.
+func dryRunCreateState(stateName string, checkNamespace string, stateNamespace string) (string, bool, string) {
+	initialState := initialStateResource(stateName, checkNamespace, stateNamespace, health.KHCheck)
+
+	stateClientsLock.RLock()
+	defer stateClientsLock.RUnlock()
+	_, err := khStateClient.CreateWithOptions(metav1.CreateOptions{DryRun: []string{metav1.DryRunAll}}, &initialState, stateCRDResource, stateNamespace)
+	switch {
+	case err == nil:
+		return validationStepCreate, true, "kuberhealthy can create khstate " + stateNamespace + "/" + stateName
+	case k8sErrors.IsAlreadyExists(err):
+		return validationStepCreate, true, "khstate " + stateNamespace + "/" + stateName + " already exists and will be reused"
+	case k8sErrors.IsForbidden(err):
+		return validationStepCreate, false, "kuberhealthy is not allowed to create khstates in namespace " + stateNamespace + ": " + err.Error()
+	}
+	return validationStepCreate, false, "the dry run create of khstate " + stateNamespace + "/" + stateName + " failed: " + err.Error()
+}
+
+// validateCheckHandler validates the khcheck manifest in the request body, as YAML or JSON, without applying it and
+// writes the report as JSON.  The namespace query parameter is used when the manifest has no namespace.  Valid
+// checks are answered with 200 and invalid ones with 422.
+func validateCheckHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	khCheck := khcheckcrd.KuberhealthyCheck{}
+	err = yaml.Unmarshal(b, &khCheck)
+	if err != nil {
+		http.Error(w, "failed to unmarshal khcheck: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(khCheck.Namespace) == 0 {
+		khCheck.Namespace = r.URL.Query().Get("namespace")
+	}
+	if len(khCheck.Namespace) == 0 {
+		http.Error(w, "the khcheck has no namespace", http.StatusBadRequest)
+		return
+	}
+	log.Infoln("admin: validation of check", khCheck.Name, "in namespace", khCheck.Namespace, "requested by", r.RemoteAddr)
+
+	c := external.New(kubernetesClient, &khCheck, khCheckClient, khStateClient, cfg.ExternalCheckReportingURL)
+	c.StateNamespace = khCheckStateNamespace(khCheck.Namespace, khCheck.GetAnnotations())
+	report := validateCheck(c)
+
+	b, err = json.Marshal(report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !report.Valid {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	_, err = w.Write(b)
+	if err != nil {
+		log.Warningln("admin: error writing check validation report to caller:", err)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// TestValidateCheckSteps ensures that invalid khstate names, khstates claimed by other checks and unmanaged
+// namespaces fail validation, and that a check being reapplied does not collide with itself
+func TestValidateCheckSteps(t *testing.T) {
+	originalExcluded := cfg.ExcludedStateNamespaces
+	defer func() { cfg.ExcludedStateNamespaces = originalExcluded }()
+	cfg.ExcludedStateNamespaces = []string{"kube-*"}
+
+	if _, ok, message := validateStateName("DNS Check"); !ok || !strings.Contains(message, "dns-check") {
+		t.Fatalf("expected a sanitized name to pass and be reported, got %v and %q", ok, message)
+	}
+	if _, ok, _ := validateStateName("dns_check!"); ok {
+		t.Fatal("expected a name that does not sanitize to a valid resource name to fail")
+	}
+
+	registrations := []checkRegistration{
+		{Kind: "khcheck", Name: "dns", Namespace: "kuberhealthy", StateNamespace: "kuberhealthy"},
+		{Kind: "khjob", Name: "DNS", Namespace: "kuberhealthy", StateNamespace: "kuberhealthy"},
+	}
+	candidate := checkRegistration{Kind: "khcheck", Name: "dns", Namespace: "kuberhealthy", StateNamespace: "kuberhealthy"}
+	if _, ok, message := validateStateCollisions(candidate, registrations); ok || !strings.Contains(message, "khjob kuberhealthy/DNS") || strings.Contains(message, "khcheck") {
+		t.Fatalf("expected only the khjob to collide with the check, got %v and %q", ok, message)
+	}
+	if _, ok, _ := validateStateCollisions(candidate, registrations[:1]); !ok {
+		t.Fatal("expected a check being reapplied to not collide with itself")
+	}
+
+	if _, ok, _ := validateStateNamespace("kube-system"); ok {
+		t.Fatal("expected an excluded namespace to fail")
+	}
+	if _, ok, _ := validateStateNamespace("kuberhealthy"); !ok {
+		t.Fatal("expected a managed namespace to pass")
+	}
+}
+
+// TestDryRunCreateState ensures that the khstate is created with a server-side dry run and that existing khstates
+// pass while forbidden creates fail
+func TestDryRunCreateState(t *testing.T) {
+	var dryRun string
+	var created khstatecrd.KuberhealthyState
+	useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		dryRun = r.URL.Query().Get("dryRun")
+		json.NewDecoder(r.Body).Decode(&created)
+		gr := schema.GroupResource{Group: stateCRDGroup, Resource: stateCRDResource}
+		var status *k8sErrors.StatusError
+		switch {
+		case strings.Contains(r.URL.Path, "/namespaces/existing/"):
+			status = k8sErrors.NewAlreadyExists(gr, created.Name)
+		case strings.Contains(r.URL.Path, "/namespaces/forbidden/"):
+			status = k8sErrors.NewForbidden(gr, created.Name, nil)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if status == nil {
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(created)
+			return
+		}
+		w.WriteHeader(int(status.ErrStatus.Code))
+		json.NewEncoder(w).Encode(status.ErrStatus)
+	})
+
+	_, ok, message := dryRunCreateState("dry-run-check", "kuberhealthy", "kuberhealthy")
+	if !ok || dryRun != "All" {
+		t.Fatalf("expected a dry run create to pass, got %v, %q and dry run %q", ok, message, dryRun)
+	}
+	if created.Name != "dry-run-check" || created.Spec.GetStatus() != health.StatusUnknown {
+		t.Fatalf("expected the initial khstate of the check to be created, got %+v", created)
+	}
+	if _, ok, message := dryRunCreateState("dry-run-check", "existing", "existing"); !ok || !strings.Contains(message, "already exists") {
+		t.Fatalf("expected an existing khstate to pass, got %v and %q", ok, message)
+	}
+	if _, ok, message := dryRunCreateState("dry-run-check", "forbidden", "forbidden"); ok || !strings.Contains(message, "not allowed") {
+		t.Fatalf("expected a forbidden create to fail, got %v and %q", ok, message)
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultMaxClockSkew is how far the clock of a checker can be from the clock of Kuberhealthy when no maximum is configured
+const defaultMaxClockSkew = time.Second * 30
+
+// maxClockSkew returns the configured maximum clock skew
+func maxClockSkew() time.Duration {
+	if cfg.MaxClockSkew <= 0 {
+		return defaultMaxClockSkew
+	}
+	return cfg.MaxClockSkew
+}
+
+// checkClockSkew compares the time a checker reported at against the clock of Kuberhealthy.  The skew is recorded
+// on the clock skew gauge, and when it is more than the maximum clock skew the report is counted, logged and the
+// skew is returned for the ClockSkew field of the check.  Reports without a timestamp come from checkers built
+// before reports carried one and are not checked.  The reported time is only used to measure the skew.  The stored
+// LastRun, and every other stored time, is always taken from the clock of Kuberhealthy, so there is no checker
+// timestamp to clamp and a skewed checker can never move a result into the future or the past.
+func checkClockSkew(checkName string, checkNamespace string, reported time.Time, now time.Time) string {
+	if reported.IsZero() {
+		return ""
+	}
+	skew := reported.Sub(now)
+	checkerClockSkew.Set(skew.Seconds(), checkName, checkNamespace)
+
+	if skew <= maxClockSkew() && skew >= -maxClockSkew() {
+		return ""
+	}
+	clockSkewEvents.Inc(checkName, checkNamespace)
+	rounded := skew.Round(time.Millisecond).String()
+	log.Warningln("Clock of the checker pod for", checkNamespace+"/"+checkName, "is off by", rounded, "which is more than the maximum clock skew of", maxClockSkew())
+	return rounded
+}
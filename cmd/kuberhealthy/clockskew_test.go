@@ -0,0 +1,70 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// TestCheckClockSkew ensures that only reports from checkers with a clock further off than the maximum clock skew
+// are flagged
+func TestCheckClockSkew(t *testing.T) {
+	original := cfg.MaxClockSkew
+	defer func() { cfg.MaxClockSkew = original }()
+	cfg.MaxClockSkew = time.Minute
+
+	now := time.Now()
+	tests := map[time.Time]string{
+		{}:                         "",
+		now.Add(time.Second * 5):   "",
+		now.Add(-time.Second * 59): "",
+		now.Add(time.Hour):         "1h0m0s",
+		now.Add(-time.Minute * 2):  "-2m0s",
+	}
+	for reported, expected := range tests {
+		if skew := checkClockSkew("skew-check", "kuberhealthy", reported, now); skew != expected {
+			t.Fatalf("expected a clock skew of %q for a report at %s, got %q", expected, reported, skew)
+		}
+	}
+}
+
+// TestRunCheckKeepsClockSkew ensures that the clock skew measured when the checker reported is still stored once
+// runCheck has written the result of the finished run, and that the stored LastRun comes from the clock of
+// Kuberhealthy
+func TestRunCheckKeepsClockSkew(t *testing.T) {
+	c := &FakeCheck{CheckName: "skew-run-check", Namespace: "kuberhealthy", OK: true}
+	reported := health.WorkloadDetails{OK: true, Status: health.StatusOK, Namespace: "kuberhealthy", ClockSkew: "1h0m0s", LastRun: time.Now().Add(time.Hour)}
+
+	before := time.Now()
+	stored := runCheckOnce(t, c, reported)
+	if stored.ClockSkew != "1h0m0s" {
+		t.Fatalf("expected the clock skew to be kept after the run, got %q", stored.ClockSkew)
+	}
+	if stored.LastRun.Before(before.Add(-time.Second)) || stored.LastRun.After(time.Now().Add(time.Second)) {
+		t.Fatalf("expected the last run to come from the clock of kuberhealthy, got %s", stored.LastRun)
+	}
+}
+
+// TestRunJobKeepsClockSkew ensures that the clock skew measured when a khjob reported is still stored once runJob
+// has written the result of the finished run
+func TestRunJobKeepsClockSkew(t *testing.T) {
+	c := &FakeCheck{CheckName: "skew-run-job", Namespace: "kuberhealthy", OK: true}
+	reported := health.WorkloadDetails{OK: true, Status: health.StatusOK, Namespace: "kuberhealthy", ClockSkew: "-30m0s"}
+
+	stored := runJobOnce(t, c, reported)
+	if stored.ClockSkew != "-30m0s" {
+		t.Fatalf("expected the clock skew to be kept after the run, got %q", stored.ClockSkew)
+	}
+}
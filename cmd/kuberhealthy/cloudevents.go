@@ -0,0 +1,229 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// cloudEventType is the type of the CloudEvents emitted when the result of a check changes
+const cloudEventType = "io.kuberhealthy.check.result"
+
+// cloudEventsSpecVersion is the version of the CloudEvents specification that emitted events follow
+const cloudEventsSpecVersion = "1.0"
+
+// defaultCloudEventsSubject is the NATS subject CloudEvents are published on when no subject is configured
+const defaultCloudEventsSubject = "kuberhealthy.check.result"
+
+// defaultCloudEventsBufferSize is the number of CloudEvents that can wait to be sent before new events are dropped
+const defaultCloudEventsBufferSize = 100
+
+// cloudEventsTimeout bounds every delivery of a CloudEvent to the sink
+const cloudEventsTimeout = time.Second * 10
+
+// errCloudEventsBufferFull is returned when a CloudEvent is dropped because the buffer is full
+var errCloudEventsBufferFull = errors.New("cloudevents buffer is full")
+
+// cloudEventEmitter sends CloudEvents for check result changes.  nil when CloudEvents are disabled.
+var cloudEventEmitter *cloudEventsEmitter
+
+// cloudEvent is a CloudEvent in the structured JSON format
+type cloudEvent struct {
+	SpecVersion     string                 `json:"specversion"`
+	ID              string                 `json:"id"`
+	Source          string                 `json:"source"`
+	Type            string                 `json:"type"`
+	Subject         string                 `json:"subject"`
+	Time            time.Time              `json:"time"`
+	DataContentType string                 `json:"datacontenttype"`
+	Data            health.WorkloadDetails `json:"data"`
+}
+
+// newCheckResultEvent creates the CloudEvent for a changed check result.  The run ID of the result is the event ID,
+// so consumers can drop an event they already received.  Results without a run ID are identified by when they ran.
+func newCheckResultEvent(checkName string, checkNamespace string, state health.WorkloadDetails) cloudEvent {
+	id := state.RunID
+	if len(id) == 0 {
+		id = checkNamespace + "/" + checkName + "/" + strconv.FormatInt(state.LastRun.UnixNano(), 10)
+	}
+	eventTime := state.LastRun
+	if eventTime.IsZero() {
+		eventTime = time.Now()
+	}
+	return cloudEvent{
+		SpecVersion:     cloudEventsSpecVersion,
+		ID:              id,
+		Source:          "/kuberhealthy/" + podNamespace,
+		Type:            cloudEventType,
+		Subject:         checkNamespace + "/" + checkName,
+		Time:            eventTime.UTC(),
+		DataContentType: "application/json",
+		Data:            state,
+	}
+}
+
+// cloudEventSender delivers an encoded CloudEvent to a sink
+type cloudEventSender interface {
+	send(event []byte) error
+}
+
+// cloudEventsEmitter sends CloudEvents to a sink in the background so that a slow sink never slows down khstate
+// writes
+type cloudEventsEmitter struct {
+	sender cloudEventSender
+	queue  chan cloudEvent
+}
+
+// newCloudEventsEmitter creates an emitter for the supplied sink and starts sending events in the background.  http
+// and https sinks receive events with HTTP POST in the structured content mode.  nats sinks receive them as
+// messages on the subject.
+func newCloudEventsEmitter(sink string, subject string, bufferSize int) (*cloudEventsEmitter, error) {
+	u, err := url.Parse(sink)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing cloudevents sink: %w", err)
+	}
+	var sender cloudEventSender
+	switch u.Scheme {
+	case "http", "https":
+		sender = &httpCloudEventSender{url: sink, client: &http.Client{Timeout: cloudEventsTimeout}}
+	case "nats":
+		if len(u.Host) == 0 {
+			return nil, fmt.Errorf("cloudevents sink %s has no nats server", sink)
+		}
+		if len(subject) == 0 {
+			subject = defaultCloudEventsSubject
+		}
+		sender = &natsCloudEventSender{url: sink, subject: subject}
+	default:
+		return nil, fmt.Errorf("cloudevents sink %s must be http, https or nats", sink)
+	}
+	if bufferSize <= 0 {
+		bufferSize = defaultCloudEventsBufferSize
+	}
+
+	e := &cloudEventsEmitter{sender: sender, queue: make(chan cloudEvent, bufferSize)}
+	go e.run()
+	return e, nil
+}
+
+// emit queues a CloudEvent to be sent.  If the buffer is full, the event is dropped.
+func (e *cloudEventsEmitter) emit(event cloudEvent) error {
+	select {
+	case e.queue <- event:
+		return nil
+	default:
+		cloudEvents.Inc("dropped")
+		return errCloudEventsBufferFull
+	}
+}
+
+// run sends queued CloudEvents one at a time until the queue is closed
+func (e *cloudEventsEmitter) run() {
+	for event := range e.queue {
+		b, err := json.Marshal(event)
+		if err == nil {
+			err = e.sender.send(b)
+		}
+		if err != nil {
+			log.Warningln("cloudevents: failed to send event", event.ID, "for", event.Subject+":", err)
+			cloudEvents.Inc("failed")
+			continue
+		}
+		cloudEvents.Inc("sent")
+	}
+}
+
+// emitCheckResultEvent emits a CloudEvent for a changed check result when CloudEvents are enabled
+func emitCheckResultEvent(checkName string, checkNamespace string, state health.WorkloadDetails) {
+	if cloudEventEmitter == nil {
+		return
+	}
+	err := cloudEventEmitter.emit(newCheckResultEvent(checkName, checkNamespace, state))
+	if err != nil {
+		log.Debugln(checkNamespace, checkName, "cloudevent was not emitted:", err)
+	}
+}
+
+// httpCloudEventSender posts CloudEvents to an HTTP sink
+type httpCloudEventSender struct {
+	url    string
+	client *http.Client
+}
+
+// send posts a structured CloudEvent to the sink
+func (s *httpCloudEventSender) send(event []byte) error {
+	resp, err := s.client.Post(s.url, "application/cloudevents+json; charset=UTF-8", bytes.NewReader(event))
+	if err != nil {
+		return fmt.Errorf("error posting cloudevent: %w", err)
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("cloudevents sink responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// natsCloudEventSender publishes CloudEvents to a NATS subject.  The connection is opened with the first event and
+// kept open, and the NATS client reconnects it when it is lost.  Every publish is flushed so that an event the
+// server did not receive is counted as failed.
+type natsCloudEventSender struct {
+	url     string
+	subject string
+	conn    *nats.Conn
+}
+
+// connect opens a connection to the NATS server.  The client upgrades the connection to TLS when the server
+// requires it and authenticates with the credentials in the sink URL.
+func (s *natsCloudEventSender) connect() error {
+	conn, err := nats.Connect(s.url, nats.Name("kuberhealthy"), nats.Timeout(cloudEventsTimeout),
+		nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+			log.Warningln("cloudevents: nats server reported an error:", err)
+		}))
+	if err != nil {
+		return fmt.Errorf("error connecting to nats: %w", err)
+	}
+	s.conn = conn
+	return nil
+}
+
+// send publishes a CloudEvent on the subject and waits for the server to receive it
+func (s *natsCloudEventSender) send(event []byte) error {
+	if s.conn == nil || s.conn.IsClosed() {
+		err := s.connect()
+		if err != nil {
+			return err
+		}
+	}
+	err := s.conn.Publish(s.subject, event)
+	if err != nil {
+		return fmt.Errorf("error publishing to nats: %w", err)
+	}
+	err = s.conn.FlushTimeout(cloudEventsTimeout)
+	if err != nil {
+		return fmt.Errorf("error flushing cloudevent to nats: %w", err)
+	}
+	return nil
+}
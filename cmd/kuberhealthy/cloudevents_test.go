@@ -0,0 +1,246 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// TestCloudEventsHTTPSink ensures that changed check results are posted as structured CloudEvents with the run ID
+// as the event ID
+func TestCloudEventsHTTPSink(t *testing.T) {
+	received := make(chan cloudEvent, 1)
+	contentTypes := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event cloudEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		contentTypes <- r.Header.Get("Content-Type")
+		received <- event
+	}))
+	defer server.Close()
+
+	emitter, err := newCloudEventsEmitter(server.URL, "", 0)
+	if err != nil {
+		t.Fatal("unexpected error creating the emitter:", err)
+	}
+	defer close(emitter.queue)
+
+	lastRun := time.Now().Truncate(time.Second)
+	err = emitter.emit(newCheckResultEvent("dns", "kuberhealthy", health.WorkloadDetails{OK: false, Errors: []string{"lookup failed"}, RunID: "run-1", LastRun: lastRun}))
+	if err != nil {
+		t.Fatal("unexpected error emitting the event:", err)
+	}
+
+	select {
+	case event := <-received:
+		if event.SpecVersion != "1.0" || event.Type != cloudEventType || event.ID != "run-1" || event.Subject != "kuberhealthy/dns" {
+			t.Fatalf("expected a check result cloudevent for run-1, got %+v", event)
+		}
+		if !event.Time.Equal(lastRun) || event.Data.OK || len(event.Data.Errors) != 1 {
+			t.Fatalf("expected the check result as the event data, got %+v", event)
+		}
+		if contentType := <-contentTypes; !strings.HasPrefix(contentType, "application/cloudevents+json") {
+			t.Fatal("expected a structured cloudevent, got content type", contentType)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for the cloudevent")
+	}
+}
+
+// useFakeNATSServer starts a NATS server that introduces itself with the supplied INFO options, answers pings and
+// sends the CONNECT options and the subject and payload of every published message to the returned channels
+func useFakeNATSServer(t *testing.T, info string) (string, chan string, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("failed to listen for the fake nats server:", err)
+	}
+	t.Cleanup(func() {
+		listener.Close()
+	})
+
+	connects := make(chan string, 1)
+	published := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.WriteString(conn, "INFO "+info+"\r\n")
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			switch {
+			case len(fields) > 1 && fields[0] == "CONNECT":
+				connects <- strings.TrimSpace(strings.TrimPrefix(line, "CONNECT"))
+			case len(fields) == 3 && fields[0] == "PUB":
+				size, _ := strconv.Atoi(fields[2])
+				payload := make([]byte, size+2)
+				io.ReadFull(reader, payload)
+				published <- fields[1] + " " + string(payload[:size])
+			case len(fields) == 1 && fields[0] == "PING":
+				io.WriteString(conn, "PONG\r\n")
+			}
+		}
+	}()
+	return listener.Addr().String(), connects, published
+}
+
+// TestCloudEventsNATSSink ensures that CloudEvents are published on the configured NATS subject with the
+// credentials in the sink URL
+func TestCloudEventsNATSSink(t *testing.T) {
+	address, connects, published := useFakeNATSServer(t, `{"server_id":"test","max_payload":1048576,"auth_required":true}`)
+
+	emitter, err := newCloudEventsEmitter("nats://kuberhealthy:secret@"+address, "checks.results", 0)
+	if err != nil {
+		t.Fatal("unexpected error creating the emitter:", err)
+	}
+	defer close(emitter.queue)
+	emitter.emit(newCheckResultEvent("dns", "kuberhealthy", health.WorkloadDetails{OK: true, Errors: []string{}, LastRun: time.Unix(100, 0)}))
+
+	select {
+	case connect := <-connects:
+		options := struct {
+			User string `json:"user"`
+			Pass string `json:"pass"`
+		}{}
+		json.Unmarshal([]byte(connect), &options)
+		if options.User != "kuberhealthy" || options.Pass != "secret" {
+			t.Fatal("expected the credentials in the sink URL to be sent, got", connect)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for the nats client to connect")
+	}
+
+	select {
+	case message := <-published:
+		parts := strings.SplitN(message, " ", 2)
+		if parts[0] != "checks.results" {
+			t.Fatal("expected the event on the configured subject, got", parts[0])
+		}
+		var event cloudEvent
+		err := json.Unmarshal([]byte(parts[1]), &event)
+		if err != nil {
+			t.Fatal("failed to decode the published cloudevent:", err)
+		}
+		if event.ID != "kuberhealthy/dns/100000000000" || !event.Data.OK {
+			t.Fatalf("expected a cloudevent identified by when the check ran, got %+v", event)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for the cloudevent")
+	}
+}
+
+// TestCloudEventsNATSLimits ensures that events larger than the server allows are refused before they are sent
+func TestCloudEventsNATSLimits(t *testing.T) {
+	address, _, published := useFakeNATSServer(t, `{"server_id":"test","max_payload":16}`)
+
+	sender := &natsCloudEventSender{url: "nats://" + address, subject: defaultCloudEventsSubject}
+	err := sender.send([]byte(`{"specversion":"1.0","id":"too-large"}`))
+	if !errors.Is(err, nats.ErrMaxPayload) {
+		t.Fatal("expected an event over the max payload of the server to be refused, got", err)
+	}
+	select {
+	case message := <-published:
+		t.Fatal("expected the oversized event not to be published, got", message)
+	default:
+	}
+}
+
+// TestCloudEventsNATSRequiresTLS ensures that the connection is upgraded to TLS when the server requires it
+func TestCloudEventsNATSRequiresTLS(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("failed to listen for the fake nats server:", err)
+	}
+	defer listener.Close()
+
+	handshake := make(chan byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.WriteString(conn, "INFO {\"server_id\":\"test\",\"max_payload\":1048576,\"tls_required\":true}\r\n")
+		first := make([]byte, 1)
+		io.ReadFull(conn, first)
+		handshake <- first[0]
+	}()
+
+	sender := &natsCloudEventSender{url: "nats://" + listener.Addr().String(), subject: defaultCloudEventsSubject}
+	err = sender.send([]byte(`{}`))
+	if err == nil {
+		t.Fatal("expected the connection to fail against a server that can not complete a tls handshake")
+	}
+	select {
+	case b := <-handshake:
+		// 0x16 starts a tls handshake record, where a plain text client would have sent CONNECT
+		if b != 0x16 {
+			t.Fatalf("expected a tls handshake after the server required tls, got %q", b)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for the nats client to start a tls handshake")
+	}
+}
+
+// TestCloudEventsBufferFull ensures that events are dropped instead of blocking when the sink is not keeping up
+func TestCloudEventsBufferFull(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	emitter, err := newCloudEventsEmitter(server.URL, "", 1)
+	if err != nil {
+		t.Fatal("unexpected error creating the emitter:", err)
+	}
+	defer close(emitter.queue)
+
+	event := newCheckResultEvent("dns", "kuberhealthy", health.WorkloadDetails{OK: true})
+	var dropped bool
+	for i := 0; i < 5; i++ {
+		if emitter.emit(event) == errCloudEventsBufferFull {
+			dropped = true
+		}
+	}
+	if !dropped {
+		t.Fatal("expected events to be dropped while the sink is blocked")
+	}
+
+	_, err = newCloudEventsEmitter("kafka://broker:9092", "", 0)
+	if err == nil {
+		t.Fatal("expected an unsupported sink to be refused")
+	}
+}
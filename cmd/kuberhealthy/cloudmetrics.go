@@ -0,0 +1,264 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+
+	awsutil "github.com/Comcast/kuberhealthy/v2/pkg/aws"
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// cloudMetricTimeout is how long a cloud provider has to accept the metrics of a single check result
+const cloudMetricTimeout = time.Second * 10
+
+// defaultGCPMetricPrefix is the prefix of the custom metric types check results are written to in GCP Monitoring
+const defaultGCPMetricPrefix = "custom.googleapis.com/kuberhealthy"
+
+// gcpMonitoringEndpoint is the GCP Monitoring API that time series are written to
+const gcpMonitoringEndpoint = "https://monitoring.googleapis.com/v3"
+
+// gcpMetadataTokenURL is where the access token of the pod's service account is fetched from on GKE
+const gcpMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// runDurationSeconds parses the run duration of a check result.  Results without a run duration report zero.
+func runDurationSeconds(state health.WorkloadDetails) float64 {
+	d, err := time.ParseDuration(state.RunDuration)
+	if err != nil {
+		return 0
+	}
+	return d.Seconds()
+}
+
+// okValue returns 1 for a passing check result and 0 for any other result
+func okValue(state health.WorkloadDetails) int {
+	if state.OK {
+		return 1
+	}
+	return 0
+}
+
+// cloudWatchStateStore publishes check results to AWS CloudWatch as custom metrics with the check name and
+// namespace as dimensions
+type cloudWatchStateStore struct {
+	client    cloudwatchiface.CloudWatchAPI
+	namespace string // the CloudWatch metric namespace, such as Kuberhealthy
+}
+
+// newCloudWatchStateStore creates a CloudWatch state store using the default AWS credential chain
+func newCloudWatchStateStore(namespace string) (*cloudWatchStateStore, error) {
+	if len(namespace) == 0 {
+		return nil, errors.New("a cloudwatch metric namespace must be configured")
+	}
+	return &cloudWatchStateStore{
+		client:    cloudwatch.New(awsutil.CreateAWSSession()),
+		namespace: namespace,
+	}, nil
+}
+
+// Name returns the name of the state store for logs and metrics
+func (c *cloudWatchStateStore) Name() string {
+	return "cloudwatch"
+}
+
+// SetCheckState publishes whether a check is OK and how long its run took
+func (c *cloudWatchStateStore) SetCheckState(checkName string, checkNamespace string, state health.WorkloadDetails) error {
+	dimensions := []*cloudwatch.Dimension{
+		{Name: aws.String("CheckName"), Value: aws.String(checkName)},
+		{Name: aws.String("Namespace"), Value: aws.String(checkNamespace)},
+	}
+	timestamp := state.LastRun
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cloudMetricTimeout)
+	defer cancel()
+	_, err := c.client.PutMetricDataWithContext(ctx, &cloudwatch.PutMetricDataInput{
+		Namespace: aws.String(c.namespace),
+		MetricData: []*cloudwatch.MetricDatum{
+			{
+				MetricName: aws.String("CheckOK"),
+				Dimensions: dimensions,
+				Timestamp:  aws.Time(timestamp),
+				Unit:       aws.String(cloudwatch.StandardUnitNone),
+				Value:      aws.Float64(float64(okValue(state))),
+			},
+			{
+				MetricName: aws.String("RunDuration"),
+				Dimensions: dimensions,
+				Timestamp:  aws.Time(timestamp),
+				Unit:       aws.String(cloudwatch.StandardUnitSeconds),
+				Value:      aws.Float64(runDurationSeconds(state)),
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error putting metric data to cloudwatch: %w", err)
+	}
+	return nil
+}
+
+// gcpMonitoringStateStore publishes check results to GCP Monitoring as custom metrics with the check name and
+// namespace as labels.  It authenticates with the service account of the pod from the GKE metadata server.
+type gcpMonitoringStateStore struct {
+	project   string
+	prefix    string // the prefix of the metric types, such as custom.googleapis.com/kuberhealthy
+	endpoint  string
+	tokenURL  string
+	client    *http.Client
+	tokenLock sync.Mutex
+	token     string
+	expires   time.Time
+}
+
+// newGCPMonitoringStateStore creates a GCP Monitoring state store for the supplied project.  A blank prefix uses
+// the default metric prefix.
+func newGCPMonitoringStateStore(project string, prefix string) (*gcpMonitoringStateStore, error) {
+	if len(project) == 0 {
+		return nil, errors.New("a gcp project must be configured")
+	}
+	if len(prefix) == 0 {
+		prefix = defaultGCPMetricPrefix
+	}
+	return &gcpMonitoringStateStore{
+		project:  project,
+		prefix:   prefix,
+		endpoint: gcpMonitoringEndpoint,
+		tokenURL: gcpMetadataTokenURL,
+		client:   &http.Client{Timeout: cloudMetricTimeout},
+	}, nil
+}
+
+// Name returns the name of the state store for logs and metrics
+func (g *gcpMonitoringStateStore) Name() string {
+	return "gcp-monitoring"
+}
+
+// accessToken returns the access token of the pod's service account.  Tokens are cached until shortly before
+// they expire.
+func (g *gcpMonitoringStateStore) accessToken() (string, error) {
+	g.tokenLock.Lock()
+	defer g.tokenLock.Unlock()
+	if len(g.token) > 0 && time.Now().Before(g.expires) {
+		return g.token, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, g.tokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error fetching gcp access token from the metadata server: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata server responded with status %d when fetching a gcp access token", resp.StatusCode)
+	}
+
+	token := struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return "", fmt.Errorf("error decoding gcp access token: %w", err)
+	}
+	g.token = token.AccessToken
+	g.expires = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return g.token, nil
+}
+
+// gcpTimeSeries is a single point of a custom metric in the GCP Monitoring API
+type gcpTimeSeries struct {
+	Metric struct {
+		Type   string            `json:"type"`
+		Labels map[string]string `json:"labels"`
+	} `json:"metric"`
+	Resource struct {
+		Type   string            `json:"type"`
+		Labels map[string]string `json:"labels"`
+	} `json:"resource"`
+	Points []gcpPoint `json:"points"`
+}
+
+// gcpPoint is the value of a time series at a point in time
+type gcpPoint struct {
+	Interval struct {
+		EndTime string `json:"endTime"`
+	} `json:"interval"`
+	Value map[string]interface{} `json:"value"`
+}
+
+// timeSeries builds a time series of a check result metric
+func (g *gcpMonitoringStateStore) timeSeries(metric string, checkName string, checkNamespace string, at time.Time, value map[string]interface{}) gcpTimeSeries {
+	series := gcpTimeSeries{}
+	series.Metric.Type = g.prefix + "/" + metric
+	series.Metric.Labels = map[string]string{"check": checkName, "namespace": checkNamespace}
+	series.Resource.Type = "global"
+	series.Resource.Labels = map[string]string{"project_id": g.project}
+	point := gcpPoint{Value: value}
+	point.Interval.EndTime = at.UTC().Format(time.RFC3339Nano)
+	series.Points = []gcpPoint{point}
+	return series
+}
+
+// SetCheckState publishes whether a check is OK and how long its run took
+func (g *gcpMonitoringStateStore) SetCheckState(checkName string, checkNamespace string, state health.WorkloadDetails) error {
+	at := state.LastRun
+	if at.IsZero() {
+		at = time.Now()
+	}
+	body, err := json.Marshal(map[string][]gcpTimeSeries{
+		"timeSeries": {
+			g.timeSeries("check_ok", checkName, checkNamespace, at, map[string]interface{}{"int64Value": strconv.Itoa(okValue(state))}),
+			g.timeSeries("run_duration_seconds", checkName, checkNamespace, at, map[string]interface{}{"doubleValue": runDurationSeconds(state)}),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error marshaling gcp time series: %w", err)
+	}
+
+	token, err := g.accessToken()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, g.endpoint+"/projects/"+g.project+"/timeSeries", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error writing time series to gcp monitoring: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("gcp monitoring responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
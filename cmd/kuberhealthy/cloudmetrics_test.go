@@ -0,0 +1,117 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// fakeCloudWatch records the metric data put to it
+type fakeCloudWatch struct {
+	cloudwatchiface.CloudWatchAPI
+	inputs []*cloudwatch.PutMetricDataInput
+}
+
+// PutMetricDataWithContext records the metric data
+func (f *fakeCloudWatch) PutMetricDataWithContext(ctx aws.Context, input *cloudwatch.PutMetricDataInput, opts ...request.Option) (*cloudwatch.PutMetricDataOutput, error) {
+	f.inputs = append(f.inputs, input)
+	return &cloudwatch.PutMetricDataOutput{}, nil
+}
+
+// TestCloudWatchStateStore ensures that check results are put to cloudwatch with the check as dimensions
+func TestCloudWatchStateStore(t *testing.T) {
+	fake := &fakeCloudWatch{}
+	store := &cloudWatchStateStore{client: fake, namespace: "Kuberhealthy"}
+
+	err := store.SetCheckState("dns", "kuberhealthy", health.WorkloadDetails{OK: true, RunDuration: "2.5s"})
+	if err != nil {
+		t.Fatal("unexpected error putting metric data:", err)
+	}
+	if len(fake.inputs) != 1 || aws.StringValue(fake.inputs[0].Namespace) != "Kuberhealthy" {
+		t.Fatalf("expected one put to the Kuberhealthy namespace, got %v", fake.inputs)
+	}
+	data := fake.inputs[0].MetricData
+	if len(data) != 2 || aws.Float64Value(data[0].Value) != 1 || aws.Float64Value(data[1].Value) != 2.5 {
+		t.Fatalf("expected CheckOK of 1 and RunDuration of 2.5, got %v", data)
+	}
+	dimensions := data[0].Dimensions
+	if aws.StringValue(dimensions[0].Value) != "dns" || aws.StringValue(dimensions[1].Value) != "kuberhealthy" {
+		t.Fatalf("expected the check name and namespace as dimensions, got %v", dimensions)
+	}
+}
+
+// TestGCPMonitoringStateStore ensures that check results are written to gcp monitoring as time series labeled
+// with the check and that the metadata server token is reused
+func TestGCPMonitoringStateStore(t *testing.T) {
+	var tokenRequests int
+	var written []gcpTimeSeries
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			tokenRequests++
+			if r.Header.Get("Metadata-Flavor") != "Google" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Write([]byte(`{"access_token":"secret","expires_in":3600,"token_type":"Bearer"}`))
+		case "/projects/my-project/timeSeries":
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			body := map[string][]gcpTimeSeries{}
+			json.NewDecoder(r.Body).Decode(&body)
+			written = append(written, body["timeSeries"]...)
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	store, err := newGCPMonitoringStateStore("my-project", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.endpoint = server.URL
+	store.tokenURL = server.URL + "/token"
+
+	for i := 0; i < 2; i++ {
+		err = store.SetCheckState("dns", "kuberhealthy", health.WorkloadDetails{OK: false, RunDuration: "1s"})
+		if err != nil {
+			t.Fatal("unexpected error writing time series:", err)
+		}
+	}
+	if tokenRequests != 1 {
+		t.Fatalf("expected the access token to be fetched once, got %d", tokenRequests)
+	}
+	if len(written) != 4 {
+		t.Fatalf("expected 4 time series to be written, got %d", len(written))
+	}
+	series := written[0]
+	if series.Metric.Type != defaultGCPMetricPrefix+"/check_ok" || series.Metric.Labels["check"] != "dns" || series.Metric.Labels["namespace"] != "kuberhealthy" {
+		t.Fatalf("expected a check_ok time series labeled with the check, got %+v", series.Metric)
+	}
+	if series.Points[0].Value["int64Value"] != "0" {
+		t.Fatalf("expected a failing check to write 0, got %v", series.Points[0].Value)
+	}
+}
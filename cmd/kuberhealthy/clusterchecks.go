@@ -0,0 +1,140 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// clusterScopedCheckAnnotation marks a khcheck as cluster-scoped.  The khstates of cluster-scoped checks are kept
+// in the cluster checks namespace instead of the namespace of the khcheck.
+const clusterScopedCheckAnnotation = "comcast.github.io/cluster-scoped"
+
+// stateNamespaceAnnotation names the namespace that the khstate of a khcheck is kept in.  It takes precedence over
+// the cluster-scoped annotation.
+const stateNamespaceAnnotation = "kuberhealthy.io/state-namespace"
+
+// annotatedStateNamespaces holds the state namespace of every loaded khcheck with a valid state namespace
+// annotation keyed by stateRoutingKey
+var annotatedStateNamespaces = struct {
+	sync.RWMutex
+	checks map[string]string
+}{checks: make(map[string]string)}
+
+// clusterScopedChecks holds the stateRoutingKey of every loaded khcheck that is cluster-scoped
+var clusterScopedChecks = struct {
+	sync.RWMutex
+	checks map[string]bool
+}{checks: make(map[string]bool)}
+
+// stateRoutingKey returns the key that the state namespace of a check or job is routed by.  A khcheck and a khjob
+// can have the same namespace and name, so the kind of workload is part of the key and the routing of a khcheck
+// never applies to a khjob.
+func stateRoutingKey(workload health.KHWorkload, checkNamespace string, checkName string) string {
+	return string(workload) + "/" + checkNamespace + "/" + checkName
+}
+
+// workloadOf returns the kind of workload that details belong to.  Details without a workload type belong to a
+// khcheck.
+func workloadOf(details health.WorkloadDetails) health.KHWorkload {
+	if details.IsKHJob() {
+		return health.KHJob
+	}
+	return health.KHCheck
+}
+
+// annotatedStateNamespace returns the namespace named by the state namespace annotation of a khcheck.  It is blank
+// when the khcheck is not annotated.  An error is returned when the namespace can not hold khstates, and the
+// annotation must then be ignored.
+func annotatedStateNamespace(annotations map[string]string) (string, error) {
+	stateNamespace, ok := annotations[stateNamespaceAnnotation]
+	if !ok {
+		return "", nil
+	}
+	if problems := validation.IsDNS1123Label(stateNamespace); len(problems) > 0 {
+		return "", fmt.Errorf("%s is not a valid namespace name: %s", stateNamespace, strings.Join(problems, ", "))
+	}
+	if !stateNamespaceManaged(stateNamespace) {
+		return "", fmt.Errorf("namespace %s is excluded from state management", stateNamespace)
+	}
+	if len(listenNamespace) > 0 && stateNamespace != listenNamespace {
+		return "", fmt.Errorf("kuberhealthy only reads khstates in namespace %s", listenNamespace)
+	}
+	return stateNamespace, nil
+}
+
+// khCheckStateNamespace returns the namespace that the khstate of a khcheck lives in based on its annotations.  A
+// valid state namespace annotation wins, then cluster-scoped khchecks go to the cluster checks namespace.
+func khCheckStateNamespace(checkNamespace string, annotations map[string]string) string {
+	if stateNamespace, err := annotatedStateNamespace(annotations); err == nil && len(stateNamespace) > 0 {
+		return stateNamespace
+	}
+	if len(cfg.ClusterChecksNamespace) > 0 && annotations[clusterScopedCheckAnnotation] == "true" {
+		return cfg.ClusterChecksNamespace
+	}
+	return checkNamespace
+}
+
+// setClusterScopedChecks replaces the set of cluster-scoped checks with the supplied stateRoutingKey keys
+func setClusterScopedChecks(checks map[string]bool) {
+	clusterScopedChecks.Lock()
+	defer clusterScopedChecks.Unlock()
+	clusterScopedChecks.checks = checks
+}
+
+// setAnnotatedStateNamespaces replaces the state namespaces of annotated checks with the supplied ones keyed by
+// stateRoutingKey
+func setAnnotatedStateNamespaces(checks map[string]string) {
+	annotatedStateNamespaces.Lock()
+	defer annotatedStateNamespaces.Unlock()
+	annotatedStateNamespaces.checks = checks
+}
+
+// isClusterScopedCheck indicates if a loaded khcheck is cluster-scoped
+func isClusterScopedCheck(checkName string, checkNamespace string) bool {
+	return isClusterScopedWorkload(checkName, checkNamespace, health.KHCheck)
+}
+
+// isClusterScopedWorkload indicates if a loaded check or job is cluster-scoped.  Only khchecks can be.
+func isClusterScopedWorkload(checkName string, checkNamespace string, workload health.KHWorkload) bool {
+	clusterScopedChecks.RLock()
+	defer clusterScopedChecks.RUnlock()
+	return clusterScopedChecks.checks[stateRoutingKey(workload, checkNamespace, checkName)]
+}
+
+// stateNamespaceForCheck returns the namespace that the khstate of a khcheck lives in
+func stateNamespaceForCheck(checkName string, checkNamespace string) string {
+	return stateNamespaceForWorkload(checkName, checkNamespace, health.KHCheck)
+}
+
+// stateNamespaceForWorkload returns the namespace that the khstate of a check or job lives in.  khchecks annotated
+// with a state namespace are routed to it, cluster-scoped khchecks are routed to the cluster checks namespace and
+// all other checks and jobs use their own namespace.
+func stateNamespaceForWorkload(checkName string, checkNamespace string, workload health.KHWorkload) string {
+	key := stateRoutingKey(workload, checkNamespace, checkName)
+	annotatedStateNamespaces.RLock()
+	stateNamespace, ok := annotatedStateNamespaces.checks[key]
+	annotatedStateNamespaces.RUnlock()
+	if ok {
+		return stateNamespace
+	}
+	if len(cfg.ClusterChecksNamespace) > 0 && isClusterScopedWorkload(checkName, checkNamespace, workload) {
+		return cfg.ClusterChecksNamespace
+	}
+	return checkNamespace
+}
@@ -0,0 +1,126 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// TestStateNamespaceForCheck ensures that only cluster-scoped checks are routed to the cluster checks namespace
+func TestStateNamespaceForCheck(t *testing.T) {
+	originalNamespace := cfg.ClusterChecksNamespace
+	defer func() {
+		cfg.ClusterChecksNamespace = originalNamespace
+		setClusterScopedChecks(make(map[string]bool))
+	}()
+
+	annotations := map[string]string{clusterScopedCheckAnnotation: "true"}
+	setClusterScopedChecks(map[string]bool{stateRoutingKey(health.KHCheck, "team-a", "dns"): true})
+
+	cfg.ClusterChecksNamespace = ""
+	if stateNamespaceForCheck("dns", "team-a") != "team-a" || khCheckStateNamespace("team-a", annotations) != "team-a" {
+		t.Fatal("check was routed to a cluster checks namespace when none is configured")
+	}
+
+	cfg.ClusterChecksNamespace = "kuberhealthy-cluster"
+	if stateNamespaceForCheck("dns", "team-a") != "kuberhealthy-cluster" {
+		t.Fatal("cluster-scoped check was not routed to the cluster checks namespace")
+	}
+	if khCheckStateNamespace("team-a", annotations) != "kuberhealthy-cluster" {
+		t.Fatal("annotated khcheck was not routed to the cluster checks namespace")
+	}
+	if stateNamespaceForCheck("deployment", "team-a") != "team-a" {
+		t.Fatal("namespaced check was routed to the cluster checks namespace")
+	}
+	if khCheckStateNamespace("team-a", map[string]string{}) != "team-a" {
+		t.Fatal("khcheck without the annotation was routed to the cluster checks namespace")
+	}
+}
+
+// TestAnnotatedStateNamespace ensures that a valid state namespace annotation routes the khstate of a check and
+// takes precedence over the cluster-scoped annotation, while invalid annotations fall back to the default routing
+func TestAnnotatedStateNamespace(t *testing.T) {
+	originalNamespace, originalExcluded, originalListen := cfg.ClusterChecksNamespace, cfg.ExcludedStateNamespaces, listenNamespace
+	defer func() {
+		cfg.ClusterChecksNamespace, cfg.ExcludedStateNamespaces, listenNamespace = originalNamespace, originalExcluded, originalListen
+		setAnnotatedStateNamespaces(make(map[string]string))
+	}()
+	cfg.ClusterChecksNamespace = "kuberhealthy-cluster"
+	cfg.ExcludedStateNamespaces = []string{"kube-system"}
+	listenNamespace = ""
+
+	annotations := map[string]string{stateNamespaceAnnotation: "team-a-states", clusterScopedCheckAnnotation: "true"}
+	if khCheckStateNamespace("team-a", annotations) != "team-a-states" {
+		t.Fatal("expected the state namespace annotation to win over the cluster-scoped annotation")
+	}
+	for _, invalid := range []string{"Team_A", "kube-system"} {
+		annotations[stateNamespaceAnnotation] = invalid
+		if _, err := annotatedStateNamespace(annotations); err == nil {
+			t.Fatal("expected an error for state namespace", invalid)
+		}
+		if khCheckStateNamespace("team-a", annotations) != "kuberhealthy-cluster" {
+			t.Fatal("expected an invalid state namespace annotation to fall back to the default routing, got", khCheckStateNamespace("team-a", annotations))
+		}
+	}
+	listenNamespace = "kuberhealthy"
+	if _, err := annotatedStateNamespace(map[string]string{stateNamespaceAnnotation: "team-a-states"}); err == nil {
+		t.Fatal("expected an error for a state namespace that kuberhealthy does not read")
+	}
+
+	// reads and writes of loaded checks are routed to the annotated namespace
+	setAnnotatedStateNamespaces(map[string]string{stateRoutingKey(health.KHCheck, "team-a", "dns"): "team-a-states"})
+	if stateNamespaceForCheck("dns", "team-a") != "team-a-states" || stateNamespaceForCheck("deployment", "team-a") != "team-a" {
+		t.Fatal("expected only the annotated check to be routed to its state namespace")
+	}
+}
+
+// TestGetJobStateReadsStateNamespace ensures that the khstate of a job is read from the namespace that it is
+// created and written in, even when a cluster-scoped khcheck has the same namespace and name
+func TestGetJobStateReadsStateNamespace(t *testing.T) {
+	originalNamespace := cfg.ClusterChecksNamespace
+	defer func() {
+		cfg.ClusterChecksNamespace = originalNamespace
+		setClusterScopedChecks(make(map[string]bool))
+	}()
+	cfg.ClusterChecksNamespace = "kuberhealthy-cluster"
+	setClusterScopedChecks(map[string]bool{stateRoutingKey(health.KHCheck, "team-a", "backup"): true})
+	if stateNamespaceForWorkload("backup", "team-a", health.KHJob) != "team-a" || isClusterScopedWorkload("backup", "team-a", health.KHJob) {
+		t.Fatal("a cluster-scoped khcheck rerouted the khstate of a khjob with the same name")
+	}
+
+	stored := khstatecrd.NewKuberhealthyState("backup", health.WorkloadDetails{OK: true, RunID: "run-7"})
+	stored.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	stored.Kind = "KuberhealthyState"
+	stored.Namespace = "team-a"
+	useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodGet || !strings.Contains(r.URL.Path, "/namespaces/team-a/") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(stored)
+	})
+
+	state, err := getJobState(&FakeCheck{CheckName: "backup", Namespace: "team-a"})
+	if err != nil {
+		t.Fatal("unexpected error reading the job state:", err)
+	}
+	if state.RunID != "run-7" {
+		t.Fatal("expected the job state to be read from the namespace of the job, got", state)
+	}
+}
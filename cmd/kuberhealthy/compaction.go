@@ -0,0 +1,82 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// The ways results that are identical to the stored result are written
+const (
+	identicalWriteModeWrite     = "write"     // identical results are written like any other.  This is the default
+	identicalWriteModeHeartbeat = "heartbeat" // only the fields that show the check is still running are written
+	identicalWriteModeSkip      = "skip"      // nothing is written
+)
+
+// compactsIdenticalWrites returns true if results that are identical to the stored result are not written in full
+func compactsIdenticalWrites() bool {
+	return cfg.IdenticalWriteMode == identicalWriteModeHeartbeat || cfg.IdenticalWriteMode == identicalWriteModeSkip
+}
+
+// withoutLiveness returns the details without the fields that change on every run even when the result is the same.
+// Empty lists and maps are dropped so that they compare the same as missing ones.
+func withoutLiveness(details health.WorkloadDetails) health.WorkloadDetails {
+	details.LastRun = time.Time{}
+	details.AuthoritativePod = ""
+	details.AuthoritativeNamespace = ""
+	details.RunID = ""
+	details.RunDuration = ""
+	details.ScheduleDelay = ""
+	details.LastSuccess = time.Time{}
+	details.Generation = 0
+	if len(details.Errors) == 0 {
+		details.Errors = nil
+	}
+	if len(details.Annotations) == 0 {
+		details.Annotations = nil
+	}
+	if len(details.ArtifactURLs) == 0 {
+		details.ArtifactURLs = nil
+	}
+	return details
+}
+
+// identicalResult returns true if a result is field for field the same as the stored details, apart from the fields
+// that show the check is still running
+func identicalResult(stored health.WorkloadDetails, state health.WorkloadDetails) bool {
+	storedJSON, err := json.Marshal(withoutLiveness(stored))
+	if err != nil {
+		return false
+	}
+	stateJSON, err := json.Marshal(withoutLiveness(state))
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(storedJSON, stateJSON)
+}
+
+// compactIdenticalWrite updates the stored details of a result that is identical to them according to the identical
+// write mode.  It returns true if anything should be written.
+func compactIdenticalWrite(details *health.WorkloadDetails, state health.WorkloadDetails) bool {
+	if cfg.IdenticalWriteMode == identicalWriteModeSkip {
+		return false
+	}
+	recordHeartbeat(details, state)
+	details.RunDuration = state.RunDuration
+	details.ScheduleDelay = state.ScheduleDelay
+	details.LastSuccess = state.LastSuccess
+	return true
+}
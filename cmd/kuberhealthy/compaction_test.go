@@ -0,0 +1,92 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// TestIdenticalWriteCompaction ensures that results identical to the stored result only refresh the heartbeat
+// fields in heartbeat mode, are not written at all in skip mode and that changed results are always written
+func TestIdenticalWriteCompaction(t *testing.T) {
+	originalMode := cfg.IdenticalWriteMode
+	defer func() { cfg.IdenticalWriteMode = originalMode }()
+	stateVersions.reset()
+	defer stateVersions.reset()
+
+	stored := khstatecrd.NewKuberhealthyState("identical-check", health.WorkloadDetails{Status: health.StatusUnknown, Errors: []string{}})
+	stored.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	stored.Kind = "KuberhealthyState"
+	stored.Namespace = "kuberhealthy"
+	var puts int
+	useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPut {
+			puts++
+			stored = khstatecrd.KuberhealthyState{}
+			json.NewDecoder(r.Body).Decode(&stored)
+		}
+		json.NewEncoder(w).Encode(stored)
+	})
+
+	write := func(state health.WorkloadDetails) bool {
+		written, err := setCheckStateResourceIf("identical-check", "kuberhealthy", state, nil)
+		if err != nil {
+			t.Fatal("unexpected error writing the khstate:", err)
+		}
+		return written
+	}
+
+	cfg.IdenticalWriteMode = identicalWriteModeHeartbeat
+	if !write(health.WorkloadDetails{OK: true, Errors: []string{}, RunDuration: "1s", RunID: "run-1"}) {
+		t.Fatal("expected the first result to be written")
+	}
+	firstRun := stored.Spec.LastRun
+
+	// the same result with new liveness fields only refreshes them
+	if write(health.WorkloadDetails{OK: true, RunDuration: "2s", RunID: "run-2"}) {
+		t.Fatal("expected the identical result to not be written in full")
+	}
+	if puts != 2 || stored.Spec.RunID != "run-2" || stored.Spec.RunDuration != "2s" || stored.Spec.LastRun.Before(firstRun) {
+		t.Fatalf("expected a heartbeat write with the new run, got %d writes and %+v", puts, stored.Spec)
+	}
+
+	// nothing is written in skip mode
+	cfg.IdenticalWriteMode = identicalWriteModeSkip
+	if write(health.WorkloadDetails{OK: true, Errors: []string{}, RunID: "run-3"}) {
+		t.Fatal("expected the identical result to be skipped")
+	}
+	if puts != 2 || stored.Spec.RunID != "run-2" {
+		t.Fatalf("expected nothing to be written in skip mode, got %d writes and %+v", puts, stored.Spec)
+	}
+
+	// a changed result is written in full
+	if !write(health.WorkloadDetails{OK: false, Errors: []string{"broken"}, RunID: "run-4"}) {
+		t.Fatal("expected the changed result to be written")
+	}
+	if puts != 3 || stored.Spec.OK || stored.Spec.RunID != "run-4" {
+		t.Fatalf("expected the changed result to be written, got %d writes and %+v", puts, stored.Spec)
+	}
+
+	// identical results are written like any other by default
+	cfg.IdenticalWriteMode = ""
+	write(health.WorkloadDetails{OK: true, Errors: []string{}, RunID: "run-5"})
+	write(health.WorkloadDetails{OK: true, Errors: []string{}, RunID: "run-6"})
+	if puts != 5 || stored.Spec.RunID != "run-6" {
+		t.Fatalf("expected every result to be written, got %d writes and %+v", puts, stored.Spec)
+	}
+}
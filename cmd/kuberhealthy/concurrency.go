@@ -0,0 +1,291 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// checkRunLimiter limits how many checks run at once.  Every check has its own slot so that runs of the same check
+// never overlap, and all checks share a global pool of slots when a global limit is set.  When the global pool is
+// full, runs of higher priority checks are given the next free slot first.
+type checkRunLimiter struct {
+	sync.Mutex
+	limit   int                      // 0 when the number of checks running at once is unlimited
+	running int                      // global slots taken
+	waiting []*runWaiter             // runs waiting for a global slot, highest priority first
+	checks  map[string]chan struct{} // keyed by namespace/name
+	active  int
+	queued  int
+	arrived uint64 // counts waiting runs so that runs of the same priority are started in the order they queued
+
+	priorities map[int]bool // every priority that has waited, so that its queued gauge drops back to 0
+}
+
+// runWaiter is a run waiting for a global slot
+type runWaiter struct {
+	priority int
+	arrived  uint64
+	ready    chan struct{} // closed when the run is given a slot
+	deferred chan struct{} // closed when a run of a higher priority check is given a slot first
+	passed   bool
+}
+
+// checkRunLimits is the run limiter used by every check run
+var checkRunLimits = newCheckRunLimiter(0)
+
+// newCheckRunLimiter creates a run limiter that allows maxConcurrent checks to run at once.  0 is unlimited.
+func newCheckRunLimiter(maxConcurrent int) *checkRunLimiter {
+	return &checkRunLimiter{
+		limit:      maxConcurrent,
+		checks:     make(map[string]chan struct{}),
+		priorities: make(map[int]bool),
+	}
+}
+
+// checkSlot returns the slot of a single check, creating it if needed
+func (l *checkRunLimiter) checkSlot(key string) chan struct{} {
+	l.Lock()
+	defer l.Unlock()
+	slot, ok := l.checks[key]
+	if !ok {
+		slot = make(chan struct{}, 1)
+		l.checks[key] = slot
+	}
+	return slot
+}
+
+// tryAcquireGlobal takes a global slot without waiting
+func (l *checkRunLimiter) tryAcquireGlobal() bool {
+	l.Lock()
+	defer l.Unlock()
+	if l.limit > 0 && l.running >= l.limit {
+		return false
+	}
+	l.running++
+	return true
+}
+
+// tryAcquire takes the check's slot and a global slot without waiting.  Nothing is held if it returns false.
+func (l *checkRunLimiter) tryAcquire(slot chan struct{}) bool {
+	select {
+	case slot <- struct{}{}:
+	default:
+		return false
+	}
+	if l.tryAcquireGlobal() {
+		return true
+	}
+	<-slot
+	return false
+}
+
+// acquireGlobal waits for a global slot.  Runs of higher priority checks are given free slots first.  If a run of
+// a higher priority check is given a slot while this run waits, onDeferred is called once.
+func (l *checkRunLimiter) acquireGlobal(ctx context.Context, priority int, onDeferred func()) error {
+	l.Lock()
+	if l.limit <= 0 || l.running < l.limit {
+		l.running++
+		l.Unlock()
+		return nil
+	}
+	w := &runWaiter{priority: priority, arrived: l.arrived, ready: make(chan struct{}), deferred: make(chan struct{})}
+	l.arrived++
+	i := sort.Search(len(l.waiting), func(i int) bool {
+		return l.waiting[i].priority < priority
+	})
+	l.waiting = append(l.waiting, nil)
+	copy(l.waiting[i+1:], l.waiting[i:])
+	l.waiting[i] = w
+	l.setQueuedByPriority()
+	l.Unlock()
+
+	deferred := w.deferred
+	for {
+		select {
+		case <-w.ready:
+			return nil
+		case <-deferred:
+			deferred = nil
+			onDeferred()
+		case <-ctx.Done():
+			l.Lock()
+			defer l.Unlock()
+			select {
+			case <-w.ready:
+				// the slot was given to this run just as it was canceled, so it is handed on
+				l.running--
+				l.grantWaiting()
+			default:
+				l.removeWaiting(w)
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+// releaseGlobal frees a global slot and gives it to the highest priority waiting run
+func (l *checkRunLimiter) releaseGlobal() {
+	l.Lock()
+	defer l.Unlock()
+	l.running--
+	l.grantWaiting()
+}
+
+// grantWaiting gives free global slots to waiting runs, highest priority first.  Runs of lower priority checks that
+// were waiting before a run that was given a slot are marked deferred.  The limiter must be locked.
+func (l *checkRunLimiter) grantWaiting() {
+	for len(l.waiting) > 0 && l.running < l.limit {
+		next := l.waiting[0]
+		l.waiting = l.waiting[1:]
+		l.running++
+		close(next.ready)
+		for _, w := range l.waiting {
+			if w.priority < next.priority && w.arrived < next.arrived && !w.passed {
+				w.passed = true
+				close(w.deferred)
+				checkRunsDeferred.Inc(strconv.Itoa(w.priority))
+			}
+		}
+	}
+	l.setQueuedByPriority()
+}
+
+// removeWaiting removes a run that stopped waiting.  The limiter must be locked.
+func (l *checkRunLimiter) removeWaiting(w *runWaiter) {
+	for i := range l.waiting {
+		if l.waiting[i] == w {
+			l.waiting = append(l.waiting[:i], l.waiting[i+1:]...)
+			break
+		}
+	}
+	l.setQueuedByPriority()
+}
+
+// setQueuedByPriority updates the number of runs waiting on a global slot by priority.  The limiter must be locked.
+func (l *checkRunLimiter) setQueuedByPriority() {
+	counts := make(map[int]int)
+	for _, w := range l.waiting {
+		counts[w.priority]++
+		l.priorities[w.priority] = true
+	}
+	for priority := range l.priorities {
+		checkRunsQueuedByPriority.Set(float64(counts[priority]), strconv.Itoa(priority))
+	}
+}
+
+// acquire waits until the check identified by key is allowed to run.  If the check can not run right away,
+// onQueued is called before waiting, and onDeferred is called if a higher priority check is started first while
+// it waits.  The returned func must be called when the run is done.  An error is only returned if the context is
+// canceled while waiting.
+func (l *checkRunLimiter) acquire(ctx context.Context, key string, priority int, onQueued func(), onDeferred func()) (func(), error) {
+	slot := l.checkSlot(key)
+
+	if !l.tryAcquire(slot) {
+		l.addQueued(1)
+		defer l.addQueued(-1)
+		onQueued()
+
+		// the check's own slot is always taken before a global slot so that waiting runs can not deadlock
+		select {
+		case slot <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		err := l.acquireGlobal(ctx, priority, onDeferred)
+		if err != nil {
+			<-slot
+			return nil, err
+		}
+	}
+
+	l.addActive(1)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.addActive(-1)
+			l.releaseGlobal()
+			<-slot
+		})
+	}, nil
+}
+
+func (l *checkRunLimiter) addActive(n int) {
+	l.Lock()
+	defer l.Unlock()
+	l.active += n
+	checkRunsActive.Set(float64(l.active))
+}
+
+func (l *checkRunLimiter) addQueued(n int) {
+	l.Lock()
+	defer l.Unlock()
+	l.queued += n
+	checkRunsQueued.Set(float64(l.queued))
+}
+
+// checkPriorities holds the priority of every loaded khcheck that sets one, keyed by namespace/name
+var checkPriorities = struct {
+	sync.RWMutex
+	checks map[string]int
+}{checks: make(map[string]int)}
+
+// setCheckPriorities replaces the check priorities
+func setCheckPriorities(priorities map[string]int) {
+	checkPriorities.Lock()
+	defer checkPriorities.Unlock()
+	checkPriorities.checks = priorities
+}
+
+// checkPriority returns the priority of a loaded check.  Checks that do not set one have a priority of 0.
+func checkPriority(checkName string, checkNamespace string) int {
+	checkPriorities.RLock()
+	defer checkPriorities.RUnlock()
+	return checkPriorities.checks[checkNamespace+"/"+checkName]
+}
+
+// setCheckQueued records if a check run is waiting on the run limiter in the check's khstate.  The last result
+// of the check is left as it is.
+func setCheckQueued(checkName string, checkNamespace string, queued bool) {
+	err := updateCheckStateResource(checkName, stateNamespaceForCheck(checkName, checkNamespace), func(details *health.WorkloadDetails) bool {
+		if details.Queued == queued {
+			return false
+		}
+		details.Queued = queued
+		return true
+	})
+	if err != nil {
+		log.Warningln("Error setting queued to", queued, "for check", checkName, "in namespace", checkNamespace+":", err)
+	}
+}
+
+// setCheckDeferred records if a queued check run was passed over for a higher priority check in the check's khstate,
+// so that the status page shows why it has not run.  The last result of the check is left as it is.
+func setCheckDeferred(checkName string, checkNamespace string, deferred bool) {
+	err := updateCheckStateResource(checkName, stateNamespaceForCheck(checkName, checkNamespace), func(details *health.WorkloadDetails) bool {
+		if details.Deferred == deferred {
+			return false
+		}
+		details.Deferred = deferred
+		return true
+	})
+	if err != nil {
+		log.Warningln("Error setting deferred to", deferred, "for check", checkName, "in namespace", checkNamespace+":", err)
+	}
+}
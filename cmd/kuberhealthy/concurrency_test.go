@@ -0,0 +1,144 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestCheckRunLimiter ensures that runs beyond the global limit and overlapping runs of the same check are deferred
+// until a slot is released
+func TestCheckRunLimiter(t *testing.T) {
+	l := newCheckRunLimiter(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	notDeferred := func() {
+		t.Fatal("run was deferred while a slot was free")
+	}
+	releaseA, err := l.acquire(ctx, "kuberhealthy/a", 0, notDeferred, notDeferred)
+	if err != nil {
+		t.Fatal("unexpected error acquiring a free slot:", err)
+	}
+
+	// a second check must wait for the only global slot
+	deferred := make(chan struct{})
+	acquired := make(chan func())
+	go func() {
+		release, err := l.acquire(ctx, "kuberhealthy/b", 0, func() { close(deferred) }, func() {})
+		if err != nil {
+			t.Error("unexpected error waiting for a slot:", err)
+			return
+		}
+		acquired <- release
+	}()
+
+	select {
+	case <-deferred:
+	case <-time.After(time.Second):
+		t.Fatal("run over the global limit was not deferred")
+	}
+	select {
+	case <-acquired:
+		t.Fatal("run over the global limit was started")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	releaseA()
+	releaseA() // releasing twice must not free a second slot
+	var releaseB func()
+	select {
+	case releaseB = <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("deferred run was not started after a slot was released")
+	}
+	releaseB()
+
+	// runs of the same check never overlap even without a global limit
+	unlimited := newCheckRunLimiter(0)
+	releaseC, err := unlimited.acquire(ctx, "kuberhealthy/c", 0, notDeferred, notDeferred)
+	if err != nil {
+		t.Fatal("unexpected error acquiring a free slot:", err)
+	}
+	waitCtx, waitCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer waitCancel()
+	var overlapDeferred bool
+	_, err = unlimited.acquire(waitCtx, "kuberhealthy/c", 0, func() { overlapDeferred = true }, func() {})
+	if !overlapDeferred || err == nil {
+		t.Fatal("overlapping run of the same check was not deferred")
+	}
+	releaseC()
+}
+
+// TestCheckRunLimiterPriority ensures that waiting runs of higher priority checks are started first and that the
+// lower priority runs they pass over are deferred
+func TestCheckRunLimiterPriority(t *testing.T) {
+	l := newCheckRunLimiter(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	releaseA, err := l.acquire(ctx, "kuberhealthy/a", 0, func() {}, func() {})
+	if err != nil {
+		t.Fatal("unexpected error acquiring a free slot:", err)
+	}
+
+	// queue a run and wait until it is waiting on a global slot
+	type started struct {
+		name    string
+		release func()
+	}
+	starts := make(chan started)
+	queue := func(name string, priority int, onDeferred func()) {
+		l.Lock()
+		waitingBefore := len(l.waiting)
+		l.Unlock()
+		go func() {
+			release, err := l.acquire(ctx, "kuberhealthy/"+name, priority, func() {}, onDeferred)
+			if err != nil {
+				t.Error("unexpected error waiting for a slot:", err)
+				return
+			}
+			starts <- started{name: name, release: release}
+		}()
+		for ; ; time.Sleep(time.Millisecond) {
+			l.Lock()
+			waiting := len(l.waiting)
+			l.Unlock()
+			if waiting > waitingBefore {
+				return
+			}
+		}
+	}
+
+	lowDeferred := make(chan struct{})
+	queue("best-effort", 0, func() { close(lowDeferred) })
+	queue("critical", 10, func() { t.Error("the highest priority run was deferred") })
+
+	releaseA()
+	first := <-starts
+	if first.name != "critical" {
+		t.Fatal("expected the critical check to be started first, got", first.name)
+	}
+	select {
+	case <-lowDeferred:
+	case <-time.After(time.Second):
+		t.Fatal("expected the best effort check to be deferred")
+	}
+	first.release()
+	second := <-starts
+	if second.name != "best-effort" {
+		t.Fatal("expected the best effort check to be started second, got", second.name)
+	}
+	second.release()
+}
@@ -10,6 +10,18 @@ import (
 	"sigs.k8s.io/yaml"
 )
 
+// durationMap holds durations keyed by namespace/name for per-check overrides of global options
+type durationMap map[string]time.Duration
+
+// patternMap holds lists of patterns keyed by namespace/name for per-check overrides of global options
+type patternMap map[string][]string
+
+// stringMap holds strings by key, such as namespace/name for per-check overrides of global options
+type stringMap map[string]string
+
+// intMap holds numbers keyed by namespace/name for per-check overrides of global options
+type intMap map[string]int
+
 // Config holds all configurable options
 type Config struct {
 	kubeConfigFile            string
@@ -22,8 +34,114 @@ type Config struct {
 	InfluxDB                  string        `yaml:"influxDB,omitempty"`
 	EnableInflux              bool          `yaml:"enableInflux,omitempty"`
 	ExternalCheckReportingURL string        `yaml:"externalCheckReportingURL,omitempty"`
-	JobCleanupDuration      time.Duration 	`yaml:"jobCleanupDuration,omitempty"`
+	JobCleanupDuration        time.Duration `yaml:"jobCleanupDuration,omitempty"`
 	MaxCheckPods              int           `yaml:"maxCheckPods,omitempty"`
+	StateReadKubeConfigFile   string        `yaml:"stateReadKubeConfigFile,omitempty"`   // kubeconfig used only for khstate reads, such as one pointing at a cache proxy
+	ResetStatesOnStartup      bool          `yaml:"resetStatesOnStartup,omitempty"`      // set all khstates to Unknown when this instance first becomes master so old results are not shown as current
+	EnableAdminAPI            bool          `yaml:"enableAdminAPI,omitempty"`            // enables the /admin endpoints on the web server
+	StaticResultWindow        time.Duration `yaml:"staticResultWindow,omitempty"`        // flag checks whose result has not changed in this long. 0 disables detection
+	RemoteStateKubeConfigFile string        `yaml:"remoteStateKubeConfigFile,omitempty"` // kubeconfig of another cluster to also write khstates to, usually mounted from a secret
+	RemoteStateNamespace      string        `yaml:"remoteStateNamespace,omitempty"`      // namespace to write remote khstates to. defaults to the check's namespace
+	ResourceNameSanitizer     string        `yaml:"resourceNameSanitizer,omitempty"`     // how check names are turned into khstate names. "hash" guarantees unique names
+	KafkaBrokers              []string      `yaml:"kafkaBrokers,omitempty"`              // kafka brokers to publish check results to. publishing is disabled when empty
+	KafkaTopic                string        `yaml:"kafkaTopic,omitempty"`                // kafka topic to publish check results to
+	KafkaBufferSize           int           `yaml:"kafkaBufferSize,omitempty"`           // number of results to buffer for kafka before dropping new ones. defaults to 1000
+	MinWriteInterval          time.Duration `yaml:"minWriteInterval,omitempty"`          // minimum time between khstate writes of a check. 0 disables throttling
+	MinWriteIntervalOverrides durationMap   `yaml:"minWriteIntervalOverrides,omitempty"` // per-check minimum write intervals keyed by namespace/name
+	ClusterChecksNamespace    string        `yaml:"clusterChecksNamespace,omitempty"`    // namespace that holds the khstates of khchecks annotated as cluster-scoped
+	ResultTTL                 time.Duration `yaml:"resultTTL,omitempty"`                 // check results older than this are expired. 0 disables expiry
+	MaxConcurrentChecks       int           `yaml:"maxConcurrentChecks,omitempty"`       // maximum number of checks that run at once. 0 is unlimited
+	NotificationWebhookURL    string        `yaml:"notificationWebhookURL,omitempty"`    // URL to post a notification to when a check result changes. disabled when empty
+	NotificationTemplate      string        `yaml:"notificationTemplate,omitempty"`      // Go text/template for notification bodies. a default is used when empty
+	InitialGracePeriod        time.Duration `yaml:"initialGracePeriod,omitempty"`        // failures of new checks are shown as Unknown for this long. 0 disables it
+	InitialGraceOverrides     durationMap   `yaml:"initialGraceOverrides,omitempty"`     // per-check initial grace periods keyed by namespace/name
+	ReadOnly                  bool          `yaml:"readOnly,omitempty"`                  // only serve the status API from the khstate cache. checks are not run and nothing is written
+	MaxErrorLength            int           `yaml:"maxErrorLength,omitempty"`            // maximum length in bytes of a single check error. longer errors are truncated. defaults to 4096
+	FailingSeverities         []string      `yaml:"failingSeverities,omitempty"`         // severities whose errors fail the overall status. defaults to critical
+	StateUpdateStrategy       string        `yaml:"stateUpdateStrategy,omitempty"`       // update, merge-patch, json-patch or strategic-merge-patch. defaults to update
+	EnableOverallState        bool          `yaml:"enableOverallState,omitempty"`        // maintain a synthetic kuberhealthy-overall khstate that is OK only when every check is
+	AllowForeignTakeover      bool          `yaml:"allowForeignTakeover,omitempty"`      // allow overwriting khstates owned by kuberhealthy instances in other namespaces
+	ReliabilityWindow         time.Duration `yaml:"reliabilityWindow,omitempty"`         // the window check reliability is reported over. defaults to 24h
+	StateClientQPS            float32       `yaml:"stateClientQPS,omitempty"`            // queries per second allowed to the khstate client. defaults to the client-go default
+	StateClientBurst          int           `yaml:"stateClientBurst,omitempty"`          // burst of queries allowed to the khstate client. defaults to the client-go default
+	RecordResourceUsage       bool          `yaml:"recordResourceUsage,omitempty"`       // record the CPU and memory used by checker pods from metrics-server
+	FailingSummaryInterval    time.Duration `yaml:"failingSummaryInterval,omitempty"`    // how often to log a summary of failing checks. 0 disables the summary
+	ConfirmWrites             bool          `yaml:"confirmWrites,omitempty"`             // read every khstate back after writing it and retry if the result does not match
+	CloudWatchNamespace       string        `yaml:"cloudWatchNamespace,omitempty"`       // aws cloudwatch metric namespace to publish check results to. disabled when empty
+	GCPMonitoringProject      string        `yaml:"gcpMonitoringProject,omitempty"`      // gcp project to publish check results to gcp monitoring in. disabled when empty
+	GCPMetricPrefix           string        `yaml:"gcpMetricPrefix,omitempty"`           // prefix of the gcp monitoring metric types. defaults to custom.googleapis.com/kuberhealthy
+	StateNamespaces           []string      `yaml:"stateNamespaces,omitempty"`           // namespaces kuberhealthy manages state in. supports * wildcards. empty means all namespaces
+	ExcludedStateNamespaces   []string      `yaml:"excludedStateNamespaces,omitempty"`   // namespaces kuberhealthy never manages state in, even when allowed. supports * wildcards
+	StateCreateRetries        int           `yaml:"stateCreateRetries,omitempty"`        // how many times to retry creating a khstate after a transient api error. defaults to 4. -1 never retries
+	RecordLogTail             bool          `yaml:"recordLogTail,omitempty"`             // record the last lines logged by checker pods that report a failure
+	LogTailBytes              int           `yaml:"logTailBytes,omitempty"`              // maximum size of a recorded log tail. defaults to 2048 and can not exceed 16384
+	MaxStateBytes             int           `yaml:"maxStateBytes,omitempty"`             // maximum size in bytes of a check result stored in a khstate. disabled when 0
+	StateSizeMode             string        `yaml:"stateSizeMode,omitempty"`             // truncate or reject check results that are too large to store. defaults to truncate
+	StateBreakerThreshold     int           `yaml:"stateBreakerThreshold,omitempty"`     // failed khstate writes in a row that short-circuit writes. defaults to 5. -1 disables the circuit breaker
+	StateBreakerCooldown      time.Duration `yaml:"stateBreakerCooldown,omitempty"`      // how long khstate writes are short-circuited before probing the api server. defaults to 30s
+	OrphanedStateRetention    time.Duration `yaml:"orphanedStateRetention,omitempty"`    // how long the khstate of a deleted check is kept after its last run. defaults to 0, which deletes it right away
+	PostgresURL               string        `yaml:"postgresURL,omitempty"`               // postgres connection string to append check results to. disabled when empty
+	PostgresBufferSize        int           `yaml:"postgresBufferSize,omitempty"`        // number of results to buffer for postgres before dropping new ones. defaults to 1000
+	RedactPatterns            []string      `yaml:"redactPatterns,omitempty"`            // regular expressions whose matches in check errors, annotations and log tails are replaced with ***
+	RedactPatternOverrides    patternMap    `yaml:"redactPatternOverrides,omitempty"`    // per-check redaction patterns keyed by namespace/name. replaces redactPatterns for that check
+	StatusCacheTTL            time.Duration `yaml:"statusCacheTTL,omitempty"`            // how long rendered status page responses are served from memory. 0 disables the cache
+	EnableExemplars           bool          `yaml:"enableExemplars,omitempty"`           // serve metrics in the openmetrics format with run id exemplars to scrapers that ask for it
+	MaxClockSkew              time.Duration `yaml:"maxClockSkew,omitempty"`              // how far the clock of a checker can be from the clock of Kuberhealthy before its reports are flagged. defaults to 30s
+	WriteFilter               string        `yaml:"writeFilter,omitempty"`               // which check results are recorded: all, failures or changes. defaults to all
+	WriteFilterOverrides      stringMap     `yaml:"writeFilterOverrides,omitempty"`      // per-check write filters keyed by namespace/name
+	AggregateRuns             int           `yaml:"aggregateRuns,omitempty"`             // number of recent runs rolled up on the status page of every check. disabled when 0
+	AggregateFailThreshold    int           `yaml:"aggregateFailThreshold,omitempty"`    // failed runs among the rolled up runs that make the rollup failing. defaults to a majority
+	StateExportPath           string        `yaml:"stateExportPath,omitempty"`           // file to periodically export the state to. disabled when empty
+	StateExportFormat         string        `yaml:"stateExportFormat,omitempty"`         // json or csv. defaults to json
+	StateExportInterval       time.Duration `yaml:"stateExportInterval,omitempty"`       // how often the state is exported. defaults to 5m
+	StateExportKeep           int           `yaml:"stateExportKeep,omitempty"`           // number of export files to keep, including the latest. defaults to 5
+	DuplicateCheckMode        string        `yaml:"duplicateCheckMode,omitempty"`        // fail or warn when two checks share one khstate at startup. defaults to fail
+	TrendRuns                 int           `yaml:"trendRuns,omitempty"`                 // number of recent runs split in half to find the trend of a check. defaults to 20
+	NotificationQueueSize     int           `yaml:"notificationQueueSize,omitempty"`     // most notifications waiting to be sent before the oldest are dropped. defaults to 100
+	NotificationCoalescing    time.Duration `yaml:"notificationCoalescing,omitempty"`    // how long notifications wait so that repeats for the same check are sent once. defaults to 10s
+	TraceStateWrites          bool          `yaml:"traceStateWrites,omitempty"`          // log a span for every attempt and API call of every khstate write
+	AnnotateCheckerPods       bool          `yaml:"annotateCheckerPods,omitempty"`       // record each reported result on the checker pod so khstates can be rebuilt from pods
+	PrometheusURL             string        `yaml:"prometheusURL,omitempty"`             // prometheus that scrapes kuberhealthy, queried on startup to backfill run history. disabled when empty
+	PrometheusBackfillStep    time.Duration `yaml:"prometheusBackfillStep,omitempty"`    // resolution of the run history backfilled from prometheus. defaults to 5m
+	TerminatingNamespaceMode  string        `yaml:"terminatingNamespaceMode,omitempty"`  // skip or write khstates of checks in namespaces that are being deleted. defaults to skip
+	ResultProcessors          []string      `yaml:"resultProcessors,omitempty"`          // result processors that check results run through in order before they are written. defaults to redact and size
+	ElasticsearchURL          string        `yaml:"elasticsearchURL,omitempty"`          // elasticsearch to index check results in. disabled when empty
+	ElasticsearchIndex        string        `yaml:"elasticsearchIndex,omitempty"`        // index check results are indexed in. defaults to kuberhealthy
+	ElasticsearchUsername     string        `yaml:"elasticsearchUsername,omitempty"`     // username to index check results with basic auth
+	ElasticsearchPassword     string        `yaml:"elasticsearchPassword,omitempty"`     // password to index check results with basic auth
+	ElasticsearchAPIKey       string        `yaml:"elasticsearchAPIKey,omitempty"`       // base64 encoded api key to index check results with instead of basic auth
+	ElasticsearchFlush        time.Duration `yaml:"elasticsearchFlush,omitempty"`        // how often buffered check results are bulk indexed. defaults to 5s
+	ElasticsearchBufferSize   int           `yaml:"elasticsearchBufferSize,omitempty"`   // number of results to buffer for elasticsearch before dropping new ones. defaults to 1000
+	AlertAfterConsecutive     int           `yaml:"alertAfterConsecutive,omitempty"`     // failed runs in a row before a check is notified and shown as alerting. defaults to 1
+	AlertAfterOverrides       intMap        `yaml:"alertAfterOverrides,omitempty"`       // per-check consecutive failure thresholds keyed by namespace/name
+	AdaptiveWriteBackoff      bool          `yaml:"adaptiveWriteBackoff,omitempty"`      // adapts the wait between khstate write retries to the conflict rate of each khstate
+	WriteBackoffMin           time.Duration `yaml:"writeBackoffMin,omitempty"`           // the shortest wait between khstate write retries. defaults to 10ms
+	WriteBackoffMax           time.Duration `yaml:"writeBackoffMax,omitempty"`           // the longest wait between khstate write retries. defaults to 1s
+	CloudEventsSink           string        `yaml:"cloudEventsSink,omitempty"`           // http, https or nats url to send cloudevents for check result changes to. disabled when empty
+	CloudEventsSubject        string        `yaml:"cloudEventsSubject,omitempty"`        // nats subject cloudevents are published on. defaults to kuberhealthy.check.result
+	CloudEventsBufferSize     int           `yaml:"cloudEventsBufferSize,omitempty"`     // number of cloudevents to buffer before dropping new ones. defaults to 100
+	IdenticalWriteMode        string        `yaml:"identicalWriteMode,omitempty"`        // write, heartbeat or skip results identical to the stored result. defaults to write
+	StateLabels               stringMap     `yaml:"stateLabels,omitempty"`               // labels to inject onto khstates, each taken from the khcheck label or annotation it names
+	StateWriteTimeout         time.Duration `yaml:"stateWriteTimeout,omitempty"`         // how long a khstate write, including its retries, may take. defaults to 30s. -1 never times out
+	WriteTimeoutOverrides     durationMap   `yaml:"writeTimeoutOverrides,omitempty"`     // per-check khstate write timeouts keyed by namespace/name
+	StatusSocketPath          string        `yaml:"statusSocketPath,omitempty"`          // also serve the status page and check status API on a unix socket at this path. blank disables the socket
+	StatusSocketMode          string        `yaml:"statusSocketMode,omitempty"`          // the octal file mode of the status socket. defaults to 0660
+	FieldChangeEvents         []string      `yaml:"fieldChangeEvents,omitempty"`         // result fields to send watch events for when they change: errors, duration, status or annotations
+	MaxFieldChangeEvents      int           `yaml:"maxFieldChangeEvents,omitempty"`      // the most field change events sent for a single write. defaults to 10
+	DurationSpikeFactor       float64       `yaml:"durationSpikeFactor,omitempty"`       // how many times longer than the previous run a run must take to send a duration event. defaults to 2
+	ResultTTLMultiplier       float64       `yaml:"resultTTLMultiplier,omitempty"`       // expire check results after their run interval times this. 0 uses resultTTL for every check
+	ResultTTLOverrides        durationMap   `yaml:"resultTTLOverrides,omitempty"`        // per-check result TTLs keyed by namespace/name
+	DisruptionGateChecks      []string      `yaml:"disruptionGateChecks,omitempty"`      // namespace/name of the checks that must pass for voluntary disruptions to proceed. supports * wildcards. empty means every check
+	PartialListMode           string        `yaml:"partialListMode,omitempty"`           // fail or partial. how khstate lists that fail partway are handled. defaults to fail
+	StateEncryptionFields     []string      `yaml:"stateEncryptionFields,omitempty"`     // khstate fields encrypted before they are written: errors, logTail or annotations
+	StateEncryptionKeyDir     string        `yaml:"stateEncryptionKeyDir,omitempty"`     // directory of the mounted secret holding the khstate encryption keys, one file per key ID
+	StateEncryptionKeyID      string        `yaml:"stateEncryptionKeyID,omitempty"`      // the key new values are encrypted with. defaults to the only key
+	RunIDPattern              string        `yaml:"runIDPattern,omitempty"`              // regular expression reported run IDs must match. defaults to letters, digits, '.', '_', ':' and '-' up to 128 characters
+	RunIDPatternOverrides     stringMap     `yaml:"runIDPatternOverrides,omitempty"`     // per-check run ID patterns keyed by namespace/name
+	StateReaperInterval       time.Duration `yaml:"stateReaperInterval,omitempty"`       // how often orphaned khstates are reaped. defaults to 1m
+	StateReaperFraction       float64       `yaml:"stateReaperFraction,omitempty"`       // the fraction of khstates examined on each reaper pass. defaults to 1, which examines all of them
+	AdminAPITokenFile         string        `yaml:"adminAPITokenFile,omitempty"`         // file holding the bearer token admin API requests must carry, usually mounted from a secret
+	RemoteStateClusterID      string        `yaml:"remoteStateClusterID,omitempty"`      // identifies this cluster in the names and labels of its remote khstates
 }
 
 // Load loads file from disk
@@ -165,12 +283,27 @@ func configReloader(ctx context.Context, kh *Kuberhealthy) {
 
 	// when outChan gets events, reload configuration and checks
 	for range outChan {
+		previousStateStoreConfig := stateStoreConfigFrom(cfg)
 		err := cfg.Load(configPath)
 		if err != nil {
 			log.Errorln("configReloader: Error reloading config:", err)
 			continue
 		}
 
+		// rebuild the khstate clients before the checks restart so that they pick up the new clients
+		if stateStoreConfigFrom(cfg) != previousStateStoreConfig {
+			err = reloadStateStores(previousStateStoreConfig)
+			if err != nil {
+				log.Errorln("configReloader: Error reloading state store configuration:", err)
+			}
+		}
+
+		// pick up rotated khstate encryption keys.  the previous keys are kept when the new ones fail to load
+		err = loadStateCipher()
+		if err != nil {
+			log.Errorln("configReloader: Error reloading khstate encryption keys. Keeping the previous keys:", err)
+		}
+
 		// reparse and set logging level
 		parsedLogLevel, err := log.ParseLevel(cfg.LogLevel)
 		if err != nil {
@@ -0,0 +1,74 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// ErrWriteNotConfirmed is returned when a khstate write succeeded but reading it back did not show the result that
+// was written, such as when an admission webhook mutated it
+var ErrWriteNotConfirmed = errors.New("khstate write could not be confirmed")
+
+// confirmStateWrite reads a khstate back after it was written and ensures that its resource version advanced past
+// previousVersion and that the result fields match what was written.  If a newer write landed before the read, the
+// write is superseded and counts as confirmed because it can no longer be verified or retried safely.
+func confirmStateWrite(ctx context.Context, name string, namespace string, previousVersion string, intended health.WorkloadDetails, written *khstatecrd.KuberhealthyState) error {
+	if written.GetResourceVersion() == previousVersion {
+		writeConfirmations.Inc("mismatch")
+		return fmt.Errorf("%w: resource version did not advance from %s", ErrWriteNotConfirmed, previousVersion)
+	}
+
+	readBack, err := khStateClient.GetContext(ctx, metav1.GetOptions{}, stateCRDResource, name, namespace)
+	if err != nil {
+		writeConfirmations.Inc("error")
+		return fmt.Errorf("%w: error reading back khstate: %v", ErrWriteNotConfirmed, err)
+	}
+	if readBack.GetResourceVersion() != written.GetResourceVersion() {
+		writeConfirmations.Inc("superseded")
+		return nil
+	}
+
+	mismatch := stateWriteMismatch(intended, readBack.Spec)
+	if len(mismatch) > 0 {
+		writeConfirmations.Inc("mismatch")
+		return fmt.Errorf("%w: %s does not match what was written", ErrWriteNotConfirmed, mismatch)
+	}
+	writeConfirmations.Inc("confirmed")
+	return nil
+}
+
+// stateWriteMismatch returns the name of the first result field that differs between the written and the read
+// back details of a khstate.  Blank means they match.
+func stateWriteMismatch(written health.WorkloadDetails, readBack health.WorkloadDetails) string {
+	switch {
+	case written.OK != readBack.OK:
+		return "OK"
+	case written.GetStatus() != readBack.GetStatus():
+		return "Status"
+	case !reflect.DeepEqual(written.Errors, readBack.Errors) && (len(written.Errors) > 0 || len(readBack.Errors) > 0):
+		return "Errors"
+	case written.CurrentUUID != readBack.CurrentUUID:
+		return "uuid"
+	case written.RunID != readBack.RunID:
+		return "RunID"
+	}
+	return ""
+}
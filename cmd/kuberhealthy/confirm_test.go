@@ -0,0 +1,85 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// TestConfirmWrites ensures that writes mutated on the way in are retried until the read back matches and that
+// writes that can never be confirmed return an error
+func TestConfirmWrites(t *testing.T) {
+	originalConfirm := cfg.ConfirmWrites
+	defer func() {
+		cfg.ConfirmWrites = originalConfirm
+	}()
+	cfg.ConfirmWrites = true
+
+	tests := []struct {
+		mutations int // the number of writes the fake admission webhook mutates
+		writes    int
+		err       error
+	}{
+		{mutations: 0, writes: 1},
+		{mutations: 1, writes: 2},
+		{mutations: 100, writes: 5, err: ErrWriteNotConfirmed},
+	}
+
+	for _, test := range tests {
+		var lock sync.Mutex
+		stored := khstatecrd.NewKuberhealthyState("check", health.WorkloadDetails{OK: true, Status: health.StatusOK})
+		stored.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+		stored.Kind = "KuberhealthyState"
+		stored.Namespace = "kuberhealthy"
+		stored.ResourceVersion = "1"
+		mutations := test.mutations
+		var writes int
+
+		useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			defer lock.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			if r.Method == http.MethodPut {
+				writes++
+				json.NewDecoder(r.Body).Decode(&stored)
+				version, _ := strconv.Atoi(stored.ResourceVersion)
+				stored.ResourceVersion = strconv.Itoa(version + 1)
+				if mutations > 0 {
+					mutations--
+					stored.Spec.Errors = nil
+				}
+			}
+			json.NewEncoder(w).Encode(stored)
+		})
+
+		err := updateCheckStateResource("check", "kuberhealthy", func(details *health.WorkloadDetails) bool {
+			details.OK = false
+			details.Status = health.StatusNotOK
+			details.Errors = []string{"broken"}
+			return true
+		})
+		if !errors.Is(err, test.err) {
+			t.Fatalf("%d mutations: expected error %v, got %v", test.mutations, test.err, err)
+		}
+		if writes != test.writes {
+			t.Fatalf("%d mutations: expected %d writes, got %d", test.mutations, test.writes, writes)
+		}
+	}
+}
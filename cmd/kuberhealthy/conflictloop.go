@@ -0,0 +1,59 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// persistentConflictThreshold is the number of conflicts in a row, each against a khstate that changed without a
+// write from Kuberhealthy landing, after which a khstate write gives up
+const persistentConflictThreshold = 3
+
+// ErrPersistentConflict is returned when a khstate write keeps conflicting with changes that were not made by
+// Kuberhealthy, such as when an admission webhook or another controller modifies the khstate on every write
+var ErrPersistentConflict = errors.New("khstate write keeps conflicting with changes made outside of kuberhealthy")
+
+// conflictLoopDetector watches the attempts of a single khstate write for a conflict loop.  Every khstate write made
+// by Kuberhealthy bumps the generation of the khstate, so a conflict with a khstate whose resource version changed
+// while its generation did not means something other than Kuberhealthy modified it.  When that keeps happening,
+// retrying can never make progress.
+type conflictLoopDetector struct {
+	conflicted bool   // the last attempt ran into a conflict
+	version    string // the resource version the last attempt was written against
+	generation int64  // the generation of the khstate the last attempt was written against
+	repeats    int    // conflicts in a row against khstates that changed without a kuberhealthy write landing
+}
+
+// attempt records that a write is being attempted against the supplied resource version and generation of a
+// khstate.  It returns ErrPersistentConflict once the khstate has changed without a kuberhealthy write landing too
+// many times in a row.
+func (d *conflictLoopDetector) attempt(version string, generation int64) error {
+	if d.conflicted && version != d.version && generation == d.generation {
+		d.repeats++
+	} else if !d.conflicted || generation != d.generation {
+		d.repeats = 0
+	}
+	d.version = version
+	d.generation = generation
+	d.conflicted = false
+	if d.repeats >= persistentConflictThreshold {
+		return fmt.Errorf("%w: resource version changed %d times in a row without the generation advancing from %d", ErrPersistentConflict, d.repeats, generation)
+	}
+	return nil
+}
+
+// conflict records that the last attempt ran into a conflict
+func (d *conflictLoopDetector) conflict() {
+	d.conflicted = true
+}
@@ -0,0 +1,72 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// TestPersistentConflict ensures that a khstate write gives up with ErrPersistentConflict when the khstate keeps
+// changing without its generation advancing, and that ordinary contention between kuberhealthy writers is retried
+func TestPersistentConflict(t *testing.T) {
+	var version, generation, puts int
+	var advanceGeneration bool
+	useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodGet:
+			stored := khstatecrd.NewKuberhealthyState("looping-check", health.WorkloadDetails{OK: true, Generation: int64(generation)})
+			stored.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+			stored.Kind = "KuberhealthyState"
+			stored.Namespace = "kuberhealthy"
+			stored.ResourceVersion = strconv.Itoa(version)
+			json.NewEncoder(w).Encode(stored)
+		case http.MethodPut:
+			// the khstate is modified every time it is written, so every write conflicts
+			puts++
+			version++
+			if advanceGeneration {
+				generation++
+			}
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(metav1.Status{Status: metav1.StatusFailure, Reason: metav1.StatusReasonConflict, Code: http.StatusConflict})
+		}
+	})
+
+	err := setCheckStateResource("looping-check", "kuberhealthy", health.WorkloadDetails{OK: false, Errors: []string{"broken"}})
+	if !errors.Is(err, ErrPersistentConflict) {
+		t.Fatal("expected the write to give up with a persistent conflict, got", err)
+	}
+	if puts != persistentConflictThreshold {
+		t.Fatalf("expected the write to give up after %d conflicts, got %d", persistentConflictThreshold, puts)
+	}
+
+	// other kuberhealthy writers advance the generation, so their conflicts are retried as usual
+	puts = 0
+	advanceGeneration = true
+	err = setCheckStateResource("looping-check", "kuberhealthy", health.WorkloadDetails{OK: false, Errors: []string{"broken"}})
+	if errors.Is(err, ErrPersistentConflict) || err == nil {
+		t.Fatal("expected the write to run out of conflict retries, got", err)
+	}
+	if puts <= persistentConflictThreshold {
+		t.Fatalf("expected every conflict retry to be attempted, got %d writes", puts)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// alertAfterForCheck returns the number of failed runs in a row before a check alerts, from its override if one is
+// configured.  Every failure alerts when no threshold is configured.
+func alertAfterForCheck(checkName string, checkNamespace string) int {
+	threshold := cfg.AlertAfterConsecutive
+	if override, ok := cfg.AlertAfterOverrides[checkNamespace+"/"+checkName]; ok {
+		threshold = override
+	}
+	if threshold < 1 {
+		return 1
+	}
+	return threshold
+}
+
+// countConsecutiveFailures returns the number of runs in a row that failed once the state is written over the
+// previous state.  States that are not run results, such as queued runs, keep the previous count.
+func countConsecutiveFailures(previous health.WorkloadDetails, state health.WorkloadDetails) int {
+	if !isRunResult(state) {
+		return previous.ConsecutiveFailures
+	}
+	if state.GetStatus() == health.StatusNotOK {
+		return previous.ConsecutiveFailures + 1
+	}
+	return 0
+}
+
+// shouldAlert determines if a state change of a check should be notified while honoring its alert threshold.  A
+// failing check alerts on the run that reaches the threshold and on changes to its errors after that.  Recoveries
+// are only notified for checks that alerted.
+func shouldAlert(checkName string, checkNamespace string, previous health.WorkloadDetails, state health.WorkloadDetails) bool {
+	threshold := alertAfterForCheck(checkName, checkNamespace)
+	if threshold == 1 {
+		return shouldNotify(previous, state)
+	}
+	if state.Disabled {
+		return false
+	}
+
+	switch state.GetStatus() {
+	case health.StatusNotOK:
+		if isRunResult(state) && state.ConsecutiveFailures >= threshold && previous.ConsecutiveFailures < threshold {
+			return true
+		}
+		return state.ConsecutiveFailures > threshold && shouldNotify(previous, state)
+	case health.StatusOK:
+		return previous.ConsecutiveFailures >= threshold && shouldNotify(previous, state)
+	}
+	return false
+}
+
+// markAlerting flags the failing checks that have failed at least as many runs in a row as their alert threshold
+func markAlerting(checkDetails map[string]health.WorkloadDetails) {
+	for key, details := range checkDetails {
+		namespace, name := splitCheckKey(key)
+		details.Alerting = details.GetStatus() == health.StatusNotOK && details.ConsecutiveFailures >= alertAfterForCheck(name, namespace)
+		checkDetails[key] = details
+	}
+}
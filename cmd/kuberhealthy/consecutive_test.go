@@ -0,0 +1,111 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// TestConsecutiveFailures ensures that failed runs in a row are counted on the khstate and reset by an OK run
+func TestConsecutiveFailures(t *testing.T) {
+	stateVersions.reset()
+	defer stateVersions.reset()
+
+	stored := khstatecrd.NewKuberhealthyState("consecutive-check", health.WorkloadDetails{Status: health.StatusUnknown, Errors: []string{}})
+	stored.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	stored.Kind = "KuberhealthyState"
+	stored.Namespace = "kuberhealthy"
+	useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPut {
+			stored = khstatecrd.KuberhealthyState{}
+			json.NewDecoder(r.Body).Decode(&stored)
+		}
+		json.NewEncoder(w).Encode(stored)
+	})
+
+	failing := health.WorkloadDetails{OK: false, Errors: []string{"broken"}}
+	passing := health.WorkloadDetails{OK: true, Errors: []string{}}
+	for i, test := range []struct {
+		state    health.WorkloadDetails
+		expected int
+	}{
+		{state: failing, expected: 1},
+		{state: failing, expected: 2},
+		{state: health.WorkloadDetails{OK: false, Errors: []string{"broken"}, Queued: true}, expected: 2},
+		{state: failing, expected: 3},
+		{state: passing, expected: 0},
+		{state: failing, expected: 1},
+	} {
+		_, err := setCheckStateResourceIf("consecutive-check", "kuberhealthy", test.state, nil)
+		if err != nil {
+			t.Fatal("unexpected error writing the khstate:", err)
+		}
+		if stored.Spec.ConsecutiveFailures != test.expected {
+			t.Fatalf("write %d: expected %d consecutive failures, got %d", i, test.expected, stored.Spec.ConsecutiveFailures)
+		}
+	}
+}
+
+// TestShouldAlert ensures that checks with a consecutive failure threshold only alert once the threshold is reached
+// and only notify recoveries after alerting
+func TestShouldAlert(t *testing.T) {
+	originalThreshold, originalOverrides := cfg.AlertAfterConsecutive, cfg.AlertAfterOverrides
+	defer func() {
+		cfg.AlertAfterConsecutive, cfg.AlertAfterOverrides = originalThreshold, originalOverrides
+	}()
+	cfg.AlertAfterConsecutive = 3
+	cfg.AlertAfterOverrides = intMap{"kuberhealthy/noisy": 1}
+
+	failing := func(n int, errs ...string) health.WorkloadDetails {
+		return health.WorkloadDetails{OK: false, Status: health.StatusNotOK, Errors: errs, ConsecutiveFailures: n}
+	}
+	passing := health.WorkloadDetails{OK: true, Status: health.StatusOK}
+
+	tests := []struct {
+		description string
+		previous    health.WorkloadDetails
+		state       health.WorkloadDetails
+		expected    bool
+	}{
+		{description: "a first failure", previous: passing, state: failing(1, "broken"), expected: false},
+		{description: "a failure below the threshold", previous: failing(1, "broken"), state: failing(2, "broken"), expected: false},
+		{description: "the failure reaching the threshold", previous: failing(2, "broken"), state: failing(3, "broken"), expected: true},
+		{description: "a repeated failure after alerting", previous: failing(3, "broken"), state: failing(4, "broken"), expected: false},
+		{description: "changed errors after alerting", previous: failing(3, "broken"), state: failing(4, "timed out"), expected: true},
+		{description: "a recovery before alerting", previous: failing(2, "broken"), state: passing, expected: false},
+		{description: "a recovery after alerting", previous: failing(3, "broken"), state: passing, expected: true},
+	}
+	for _, test := range tests {
+		if alert := shouldAlert("dns", "kuberhealthy", test.previous, test.state); alert != test.expected {
+			t.Fatalf("%s: expected alert to be %t, got %t", test.description, test.expected, alert)
+		}
+	}
+	if !shouldAlert("noisy", "kuberhealthy", passing, failing(1, "broken")) {
+		t.Fatal("expected a check with a threshold override of 1 to alert on its first failure")
+	}
+
+	checkDetails := map[string]health.WorkloadDetails{
+		"kuberhealthy/dns":   failing(3, "broken"),
+		"kuberhealthy/flaky": failing(2, "broken"),
+		"kuberhealthy/noisy": failing(1, "broken"),
+	}
+	markAlerting(checkDetails)
+	if !checkDetails["kuberhealthy/dns"].Alerting || checkDetails["kuberhealthy/flaky"].Alerting || !checkDetails["kuberhealthy/noisy"].Alerting {
+		t.Fatalf("expected only checks at their threshold to be alerting, got %+v", checkDetails)
+	}
+}
@@ -12,47 +12,588 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/util/retry"
 
 	log "github.com/sirupsen/logrus"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
 
 	v1 "github.com/Comcast/kuberhealthy/v2/pkg/apis/khjob/v1"
 	"github.com/Comcast/kuberhealthy/v2/pkg/health"
 	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
 )
 
+// defaultStateCreateRetries is how many times a khstate create that failed with a transient error is retried when
+// stateCreateRetries is not configured
+const defaultStateCreateRetries = 4
+
+// maxStateAnnotationBytes is the maximum combined size of the keys and values of annotations stored on a khstate
+const maxStateAnnotationBytes = 4096
+
+// defaultMaxErrorLength is the maximum length in bytes of a single error stored on a khstate when maxErrorLength
+// is not configured
+const defaultMaxErrorLength = 4096
+
+// truncatedErrorMarker is appended to errors that were cut short to fit within the maximum error length
+const truncatedErrorMarker = "... [truncated]"
+
+// sanitizerModeHash is the resource name sanitizer mode that appends a hash to any name it has to change
+const sanitizerModeHash = "hash"
+
+// resourceNameHashLength is the number of hex characters of the hash appended by the hash sanitizer mode
+const resourceNameHashLength = 8
+
+// invalidResourceNameChars matches runs of characters that can not be used in a CRD name by the hash sanitizer mode
+var invalidResourceNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
 // setCheckStateResource puts a check state's state into the specified CRD resource.  It sets the AuthoritativePod
 // to the server's hostname and sets the LastUpdate time to now.
 func setCheckStateResource(checkName string, checkNamespace string, state health.WorkloadDetails) error {
+	held, err := holdCheckStateWrite(checkName, checkNamespace, state)
+	if err != nil || held {
+		return err
+	}
 
-	name := sanitizeResourceName(checkName)
+	_, err = setCheckStateResourceIf(checkName, checkNamespace, state, nil)
+	return err
+}
 
-	// we must fetch the existing state to use the current resource version
-	// int found within
-	existingState, err := khStateClient.Get(metav1.GetOptions{}, stateCRDResource, name, checkNamespace)
-	if err != nil {
-		return errors.New("Error retrieving CRD for: " + name + " " + err.Error())
+// holdCheckStateWrite applies the guards that a result goes through before it is written.  Read-only replicas
+// refuse the write, and checks that report too often have their writes coalesced instead of hammering etcd.  The
+// returned bool indicates if the write is being held by the minimum write interval.
+func holdCheckStateWrite(checkName string, checkNamespace string, state health.WorkloadDetails) (bool, error) {
+
+	// read-only replicas never write, so there is nothing to hold back
+	if cfg.ReadOnly {
+		return false, ErrReadOnly
 	}
-	resourceVersion := existingState.GetResourceVersion()
 
-	// set the pod name that wrote the khstate
+	return throttleCheckStateWrite(checkName, checkNamespace, state), nil
+}
+
+// setCheckStateResourceIf is setCheckStateResource with a condition that is evaluated against the latest details
+// stored in the khstate.  If the condition returns false, nothing is written.  A nil condition always writes.
+// The returned bool indicates if the state was written.
+func setCheckStateResourceIf(checkName string, checkNamespace string, state health.WorkloadDetails, condition func(existing health.WorkloadDetails) bool) (bool, error) {
+
+	// read-only replicas never write, including the writes that do not go through setCheckStateResource
+	if cfg.ReadOnly {
+		return false, ErrReadOnly
+	}
+
+	// cluster-scoped checks keep their khstate in the cluster checks namespace
+	workload := workloadOf(state)
+	stateNamespace := stateNamespaceForWorkload(checkName, checkNamespace, workload)
+	state.ClusterScoped = stateNamespace != checkNamespace && isClusterScopedWorkload(checkName, checkNamespace, workload)
+
+	// namespaces outside of the configured allow-list or inside of the deny-list are left alone
+	if !stateNamespaceManaged(stateNamespace) {
+		log.Infoln("Skipping khstate write for", checkName, "because namespace", stateNamespace, "is excluded from state management")
+		return false, ErrNamespaceExcluded
+	}
+
+	// namespaces that are being deleted refuse writes, so they are skipped instead of failing on every run
+	if skipWriteToTerminatingNamespace(checkName, stateNamespace) {
+		log.Debugln("Skipping khstate write for", checkName, "because namespace", stateNamespace, "is being deleted")
+		return false, ErrNamespaceTerminating
+	}
+
+	// set the pod name and namespace that wrote the khstate
 	state.AuthoritativePod = podHostname
+	state.AuthoritativeNamespace = podNamespace
 	state.LastRun = time.Now() // set the time the khstate was last
 
-	khState := khstatecrd.NewKuberhealthyState(name, state)
-	khState.SetResourceVersion(resourceVersion)
-	// TODO - if "try again" message found in error, then try again
+	// a reported result is never unknown unless the caller explicitly says so
+	if state.Status == "" {
+		state.Status = health.StatusFromOK(state.OK)
+	}
+
+	// results carry the expiry policy of their check.  Whether a result has expired is worked out when it is read,
+	// so it is never stored
+	stampResultTTL(checkName, checkNamespace, &state)
+	stampScheduleDelay(checkName, checkNamespace, &state)
+	state.Expired = false
+
+	// the write that finishes a scheduled run of a check or job carries the run ID that its checker reported.  It adds
+	// the run duration to that result instead of repeating it, so it is never skipped as a duplicate
+	scheduled, _ := state.Schedule()
+	finishesRun := !scheduled.IsZero()
+
+	// run the result through the result processor chain, which masks sensitive data and keeps huge errors, such as
+	// full stack traces, and checker supplied annotations from bloating the resource in etcd by default
+	state, processErr := processCheckResult(checkName, checkNamespace, state)
+
+	log.Debugln(stateNamespace, checkName, "writing khstate with ok:", state.OK, "status:", state.Status, "and errors:", state.Errors, "at last run:", state.LastRun)
+	var written, foreign, suppressed, identical bool
+	var previous health.WorkloadDetails
+	var owner string
+	update := func(details *health.WorkloadDetails) bool {
+		identical = false
 
-	log.Debugln(checkNamespace, checkName, "writing khstate with ok:", state.OK, "and errors:", state.Errors, "at last run:", state.LastRun)
-	_, err = khStateClient.Update(&khState, stateCRDResource, name, checkNamespace)
+		// instances in other namespaces must not overwrite each other's results
+		foreign = isForeignOwned(*details)
+		if foreign {
+			owner = details.AuthoritativeNamespace
+			return false
+		}
+		// a result that was already recorded, such as a report processed by two replicas, is not written again
+		if len(state.RunID) > 0 && state.RunID == details.RunID && !finishesRun {
+			log.Infoln(stateNamespace, checkName, "not writing khstate because the result of run", state.RunID, "was already recorded")
+			written = false
+			return false
+		}
+		written = condition == nil || condition(*details)
+		if !written {
+			return false
+		}
+
+		// results that the write filter of the check holds back only record that the check is still running
+		suppressed = isRunResult(state) && !writeFilterForCheck(checkName, checkNamespace)(*details, state)
+		if suppressed {
+			written = false
+			recordHeartbeat(details, state)
+			return true
+		}
+		previous = *details
+
+		// the creation time is kept so that the initial grace period does not restart on every write
+		state.Created = details.Created
+
+		// track when the result last changed so that checks stuck on one result can be detected
+		state.LastResultChange = details.LastResultChange
+		if state.LastResultChange.IsZero() || state.ResultChangedFrom(*details) {
+			state.LastResultChange = state.LastRun
+		}
+
+		// track how long the check has been failing, regardless of how its errors change while it fails
+		state.ErrorsSince = time.Time{}
+		if state.GetStatus() == health.StatusNotOK {
+			state.ErrorsSince = details.ErrorsSince
+			if state.ErrorsSince.IsZero() || details.GetStatus() != health.StatusNotOK {
+				state.ErrorsSince = state.LastRun
+			}
+		}
+
+		// remember when the check last passed, so that a failing check still shows how long ago it was OK
+		state.LastSuccess = details.LastSuccess
+		if isRunResult(state) && state.GetStatus() == health.StatusOK {
+			state.LastSuccess = state.LastRun
+		}
+
+		// count the failed runs in a row so that alerts can wait for a check to keep failing
+		state.ConsecutiveFailures = countConsecutiveFailures(*details, state)
+
+		// the uid of the check is kept with its result so that the check can be found again after it is renamed
+		state.CheckUID = checkUIDFor(checkName, checkNamespace)
+		if len(state.CheckUID) == 0 {
+			state.CheckUID = details.CheckUID
+		}
+
+		// a result that is the same as the stored one only refreshes the fields that show the check is still
+		// running, so that a checker reporting the same result does not churn the khstate
+		if compactsIdenticalWrites() && identicalResult(*details, state) {
+			identical = true
+			written = false
+			return compactIdenticalWrite(details, state)
+		}
+		*details = state
+		return true
+	}
+	// large results can be given more time to write than the global khstate write timeout
+	timeout := stateWriteTimeoutForCheck(checkName, checkNamespace)
+	err := updateCheckStateResourceWithin(checkName, stateNamespace, timeout, update)
+
+	// a result that the khstate schema rejects would be lost on every write, so a failure saying which fields were
+	// rejected is written in its place
+	var rejected bool
+	if k8sErrors.IsInvalid(err) {
+		state = schemaViolationState(checkName, stateNamespace, state, err)
+		rejected = true
+		err = updateCheckStateResourceWithin(checkName, stateNamespace, timeout, update)
+	}
+	if err == nil && foreign {
+		log.Warningln(stateNamespace, checkName, "not writing khstate because it is owned by the kuberhealthy instance in namespace", owner)
+		foreignOwnershipRefusals.Inc(owner)
+		return false, ErrForeignOwnership
+	}
+	if err == nil && suppressed {
+		log.Debugln(stateNamespace, checkName, "recorded only the last run because the write filter of the check suppressed the result")
+		suppressedWrites.Inc(checkName, checkNamespace)
+		return false, ErrWriteSuppressed
+	}
+	if err == nil && becameStatic(previous, state, cfg.StaticResultWindow) {
+		log.Warningln(stateNamespace, checkName, "has reported the same result since", state.LastResultChange, "and may be broken")
+	}
+	if err == nil && identical {
+		log.Debugln(stateNamespace, checkName, "result is identical to the stored result. Not writing it in full with identical write mode", cfg.IdenticalWriteMode)
+		identicalWrites.Inc(checkName, checkNamespace, cfg.IdenticalWriteMode)
+		if isRunResult(state) {
+			recordRun(checkName, stateNamespace, state.OK, state.LastRun)
+		}
+		return false, processErr
+	}
+	if err != nil || !written {
+		return false, err
+	}
+
+	if state.ResultChangedFrom(previous) {
+		log.Infoln(stateNamespace, checkName, "result changed:", describeStateTransition(previous, state))
+		publishStateChange(checkName, checkNamespace, previous, state)
+		emitCheckResultEvent(checkName, checkNamespace, state)
+	}
+	publishFieldChanges(checkName, checkNamespace, previous, state)
+
+	// keep the run history that check reliability is calculated from
+	if isRunResult(state) {
+		recordRun(checkName, stateNamespace, state.OK, state.LastRun)
+	}
+
+	// copy the written state to any secondary state stores without waiting on them
+	writeToSecondaryStateStores(checkName, stateNamespace, state)
+
+	if shouldAlert(checkName, checkNamespace, previous, state) && !suppressedByInitialGracePeriod(checkName, checkNamespace, state, time.Now()) {
+		trend := computeTrend(checkName, stateNamespace, trendRuns())
+		state.Trend = &trend
+		notifyStateChange(checkName, checkNamespace, state)
+	}
+	if rejected {
+		return true, ErrStateRejected
+	}
+	if processErr != nil {
+		return true, processErr
+	}
+	return true, nil
+}
+
+// becameStatic indicates if a write is the first one after the result of a check stopped changing for longer
+// than the static result window, so that a static check is only warned about once.
+func becameStatic(previous health.WorkloadDetails, state health.WorkloadDetails, window time.Duration) bool {
+	return state.IsStatic(window, state.LastRun) && !previous.IsStatic(window, previous.LastRun)
+}
+
+// describeStateTransition describes a change in the result of a check for logs, such as "was OK, now failing
+// with 1 errors: <first error>"
+func describeStateTransition(previous health.WorkloadDetails, state health.WorkloadDetails) string {
+	describe := func(details health.WorkloadDetails) string {
+		switch details.GetStatus() {
+		case health.StatusUnknown:
+			return string(health.StatusUnknown)
+		case health.StatusOK:
+			return "OK"
+		}
+		return fmt.Sprintf("failing with %d errors", len(details.Errors))
+	}
+
+	transition := "was " + describe(previous) + ", now " + describe(state)
+	if state.GetStatus() == health.StatusNotOK && len(state.Errors) > 0 {
+		transition += ": " + state.Errors[0]
+	}
+	return transition
+}
+
+// updateCheckStateResource is the conflict-safe write path for khstate resources.  It fetches the current khstate
+// for a check, applies the supplied update func to its details and writes it back.  If the update func returns false,
+// nothing is written.  If the resource was modified between the fetch and the write, the whole fetch and update is
+// retried against the latest version.  The write is bounded by the global khstate write timeout.
+func updateCheckStateResource(checkName string, checkNamespace string, update func(details *health.WorkloadDetails) bool) error {
+	return updateCheckStateResourceWithin(checkName, checkNamespace, stateWriteTimeout(), update)
+}
+
+// updateCheckStateResourceWithin is updateCheckStateResource with a timeout for the whole write, including every
+// retry.  A timeout of zero or less leaves the write unbounded.
+func updateCheckStateResourceWithin(checkName string, checkNamespace string, timeout time.Duration, update func(details *health.WorkloadDetails) bool) error {
+
+	name := sanitizeResourceName(checkName)
+
+	if err := stateWritesBlocked(); err != nil {
+		log.Warningln(checkNamespace, checkName, "not writing khstate:", err)
+		return err
+	}
+
+	// fail fast while the API server keeps failing instead of piling up retries
+	if err := stateBreaker.allow(time.Now()); err != nil {
+		log.Warningln(checkNamespace, checkName, "not writing khstate:", err)
+		return err
+	}
+
+	// keep the khstate clients from being swapped by a config reload until this write is done
+	stateClientsLock.RLock()
+	defer stateClientsLock.RUnlock()
+
+	// every write is traced with a span for each attempt and each API call within it
+	ctx, span := stateTracer.Start(context.Background(), "khstate.write")
+	span.SetAttribute("check", checkName)
+	span.SetAttribute("namespace", checkNamespace)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	var attempts int
+	var conflictLoop conflictLoopDetector
+
+	retriable := func(err error) bool {
+		return k8sErrors.IsConflict(err) || isResourceVersionTooOld(err) || errors.Is(err, ErrWriteNotConfirmed)
+	}
+	write := func(ctx context.Context) error {
+
+		// we must use the current resource version of the existing state.  the state we last wrote is used
+		// when it is cached, otherwise it is fetched with the write client so that the version is not stale
+		existingState := stateVersions.get(name, checkNamespace)
+		if existingState == nil {
+			err := traceStateAPICall(ctx, "khstate.get", func() (err error) {
+				existingState, err = khStateClient.GetContext(ctx, metav1.GetOptions{}, stateCRDResource, name, checkNamespace)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("Error retrieving CRD for: %s %w", name, err)
+			}
+		}
+
+		// patches are built from the fields that the update changes
+		original, err := json.Marshal(existingState)
+		if err != nil {
+			return fmt.Errorf("Error encoding CRD for: %s %w", name, err)
+		}
+
+		// updates see the stored fields decrypted and write them encrypted again
+		existingState.Spec = decryptStateFields(existingState.Spec)
+
+		// the generation is based on the version fetched for this attempt, so retries never skip or repeat one
+		generation := existingState.Spec.Generation
+		err = conflictLoop.attempt(existingState.GetResourceVersion(), generation)
+		if err != nil {
+			return err
+		}
+		if !update(&existingState.Spec) {
+			log.Debugln(checkNamespace, checkName, "khstate update was skipped")
+			return nil
+		}
+		existingState.Spec.Generation = generation + 1
+		existingState.Spec, err = encryptStateFields(existingState.Spec)
+		if err != nil {
+			return fmt.Errorf("Error encrypting CRD for: %s %w", name, err)
+		}
+
+		// keep the labels injected from the khcheck in sync with its configuration
+		injectStateLabels(&existingState.ObjectMeta, name, checkNamespace)
+
+		// keep the Ready condition in sync with the result so that generic tooling sees the same thing as OK
+		existingState.SyncReadyCondition()
+
+		var updatedState *khstatecrd.KuberhealthyState
+		err = traceStateAPICall(ctx, "khstate.update", func() (err error) {
+			updatedState, err = writeStateResource(ctx, name, checkNamespace, original, existingState)
+			return err
+		})
+		if err == nil && cfg.ConfirmWrites {
+			err = traceStateAPICall(ctx, "khstate.confirm", func() error {
+				return confirmStateWrite(ctx, name, checkNamespace, existingState.GetResourceVersion(), existingState.Spec, updatedState)
+			})
+			if err != nil {
+				log.Warningln(checkNamespace, checkName, "khstate write was not confirmed. Retrying with the latest version:", err)
+				stateVersions.invalidate(name, checkNamespace, "unconfirmed")
+				return err
+			}
+		}
+		if err == nil {
+			stateVersions.set(updatedState)
+			return nil
+		}
+
+		// drop the cached version so that the next attempt fetches the current one
+		if reason := stateVersionCacheInvalidationReason(err); reason != "" {
+			stateVersions.invalidate(name, checkNamespace, reason)
+		}
+		if k8sErrors.IsConflict(err) {
+			log.Debugln(checkNamespace, checkName, "khstate was modified while being written. Retrying with the latest version.")
+		}
+		if isResourceVersionTooOld(err) {
+			log.Infoln(checkNamespace, checkName, "khstate resource version is too old to write. Retrying with the latest version.")
+		}
+		return err
+	}
+	// the wait between retries adapts to how often writes of this khstate run into conflicts
+	var conflicts int
+	err := retry.OnError(stateWriteBackoff(name, checkNamespace), retriable, func() error {
+		attempts++
+		attemptCtx, attemptSpan := stateTracer.Start(ctx, "khstate.write.attempt")
+		attemptSpan.SetAttribute("attempt", attempts)
+		err := write(attemptCtx)
+		if k8sErrors.IsConflict(err) {
+			conflicts++
+			conflictLoop.conflict()
+		}
+		attemptSpan.SetAttribute("conflict", k8sErrors.IsConflict(err))
+		endSpan(attemptSpan, err)
+		return err
+	})
+	span.SetAttribute("attempts", attempts)
+	endSpan(span, err)
+	recordWriteConflicts(name, checkNamespace, conflicts)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		log.Warningln(checkNamespace, checkName, "khstate write timed out after", timeout)
+		stateWriteTimeouts.Inc(checkName, checkNamespace)
+	}
+	if errors.Is(err, ErrPersistentConflict) {
+		log.Errorln(checkNamespace, checkName, "khstate write gave up because the khstate is modified outside of kuberhealthy on every write. This is usually caused by a mutating admission webhook or another controller changing khstates:", err)
+		persistentConflicts.Inc(checkName, checkNamespace)
+	}
+
+	stateBreaker.record(err, time.Now())
+	if err == nil {
+		statusCache.invalidate()
+	}
 	return err
 }
 
+// resetAllCheckStatesToUnknown sets the status of every khstate resource to Unknown while preserving the rest of
+// its details.  This is used on startup so that results from before a restart are not shown as current.
+func resetAllCheckStatesToUnknown() error {
+
+	khStates, err := getAllCheckStates(listenNamespace, projectionMetadata)
+	if err != nil {
+		return fmt.Errorf("error listing khStates to reset: %w", err)
+	}
+
+	log.Infoln("Resetting the status of", len(khStates), "khState resources to", health.StatusUnknown)
+	var resetErrors []string
+	for key := range khStates {
+		stateNamespace, name := splitCheckKey(key)
+		err := updateCheckStateResource(name, stateNamespace, func(details *health.WorkloadDetails) bool {
+			details.Status = health.StatusUnknown
+			return true
+		})
+		if err != nil {
+			resetErrors = append(resetErrors, key+": "+err.Error())
+		}
+	}
+
+	if len(resetErrors) > 0 {
+		return errors.New("failed to reset khStates: " + strings.Join(resetErrors, ", "))
+	}
+	return nil
+}
+
+// releaseOwnedCheckStates clears the AuthoritativePod of every khstate last written by this pod so that another
+// replica can take them over right away.  The owner is checked again against the latest version of each khstate,
+// so checks that another pod has already taken over are left alone.  The number of released khstates is returned.
+func releaseOwnedCheckStates() (int, error) {
+
+	khStates, err := getAllCheckStates(listenNamespace, projectionFull)
+	if err != nil {
+		return 0, fmt.Errorf("error listing khStates to release: %w", err)
+	}
+
+	var released int
+	var releaseErrors []string
+	for key, details := range khStates {
+		if details.AuthoritativePod != podHostname {
+			continue
+		}
+		stateNamespace, name := splitCheckKey(key)
+		var wasReleased bool
+		err := updateCheckStateResource(name, stateNamespace, func(details *health.WorkloadDetails) bool {
+			wasReleased = details.AuthoritativePod == podHostname
+			if !wasReleased {
+				return false
+			}
+			details.AuthoritativePod = ""
+			return true
+		})
+		if err != nil {
+			releaseErrors = append(releaseErrors, key+": "+err.Error())
+			continue
+		}
+		if wasReleased {
+			released++
+		}
+	}
+
+	if len(releaseErrors) > 0 {
+		return released, errors.New("failed to release khStates: " + strings.Join(releaseErrors, ", "))
+	}
+	return released, nil
+}
+
+// boundAnnotations returns the supplied annotations with entries dropped until the total size of all keys and
+// values is at or under maxBytes.  Keys are considered in sorted order so that the same annotations are always kept.
+func boundAnnotations(annotations map[string]string, maxBytes int) map[string]string {
+	if len(annotations) == 0 {
+		return annotations
+	}
+
+	keys := make([]string, 0, len(annotations))
+	for k := range annotations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	bounded := make(map[string]string)
+	var size int
+	for _, k := range keys {
+		entrySize := len(k) + len(annotations[k])
+		if size+entrySize > maxBytes {
+			log.Warningln("Dropping khstate annotation", k, "because annotations exceed the maximum size of", maxBytes, "bytes")
+			continue
+		}
+		size += entrySize
+		bounded[k] = annotations[k]
+	}
+	return bounded
+}
+
+// truncateErrors returns the supplied errors with each one cut down to at most maxLength bytes
+func truncateErrors(errs []string, maxLength int) []string {
+	var truncated []string
+	for i, e := range errs {
+		t := truncateError(e, maxLength)
+		if t == e {
+			continue
+		}
+		if truncated == nil {
+			truncated = make([]string, len(errs))
+			copy(truncated, errs)
+		}
+		truncated[i] = t
+	}
+	if truncated == nil {
+		return errs
+	}
+	return truncated
+}
+
+// truncateError cuts an error down to at most maxLength bytes.  The beginning of the error is kept and the
+// truncated error marker is put at the end.  Errors are never cut in the middle of a UTF-8 character.
+func truncateError(e string, maxLength int) string {
+	if len(e) <= maxLength {
+		return e
+	}
+	marker := truncatedErrorMarker
+	if maxLength <= len(marker) {
+		marker = ""
+	}
+	cut := maxLength - len(marker)
+	for cut > 0 && !utf8.RuneStart(e[cut]) {
+		cut--
+	}
+	return e[:cut] + marker
+}
+
 // sanitizeResourceName cleans up the check names for use in CRDs.
 // DNS-1123 subdomains must consist of lower case alphanumeric characters, '-'
 // or '.', and must start and end with an alphanumeric character (e.g.
@@ -60,39 +601,222 @@ func setCheckStateResource(checkName string, checkNamespace string, state health
 // (\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*')
 func sanitizeResourceName(c string) string {
 
+	if cfg.ResourceNameSanitizer == sanitizerModeHash {
+		return sanitizeResourceNameWithHash(c)
+	}
+
 	// the name we pass to the CRD must be lowercase
 	nameLower := strings.ToLower(c)
 	return strings.Replace(nameLower, " ", "-", -1)
 }
 
+// sanitizeResourceNameWithHash cleans up check names for use in CRDs while guaranteeing that two different
+// check names never map to the same CRD name.  Names that are already valid are used as-is.  Any name that
+// has to be changed gets a short hash of the original name appended so that it can not collide.
+func sanitizeResourceNameWithHash(c string) string {
+
+	if len(validation.IsDNS1123Subdomain(c)) == 0 {
+		return c
+	}
+
+	sum := sha256.Sum256([]byte(c))
+	hash := hex.EncodeToString(sum[:])[:resourceNameHashLength]
+
+	cleaned := invalidResourceNameChars.ReplaceAllString(strings.ToLower(c), "-")
+	maxCleanedLength := validation.DNS1123SubdomainMaxLength - resourceNameHashLength - 1
+	if len(cleaned) > maxCleanedLength {
+		cleaned = cleaned[:maxCleanedLength]
+	}
+	cleaned = strings.Trim(cleaned, "-")
+	if len(cleaned) == 0 {
+		return hash
+	}
+	return cleaned + "-" + hash
+}
+
+// stateProjection determines how much of each khstate is returned by getAllCheckStates
+type stateProjection int
+
+const (
+	projectionFull     stateProjection = iota // return the full details of every khstate
+	projectionMetadata                        // return only the status and timestamps of every khstate
+	projectionStored                          // return the full details of every khstate with encrypted fields left encrypted
+)
+
+// stateListPageSize is the number of khstates fetched per request when listing all khstates
+const stateListPageSize = 250
+
+// getAllCheckStates lists every khstate in the supplied namespace, or all namespaces when blank, and returns
+// their details keyed by namespace/name.  Results are fetched in pages to keep individual responses small.
+// Custom resources do not support server-side projection of spec fields, so the metadata projection is
+// trimmed after each page is fetched.
+func getAllCheckStates(namespace string, projection stateProjection) (map[string]health.WorkloadDetails, error) {
+	return listCheckStates(namespace, metav1.ListOptions{}, projection)
+}
+
+// listCheckStates is getAllCheckStates for only the khstates that match the label and field selectors of the supplied
+// list options.  When a page after the first fails, nothing is returned unless partial lists are configured, in
+// which case the khstates listed so far are returned with an error that is ErrPartialList.
+func listCheckStates(namespace string, listOptions metav1.ListOptions, projection stateProjection) (map[string]health.WorkloadDetails, error) {
+	states := make(map[string]health.WorkloadDetails)
+
+	// the estimate of how many khstates were left after the last page that was fetched
+	var remaining *int64
+
+	listOptions.Limit = stateListPageSize
+	for {
+		khStates, err := khStateReadClient.List(listOptions, stateCRDResource, namespace)
+		if err != nil {
+			err = fmt.Errorf("error listing khStates: %w", err)
+			if len(states) > 0 && returnsPartialLists() {
+				return states, newPartialListError(len(states), remaining, err)
+			}
+			return make(map[string]health.WorkloadDetails), err
+		}
+		remaining = khStates.GetRemainingItemCount()
+
+		for _, khState := range khStates.Items {
+			details := khState.Spec
+			switch projection {
+			case projectionMetadata:
+				details = details.Metadata()
+			case projectionFull:
+				details = decryptStateFields(details)
+			}
+			states[khState.GetNamespace()+"/"+khState.GetName()] = details
+		}
+
+		if len(khStates.GetContinue()) == 0 {
+			return states, nil
+		}
+		listOptions.Continue = khStates.GetContinue()
+	}
+}
+
 // ensureStateResourceExists checks for the existence of the specified resource and creates it if it does not exist
 func ensureStateResourceExists(checkName string, checkNamespace string, workload health.KHWorkload) error {
+	_, err := ensureStateResource(checkName, checkNamespace, workload)
+	return err
+}
+
+// ensureStateResource checks for the existence of the specified resource and creates it if it does not exist.  The
+// returned bool indicates if the resource had to be created.
+func ensureStateResource(checkName string, checkNamespace string, workload health.KHWorkload) (bool, error) {
 	name := sanitizeResourceName(checkName)
+	stateNamespace := stateNamespaceForWorkload(checkName, checkNamespace, workload)
+
+	// read-only replicas only serve state that other instances create
+	if cfg.ReadOnly {
+		return false, ErrReadOnly
+	}
 
+	// namespaces outside of the configured allow-list or inside of the deny-list are left alone
+	if !stateNamespaceManaged(stateNamespace) {
+		log.Infoln("Skipping khstate creation for", checkName, "because namespace", stateNamespace, "is excluded from state management")
+		return false, ErrNamespaceExcluded
+	}
+
+	// the existence check goes through the write client, since a stale read would lead to needless creates
 	log.Debugln("Checking existence of custom resource:", name)
-	state, err := khStateClient.Get(metav1.GetOptions{}, stateCRDResource, name, checkNamespace)
+	state, err := khStateClient.Get(metav1.GetOptions{}, stateCRDResource, name, stateNamespace)
 	if err != nil {
 		if k8sErrors.IsNotFound(err) || strings.Contains(err.Error(), "not found") {
+			// nothing can be created in a namespace that is being deleted
+			if skipsTerminatingNamespaces() && namespaceTerminating(stateNamespace, time.Now()) {
+				log.Debugln("Skipping khstate creation for", checkName, "because namespace", stateNamespace, "is being deleted")
+				return false, ErrNamespaceTerminating
+			}
 			log.Infoln("Custom resource not found, creating resource:", name, " - ", err)
-			initialDetails := health.NewWorkloadDetails(workload)
-			initialState := khstatecrd.NewKuberhealthyState(name, initialDetails)
-			_, err := khStateClient.Create(&initialState, stateCRDResource, checkNamespace)
+			initialState := initialStateResource(name, checkNamespace, stateNamespace, workload)
+			if err := stateWritesBlocked(); err != nil {
+				return false, errors.New("Error creating custom resource: " + name + ": " + err.Error())
+			}
+			err := createStateResource(&initialState, stateNamespace)
+			if k8sErrors.IsAlreadyExists(err) {
+				log.Infoln("Custom resource", name, "was created by another writer")
+				return false, nil
+			}
 			if err != nil {
-				return errors.New("Error creating custom resource: " + name + ": " + err.Error())
+				return false, errors.New("Error creating custom resource: " + name + ": " + err.Error())
 			}
-		} else {
-			return err
+			return true, nil
 		}
+		return false, err
 	}
 	if state.Spec.Errors != nil {
 		log.Debugln("khstate custom resource found:", name)
 	}
-	return nil
+	return false, nil
 }
 
-// getCheckState retrieves the check values from the kuberhealthy khstate
-// custom resource
+// initialStateResource returns the khstate that is created for a check before it has reported a result
+func initialStateResource(name string, checkNamespace string, stateNamespace string, workload health.KHWorkload) khstatecrd.KuberhealthyState {
+	initialDetails := health.NewWorkloadDetails(workload)
+	initialDetails.Status = health.StatusUnknown // no result has been reported yet
+	initialDetails.ClusterScoped = stateNamespace != checkNamespace
+	initialDetails.Created = time.Now()
+	initialState := khstatecrd.NewKuberhealthyState(name, initialDetails)
+	initialState.SyncReadyCondition()
+	injectStateLabels(&initialState.ObjectMeta, name, stateNamespace)
+	return initialState
+}
+
+// stateCreateBackoff returns the backoff that khstate creates are retried with.  The first retry waits 100ms and
+// every retry after it waits twice as long.
+func stateCreateBackoff() wait.Backoff {
+	retries := cfg.StateCreateRetries
+	if retries == 0 {
+		retries = defaultStateCreateRetries
+	}
+	if retries < 0 {
+		retries = 0
+	}
+	return wait.Backoff{
+		Duration: time.Millisecond * 100,
+		Factor:   2,
+		Jitter:   0.1,
+		Steps:    retries + 1,
+	}
+}
+
+// createStateResource creates a khstate, retrying transient API errors so that a blip in the API server does not
+// abort check startup.  Permanent errors, including AlreadyExists, are returned without retrying.
+func createStateResource(state *khstatecrd.KuberhealthyState, stateNamespace string) error {
+	if err := stateBreaker.allow(time.Now()); err != nil {
+		return err
+	}
+	attempt := 0
+	err := retry.OnError(stateCreateBackoff(), isTransientStateError, func() error {
+		attempt++
+		if attempt > 1 {
+			log.Infoln("Retrying creation of custom resource", state.Name, "attempt", attempt)
+		}
+		stateClientsLock.RLock()
+		defer stateClientsLock.RUnlock()
+		_, err := khStateClient.Create(state, stateCRDResource, stateNamespace)
+		return err
+	})
+	stateBreaker.record(err, time.Now())
+	return err
+}
+
+// getCheckState retrieves the check values from the kuberhealthy khstate custom resource.  It is a fast read that
+// goes through the state read client, which may be a cache proxy or a lagging API server replica, so the returned
+// state may be slightly stale.  Use getCheckStateConsistent when acting on stale state would be incorrect.
 func getCheckState(c KuberhealthyCheck) (health.WorkloadDetails, error) {
+	return readCheckState(c, khStateReadClient)
+}
+
+// getCheckStateConsistent retrieves the check values from the kuberhealthy khstate custom resource with a strongly
+// consistent read.  The read goes straight to the API server with the write client and an empty resource version,
+// which makes the API server do a quorum read from etcd instead of answering from its watch cache.  The returned
+// state is at least as new as every write that finished before the call, at the cost of a slower read.
+func getCheckStateConsistent(c KuberhealthyCheck) (health.WorkloadDetails, error) {
+	return readCheckState(c, khStateClient)
+}
+
+// readCheckState retrieves the check values from the kuberhealthy khstate custom resource with the supplied client
+func readCheckState(c KuberhealthyCheck, client *khstatecrd.KuberhealthyStateClient) (health.WorkloadDetails, error) {
 
 	var state = health.NewWorkloadDetails(health.KHCheck)
 	var err error
@@ -104,16 +828,19 @@ func getCheckState(c KuberhealthyCheck) (health.WorkloadDetails, error) {
 		return state, errors.New("Error validating CRD exists: " + name + " " + err.Error())
 	}
 
+	// an empty resource version is a quorum read.  "0" would allow any cached version to be returned
 	log.Debugln("Retrieving khstate custom resource for:", name)
-	khstate, err := khStateClient.Get(metav1.GetOptions{}, stateCRDResource, name, c.CheckNamespace())
+	khstate, err := client.Get(metav1.GetOptions{ResourceVersion: ""}, stateCRDResource, name, stateNamespaceForCheck(c.Name(), c.CheckNamespace()))
 	if err != nil {
 		return state, errors.New("Error retrieving custom khstate resource: " + name + " " + err.Error())
 	}
 	log.Debugln("Successfully retrieved khstate resource:", name)
-	return khstate.Spec, nil
+	state = decryptStateFields(khstate.Spec)
+	state.Expired = state.IsExpired(cfg.ResultTTL, time.Now())
+	return state, nil
 }
 
-// getCheckState retrieves the check values from the kuberhealthy khstate
+// getJobState retrieves the job values from the kuberhealthy khstate
 // custom resource
 func getJobState(j KuberhealthyCheck) (health.WorkloadDetails, error) {
 
@@ -127,17 +854,29 @@ func getJobState(j KuberhealthyCheck) (health.WorkloadDetails, error) {
 		return state, errors.New("Error validating CRD exists: " + name + " " + err.Error())
 	}
 
+	// the khstate is read from the namespace it was created and written in
 	log.Debugln("Retrieving khstate custom resource for:", name)
-	khstate, err := khStateClient.Get(metav1.GetOptions{}, stateCRDResource, name, j.CheckNamespace())
+	khstate, err := khStateReadClient.Get(metav1.GetOptions{}, stateCRDResource, name, stateNamespaceForWorkload(j.Name(), j.CheckNamespace(), health.KHJob))
 	if err != nil {
 		return state, errors.New("Error retrieving custom khstate resource: " + name + " " + err.Error())
 	}
 	log.Debugln("Successfully retrieved khstate resource:", name)
-	return khstate.Spec, nil
+	return decryptStateFields(khstate.Spec), nil
 }
 
 // setJobPhase updates the kuberhealthy job phase depending on the state of its run.
 func setJobPhase(jobName string, jobNamespace string, jobPhase v1.JobPhase) error {
+	return setJobPhaseWithReason(jobName, jobNamespace, jobPhase, "", "")
+}
+
+// setJobPhaseWithReason is setJobPhase with a reason and message that say why the job is in the phase, such as why
+// it errored.  The reason and message of the previous phase are always replaced.
+func setJobPhaseWithReason(jobName string, jobNamespace string, jobPhase v1.JobPhase, reason v1.JobReason, message string) error {
+
+	if err := stateWritesBlocked(); err != nil {
+		log.Warningln(jobNamespace, jobName, "not setting khjob phase to", jobPhase+":", err)
+		return err
+	}
 
 	kj, err := khJobClient.KuberhealthyJobs(jobNamespace).Get(jobName, metav1.GetOptions{})
 	if err != nil {
@@ -147,8 +886,10 @@ func setJobPhase(jobName string, jobNamespace string, jobPhase v1.JobPhase) erro
 	resourceVersion := kj.GetResourceVersion()
 	updatedJob := v1.NewKuberhealthyJob(jobName, jobNamespace, kj.Spec)
 	updatedJob.SetResourceVersion(resourceVersion)
-	log.Infoln("Setting khjob phase to:", jobPhase)
+	log.Infoln("Setting khjob phase to:", jobPhase, reason)
 	updatedJob.Spec.Phase = jobPhase
+	updatedJob.Spec.Reason = reason
+	updatedJob.Spec.Message = message
 
 	_, err = khJobClient.KuberhealthyJobs(jobNamespace).Update(&updatedJob)
 	return err
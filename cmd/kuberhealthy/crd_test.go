@@ -0,0 +1,882 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/rest"
+
+	khjobv1 "github.com/Comcast/kuberhealthy/v2/pkg/apis/khjob/v1"
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// TestBoundAnnotations ensures that annotations are trimmed to the maximum size deterministically
+func TestBoundAnnotations(t *testing.T) {
+	annotations := map[string]string{
+		"a": "1234",
+		"b": "1234",
+		"c": "1234",
+	}
+
+	bounded := boundAnnotations(annotations, 10)
+	if len(bounded) != 2 {
+		t.Fatal("Expected 2 annotations to be kept but got", len(bounded), bounded)
+	}
+	if _, ok := bounded["c"]; ok {
+		t.Fatal("Expected annotation c to be dropped but it was kept")
+	}
+
+	bounded = boundAnnotations(annotations, maxStateAnnotationBytes)
+	if len(bounded) != len(annotations) {
+		t.Fatal("Expected all annotations to be kept but got", bounded)
+	}
+}
+
+// TestWritesPaused ensures that state writes are refused while writes are paused
+func TestWritesPaused(t *testing.T) {
+	pauseWrites()
+	defer resumeWrites()
+
+	err := setCheckStateResource("paused-check", "kuberhealthy", health.NewWorkloadDetails(health.KHCheck))
+	if !errors.Is(err, ErrWritesPaused) {
+		t.Fatalf("expected ErrWritesPaused from setCheckStateResource but got: %v", err)
+	}
+
+	err = setJobPhase("paused-job", "kuberhealthy", khjobv1.JobCompleted)
+	if !errors.Is(err, ErrWritesPaused) {
+		t.Fatalf("expected ErrWritesPaused from setJobPhase but got: %v", err)
+	}
+}
+
+// TestValidateStateSnapshot ensures that modified and incompatible snapshots are rejected
+func TestValidateStateSnapshot(t *testing.T) {
+	states := []snapshotState{{Name: "check", Namespace: "kuberhealthy", Spec: health.WorkloadDetails{OK: true}}}
+	checksum, err := checksumSnapshotStates(states)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot := stateSnapshot{Version: stateSnapshotVersion, Checksum: checksum, States: states}
+	if err := validateStateSnapshot(snapshot); err != nil {
+		t.Fatal("expected valid snapshot but got:", err)
+	}
+
+	modified := snapshot
+	modified.States = []snapshotState{{Name: "check", Namespace: "kuberhealthy", Spec: health.WorkloadDetails{OK: false}}}
+	if err := validateStateSnapshot(modified); err == nil {
+		t.Fatal("expected snapshot with modified states to be rejected")
+	}
+
+	incompatible := snapshot
+	incompatible.Version = "v0"
+	if err := validateStateSnapshot(incompatible); err == nil {
+		t.Fatal("expected snapshot with an incompatible version to be rejected")
+	}
+
+	if err := restoreStates(snapshot, restoreMode("merge")); err == nil {
+		t.Fatal("expected unknown restore mode to be rejected")
+	}
+}
+
+// TestSnapshotKeepsEncryptedFields ensures that fields encrypted in a khstate stay encrypted in its snapshot
+func TestSnapshotKeepsEncryptedFields(t *testing.T) {
+	dir, err := ioutil.TempDir("", "khstate-keys")
+	if err != nil {
+		t.Fatal("failed to create the key directory:", err)
+	}
+	defer os.RemoveAll(dir)
+	writeStateEncryptionKey(t, dir, "current", []byte(strings.Repeat("c", 32)))
+
+	originalFields, originalDir := cfg.StateEncryptionFields, cfg.StateEncryptionKeyDir
+	defer func() {
+		cfg.StateEncryptionFields, cfg.StateEncryptionKeyDir = originalFields, originalDir
+		setStateCipher(nil)
+	}()
+	cfg.StateEncryptionFields = []string{encryptedFieldErrors}
+	cfg.StateEncryptionKeyDir = dir
+	err = loadStateCipher()
+	if err != nil {
+		t.Fatal("unexpected error loading the encryption keys:", err)
+	}
+
+	spec, err := encryptStateFields(health.WorkloadDetails{OK: false, Errors: []string{"token abc123 rejected"}})
+	if err != nil {
+		t.Fatal("unexpected error encrypting the khstate:", err)
+	}
+	stored := khstatecrd.NewKuberhealthyState("secret-check", spec)
+	stored.Namespace = "kuberhealthy"
+	useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(khstatecrd.KuberhealthyStateList{Items: []khstatecrd.KuberhealthyState{stored}})
+	})
+
+	snapshot, err := snapshotAllStates("")
+	if err != nil {
+		t.Fatal("unexpected error creating the snapshot:", err)
+	}
+	if len(snapshot.States) != 1 || len(snapshot.States[0].Spec.Errors) != 1 {
+		t.Fatalf("expected the khstate in the snapshot, got %+v", snapshot.States)
+	}
+	if snapshot.States[0].Spec.Errors[0] != spec.Errors[0] {
+		t.Fatal("expected the errors to be kept encrypted in the snapshot, got", snapshot.States[0].Spec.Errors[0])
+	}
+}
+
+// TestRestoreRetriesCreate ensures that restoring a khstate that does not exist retries transient create errors
+func TestRestoreRetriesCreate(t *testing.T) {
+	creates := 0
+	useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodGet:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(metav1.Status{Status: metav1.StatusFailure, Reason: metav1.StatusReasonNotFound, Code: http.StatusNotFound})
+		case http.MethodPost:
+			creates++
+			if creates == 1 {
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(metav1.Status{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Status"}, Status: metav1.StatusFailure, Reason: metav1.StatusReasonServerTimeout, Code: http.StatusInternalServerError})
+				return
+			}
+			created := khstatecrd.KuberhealthyState{}
+			json.NewDecoder(r.Body).Decode(&created)
+			json.NewEncoder(w).Encode(created)
+		}
+	})
+
+	snapshot := stateSnapshot{
+		Version: stateSnapshotVersion,
+		States:  []snapshotState{{Name: "restored-check", Namespace: "kuberhealthy", Spec: health.WorkloadDetails{OK: true}}},
+	}
+	var err error
+	snapshot.Checksum, err = checksumSnapshotStates(snapshot.States)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = restoreStates(snapshot, restoreSkipExisting)
+	if err != nil {
+		t.Fatal("expected the restore to succeed after a transient create error, got", err)
+	}
+	if creates != 2 {
+		t.Fatalf("expected the create to be retried once, got %d creates", creates)
+	}
+}
+
+// useFakeKHStateServer points the khstate clients at a test server that serves the supplied khstate.  The
+// returned func reports how many updates were written.  The original clients are restored when the test ends.
+func useFakeKHStateServer(t *testing.T, existing *khstatecrd.KuberhealthyState) func() int {
+	var updates int32
+	useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodGet:
+			json.NewEncoder(w).Encode(existing)
+		case http.MethodPut:
+			atomic.AddInt32(&updates, 1)
+			b, _ := ioutil.ReadAll(r.Body)
+			w.Write(b)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+	return func() int {
+		return int(atomic.LoadInt32(&updates))
+	}
+}
+
+// useFakeKHStateHandler points the khstate clients at a test server that serves requests with the supplied handler
+// and empties the resource version cache so that states cached by other tests are not used.  The URL of the test
+// server is returned so that other clients can be pointed at it.
+func useFakeKHStateHandler(t *testing.T, handler http.HandlerFunc) string {
+	server := httptest.NewServer(handler)
+	stateVersions.reset()
+
+	client, err := khstatecrd.ClientForConfig(stateCRDGroup, stateCRDVersion, &rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal("failed to create khstate client for test server:", err)
+	}
+	originalClient, originalReadClient := khStateClient, khStateReadClient
+	khStateClient, khStateReadClient = client, client
+	t.Cleanup(func() {
+		khStateClient, khStateReadClient = originalClient, originalReadClient
+		stateVersions.reset()
+		server.Close()
+	})
+	return server.URL
+}
+
+// TestSetCheckStateResourceIfNewerResult ensures that a timeout is not written over a result reported during the run
+func TestSetCheckStateResourceIfNewerResult(t *testing.T) {
+	runStart := time.Now().Add(-time.Minute)
+	notWrittenSince := func(existing health.WorkloadDetails) bool {
+		return !existing.LastRun.After(runStart)
+	}
+	timeout := health.NewWorkloadDetails(health.KHCheck)
+	timeout.OK = false
+	timeout.Errors = []string{"check timed out after 60s"}
+	timeout.TimedOut = true
+
+	existing := khstatecrd.NewKuberhealthyState("timeout-check", health.WorkloadDetails{OK: true, LastRun: time.Now()})
+	existing.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	existing.Kind = "KuberhealthyState"
+	updates := useFakeKHStateServer(t, &existing)
+
+	written, err := setCheckStateResourceIf("timeout-check", "kuberhealthy", timeout, notWrittenSince)
+	if err != nil {
+		t.Fatal("unexpected error writing timeout:", err)
+	}
+	if written || updates() != 0 {
+		t.Fatal("timeout was written over a result reported during the run")
+	}
+
+	existing.Spec.LastRun = runStart.Add(-time.Minute)
+	written, err = setCheckStateResourceIf("timeout-check", "kuberhealthy", timeout, notWrittenSince)
+	if err != nil {
+		t.Fatal("unexpected error writing timeout:", err)
+	}
+	if !written || updates() != 1 {
+		t.Fatal("timeout was not written when no result was reported during the run")
+	}
+}
+
+// TestSanitizeResourceNameWithHash ensures that the hash sanitizer keeps valid names and never maps two names together
+func TestSanitizeResourceNameWithHash(t *testing.T) {
+	if sanitizeResourceNameWithHash("my-check") != "my-check" {
+		t.Fatal("expected a valid name to be unchanged but got", sanitizeResourceNameWithHash("my-check"))
+	}
+
+	names := []string{"My Check", "my check", "my-check", "MY_CHECK", "my.check!", "", "---"}
+	seen := make(map[string]string)
+	for _, name := range names {
+		sanitized := sanitizeResourceNameWithHash(name)
+		if errs := validation.IsDNS1123Subdomain(sanitized); len(errs) > 0 {
+			t.Fatalf("sanitized name %q of %q is not a valid resource name: %v", sanitized, name, errs)
+		}
+		if other, ok := seen[sanitized]; ok {
+			t.Fatalf("%q and %q both sanitized to %q", name, other, sanitized)
+		}
+		seen[sanitized] = name
+		if sanitizeResourceNameWithHash(sanitized) != sanitized {
+			t.Fatalf("sanitizing %q a second time changed it", sanitized)
+		}
+	}
+
+	long := strings.Repeat("A", 300)
+	if len(sanitizeResourceNameWithHash(long)) > validation.DNS1123SubdomainMaxLength {
+		t.Fatal("sanitized name of a long check name is too long")
+	}
+}
+
+// TestGetCheckStateConsistent ensures that consistent reads bypass the state read client, which may be stale
+func TestGetCheckStateConsistent(t *testing.T) {
+	newState := func(uuid string) khstatecrd.KuberhealthyState {
+		s := khstatecrd.NewKuberhealthyState("consistent-check", health.WorkloadDetails{OK: true, CurrentUUID: uuid})
+		s.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+		s.Kind = "KuberhealthyState"
+		return s
+	}
+	fresh, stale := newState("fresh"), newState("stale")
+	useFakeKHStateServer(t, &fresh)
+	freshClient := khStateClient
+	useFakeKHStateServer(t, &stale)
+	khStateClient = freshClient
+
+	c := &FakeCheck{CheckName: "consistent-check", Namespace: "kuberhealthy"}
+	details, err := getCheckState(c)
+	if err != nil {
+		t.Fatal("unexpected error reading check state:", err)
+	}
+	if details.CurrentUUID != "stale" {
+		t.Fatal("expected the fast read to use the state read client")
+	}
+	details, err = getCheckStateConsistent(c)
+	if err != nil {
+		t.Fatal("unexpected error reading check state:", err)
+	}
+	if details.CurrentUUID != "fresh" {
+		t.Fatal("expected the consistent read to bypass the state read client")
+	}
+}
+
+// TestReleaseOwnedCheckStates ensures that only khstates owned by this pod are released on shutdown
+func TestReleaseOwnedCheckStates(t *testing.T) {
+	newState := func(name string, owner string) khstatecrd.KuberhealthyState {
+		s := khstatecrd.NewKuberhealthyState(name, health.WorkloadDetails{OK: true, AuthoritativePod: owner})
+		s.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+		s.Kind = "KuberhealthyState"
+		s.Namespace = "kuberhealthy"
+		return s
+	}
+	states := khstatecrd.KuberhealthyStateList{Items: []khstatecrd.KuberhealthyState{newState("mine", podHostname), newState("theirs", "other-pod")}}
+	states.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	states.Kind = "KuberhealthyStateList"
+
+	var written []khstatecrd.KuberhealthyState
+	useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/khstates"):
+			json.NewEncoder(w).Encode(states)
+		case r.Method == http.MethodGet:
+			for _, s := range states.Items {
+				if strings.HasSuffix(r.URL.Path, "/"+s.Name) {
+					json.NewEncoder(w).Encode(s)
+					return
+				}
+			}
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodPut:
+			b, _ := ioutil.ReadAll(r.Body)
+			s := khstatecrd.KuberhealthyState{}
+			json.Unmarshal(b, &s)
+			written = append(written, s)
+			w.Write(b)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
+	released, err := releaseOwnedCheckStates()
+	if err != nil {
+		t.Fatal("unexpected error releasing khstates:", err)
+	}
+	if released != 1 || len(written) != 1 {
+		t.Fatalf("expected 1 khstate to be released but released %d and wrote %d", released, len(written))
+	}
+	if written[0].Name != "mine" || written[0].Spec.AuthoritativePod != "" {
+		t.Fatalf("expected khstate mine to be released but wrote %s with owner %q", written[0].Name, written[0].Spec.AuthoritativePod)
+	}
+}
+
+// TestResetAllCheckStatesToUnknown ensures that only the status of every khstate is reset and that the rest of its
+// details are kept
+func TestResetAllCheckStatesToUnknown(t *testing.T) {
+	lastRun := time.Now().Add(-time.Hour).Truncate(time.Second)
+	state := khstatecrd.NewKuberhealthyState("reset-check", health.WorkloadDetails{
+		OK:               false,
+		Status:           health.StatusNotOK,
+		Errors:           []string{"connection refused"},
+		LastRun:          lastRun,
+		AuthoritativePod: "other-pod",
+		Annotations:      map[string]string{"runbook": "https://example.com/runbook"},
+	})
+	state.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	state.Kind = "KuberhealthyState"
+	state.Namespace = "kuberhealthy"
+	states := khstatecrd.KuberhealthyStateList{Items: []khstatecrd.KuberhealthyState{state}}
+	states.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	states.Kind = "KuberhealthyStateList"
+
+	var written []khstatecrd.KuberhealthyState
+	useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/khstates"):
+			json.NewEncoder(w).Encode(states)
+		case r.Method == http.MethodGet:
+			json.NewEncoder(w).Encode(state)
+		case r.Method == http.MethodPut:
+			b, _ := ioutil.ReadAll(r.Body)
+			s := khstatecrd.KuberhealthyState{}
+			json.Unmarshal(b, &s)
+			written = append(written, s)
+			w.Write(b)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
+	err := resetAllCheckStatesToUnknown()
+	if err != nil {
+		t.Fatal("unexpected error resetting khstates:", err)
+	}
+	if len(written) != 1 {
+		t.Fatalf("expected 1 khstate to be reset, wrote %d", len(written))
+	}
+	reset := written[0].Spec
+	if reset.GetStatus() != health.StatusUnknown {
+		t.Fatal("expected the status to be reset to unknown, got", reset.GetStatus())
+	}
+	if reset.OK || len(reset.Errors) != 1 || !reset.LastRun.Equal(lastRun) || reset.AuthoritativePod != "other-pod" || reset.Annotations["runbook"] == "" {
+		t.Fatalf("expected the details other than the status to be kept, got %+v", reset)
+	}
+}
+
+// TestForeignOwnership ensures that khstates owned by a kuberhealthy instance in another namespace are only
+// overwritten when takeover is allowed
+func TestForeignOwnership(t *testing.T) {
+	originalNamespace, originalTakeover := podNamespace, cfg.AllowForeignTakeover
+	defer func() {
+		podNamespace, cfg.AllowForeignTakeover = originalNamespace, originalTakeover
+	}()
+	podNamespace = "team-a"
+
+	tests := []struct {
+		owner    string
+		takeover bool
+		expected error
+	}{
+		{owner: "", expected: nil},
+		{owner: "team-a", expected: nil},
+		{owner: "team-b", expected: ErrForeignOwnership},
+		{owner: "team-b", takeover: true, expected: nil},
+	}
+
+	for _, test := range tests {
+		cfg.AllowForeignTakeover = test.takeover
+		existing := khstatecrd.NewKuberhealthyState("check", health.WorkloadDetails{OK: true, AuthoritativePod: "other-pod", AuthoritativeNamespace: test.owner})
+		existing.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+		existing.Kind = "KuberhealthyState"
+		existing.Namespace = "team-a"
+		updates := useFakeKHStateServer(t, &existing)
+
+		written, err := setCheckStateResourceIf("check", "team-a", health.WorkloadDetails{OK: false, Errors: []string{"broken"}}, nil)
+		if err != test.expected {
+			t.Fatalf("owner %q with takeover %t: expected error %v, got %v", test.owner, test.takeover, test.expected, err)
+		}
+		expectedUpdates := 1
+		if test.expected != nil {
+			expectedUpdates = 0
+		}
+		if updates() != expectedUpdates || written != (expectedUpdates == 1) {
+			t.Fatalf("owner %q with takeover %t: expected %d writes, got %d", test.owner, test.takeover, expectedUpdates, updates())
+		}
+	}
+}
+
+// TestSetCheckStateResourceSkipsRecordedRun ensures that a result is not written again when the result of its run
+// is already stored
+func TestSetCheckStateResourceSkipsRecordedRun(t *testing.T) {
+	existing := khstatecrd.NewKuberhealthyState("check", health.WorkloadDetails{OK: true, RunID: "run-1"})
+	existing.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	existing.Kind = "KuberhealthyState"
+	existing.Namespace = "kuberhealthy"
+	updates := useFakeKHStateServer(t, &existing)
+
+	tests := []struct {
+		runID       string
+		finishesRun bool // set for the write that finishes the run of the check
+		written     bool
+	}{
+		{runID: "run-1", written: false},
+		{runID: "run-2", written: true},
+		{runID: "", written: true},
+		{runID: "run-1", finishesRun: true, written: true},
+	}
+	for _, test := range tests {
+		before := updates()
+		details := health.WorkloadDetails{OK: true, RunID: test.runID}
+		if test.finishesRun {
+			details.SetSchedule(time.Now(), time.Now())
+		}
+		written, err := setCheckStateResourceIf("check", "kuberhealthy", details, nil)
+		if err != nil {
+			t.Fatalf("run %q: unexpected error writing khstate: %v", test.runID, err)
+		}
+		if written != test.written || (updates() > before) != test.written {
+			t.Fatalf("run %q: expected written to be %t, got %t with %d writes", test.runID, test.written, written, updates()-before)
+		}
+		stateVersions.reset()
+	}
+}
+
+// TestStateGenerationSurvivesConflicts ensures that every write advances the generation from the version that was
+// fetched for it, including writes retried after a conflict
+func TestStateGenerationSurvivesConflicts(t *testing.T) {
+	stored := khstatecrd.NewKuberhealthyState("check", health.WorkloadDetails{OK: true, Generation: 4})
+	stored.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	stored.Kind = "KuberhealthyState"
+	stored.Namespace = "kuberhealthy"
+
+	var conflicted bool
+	var written khstatecrd.KuberhealthyState
+	useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodGet:
+			json.NewEncoder(w).Encode(stored)
+		case http.MethodPut:
+			// another writer gets in first, so the write has to be retried against its version
+			if !conflicted {
+				conflicted = true
+				stored.Spec.Generation = 5
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).Encode(metav1.Status{Status: metav1.StatusFailure, Reason: metav1.StatusReasonConflict, Code: http.StatusConflict})
+				return
+			}
+			json.NewDecoder(r.Body).Decode(&written)
+			json.NewEncoder(w).Encode(written)
+		}
+	})
+
+	_, err := setCheckStateResourceIf("check", "kuberhealthy", health.WorkloadDetails{OK: false, Errors: []string{"broken"}}, nil)
+	if err != nil {
+		t.Fatal("unexpected error writing khstate:", err)
+	}
+	if written.Spec.Generation != 6 {
+		t.Fatalf("expected the write to advance the generation to 6, got %d", written.Spec.Generation)
+	}
+}
+
+// TestStateNamespaceFiltering ensures that the namespace allow-list and deny-list are matched with wildcards and
+// that khstate in excluded namespaces is neither written nor reaped
+func TestStateNamespaceFiltering(t *testing.T) {
+	originalAllowed, originalExcluded := cfg.StateNamespaces, cfg.ExcludedStateNamespaces
+	defer func() {
+		cfg.StateNamespaces, cfg.ExcludedStateNamespaces = originalAllowed, originalExcluded
+	}()
+
+	tests := []struct {
+		allowed   []string
+		excluded  []string
+		namespace string
+		managed   bool
+	}{
+		{namespace: "anything", managed: true},
+		{allowed: []string{"kuberhealthy"}, namespace: "kuberhealthy", managed: true},
+		{allowed: []string{"kuberhealthy"}, namespace: "default", managed: false},
+		{allowed: []string{"team-*"}, namespace: "team-a", managed: true},
+		{allowed: []string{"team-*"}, namespace: "other-team", managed: false},
+		{excluded: []string{"kube-*"}, namespace: "kube-system", managed: false},
+		{allowed: []string{"team-*"}, excluded: []string{"team-secret"}, namespace: "team-secret", managed: false},
+	}
+	for _, test := range tests {
+		cfg.StateNamespaces, cfg.ExcludedStateNamespaces = test.allowed, test.excluded
+		if stateNamespaceManaged(test.namespace) != test.managed {
+			t.Fatalf("allowed %v excluded %v: expected namespace %s managed to be %t", test.allowed, test.excluded, test.namespace, test.managed)
+		}
+	}
+
+	// writes to an excluded namespace never reach the API server
+	cfg.StateNamespaces, cfg.ExcludedStateNamespaces = nil, []string{"kube-*"}
+	existing := khstatecrd.NewKuberhealthyState("check", health.WorkloadDetails{OK: true})
+	existing.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	existing.Kind = "KuberhealthyState"
+	existing.Namespace = "kube-system"
+	updates := useFakeKHStateServer(t, &existing)
+	written, err := setCheckStateResourceIf("check", "kube-system", health.WorkloadDetails{OK: false, Errors: []string{"broken"}}, nil)
+	if !errors.Is(err, ErrNamespaceExcluded) || written || updates() != 0 {
+		t.Fatalf("expected the write to be skipped with ErrNamespaceExcluded, got written %t, %d updates, error %v", written, updates(), err)
+	}
+	_, err = ensureStateResource("check", "kube-system", health.KHCheck)
+	if !errors.Is(err, ErrNamespaceExcluded) {
+		t.Fatal("expected ensuring a khstate in an excluded namespace to return ErrNamespaceExcluded, got", err)
+	}
+
+	// orphaned khstates in excluded namespaces are kept
+	deleted, kept, err := reapOrphanedStateResources(map[string]health.WorkloadDetails{"kube-system/orphan": {}}, map[string]bool{}, true)
+	if err != nil || deleted != 0 || kept != 1 {
+		t.Fatalf("expected the orphan in an excluded namespace to be kept, got %d deleted, %d kept, error %v", deleted, kept, err)
+	}
+}
+
+// TestEnsureStateResourceRetriesCreate ensures that a khstate create that fails with a transient error is retried,
+// that AlreadyExists counts as success and that permanent errors are not retried
+func TestEnsureStateResourceRetriesCreate(t *testing.T) {
+	tests := []struct {
+		failures []metav1.Status // the responses of creates before one succeeds
+		creates  int
+		created  bool
+		fails    bool
+	}{
+		{failures: []metav1.Status{{Reason: metav1.StatusReasonServerTimeout, Code: http.StatusInternalServerError}}, creates: 2, created: true},
+		{failures: []metav1.Status{{Reason: metav1.StatusReasonAlreadyExists, Code: http.StatusConflict}}, creates: 1},
+		{failures: []metav1.Status{{Reason: metav1.StatusReasonForbidden, Code: http.StatusForbidden}}, creates: 1, fails: true},
+	}
+
+	for _, test := range tests {
+		creates := 0
+		useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			switch r.Method {
+			case http.MethodGet:
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(metav1.Status{Status: metav1.StatusFailure, Reason: metav1.StatusReasonNotFound, Code: http.StatusNotFound})
+			case http.MethodPost:
+				creates++
+				if creates <= len(test.failures) {
+					failure := test.failures[creates-1]
+					failure.APIVersion, failure.Kind = "v1", "Status"
+					failure.Status = metav1.StatusFailure
+					w.WriteHeader(int(failure.Code))
+					json.NewEncoder(w).Encode(failure)
+					return
+				}
+				created := khstatecrd.KuberhealthyState{}
+				json.NewDecoder(r.Body).Decode(&created)
+				json.NewEncoder(w).Encode(created)
+			}
+		})
+
+		created, err := ensureStateResource("check", "kuberhealthy", health.KHCheck)
+		if (err != nil) != test.fails {
+			t.Fatalf("after %v: expected failure to be %t, got error %v", test.failures, test.fails, err)
+		}
+		if created != test.created || creates != test.creates {
+			t.Fatalf("after %v: expected %d creates and created to be %t, got %d creates and %t", test.failures, test.creates, test.created, creates, created)
+		}
+	}
+}
+
+// TestReapRetainsOrphanedStates ensures that orphaned khstates are kept and marked as retained until their last run
+// is older than the orphaned state retention window
+func TestReapRetainsOrphanedStates(t *testing.T) {
+	originalRetention := cfg.OrphanedStateRetention
+	defer func() {
+		cfg.OrphanedStateRetention = originalRetention
+		retainedStates.set(allStates, nil)
+	}()
+	cfg.OrphanedStateRetention = time.Hour
+
+	var deletes []string
+	useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			deletes = append(deletes, r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	})
+
+	existing := map[string]health.WorkloadDetails{
+		"kuberhealthy/recent": {LastRun: time.Now().Add(-time.Minute)},
+		"kuberhealthy/old":    {LastRun: time.Now().Add(-2 * time.Hour)},
+	}
+	deleted, kept, err := reapOrphanedStateResources(existing, map[string]bool{}, false)
+	if err != nil || deleted != 1 || kept != 1 {
+		t.Fatalf("expected 1 deleted and 1 retained khstate, got %d deleted, %d kept, error %v", deleted, kept, err)
+	}
+	if len(deletes) != 1 || !strings.HasSuffix(deletes[0], "/old") {
+		t.Fatal("expected only the khstate older than the retention window to be deleted, got", deletes)
+	}
+
+	checkDetails := map[string]health.WorkloadDetails{"kuberhealthy/recent": {}, "kuberhealthy/dns": {}}
+	retainedStates.mark(checkDetails)
+	if !checkDetails["kuberhealthy/recent"].Retained || checkDetails["kuberhealthy/dns"].Retained {
+		t.Fatalf("expected only the retained khstate to be marked, got %+v", checkDetails)
+	}
+}
+
+// TestDescribeStateTransition ensures that result change logs include the previous result
+func TestDescribeStateTransition(t *testing.T) {
+	tests := []struct {
+		previous health.WorkloadDetails
+		state    health.WorkloadDetails
+		expected string
+	}{
+		{
+			previous: health.WorkloadDetails{OK: true, Status: health.StatusOK},
+			state:    health.WorkloadDetails{OK: false, Status: health.StatusNotOK, Errors: []string{"pod failed", "timed out"}},
+			expected: "was OK, now failing with 2 errors: pod failed",
+		},
+		{
+			previous: health.WorkloadDetails{OK: false, Status: health.StatusNotOK, Errors: []string{"pod failed"}},
+			state:    health.WorkloadDetails{OK: true, Status: health.StatusOK},
+			expected: "was failing with 1 errors, now OK",
+		},
+		{
+			previous: health.WorkloadDetails{Status: health.StatusUnknown},
+			state:    health.WorkloadDetails{OK: true, Status: health.StatusOK},
+			expected: "was Unknown, now OK",
+		},
+	}
+	for _, test := range tests {
+		transition := describeStateTransition(test.previous, test.state)
+		if transition != test.expected {
+			t.Fatalf("expected transition %q, got %q", test.expected, transition)
+		}
+	}
+}
+
+// TestBecameStatic ensures that a check is only reported as becoming static on the first write past the window
+func TestBecameStatic(t *testing.T) {
+	window := time.Hour
+	changed := time.Now().Add(-2 * time.Hour)
+	tests := []struct {
+		previousRun time.Time
+		run         time.Time
+		expected    bool
+	}{
+		{previousRun: changed.Add(30 * time.Minute), run: changed.Add(90 * time.Minute), expected: true},
+		{previousRun: changed.Add(90 * time.Minute), run: changed.Add(2 * time.Hour), expected: false},
+		{previousRun: changed.Add(10 * time.Minute), run: changed.Add(20 * time.Minute), expected: false},
+	}
+	for _, test := range tests {
+		previous := health.WorkloadDetails{OK: true, Status: health.StatusOK, LastRun: test.previousRun, LastResultChange: changed}
+		state := health.WorkloadDetails{OK: true, Status: health.StatusOK, LastRun: test.run, LastResultChange: changed}
+		if becameStatic(previous, state, window) != test.expected {
+			t.Fatalf("expected a write at %s after a write at %s to become static: %t", test.run, test.previousRun, test.expected)
+		}
+	}
+	previous := health.WorkloadDetails{OK: true, Status: health.StatusOK, LastRun: changed, LastResultChange: changed}
+	state := health.WorkloadDetails{OK: true, Status: health.StatusOK, LastRun: changed.Add(2 * time.Hour), LastResultChange: changed}
+	if becameStatic(previous, state, 0) {
+		t.Fatalf("expected a window of zero to disable static detection")
+	}
+}
+
+// TestTruncateError ensures that errors are only truncated past the maximum length and never exceed it
+func TestTruncateError(t *testing.T) {
+	maxLength := 32
+	atLimit := strings.Repeat("a", maxLength)
+	if truncated := truncateError(atLimit, maxLength); truncated != atLimit {
+		t.Fatal("expected an error at the maximum length to be left alone, got", truncated)
+	}
+
+	overLimit := atLimit + "b"
+	truncated := truncateError(overLimit, maxLength)
+	if len(truncated) != maxLength {
+		t.Fatalf("expected a truncated error of %d bytes, got %d: %s", maxLength, len(truncated), truncated)
+	}
+	if !strings.HasPrefix(truncated, strings.Repeat("a", maxLength-len(truncatedErrorMarker))) || !strings.HasSuffix(truncated, truncatedErrorMarker) {
+		t.Fatal("expected the beginning of the error to be kept with the truncated marker, got", truncated)
+	}
+
+	// a multi-byte character that straddles the cut is dropped entirely
+	multiByte := strings.Repeat("a", maxLength-len(truncatedErrorMarker)-1) + "é" + "b"
+	truncated = truncateError(multiByte, maxLength)
+	if !utf8.ValidString(truncated) || len(truncated) > maxLength {
+		t.Fatal("expected a valid UTF-8 error within the maximum length, got", truncated)
+	}
+
+	// limits too small for the marker just cut the error
+	if truncated := truncateError(overLimit, 4); truncated != "aaaa" {
+		t.Fatal("expected an error cut to the maximum length without a marker, got", truncated)
+	}
+
+	errs := []string{"short", overLimit}
+	bounded := truncateErrors(errs, maxLength)
+	if bounded[0] != "short" || len(bounded[1]) != maxLength || errs[1] != overLimit {
+		t.Fatal("expected only the long error to be truncated in a copy of the errors, got", bounded)
+	}
+}
+
+// TestEnsureStateResource ensures that a missing khstate is created and reported as created
+func TestEnsureStateResource(t *testing.T) {
+	existing := khstatecrd.NewKuberhealthyState("existing", health.WorkloadDetails{OK: true})
+	existing.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	existing.Kind = "KuberhealthyState"
+
+	var creates int
+	useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/existing"):
+			json.NewEncoder(w).Encode(existing)
+		case r.Method == http.MethodGet:
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodPost:
+			creates++
+			b, _ := ioutil.ReadAll(r.Body)
+			w.WriteHeader(http.StatusCreated)
+			w.Write(b)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
+	created, err := ensureStateResource("existing", "kuberhealthy", health.KHCheck)
+	if err != nil || created {
+		t.Fatalf("expected an existing khstate to be found, got created %t and error %v", created, err)
+	}
+	created, err = ensureStateResource("missing", "kuberhealthy", health.KHCheck)
+	if err != nil || !created {
+		t.Fatalf("expected a missing khstate to be created, got created %t and error %v", created, err)
+	}
+	if creates != 1 {
+		t.Fatalf("expected exactly one khstate to be created, got %d", creates)
+	}
+}
+
+// TestEnsureStateResourceIgnoresStaleReads ensures that a khstate missing from a stale state read client is not
+// created again when the write client already has it
+func TestEnsureStateResourceIgnoresStaleReads(t *testing.T) {
+	existing := khstatecrd.NewKuberhealthyState("stale-check", health.WorkloadDetails{OK: true})
+	existing.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	existing.Kind = "KuberhealthyState"
+
+	var creates int
+	useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPost {
+			creates++
+		}
+		json.NewEncoder(w).Encode(existing)
+	})
+	writeClient := khStateClient
+	useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(metav1.Status{Status: metav1.StatusFailure, Reason: metav1.StatusReasonNotFound, Code: http.StatusNotFound})
+	})
+	khStateClient = writeClient
+
+	created, err := ensureStateResource("stale-check", "kuberhealthy", health.KHCheck)
+	if err != nil || created || creates != 0 {
+		t.Fatalf("expected the khstate to be found through the write client, got created %t, %d creates and error %v", created, creates, err)
+	}
+}
+
+// TestLastSuccess ensures that the time a check last passed is only moved by OK results and that a check that has
+// never passed has no last success
+func TestLastSuccess(t *testing.T) {
+	stateVersions.reset()
+	defer stateVersions.reset()
+
+	stored := khstatecrd.NewKuberhealthyState("last-success-check", health.WorkloadDetails{Status: health.StatusUnknown, Errors: []string{}})
+	stored.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	stored.Kind = "KuberhealthyState"
+	stored.Namespace = "kuberhealthy"
+	useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPut {
+			stored = khstatecrd.KuberhealthyState{}
+			json.NewDecoder(r.Body).Decode(&stored)
+		}
+		json.NewEncoder(w).Encode(stored)
+	})
+	write := func(state health.WorkloadDetails) {
+		err := setCheckStateResource("last-success-check", "kuberhealthy", state)
+		if err != nil {
+			t.Fatal("unexpected error writing the khstate:", err)
+		}
+	}
+
+	write(health.WorkloadDetails{OK: false, Errors: []string{"broken"}})
+	if !stored.Spec.LastSuccess.IsZero() {
+		t.Fatal("expected no last success for a check that never passed, got", stored.Spec.LastSuccess)
+	}
+
+	write(health.WorkloadDetails{OK: true, Errors: []string{}})
+	passed := stored.Spec.LastSuccess
+	if passed.IsZero() || !passed.Equal(stored.Spec.LastRun) {
+		t.Fatalf("expected the last success to be the passing run at %s, got %s", stored.Spec.LastRun, passed)
+	}
+
+	write(health.WorkloadDetails{OK: false, Errors: []string{"broken again"}})
+	if !stored.Spec.LastSuccess.Equal(passed) || stored.Spec.LastRun.Equal(passed) {
+		t.Fatalf("expected a failure to keep the last success at %s, got %s", passed, stored.Spec.LastSuccess)
+	}
+}
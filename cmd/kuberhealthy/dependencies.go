@@ -0,0 +1,146 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// checkDependencies holds the namespace/name of the checks that each loaded khcheck depends on, keyed by the
+// namespace/name of the dependent khcheck
+var checkDependencies = struct {
+	sync.RWMutex
+	deps map[string][]string
+}{deps: make(map[string][]string)}
+
+// dependencyKey returns the namespace/name of a dependsOn entry.  Entries without a namespace refer to a check in
+// the same namespace as the check that depends on it.
+func dependencyKey(dependency string, checkNamespace string) string {
+	if strings.Contains(dependency, "/") {
+		return dependency
+	}
+	return checkNamespace + "/" + dependency
+}
+
+// findDependencyCycles returns the namespace/name of every check that is part of a dependency cycle
+func findDependencyCycles(deps map[string][]string) map[string]bool {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	inCycle := make(map[string]bool)
+	visitState := make(map[string]int)
+	var stack []string
+
+	var visit func(key string)
+	visit = func(key string) {
+		visitState[key] = visiting
+		stack = append(stack, key)
+		for _, dep := range deps[key] {
+			switch visitState[dep] {
+			case unvisited:
+				visit(dep)
+			case visiting:
+				// everything on the stack since the last visit of dep is part of the cycle
+				for i := len(stack) - 1; i >= 0; i-- {
+					inCycle[stack[i]] = true
+					if stack[i] == dep {
+						break
+					}
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		visitState[key] = visited
+	}
+
+	// visit in a stable order so that cycles are always reported the same way
+	var keys []string
+	for key := range deps {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if visitState[key] == unvisited {
+			visit(key)
+		}
+	}
+	return inCycle
+}
+
+// setCheckDependencies replaces the dependencies of all loaded checks.  Checks in a dependency cycle would skip
+// each other forever once one of them failed, so their dependencies are dropped with an error instead.
+func setCheckDependencies(deps map[string][]string) {
+	for key := range findDependencyCycles(deps) {
+		log.Errorln("Check", key, "is part of a dependency cycle. Ignoring its dependencies:", deps[key])
+		delete(deps, key)
+	}
+
+	checkDependencies.Lock()
+	defer checkDependencies.Unlock()
+	checkDependencies.deps = deps
+}
+
+// dependenciesForCheck returns the namespace/name of every check that a loaded check depends on
+func dependenciesForCheck(checkName string, checkNamespace string) []string {
+	checkDependencies.RLock()
+	defer checkDependencies.RUnlock()
+	return checkDependencies.deps[checkNamespace+"/"+checkName]
+}
+
+// failingDependency returns the namespace/name of the first dependency of a check that is currently failing, or
+// a blank string if none are.  Dependencies that are not loaded or have not reported yet are not failing.
+func (k *Kuberhealthy) failingDependency(c KuberhealthyCheck) string {
+	for _, key := range dependenciesForCheck(c.Name(), c.CheckNamespace()) {
+		namespace, name := splitCheckKey(key)
+		dependency, err := k.getCheck(name, namespace)
+		if err != nil {
+			log.Warningln("Check", c.Name(), "in namespace", c.CheckNamespace(), "depends on", key, "which is not loaded")
+			continue
+		}
+		state, err := getCheckState(dependency)
+		if err != nil {
+			log.Errorln("Error getting the state of dependency", key, "of check", c.Name(), "in namespace", c.CheckNamespace()+":", err)
+			continue
+		}
+		if state.GetStatus() == health.StatusNotOK {
+			return key
+		}
+	}
+	return ""
+}
+
+// skipCheckRun records that a run of a check was skipped because one of its dependencies is failing
+func (k *Kuberhealthy) skipCheckRun(c KuberhealthyCheck, dependency string) error {
+	details := health.NewWorkloadDetails(health.KHCheck)
+	details.Namespace = c.CheckNamespace()
+	details.OK = false
+	details.Skipped = true
+	details.Errors = []string{fmt.Sprintf("Skipped (dependency %s failing)", dependency)}
+
+	// we need to maintain the current UUID, which means fetching it first
+	checkState, err := getCheckState(c)
+	if err != nil {
+		return fmt.Errorf("error getting check state of skipped check %s %s: %w", c.Name(), c.CheckNamespace(), err)
+	}
+	details.CurrentUUID = checkState.CurrentUUID
+
+	return k.storeCheckState(c.Name(), c.CheckNamespace(), details)
+}
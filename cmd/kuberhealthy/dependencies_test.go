@@ -0,0 +1,90 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// TestFindDependencyCycles ensures that only checks that are part of a cycle are reported
+func TestFindDependencyCycles(t *testing.T) {
+	deps := map[string][]string{
+		"kuberhealthy/a": {"kuberhealthy/b"},
+		"kuberhealthy/b": {"kuberhealthy/c"},
+		"kuberhealthy/c": {"kuberhealthy/a"},
+		"kuberhealthy/d": {"kuberhealthy/a"},
+		"kuberhealthy/e": {"kuberhealthy/e"},
+		"kuberhealthy/f": {"other/g"},
+	}
+	expected := map[string]bool{
+		"kuberhealthy/a": true,
+		"kuberhealthy/b": true,
+		"kuberhealthy/c": true,
+		"kuberhealthy/e": true,
+	}
+	cycles := findDependencyCycles(deps)
+	if !reflect.DeepEqual(cycles, expected) {
+		t.Fatalf("expected cycles %v, got %v", expected, cycles)
+	}
+
+	setCheckDependencies(deps)
+	defer setCheckDependencies(make(map[string][]string))
+	if len(dependenciesForCheck("a", "kuberhealthy")) != 0 {
+		t.Fatal("expected the dependencies of a check in a cycle to be dropped")
+	}
+	if len(dependenciesForCheck("d", "kuberhealthy")) != 1 {
+		t.Fatal("expected the dependencies of a check outside of a cycle to be kept")
+	}
+}
+
+// TestDependencyKey ensures that dependencies without a namespace use the namespace of the dependent check
+func TestDependencyKey(t *testing.T) {
+	if key := dependencyKey("dns", "kuberhealthy"); key != "kuberhealthy/dns" {
+		t.Fatal("expected a dependency without a namespace to use the check namespace, got", key)
+	}
+	if key := dependencyKey("other/dns", "kuberhealthy"); key != "other/dns" {
+		t.Fatal("expected a dependency with a namespace to keep it, got", key)
+	}
+}
+
+// TestFailingDependency ensures that only a failing dependency gates a check run
+func TestFailingDependency(t *testing.T) {
+	k := &Kuberhealthy{Checks: []KuberhealthyCheck{&FakeCheck{CheckName: "dns", Namespace: "kuberhealthy"}}}
+	c := &FakeCheck{CheckName: "deployment", Namespace: "kuberhealthy"}
+	setCheckDependencies(map[string][]string{"kuberhealthy/deployment": {"kuberhealthy/missing", "kuberhealthy/dns"}})
+	defer setCheckDependencies(make(map[string][]string))
+
+	tests := []struct {
+		name     string
+		details  health.WorkloadDetails
+		expected string
+	}{
+		{name: "passing dependency", details: health.WorkloadDetails{OK: true, Status: health.StatusOK}},
+		{name: "unknown dependency", details: health.WorkloadDetails{Status: health.StatusUnknown}},
+		{name: "failing dependency", details: health.WorkloadDetails{OK: false, Status: health.StatusNotOK}, expected: "kuberhealthy/dns"},
+	}
+	for _, test := range tests {
+		state := khstatecrd.NewKuberhealthyState("dns", test.details)
+		state.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+		state.Kind = "KuberhealthyState"
+		useFakeKHStateServer(t, &state)
+
+		dependency := k.failingDependency(c)
+		if dependency != test.expected {
+			t.Fatalf("%s: expected failing dependency %q, got %q", test.name, test.expected, dependency)
+		}
+	}
+}
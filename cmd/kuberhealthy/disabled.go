@@ -0,0 +1,70 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// disabledChecks holds the namespace/name of every loaded khcheck that is disabled
+var disabledChecks = struct {
+	sync.RWMutex
+	checks map[string]bool
+}{checks: make(map[string]bool)}
+
+// setDisabledChecks replaces the disabled checks
+func setDisabledChecks(checks map[string]bool) {
+	disabledChecks.Lock()
+	defer disabledChecks.Unlock()
+	disabledChecks.checks = checks
+}
+
+// isCheckDisabled determines if a loaded check is disabled
+func isCheckDisabled(checkName string, checkNamespace string) bool {
+	disabledChecks.RLock()
+	defer disabledChecks.RUnlock()
+	return disabledChecks.checks[checkNamespace+"/"+checkName]
+}
+
+// disabledState returns the state recorded for a disabled check.  The last result is kept for reference, but its
+// status is unknown so that it is not shown as live and does not fail the overall status.
+func disabledState(existing health.WorkloadDetails) health.WorkloadDetails {
+	details := health.NewWorkloadDetails(health.KHCheck)
+	details.Namespace = existing.Namespace
+	details.OK = existing.OK
+	details.Errors = existing.Errors
+	details.Annotations = existing.Annotations
+	details.RunDuration = existing.RunDuration
+	details.CurrentUUID = existing.CurrentUUID
+	details.Status = health.StatusUnknown
+	details.Disabled = true
+	return details
+}
+
+// recordCheckDisabled records that a check is disabled.  Nothing is written when its khstate already says so, so
+// a disabled check writes its khstate once instead of on every interval.
+func (k *Kuberhealthy) recordCheckDisabled(c KuberhealthyCheck) error {
+	checkState, err := getCheckState(c)
+	if err != nil {
+		return fmt.Errorf("error getting check state of disabled check %s %s: %w", c.Name(), c.CheckNamespace(), err)
+	}
+	if checkState.Disabled {
+		return nil
+	}
+	if len(checkState.Namespace) == 0 {
+		checkState.Namespace = c.CheckNamespace()
+	}
+	return k.storeCheckState(c.Name(), c.CheckNamespace(), disabledState(checkState))
+}
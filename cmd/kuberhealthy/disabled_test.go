@@ -0,0 +1,70 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// TestRecordCheckDisabled ensures that disabling a check keeps its last result with an unknown status, and that
+// the khstate is only written once while the check stays disabled
+func TestRecordCheckDisabled(t *testing.T) {
+	stored := khstatecrd.NewKuberhealthyState("disabled-check", health.WorkloadDetails{
+		OK:               false,
+		Status:           health.StatusNotOK,
+		Errors:           []string{"broken"},
+		Namespace:        "kuberhealthy",
+		AuthoritativePod: podHostname,
+		LastRun:          time.Now().Add(-time.Hour),
+	})
+	stored.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	stored.Kind = "KuberhealthyState"
+	stored.Namespace = "kuberhealthy"
+
+	var writes int
+	useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPut {
+			writes++
+			stored = khstatecrd.KuberhealthyState{}
+			json.NewDecoder(r.Body).Decode(&stored)
+		}
+		json.NewEncoder(w).Encode(stored)
+	})
+
+	k := &Kuberhealthy{}
+	check := &FakeCheck{CheckName: "disabled-check", Namespace: "kuberhealthy"}
+	for i := 0; i < 3; i++ {
+		err := k.recordCheckDisabled(check)
+		if err != nil {
+			t.Fatal("unexpected error recording that the check is disabled:", err)
+		}
+	}
+	if writes != 1 {
+		t.Fatal("expected the disabled check to be written once, got", writes)
+	}
+	if !stored.Spec.Disabled || stored.Spec.GetStatus() != health.StatusUnknown || len(stored.Spec.Errors) != 1 {
+		t.Fatalf("expected the last result to be kept with an unknown status, got %+v", stored.Spec)
+	}
+	if shouldNotify(health.WorkloadDetails{OK: true}, stored.Spec) {
+		t.Fatal("expected disabling a check to not be notified")
+	}
+	if stored.Spec.IsExpired(time.Minute, time.Now().Add(time.Hour)) {
+		t.Fatal("expected a disabled check to never expire")
+	}
+}
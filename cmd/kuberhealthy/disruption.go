@@ -0,0 +1,129 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// disruptionGatePath is where drain controllers ask if voluntary disruptions may proceed
+const disruptionGatePath = "/disruptionReady"
+
+// blockingCheck is a gating check that is blocking voluntary disruptions
+type blockingCheck struct {
+	Name      string
+	Namespace string
+	Reason    string
+}
+
+// disruptionGateStatus says if the gating checks allow voluntary disruptions, such as node drains, to proceed
+type disruptionGateStatus struct {
+	Ready    bool
+	Blocking []blockingCheck
+}
+
+// gatesDisruptions determines if a check, keyed by namespace/name, is one of the configured gating checks.  Every
+// check gates disruptions when none are configured.
+func gatesDisruptions(key string) bool {
+	if len(cfg.DisruptionGateChecks) == 0 {
+		return true
+	}
+	for _, pattern := range cfg.DisruptionGateChecks {
+		matched, err := path.Match(pattern, key)
+		if err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluateDisruptionGate determines if voluntary disruptions may proceed.  They are blocked while any gating check is
+// failing in a way that fails the overall status, has an expired result or has not reported a result.  Gating checks
+// that are configured without wildcards and have no khstate at all also block, so that a missing check can not be
+// mistaken for a healthy one.
+func evaluateDisruptionGate(state health.State, now time.Time) disruptionGateStatus {
+	status := disruptionGateStatus{Ready: true, Blocking: []blockingCheck{}}
+	block := func(key string, reason string) {
+		namespace, name := splitCheckKey(key)
+		status.Ready = false
+		status.Blocking = append(status.Blocking, blockingCheck{Name: name, Namespace: namespace, Reason: reason})
+	}
+
+	seen := make(map[string]bool)
+	for _, checkDetails := range []map[string]health.WorkloadDetails{state.CheckDetails, state.ClusterCheckDetails} {
+		for _, key := range sortedCheckKeys(checkDetails) {
+			details := checkDetails[key]
+			seen[key] = true
+			if details.Synthetic || details.Disabled || !gatesDisruptions(key) {
+				continue
+			}
+			switch {
+			case details.IsExpired(cfg.ResultTTL, now):
+				block(key, "result expired")
+			case details.GetStatus() == health.StatusUnknown:
+				block(key, "has not reported a result")
+			case details.GetStatus() == health.StatusNotOK && failsOverallStatus(details):
+				reason := "failing"
+				if len(details.Errors) > 0 {
+					reason = "failing: " + details.Errors[0]
+				}
+				block(key, reason)
+			}
+		}
+	}
+
+	for _, pattern := range cfg.DisruptionGateChecks {
+		if strings.ContainsAny(pattern, `*?[\`) || seen[pattern] {
+			continue
+		}
+		block(pattern, "has no khstate")
+	}
+	return status
+}
+
+// disruptionGateHandler serves the disruption gate as a readiness signal.  It responds with 200 when voluntary
+// disruptions may proceed and 503 while any gating check blocks them, with the blocking checks as JSON.
+func disruptionGateHandler(w http.ResponseWriter, r *http.Request, getState func() health.State) error {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return nil
+	}
+	status := evaluateDisruptionGate(getState(), time.Now())
+	if status.Ready {
+		disruptionGateReady.Set(1)
+	} else {
+		disruptionGateReady.Set(0)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	return json.NewEncoder(w).Encode(status)
+}
+
+// disruptionGateHandlerFunc serves the disruption gate from the khstate cache and logs the errors of
+// disruptionGateHandler
+func (k *Kuberhealthy) disruptionGateHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	err := disruptionGateHandler(w, r, k.stateReflector.CurrentStatus)
+	if err != nil {
+		log.Errorln("disruption gate endpoint error:", err)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// TestDisruptionGate ensures that only failing gating checks, and gating checks that are missing, block voluntary
+// disruptions
+func TestDisruptionGate(t *testing.T) {
+	originalChecks := cfg.DisruptionGateChecks
+	defer func() { cfg.DisruptionGateChecks = originalChecks }()
+
+	now := time.Now()
+	state := health.State{CheckDetails: map[string]health.WorkloadDetails{
+		"kube-system/dns":      {OK: true, Status: health.StatusOK, LastRun: now},
+		"kube-system/etcd":     {OK: false, Status: health.StatusNotOK, Errors: []string{"leader lost"}, LastRun: now},
+		"team-a/ingress":       {OK: false, Status: health.StatusNotOK, Errors: []string{"404"}, LastRun: now},
+		"kube-system/slow-api": {OK: false, Status: health.StatusNotOK, Errors: []string{"slow"}, Severity: health.SeverityWarning, LastRun: now},
+	}}
+	serve := func() (int, disruptionGateStatus) {
+		recorder := httptest.NewRecorder()
+		err := disruptionGateHandler(recorder, httptest.NewRequest(http.MethodGet, disruptionGatePath, nil), func() health.State { return state })
+		if err != nil {
+			t.Fatal("unexpected error serving the disruption gate:", err)
+		}
+		var status disruptionGateStatus
+		json.NewDecoder(recorder.Body).Decode(&status)
+		return recorder.Code, status
+	}
+
+	// failures that do not fail the overall status and checks that are not gating are ignored
+	cfg.DisruptionGateChecks = []string{"kube-system/*"}
+	code, status := serve()
+	if code != http.StatusServiceUnavailable || status.Ready || len(status.Blocking) != 1 || status.Blocking[0].Name != "etcd" {
+		t.Fatalf("expected only the failing etcd check to block disruptions, got %d and %+v", code, status)
+	}
+
+	state.CheckDetails["kube-system/etcd"] = health.WorkloadDetails{OK: true, Status: health.StatusOK, LastRun: now}
+	code, status = serve()
+	if code != http.StatusOK || !status.Ready || len(status.Blocking) != 0 {
+		t.Fatalf("expected disruptions to be allowed once etcd recovered, got %d and %+v", code, status)
+	}
+
+	// a gating check without a khstate can not be trusted to be healthy
+	cfg.DisruptionGateChecks = []string{"kube-system/dns", "kube-system/scheduler"}
+	code, status = serve()
+	if code != http.StatusServiceUnavailable || len(status.Blocking) != 1 || status.Blocking[0].Name != "scheduler" {
+		t.Fatalf("expected the missing scheduler check to block disruptions, got %d and %+v", code, status)
+	}
+
+	// every check gates disruptions when none are configured
+	cfg.DisruptionGateChecks = nil
+	code, status = serve()
+	if code != http.StatusServiceUnavailable || len(status.Blocking) != 1 || status.Blocking[0].Namespace != "team-a" {
+		t.Fatalf("expected the failing ingress check to block disruptions, got %d and %+v", code, status)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The ways duplicate check registrations can be handled at startup
+const (
+	duplicateCheckModeFail = "fail" // refuse to start.  This is the default
+	duplicateCheckModeWarn = "warn" // log every conflict and start anyway
+)
+
+// checkRegistration is a khcheck or khjob along with the namespace its khstate is kept in
+type checkRegistration struct {
+	Kind           string
+	Name           string
+	Namespace      string
+	StateNamespace string
+}
+
+// String describes the registration in conflict messages
+func (r checkRegistration) String() string {
+	return r.Kind + " " + r.Namespace + "/" + r.Name
+}
+
+// stateIdentity returns the namespace/name of the khstate the registration writes to
+func (r checkRegistration) stateIdentity() string {
+	return r.StateNamespace + "/" + sanitizeResourceName(r.Name)
+}
+
+// findDuplicateRegistrations returns a sorted description of every khstate that more than one registration would
+// write to
+func findDuplicateRegistrations(registrations []checkRegistration) []string {
+	claims := make(map[string][]string)
+	for _, r := range registrations {
+		identity := r.stateIdentity()
+		claims[identity] = append(claims[identity], r.String())
+	}
+
+	var conflicts []string
+	for identity, claimants := range claims {
+		if len(claimants) < 2 {
+			continue
+		}
+		sort.Strings(claimants)
+		conflicts = append(conflicts, "khstate "+identity+" is claimed by "+strings.Join(claimants, ", "))
+	}
+	sort.Strings(conflicts)
+	return conflicts
+}
+
+// listCheckRegistrations lists every khcheck and khjob in the watched namespaces
+func listCheckRegistrations() ([]checkRegistration, error) {
+	khChecks, err := listUnstructuredKHChecks()
+	if err != nil {
+		return nil, fmt.Errorf("error listing khChecks: %w", err)
+	}
+
+	var registrations []checkRegistration
+	for _, kc := range khChecks.Items {
+		r, err := convertUnstructuredKhCheck(kc)
+		if err != nil {
+			log.Errorln("Error converting unstructured object to khcheck:", err)
+			continue
+		}
+		registrations = append(registrations, checkRegistration{
+			Kind:           "khcheck",
+			Name:           r.GetName(),
+			Namespace:      r.GetNamespace(),
+			StateNamespace: khCheckStateNamespace(r.GetNamespace(), r.GetAnnotations()),
+		})
+	}
+
+	khJobs, err := khJobClient.KuberhealthyJobs(listenNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing khJobs: %w", err)
+	}
+	for _, kj := range khJobs.Items {
+		registrations = append(registrations, checkRegistration{
+			Kind:           "khjob",
+			Name:           kj.GetName(),
+			Namespace:      kj.GetNamespace(),
+			StateNamespace: kj.GetNamespace(),
+		})
+	}
+	return registrations, nil
+}
+
+// validateCheckRegistrationsOrExit lists every khcheck and khjob and exits when more than one of them would write
+// to the same khstate, since they would overwrite each other's results on every run.  In warn mode the conflicts
+// are logged and startup continues.  Failing to list the checks is not fatal because the API may not be reachable
+// yet.
+func validateCheckRegistrationsOrExit() {
+	registrations, err := listCheckRegistrations()
+	if err != nil {
+		log.Errorln("Unable to validate check registrations:", err)
+		return
+	}
+	conflicts := findDuplicateRegistrations(registrations)
+	if len(conflicts) == 0 {
+		return
+	}
+	for _, conflict := range conflicts {
+		log.Errorln("Duplicate check registration:", conflict)
+	}
+	if cfg.DuplicateCheckMode == duplicateCheckModeWarn {
+		log.Warningln("Starting with", len(conflicts), "duplicate check registrations because duplicateCheckMode is", duplicateCheckModeWarn)
+		return
+	}
+	log.Fatalln("Found", len(conflicts), "duplicate check registrations. Set duplicateCheckMode to", duplicateCheckModeWarn, "to start anyway.")
+}
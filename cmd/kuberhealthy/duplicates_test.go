@@ -0,0 +1,52 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestFindDuplicateRegistrations ensures that checks and jobs that would write to the same khstate are reported,
+// whether their names collide when sanitized or they share a state namespace
+func TestFindDuplicateRegistrations(t *testing.T) {
+	registrations := []checkRegistration{
+		{Kind: "khcheck", Name: "DNS Check", Namespace: "kuberhealthy", StateNamespace: "kuberhealthy"},
+		{Kind: "khcheck", Name: "dns-check", Namespace: "kuberhealthy", StateNamespace: "kuberhealthy"},
+		{Kind: "khcheck", Name: "deployment", Namespace: "team-a", StateNamespace: "cluster-checks"},
+		{Kind: "khcheck", Name: "deployment", Namespace: "team-b", StateNamespace: "cluster-checks"},
+		{Kind: "khcheck", Name: "daemonset", Namespace: "team-a", StateNamespace: "team-a"},
+		{Kind: "khcheck", Name: "daemonset", Namespace: "team-b", StateNamespace: "team-b"},
+		{Kind: "khjob", Name: "backup", Namespace: "kuberhealthy", StateNamespace: "kuberhealthy"},
+		{Kind: "khcheck", Name: "backup", Namespace: "kuberhealthy", StateNamespace: "kuberhealthy"},
+	}
+
+	conflicts := findDuplicateRegistrations(registrations)
+	expected := []string{
+		"khstate cluster-checks/deployment is claimed by khcheck team-a/deployment, khcheck team-b/deployment",
+		"khstate kuberhealthy/backup is claimed by khcheck kuberhealthy/backup, khjob kuberhealthy/backup",
+		"khstate kuberhealthy/dns-check is claimed by khcheck kuberhealthy/DNS Check, khcheck kuberhealthy/dns-check",
+	}
+	if strings.Join(conflicts, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("expected the conflicts:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(conflicts, "\n"))
+	}
+
+	// hashed names never collide when sanitized
+	originalSanitizer := cfg.ResourceNameSanitizer
+	cfg.ResourceNameSanitizer = sanitizerModeHash
+	defer func() {
+		cfg.ResourceNameSanitizer = originalSanitizer
+	}()
+	if conflicts := findDuplicateRegistrations(registrations[:2]); len(conflicts) != 0 {
+		t.Fatal("expected hashed names to not collide, got", conflicts)
+	}
+}
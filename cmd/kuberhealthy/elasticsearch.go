@@ -0,0 +1,269 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// defaultElasticsearchIndex is the index check results are indexed in when no index is configured
+const defaultElasticsearchIndex = "kuberhealthy"
+
+// defaultElasticsearchFlush is how often buffered check results are bulk indexed when no interval is configured
+const defaultElasticsearchFlush = time.Second * 5
+
+// defaultElasticsearchBufferSize is the number of results that can wait to be indexed before new results are dropped
+const defaultElasticsearchBufferSize = 1000
+
+// elasticsearchBulkSize is the most check results sent in one bulk request.  Larger batches are sent right away
+// instead of waiting for the next flush.
+const elasticsearchBulkSize = 500
+
+// elasticsearchTimeout bounds every bulk request to elasticsearch
+const elasticsearchTimeout = time.Second * 30
+
+// errElasticsearchBufferFull is returned when a result is dropped because the elasticsearch buffer is full
+var errElasticsearchBufferFull = errors.New("elasticsearch buffer is full")
+
+// elasticsearchConfig is the configuration of the elasticsearch state store
+type elasticsearchConfig struct {
+	URL        string
+	Index      string
+	Username   string
+	Password   string
+	APIKey     string
+	Flush      time.Duration
+	BufferSize int
+}
+
+// elasticsearchDocument is a single check result as it is indexed in elasticsearch
+type elasticsearchDocument struct {
+	Timestamp        time.Time `json:"@timestamp"`
+	Check            string    `json:"check"`
+	Namespace        string    `json:"namespace"`
+	OK               bool      `json:"ok"`
+	Status           string    `json:"status"`
+	Errors           []string  `json:"errors"`
+	RunID            string    `json:"runID,omitempty"`
+	RunDuration      string    `json:"runDuration,omitempty"`
+	Severity         string    `json:"severity,omitempty"`
+	AuthoritativePod string    `json:"authoritativePod,omitempty"`
+}
+
+// elasticsearchBulkResponse is the part of the response of the elasticsearch bulk API that says which documents
+// failed to index
+type elasticsearchBulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int `json:"status"`
+		Error  struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// elasticsearchStateStore indexes check results in elasticsearch for teams that centralize on the Elastic stack.
+// Results are buffered and bulk indexed in the background so that a slow cluster never slows down khstate writes.
+type elasticsearchStateStore struct {
+	config elasticsearchConfig
+	client *http.Client
+	queue  chan elasticsearchDocument
+}
+
+// newElasticsearchStateStore creates an elasticsearch state store and starts indexing results in the background
+func newElasticsearchStateStore(config elasticsearchConfig) (*elasticsearchStateStore, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing elasticsearch url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("elasticsearch url %s must be http or https", config.URL)
+	}
+	// credentials can come from a secret through the environment instead of the configuration
+	if len(config.Username) > 0 && len(config.Password) == 0 {
+		config.Password = os.Getenv("ELASTICSEARCH_PASSWORD")
+	}
+	if len(config.Username) == 0 && len(config.APIKey) == 0 {
+		config.APIKey = os.Getenv("ELASTICSEARCH_API_KEY")
+	}
+	if len(config.APIKey) > 0 && len(config.Username) > 0 {
+		return nil, errors.New("configure either an elasticsearch api key or a username and password, not both")
+	}
+	if len(config.Index) == 0 {
+		config.Index = defaultElasticsearchIndex
+	}
+	if config.Flush <= 0 {
+		config.Flush = defaultElasticsearchFlush
+	}
+	if config.BufferSize <= 0 {
+		config.BufferSize = defaultElasticsearchBufferSize
+	}
+
+	e := &elasticsearchStateStore{
+		config: config,
+		client: &http.Client{Timeout: elasticsearchTimeout},
+		queue:  make(chan elasticsearchDocument, config.BufferSize),
+	}
+	go e.index()
+	return e, nil
+}
+
+// Name returns the name of the state store for logs and metrics
+func (e *elasticsearchStateStore) Name() string {
+	return "elasticsearch"
+}
+
+// SetCheckState queues a check result to be indexed.  If the buffer is full, the result is dropped.
+func (e *elasticsearchStateStore) SetCheckState(checkName string, checkNamespace string, state health.WorkloadDetails) error {
+	document := elasticsearchDocument{
+		Timestamp:        state.LastRun,
+		Check:            checkName,
+		Namespace:        checkNamespace,
+		OK:               state.OK,
+		Status:           string(state.GetStatus()),
+		Errors:           state.Errors,
+		RunID:            state.RunID,
+		RunDuration:      state.RunDuration,
+		Severity:         string(state.Severity),
+		AuthoritativePod: state.AuthoritativePod,
+	}
+	if document.Errors == nil {
+		document.Errors = []string{}
+	}
+
+	select {
+	case e.queue <- document:
+		return nil
+	default:
+		elasticsearchDocuments.Inc("dropped")
+		return errElasticsearchBufferFull
+	}
+}
+
+// index bulk indexes queued results every flush interval, or as soon as a full batch is waiting, until the queue
+// is closed.  Whatever is left in the batch is indexed before returning.
+func (e *elasticsearchStateStore) index() {
+	ticker := time.NewTicker(e.config.Flush)
+	defer ticker.Stop()
+
+	var batch []elasticsearchDocument
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		failed, err := e.bulk(batch)
+		if err != nil {
+			log.Warningln("elasticsearch: failed to index", failed, "of", len(batch), "check results:", err)
+		}
+		elasticsearchDocuments.Add(float64(len(batch)-failed), "indexed")
+		elasticsearchDocuments.Add(float64(failed), "failed")
+		batch = nil
+	}
+
+	for {
+		select {
+		case document, ok := <-e.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, document)
+			if len(batch) >= elasticsearchBulkSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// bulk indexes documents with a single request to the elasticsearch bulk API.  The number of documents that
+// failed to index is returned along with an error describing the first failure.
+func (e *elasticsearchStateStore) bulk(documents []elasticsearchDocument) (int, error) {
+	var body bytes.Buffer
+	action, err := json.Marshal(map[string]interface{}{"index": map[string]string{"_index": e.config.Index}})
+	if err != nil {
+		return len(documents), err
+	}
+	for _, document := range documents {
+		b, err := json.Marshal(document)
+		if err != nil {
+			return len(documents), fmt.Errorf("error marshaling check result for elasticsearch: %w", err)
+		}
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(b)
+		body.WriteByte('\n')
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(e.config.URL, "/")+"/_bulk", &body)
+	if err != nil {
+		return len(documents), fmt.Errorf("error creating elasticsearch bulk request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if len(e.config.APIKey) > 0 {
+		req.Header.Set("Authorization", "ApiKey "+e.config.APIKey)
+	}
+	if len(e.config.Username) > 0 {
+		req.SetBasicAuth(e.config.Username, e.config.Password)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return len(documents), fmt.Errorf("error sending elasticsearch bulk request: %w", err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return len(documents), fmt.Errorf("error reading elasticsearch bulk response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return len(documents), fmt.Errorf("elasticsearch bulk request failed with status %d: %s", resp.StatusCode, string(b))
+	}
+
+	var result elasticsearchBulkResponse
+	err = json.Unmarshal(b, &result)
+	if err != nil {
+		return len(documents), fmt.Errorf("error decoding elasticsearch bulk response: %w", err)
+	}
+	if !result.Errors {
+		return 0, nil
+	}
+	var failed int
+	var first error
+	for _, item := range result.Items {
+		for _, outcome := range item {
+			if outcome.Status >= 200 && outcome.Status <= 299 {
+				continue
+			}
+			failed++
+			if first == nil {
+				first = fmt.Errorf("document failed to index with status %d: %s: %s", outcome.Status, outcome.Error.Type, outcome.Error.Reason)
+			}
+		}
+	}
+	return failed, first
+}
@@ -0,0 +1,103 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// TestElasticsearchStateStore ensures that queued check results are bulk indexed with the configured credentials
+// and that documents elasticsearch fails to index are counted
+func TestElasticsearchStateStore(t *testing.T) {
+	bulks := make(chan []elasticsearchDocument, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if r.URL.Path != "/_bulk" || !ok || username != "kuberhealthy" || password != "secret" {
+			t.Errorf("unexpected bulk request to %s with credentials %s:%s", r.URL.Path, username, password)
+		}
+		var documents []elasticsearchDocument
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var action map[string]map[string]string
+			json.Unmarshal(scanner.Bytes(), &action)
+			if action["index"]["_index"] != "checks" || !scanner.Scan() {
+				t.Errorf("expected an index action for the checks index, got %s", scanner.Text())
+				break
+			}
+			var document elasticsearchDocument
+			json.Unmarshal(scanner.Bytes(), &document)
+			documents = append(documents, document)
+		}
+		fmt.Fprint(w, `{"errors":true,"items":[{"index":{"status":201}},{"index":{"status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse"}}}]}`)
+		bulks <- documents
+	}))
+	defer server.Close()
+
+	store, err := newElasticsearchStateStore(elasticsearchConfig{
+		URL:      server.URL,
+		Index:    "checks",
+		Username: "kuberhealthy",
+		Password: "secret",
+		Flush:    time.Millisecond * 50,
+	})
+	if err != nil {
+		t.Fatal("unexpected error creating the elasticsearch state store:", err)
+	}
+
+	lastRun := time.Date(2020, 11, 10, 15, 4, 5, 0, time.UTC)
+	store.SetCheckState("dns", "kuberhealthy", health.WorkloadDetails{OK: true, LastRun: lastRun, RunID: "run-1"})
+	store.SetCheckState("deployment", "kuberhealthy", health.WorkloadDetails{OK: false, Errors: []string{"rollout timed out"}, LastRun: lastRun})
+
+	var documents []elasticsearchDocument
+	select {
+	case documents = <-bulks:
+	case <-time.After(time.Second * 5):
+		t.Fatal("expected the check results to be bulk indexed")
+	}
+	if len(documents) != 2 {
+		t.Fatal("expected both check results to be indexed in one bulk request, got", documents)
+	}
+	dns, deployment := documents[0], documents[1]
+	if dns.Check != "dns" || !dns.OK || dns.RunID != "run-1" || !dns.Timestamp.Equal(lastRun) || len(dns.Errors) != 0 {
+		t.Fatalf("expected the dns result to be indexed, got %+v", dns)
+	}
+	if deployment.OK || deployment.Status != string(health.StatusNotOK) || len(deployment.Errors) != 1 {
+		t.Fatalf("expected the failing deployment result to be indexed, got %+v", deployment)
+	}
+
+	failed, err := store.bulk(documents)
+	if failed != 1 || err == nil {
+		t.Fatal("expected one document to fail to index, got", failed, err)
+	}
+	<-bulks
+}
+
+// TestElasticsearchStateStoreConfig ensures that invalid elasticsearch configurations are refused
+func TestElasticsearchStateStoreConfig(t *testing.T) {
+	configs := []elasticsearchConfig{
+		{URL: "elasticsearch:9200"},
+		{URL: "https://elasticsearch:9200", APIKey: "key", Username: "kuberhealthy"},
+	}
+	for _, config := range configs {
+		if _, err := newElasticsearchStateStore(config); err == nil {
+			t.Fatalf("expected an error for elasticsearch config %+v", config)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// expirySweepInterval is how often check results are scanned for expiry
+const expirySweepInterval = time.Second * 30
+
+// expirySweeper tracks which check results are expired so that expiry is only announced once per transition
+type expirySweeper struct {
+	expired map[string]bool // keyed by namespace/name
+}
+
+// newExpirySweeper creates a new expirySweeper
+func newExpirySweeper() *expirySweeper {
+	return &expirySweeper{
+		expired: make(map[string]bool),
+	}
+}
+
+// sweep compares the supplied check results against their result TTLs and records an expired event for every result
+// that has expired since the last sweep.  The names of newly expired checks are returned.
+func (s *expirySweeper) sweep(checkDetails map[string]health.WorkloadDetails, ttl time.Duration, now time.Time) []string {
+	var newlyExpired []string
+	seen := make(map[string]bool)
+
+	for key, details := range checkDetails {
+		seen[key] = true
+		namespace, name := splitCheckKey(key)
+		expired := details.IsExpired(ttl, now)
+
+		switch {
+		case expired && !s.expired[key]:
+			log.Warningln("Result of check", key, "expired. The last result was reported at", details.LastRun)
+			checkExpiredEvents.Inc(name, namespace)
+			checkExpired.Set(1, name, namespace)
+			newlyExpired = append(newlyExpired, key)
+		case !expired && s.expired[key]:
+			log.Infoln("Check", key, "reported a new result after its last result expired")
+			checkExpired.Delete(name, namespace)
+		}
+		s.expired[key] = expired
+	}
+
+	// forget checks that no longer exist
+	for key := range s.expired {
+		if !seen[key] {
+			namespace, name := splitCheckKey(key)
+			checkExpired.Delete(name, namespace)
+			delete(s.expired, key)
+		}
+	}
+
+	return newlyExpired
+}
+
+// splitCheckKey splits a namespace/name key used in state details into its namespace and name
+func splitCheckKey(key string) (string, string) {
+	parts := strings.SplitN(key, "/", 2)
+	if len(parts) != 2 {
+		return "", key
+	}
+	return parts[0], parts[1]
+}
+
+// sweepExpiredResults periodically scans check results from the state reflector for expiry until the context is
+// canceled.  Nothing is scanned while no result TTL or expiry policy is configured.
+func (k *Kuberhealthy) sweepExpiredResults(ctx context.Context) {
+	sweeper := newExpirySweeper()
+	ticker := time.NewTicker(expirySweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Infoln("expiry sweeper: shutting down")
+			return
+		case <-ticker.C:
+		}
+
+		if !resultExpiryEnabled() {
+			continue
+		}
+
+		currentState := k.stateReflector.CurrentStatus()
+		checkDetails := make(map[string]health.WorkloadDetails, len(currentState.CheckDetails)+len(currentState.ClusterCheckDetails))
+		for key, details := range currentState.CheckDetails {
+			checkDetails[key] = details
+		}
+		for key, details := range currentState.ClusterCheckDetails {
+			checkDetails[key] = details
+		}
+		sweeper.sweep(checkDetails, cfg.ResultTTL, time.Now())
+	}
+}
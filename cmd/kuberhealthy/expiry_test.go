@@ -0,0 +1,55 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// TestExpirySweeperFiresOncePerExpiry ensures that an expiry is only announced on the transition to expired
+func TestExpirySweeperFiresOncePerExpiry(t *testing.T) {
+	sweeper := newExpirySweeper()
+	ttl := time.Minute
+	now := time.Now()
+
+	checks := map[string]health.WorkloadDetails{
+		"kuberhealthy/silent": {OK: true, LastRun: now.Add(-time.Hour)},
+		"kuberhealthy/fresh":  {OK: true, LastRun: now},
+	}
+
+	expired := sweeper.sweep(checks, ttl, now)
+	if len(expired) != 1 || expired[0] != "kuberhealthy/silent" {
+		t.Fatal("expected only the silent check to expire but got", expired)
+	}
+	if expired := sweeper.sweep(checks, ttl, now); len(expired) != 0 {
+		t.Fatal("expiry was announced again for a check that was already expired:", expired)
+	}
+
+	// a new result clears the expiry so that the next expiry is announced again
+	checks["kuberhealthy/silent"] = health.WorkloadDetails{OK: true, LastRun: now}
+	if expired := sweeper.sweep(checks, ttl, now); len(expired) != 0 {
+		t.Fatal("expected no expiry after a new result but got", expired)
+	}
+	if expired := sweeper.sweep(checks, ttl, now.Add(time.Hour)); len(expired) != 2 {
+		t.Fatal("expected both checks to expire after going silent but got", expired)
+	}
+
+	// checks that are removed are forgotten
+	delete(checks, "kuberhealthy/fresh")
+	sweeper.sweep(checks, ttl, now.Add(time.Hour))
+	if _, ok := sweeper.expired["kuberhealthy/fresh"]; ok {
+		t.Fatal("removed check was not forgotten by the sweeper")
+	}
+}
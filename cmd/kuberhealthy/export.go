@@ -0,0 +1,201 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// The formats the state can be exported to a file in
+const (
+	stateExportFormatJSON = "json" // the status page response.  This is the default
+	stateExportFormatCSV  = "csv"  // one row per check and job
+)
+
+// defaultStateExportInterval is how often the state is exported when no interval is configured
+const defaultStateExportInterval = time.Minute * 5
+
+// defaultStateExportKeep is how many export files are kept when no number is configured
+const defaultStateExportKeep = 5
+
+// stateExportInterval returns the configured state export interval
+func stateExportInterval() time.Duration {
+	if cfg.StateExportInterval <= 0 {
+		return defaultStateExportInterval
+	}
+	return cfg.StateExportInterval
+}
+
+// stateExportKeep returns the configured number of export files to keep, including the latest one
+func stateExportKeep() int {
+	if cfg.StateExportKeep <= 0 {
+		return defaultStateExportKeep
+	}
+	return cfg.StateExportKeep
+}
+
+// encodeStateExport encodes the state in an export format
+func encodeStateExport(state health.State, format string) ([]byte, error) {
+	switch format {
+	case "", stateExportFormatJSON:
+		return json.MarshalIndent(state, "", "  ")
+	case stateExportFormatCSV:
+		return encodeStateExportCSV(state)
+	}
+	return nil, fmt.Errorf("unknown state export format %s. Use json or csv", format)
+}
+
+// encodeStateExportCSV encodes every check and job of the state as a CSV row, sorted by kind, namespace and name
+func encodeStateExportCSV(state health.State) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	err := writer.Write([]string{"kind", "namespace", "name", "ok", "status", "lastRun", "runDuration", "authoritativePod", "errors"})
+	if err != nil {
+		return nil, err
+	}
+
+	kinds := []struct {
+		kind    string
+		details map[string]health.WorkloadDetails
+	}{
+		{kind: "check", details: state.CheckDetails},
+		{kind: "clusterCheck", details: state.ClusterCheckDetails},
+		{kind: "job", details: state.JobDetails},
+	}
+	for _, k := range kinds {
+		keys := make([]string, 0, len(k.details))
+		for key := range k.details {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			details := k.details[key]
+			namespace, name := splitCheckKey(key)
+			err := writer.Write([]string{
+				k.kind,
+				namespace,
+				name,
+				strconv.FormatBool(details.OK),
+				string(details.GetStatus()),
+				details.LastRun.UTC().Format(time.RFC3339),
+				details.RunDuration,
+				details.AuthoritativePod,
+				strings.Join(details.Errors, "; "),
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+// rotateStateExports shifts the previous export files up by one, so that path becomes path.1, path.1 becomes
+// path.2 and so on.  The oldest file is removed so that no more than keep files exist including the latest.
+func rotateStateExports(path string, keep int) error {
+	rotated := func(i int) string {
+		if i == 0 {
+			return path
+		}
+		return path + "." + strconv.Itoa(i)
+	}
+
+	err := os.Remove(rotated(keep - 1))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing the oldest state export: %w", err)
+	}
+	for i := keep - 2; i >= 0; i-- {
+		err := os.Rename(rotated(i), rotated(i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error rotating state export %s: %w", rotated(i), err)
+		}
+	}
+	return nil
+}
+
+// writeStateExport writes the state to the export path.  The export is written to a temporary file in the same
+// directory first and renamed into place, so readers never see a partially written file.
+func writeStateExport(path string, format string, keep int, state health.State) error {
+	b, err := encodeStateExport(state, format)
+	if err != nil {
+		return err
+	}
+
+	temp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return fmt.Errorf("error creating temporary state export file: %w", err)
+	}
+	defer os.Remove(temp.Name()) // cleans up whenever the rename did not happen
+
+	_, err = temp.Write(b)
+	if err == nil {
+		err = temp.Sync()
+	}
+	closeErr := temp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(temp.Name(), 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("error writing temporary state export file: %w", err)
+	}
+
+	// a failed rotation only means more old exports are overwritten, so the new export is still written
+	if err := rotateStateExports(path, keep); err != nil {
+		log.Warningln("state export:", err)
+	}
+	err = os.Rename(temp.Name(), path)
+	if err != nil {
+		return fmt.Errorf("error moving state export into place: %w", err)
+	}
+	return nil
+}
+
+// exportStates periodically writes the current state to the state export path until the context is canceled
+func (k *Kuberhealthy) exportStates(ctx context.Context) {
+	log.Infoln("state export: writing the state to", cfg.StateExportPath, "every", stateExportInterval())
+	ticker := time.NewTicker(stateExportInterval())
+	defer ticker.Stop()
+
+	for {
+		err := writeStateExport(cfg.StateExportPath, cfg.StateExportFormat, stateExportKeep(), k.getCurrentState([]string{}))
+		if err != nil {
+			log.Errorln("state export: failed to write the state to", cfg.StateExportPath+":", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Infoln("state export: shutting down")
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// TestWriteStateExport ensures that exports are written in place and that old exports are rotated out
+func TestWriteStateExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kuberhealthy-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	for i := 0; i < 5; i++ {
+		state := health.State{OK: i%2 == 0, CheckDetails: map[string]health.WorkloadDetails{"kuberhealthy/dns": {OK: true}}}
+		err := writeStateExport(path, stateExportFormatJSON, 3, state)
+		if err != nil {
+			t.Fatal("unexpected error exporting the state:", err)
+		}
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	if strings.Join(names, ",") != "state.json,state.json.1,state.json.2" {
+		t.Fatal("expected the latest export and two rotated exports without temporary files, got", names)
+	}
+
+	var latest health.State
+	b, _ := ioutil.ReadFile(path)
+	if err := json.Unmarshal(b, &latest); err != nil || !latest.OK {
+		t.Fatal("expected the latest export to hold the last state written, got", string(b))
+	}
+}
+
+// TestEncodeStateExportCSV ensures that every check and job is exported as a CSV row
+func TestEncodeStateExportCSV(t *testing.T) {
+	lastRun := time.Date(2020, 11, 10, 15, 4, 5, 0, time.UTC)
+	state := health.State{
+		CheckDetails: map[string]health.WorkloadDetails{
+			"kuberhealthy/dns":        {OK: false, Errors: []string{"can't resolve", "timed out"}, LastRun: lastRun},
+			"kuberhealthy/deployment": {OK: true, LastRun: lastRun, RunDuration: "12s"},
+		},
+		JobDetails: map[string]health.WorkloadDetails{"jobs/backup": {OK: true, LastRun: lastRun}},
+	}
+
+	b, err := encodeStateExport(state, stateExportFormatCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `kind,namespace,name,ok,status,lastRun,runDuration,authoritativePod,errors
+check,kuberhealthy,deployment,true,OK,2020-11-10T15:04:05Z,12s,,
+check,kuberhealthy,dns,false,NotOK,2020-11-10T15:04:05Z,,,can't resolve; timed out
+job,jobs,backup,true,OK,2020-11-10T15:04:05Z,,,
+`
+	if string(b) != expected {
+		t.Fatalf("expected the csv export:\n%s\ngot:\n%s", expected, string(b))
+	}
+
+	if _, err := encodeStateExport(state, "xml"); err == nil {
+		t.Fatal("expected an error exporting in an unknown format")
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// externalMetricsGroupVersion is the API group and version of the Kubernetes external metrics API
+const externalMetricsGroupVersion = "external.metrics.k8s.io/v1beta1"
+
+// externalMetricsPath is where the external metrics API is served
+const externalMetricsPath = "/apis/" + externalMetricsGroupVersion
+
+// The external metrics served for autoscaling.  Per-check metrics have a value for every check with check and
+// namespace labels.  The counts have a single value for all checks that match the label selector.
+const (
+	externalMetricCheckOK      = "kuberhealthy_check_ok"       // 1 when the check is OK, otherwise 0
+	externalMetricCheckFailing = "kuberhealthy_check_failing"  // 1 when the check is failing, otherwise 0
+	externalMetricChecksOK     = "kuberhealthy_checks_ok"      // the number of checks that are OK
+	externalMetricChecksFailed = "kuberhealthy_checks_failing" // the number of checks that are failing
+)
+
+// externalMetricNames lists every external metric in the order it is advertised
+var externalMetricNames = []string{externalMetricCheckOK, externalMetricCheckFailing, externalMetricChecksOK, externalMetricChecksFailed}
+
+// externalMetricValue is a single value of an external metric in the external metrics API format
+type externalMetricValue struct {
+	MetricName   string            `json:"metricName"`
+	MetricLabels map[string]string `json:"metricLabels"`
+	Timestamp    metav1.Time       `json:"timestamp"`
+	Value        resource.Quantity `json:"value"`
+}
+
+// externalMetricValueList is the response of the external metrics API
+type externalMetricValueList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+	Items           []externalMetricValue `json:"items"`
+}
+
+// externalMetricValues calculates the values of an external metric from the khstate details of every check, keyed
+// by namespace/name.  Only checks whose check and namespace labels match the selector are included.  Checks that
+// have not reported a result yet are neither OK nor failing, and synthetic khstates are not checks.  The returned
+// bool is false when the metric does not exist.
+func externalMetricValues(metric string, states map[string]health.WorkloadDetails, selector labels.Selector, now time.Time) ([]externalMetricValue, bool) {
+	var perCheck bool
+	var want health.CheckStatus
+	switch metric {
+	case externalMetricCheckOK:
+		perCheck, want = true, health.StatusOK
+	case externalMetricCheckFailing:
+		perCheck, want = true, health.StatusNotOK
+	case externalMetricChecksOK:
+		want = health.StatusOK
+	case externalMetricChecksFailed:
+		want = health.StatusNotOK
+	default:
+		return nil, false
+	}
+
+	timestamp := metav1.NewTime(now)
+	values := []externalMetricValue{}
+	var count int64
+	for _, key := range sortedCheckKeys(states) {
+		details := states[key]
+		if details.Synthetic {
+			continue
+		}
+		namespace, name := splitCheckKey(key)
+		checkLabels := map[string]string{"check": name, "namespace": namespace}
+		if !selector.Matches(labels.Set(checkLabels)) {
+			continue
+		}
+
+		var value int64
+		if details.GetStatus() == want {
+			value = 1
+		}
+		count += value
+		if perCheck {
+			values = append(values, externalMetricValue{
+				MetricName:   metric,
+				MetricLabels: checkLabels,
+				Timestamp:    timestamp,
+				Value:        *resource.NewQuantity(value, resource.DecimalSI),
+			})
+		}
+	}
+
+	if !perCheck {
+		values = append(values, externalMetricValue{
+			MetricName:   metric,
+			MetricLabels: map[string]string{},
+			Timestamp:    timestamp,
+			Value:        *resource.NewQuantity(count, resource.DecimalSI),
+		})
+	}
+	return values, true
+}
+
+// externalMetricsHandler serves check results in the format of the Kubernetes external metrics API so that a
+// HorizontalPodAutoscaler can scale on them.  The group version path lists the available metrics and
+// /namespaces/<namespace>/<metric> returns the values of a metric.  The namespace in the path is the namespace of
+// the autoscaler asking, so checks are selected with the labelSelector query parameter on their check and
+// namespace labels instead.
+func externalMetricsHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, externalMetricsPath), "/")
+	if len(path) == 0 {
+		resources := metav1.APIResourceList{GroupVersion: externalMetricsGroupVersion}
+		resources.Kind = "APIResourceList"
+		resources.APIVersion = "v1"
+		for _, metric := range externalMetricNames {
+			resources.APIResources = append(resources.APIResources, metav1.APIResource{
+				Name:       metric,
+				Namespaced: true,
+				Kind:       "ExternalMetricValueList",
+				Verbs:      []string{"get"},
+			})
+		}
+		return json.NewEncoder(w).Encode(resources)
+	}
+
+	parts := strings.Split(path, "/")
+	if len(parts) != 3 || parts[0] != "namespaces" {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+	selector, err := labels.Parse(r.URL.Query().Get("labelSelector"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "invalid label selector:", err)
+		return nil
+	}
+
+	states, err := getAllCheckStates("", projectionMetadata)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return fmt.Errorf("error listing khstates for external metrics: %w", err)
+	}
+	values, ok := externalMetricValues(parts[2], states, selector, time.Now())
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	list := externalMetricValueList{Items: values}
+	list.Kind = "ExternalMetricValueList"
+	list.APIVersion = externalMetricsGroupVersion
+	return json.NewEncoder(w).Encode(list)
+}
+
+// externalMetricsHandlerFunc logs the errors of externalMetricsHandler
+func externalMetricsHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	err := externalMetricsHandler(w, r)
+	if err != nil {
+		log.Errorln("external metrics endpoint error:", err)
+	}
+}
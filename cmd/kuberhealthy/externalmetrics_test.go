@@ -0,0 +1,61 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// TestExternalMetricValues ensures that per-check values and counts are calculated for the checks matching the
+// label selector and that unknown and synthetic states are not counted
+func TestExternalMetricValues(t *testing.T) {
+	states := map[string]health.WorkloadDetails{
+		"kuberhealthy/dns":        {OK: true, Status: health.StatusOK},
+		"kuberhealthy/deployment": {OK: false, Status: health.StatusNotOK, Errors: []string{"broken"}},
+		"kuberhealthy/new":        {Status: health.StatusUnknown},
+		"team-a/ingress":          {OK: false, Status: health.StatusNotOK, Errors: []string{"broken"}},
+		"kuberhealthy/overall":    {OK: false, Status: health.StatusNotOK, Synthetic: true},
+	}
+
+	values, ok := externalMetricValues(externalMetricCheckFailing, states, labels.SelectorFromSet(labels.Set{"namespace": "kuberhealthy"}), time.Now())
+	if !ok {
+		t.Fatal("expected", externalMetricCheckFailing, "to exist")
+	}
+	expected := map[string]int64{"deployment": 1, "dns": 0, "new": 0}
+	if len(values) != len(expected) {
+		t.Fatalf("expected %d values, got %+v", len(expected), values)
+	}
+	for _, value := range values {
+		if value.Value.Value() != expected[value.MetricLabels["check"]] {
+			t.Fatalf("expected %s to be %d, got %d", value.MetricLabels["check"], expected[value.MetricLabels["check"]], value.Value.Value())
+		}
+	}
+
+	values, _ = externalMetricValues(externalMetricChecksFailed, states, labels.Everything(), time.Now())
+	if len(values) != 1 || values[0].Value.Value() != 2 {
+		t.Fatalf("expected a single count of 2 failing checks, got %+v", values)
+	}
+	values, _ = externalMetricValues(externalMetricChecksOK, states, labels.Everything(), time.Now())
+	if len(values) != 1 || values[0].Value.Value() != 1 {
+		t.Fatalf("expected a single count of 1 OK check, got %+v", values)
+	}
+
+	_, ok = externalMetricValues("not_a_metric", states, labels.Everything(), time.Now())
+	if ok {
+		t.Fatal("expected an unknown metric to not exist")
+	}
+}
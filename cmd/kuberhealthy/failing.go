@@ -0,0 +1,141 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// failingCheck is a check that is currently failing, as listed by the failing endpoint
+type failingCheck struct {
+	Name         string
+	Namespace    string
+	Error        string    // the first error the check reported
+	FailingSince time.Time // when the check started failing
+	FailingFor   string    // how long the check has been failing
+	LastSuccess  time.Time // when the check last passed.  Zero when it has never passed
+}
+
+// failingSince returns the time a failing check started failing.  khstates written before ErrorsSince was
+// recorded fall back to the last time their result changed.
+func failingSince(details health.WorkloadDetails) time.Time {
+	if !details.ErrorsSince.IsZero() {
+		return details.ErrorsSince
+	}
+	if !details.LastResultChange.IsZero() {
+		return details.LastResultChange
+	}
+	return details.LastRun
+}
+
+// listFailingChecks returns every check in the supplied namespace, or all namespaces when blank, that is
+// currently failing.  The checks that have been failing the longest are first.  The failing checks among a partial
+// khstate list are returned with an error that is ErrPartialList.
+func listFailingChecks(namespace string) ([]failingCheck, error) {
+	states, err := getAllCheckStates(namespace, projectionFull)
+	if errors.Is(err, ErrPartialList) {
+		return failingChecks(states, time.Now()), fmt.Errorf("error listing khstates to find failing checks: %w", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error listing khstates to find failing checks: %w", err)
+	}
+	return failingChecks(states, time.Now()), nil
+}
+
+// failingChecks returns the failing checks in a map of khstate details keyed by namespace/name, longest failing
+// first.  Checks that have not reported yet are not failing, and synthetic khstates are not checks.
+func failingChecks(states map[string]health.WorkloadDetails, now time.Time) []failingCheck {
+	failing := []failingCheck{}
+	for _, key := range sortedCheckKeys(states) {
+		details := states[key]
+		if details.Synthetic || details.GetStatus() != health.StatusNotOK {
+			continue
+		}
+		namespace, name := splitCheckKey(key)
+		check := failingCheck{
+			Name:         name,
+			Namespace:    namespace,
+			FailingSince: failingSince(details),
+			LastSuccess:  details.LastSuccess,
+		}
+		if len(details.Errors) > 0 {
+			check.Error = details.Errors[0]
+		}
+		check.FailingFor = now.Sub(check.FailingSince).Round(time.Second).String()
+		failing = append(failing, check)
+	}
+
+	// stable so that checks that started failing at the same time stay sorted by name
+	sort.SliceStable(failing, func(i, j int) bool {
+		return failing[i].FailingSince.Before(failing[j].FailingSince)
+	})
+	return failing
+}
+
+// failingChecksHandler writes the failing checks as JSON.  The namespace query parameter limits the list to a
+// single namespace.
+func failingChecksHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return nil
+	}
+
+	failing, err := listFailingChecks(r.URL.Query().Get("namespace"))
+	if err != nil && !errors.Is(err, ErrPartialList) {
+		w.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+	warnListIncomplete(w, err)
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(failing)
+}
+
+// logFailingChecks logs a summary of the failing checks on every interval until the context is canceled
+func logFailingChecks(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		failing, err := listFailingChecks("")
+		if err != nil && !errors.Is(err, ErrPartialList) {
+			log.Errorln("failing summary:", err)
+			continue
+		}
+		if err != nil {
+			log.Warningln("failing summary:", err)
+		}
+		if len(failing) == 0 {
+			log.Infoln("failing summary: no checks are failing")
+			continue
+		}
+		log.Infoln("failing summary:", len(failing), "checks are failing")
+		for _, check := range failing {
+			log.Infoln("failing summary:", check.Namespace+"/"+check.Name, "failing for", check.FailingFor+":", check.Error)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// TestFailingChecks ensures that only failing checks are listed and that the longest failing are first
+func TestFailingChecks(t *testing.T) {
+	now := time.Now()
+	states := map[string]health.WorkloadDetails{
+		"kuberhealthy/passing":  {OK: true, Status: health.StatusOK},
+		"kuberhealthy/unknown":  {Status: health.StatusUnknown},
+		"kuberhealthy/recent":   {Status: health.StatusNotOK, Errors: []string{"recently broken"}, ErrorsSince: now.Add(-time.Minute)},
+		"other/oldest":          {Status: health.StatusNotOK, Errors: []string{"broken", "also broken"}, ErrorsSince: now.Add(-time.Hour)},
+		"kuberhealthy/legacy":   {Status: health.StatusNotOK, Errors: []string{"old khstate"}, LastResultChange: now.Add(-time.Minute * 10)},
+		"kuberhealthy/combined": {Status: health.StatusNotOK, Errors: []string{"synthetic"}, Synthetic: true},
+	}
+
+	failing := failingChecks(states, now)
+	expected := []string{"other/oldest", "kuberhealthy/legacy", "kuberhealthy/recent"}
+	if len(failing) != len(expected) {
+		t.Fatalf("expected %d failing checks, got %+v", len(expected), failing)
+	}
+	for i, check := range failing {
+		if check.Namespace+"/"+check.Name != expected[i] {
+			t.Fatalf("expected failing check %d to be %s, got %s/%s", i, expected[i], check.Namespace, check.Name)
+		}
+	}
+	if failing[0].Error != "broken" || failing[0].FailingFor != "1h0m0s" {
+		t.Fatalf("expected the first error and failing duration of other/oldest, got %+v", failing[0])
+	}
+}
+
+// TestErrorsSince ensures that the time a check started failing is kept while it keeps failing, even when its
+// errors change, and is cleared when it passes
+func TestErrorsSince(t *testing.T) {
+	existing := khstatecrd.NewKuberhealthyState("check", health.WorkloadDetails{OK: true, Status: health.StatusOK})
+	existing.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	existing.Kind = "KuberhealthyState"
+	existing.Namespace = "kuberhealthy"
+	useFakeKHStateServer(t, &existing)
+
+	write := func(details health.WorkloadDetails) health.WorkloadDetails {
+		_, err := setCheckStateResourceIf("check", "kuberhealthy", details, nil)
+		if err != nil {
+			t.Fatal("unexpected error writing khstate:", err)
+		}
+		return stateVersions.get(sanitizeResourceName("check"), "kuberhealthy").Spec
+	}
+
+	first := write(health.WorkloadDetails{Errors: []string{"broken"}})
+	if first.ErrorsSince.IsZero() {
+		t.Fatal("expected a failing check to record when it started failing")
+	}
+	second := write(health.WorkloadDetails{Errors: []string{"broken differently"}})
+	if !second.ErrorsSince.Equal(first.ErrorsSince) {
+		t.Fatalf("expected the check to have been failing since %s, got %s", first.ErrorsSince, second.ErrorsSince)
+	}
+	passing := write(health.WorkloadDetails{OK: true})
+	if !passing.ErrorsSince.IsZero() {
+		t.Fatal("expected a passing check to not be failing since any time")
+	}
+}
@@ -13,10 +13,21 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
 	"time"
 
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	khjobv1 "github.com/Comcast/kuberhealthy/v2/pkg/apis/khjob/v1"
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
 )
 
 // FakeCheck implements the kuberhealthy check interface with a fake
@@ -75,3 +86,117 @@ func NewFakeCheck() *FakeCheck {
 	fc.CheckName = "FakeCheck"
 	return &fc
 }
+
+// runCheckOnce runs a single run of the check through runCheck against a fake khstate server that holds the
+// supplied result, as reported by the checker during the run, and returns the khstate written when the run
+// finished.  The run interval of the check is shortened so that runCheck stops soon after the run.
+func runCheckOnce(t *testing.T, c *FakeCheck, reported health.WorkloadDetails) health.WorkloadDetails {
+	c.IntervalValue = time.Millisecond * 50
+	existing := khstatecrd.NewKuberhealthyState(c.Name(), reported)
+	existing.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	existing.Kind = "KuberhealthyState"
+	existing.Namespace = c.CheckNamespace()
+
+	var lock sync.Mutex
+	written := make(chan khstatecrd.KuberhealthyState, 1)
+	useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodGet:
+			json.NewEncoder(w).Encode(existing)
+		case http.MethodPut:
+			b, _ := ioutil.ReadAll(r.Body)
+			state := khstatecrd.KuberhealthyState{}
+			json.Unmarshal(b, &state)
+			select {
+			case written <- state:
+			default:
+			}
+			w.Write(b)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		(&Kuberhealthy{}).runCheck(ctx, c)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	select {
+	case state := <-written:
+		return state.Spec
+	case <-time.After(time.Second * 10):
+		t.Fatal("runCheck did not write the khstate of the check")
+	}
+	return health.WorkloadDetails{}
+}
+
+// runJobOnce runs the check as a khjob through runConfiguredJob against a fake server that holds the khjob and
+// the supplied result, as reported by the checker during the run, and returns the khstate written when the run
+// finished
+func runJobOnce(t *testing.T, c *FakeCheck, reported health.WorkloadDetails) health.WorkloadDetails {
+	job := khjobv1.NewKuberhealthyJob(c.Name(), c.CheckNamespace(), khjobv1.JobConfig{})
+	job.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	job.Kind = "KuberhealthyJob"
+	existing := khstatecrd.NewKuberhealthyState(c.Name(), reported)
+	existing.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	existing.Kind = "KuberhealthyState"
+	existing.Namespace = c.CheckNamespace()
+
+	var lock sync.Mutex
+	written := make(chan khstatecrd.KuberhealthyState, 1)
+	url := useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(r.URL.Path, "/khjobs/") {
+			if r.Method == http.MethodPut {
+				json.NewDecoder(r.Body).Decode(&job)
+			}
+			json.NewEncoder(w).Encode(job)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			json.NewEncoder(w).Encode(existing)
+		case http.MethodPut:
+			b, _ := ioutil.ReadAll(r.Body)
+			state := khstatecrd.KuberhealthyState{}
+			json.Unmarshal(b, &state)
+			select {
+			case written <- state:
+			default:
+			}
+			w.Write(b)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+	jobClient, err := khjobv1.NewForConfig(&rest.Config{Host: url})
+	if err != nil {
+		t.Fatal("failed to create khjob client for test server:", err)
+	}
+	originalJobClient := khJobClient
+	khJobClient = jobClient
+	defer func() {
+		khJobClient = originalJobClient
+	}()
+
+	(&Kuberhealthy{}).runConfiguredJob(context.Background(), job, c)
+	select {
+	case state := <-written:
+		return state.Spec
+	case <-time.After(time.Second * 10):
+		t.Fatal("runJob did not write the khstate of the job")
+	}
+	return health.WorkloadDetails{}
+}
@@ -0,0 +1,176 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// The fields of a check result that field change events can be enabled for
+const (
+	fieldChangeErrors      = "errors"      // an error appeared or was cleared
+	fieldChangeDuration    = "duration"    // the run took much longer than the previous run
+	fieldChangeStatus      = "status"      // the status changed, such as from Unknown to OK
+	fieldChangeAnnotations = "annotations" // an annotation was added, changed or removed
+)
+
+// The kinds of field changes
+const (
+	fieldChangeAdded     = "added"
+	fieldChangeCleared   = "cleared"
+	fieldChangeChanged   = "changed"
+	fieldChangeSpiked    = "spiked"
+	fieldChangeTruncated = "truncated" // more fields changed than are sent in events for a single write
+)
+
+// defaultDurationSpikeFactor is how many times longer than the previous run a run must take to be a duration spike
+// when no factor is configured
+const defaultDurationSpikeFactor = 2.0
+
+// defaultMaxFieldChangeEvents is the most field change events sent for a single write when no limit is configured
+const defaultMaxFieldChangeEvents = 10
+
+// fieldChange is a single change in a field of a check result
+type fieldChange struct {
+	Field    string
+	Change   string
+	Key      string `json:",omitempty"` // the annotation that changed
+	Value    string `json:",omitempty"`
+	Previous string `json:",omitempty"`
+}
+
+// fieldChangesEnabled determines if field change events are enabled for a field
+func fieldChangesEnabled(field string) bool {
+	return containsString(field, cfg.FieldChangeEvents)
+}
+
+// maxFieldChangeEvents returns the most field change events sent for a single write
+func maxFieldChangeEvents() int {
+	if cfg.MaxFieldChangeEvents <= 0 {
+		return defaultMaxFieldChangeEvents
+	}
+	return cfg.MaxFieldChangeEvents
+}
+
+// durationSpikeFactor returns how many times longer than the previous run a run must take to be a duration spike
+func durationSpikeFactor() float64 {
+	if cfg.DurationSpikeFactor <= 1 {
+		return defaultDurationSpikeFactor
+	}
+	return cfg.DurationSpikeFactor
+}
+
+// detectFieldChanges compares a result with the stored result it replaces and returns the changes in the fields
+// that field change events are enabled for.  No more than the maximum number of field change events are returned.
+// When there are more changes, the last one returned is a truncated change saying how many were left out.
+func detectFieldChanges(previous health.WorkloadDetails, state health.WorkloadDetails) []fieldChange {
+	var changes []fieldChange
+
+	if fieldChangesEnabled(fieldChangeStatus) && previous.GetStatus() != state.GetStatus() {
+		changes = append(changes, fieldChange{Field: fieldChangeStatus, Change: fieldChangeChanged, Value: string(state.GetStatus()), Previous: string(previous.GetStatus())})
+	}
+
+	if fieldChangesEnabled(fieldChangeDuration) {
+		previousDuration, previousErr := time.ParseDuration(previous.RunDuration)
+		duration, err := time.ParseDuration(state.RunDuration)
+		if previousErr == nil && err == nil && previousDuration > 0 && float64(duration) >= float64(previousDuration)*durationSpikeFactor() {
+			changes = append(changes, fieldChange{Field: fieldChangeDuration, Change: fieldChangeSpiked, Value: state.RunDuration, Previous: previous.RunDuration})
+		}
+	}
+
+	if fieldChangesEnabled(fieldChangeErrors) {
+		previousErrors := make(map[string]bool, len(previous.Errors))
+		for _, e := range previous.Errors {
+			previousErrors[e] = true
+		}
+		currentErrors := make(map[string]bool, len(state.Errors))
+		for _, e := range state.Errors {
+			currentErrors[e] = true
+			if !previousErrors[e] {
+				changes = append(changes, fieldChange{Field: fieldChangeErrors, Change: fieldChangeAdded, Value: e})
+			}
+		}
+		for _, e := range previous.Errors {
+			if !currentErrors[e] {
+				changes = append(changes, fieldChange{Field: fieldChangeErrors, Change: fieldChangeCleared, Value: e})
+				currentErrors[e] = true // duplicate errors are only cleared once
+			}
+		}
+	}
+
+	if fieldChangesEnabled(fieldChangeAnnotations) {
+		keys := make([]string, 0, len(previous.Annotations)+len(state.Annotations))
+		for key := range state.Annotations {
+			keys = append(keys, key)
+		}
+		for key := range previous.Annotations {
+			if _, exists := state.Annotations[key]; !exists {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value, exists := state.Annotations[key]
+			previousValue, existed := previous.Annotations[key]
+			switch {
+			case exists && !existed:
+				changes = append(changes, fieldChange{Field: fieldChangeAnnotations, Key: key, Change: fieldChangeAdded, Value: value})
+			case !exists && existed:
+				changes = append(changes, fieldChange{Field: fieldChangeAnnotations, Key: key, Change: fieldChangeCleared, Previous: previousValue})
+			case value != previousValue:
+				changes = append(changes, fieldChange{Field: fieldChangeAnnotations, Key: key, Change: fieldChangeChanged, Value: value, Previous: previousValue})
+			}
+		}
+	}
+
+	limit := maxFieldChangeEvents()
+	if len(changes) > limit {
+		left := len(changes) - limit + 1
+		changes = append(changes[:limit-1], fieldChange{Change: fieldChangeTruncated, Value: strconv.Itoa(left) + " more changes"})
+	}
+	return changes
+}
+
+// publishFieldChanges publishes the changes in the fields of a check result to watchers and counts them.  Nothing
+// is compared when no field change events are enabled.
+func publishFieldChanges(checkName string, checkNamespace string, previous health.WorkloadDetails, state health.WorkloadDetails) {
+	if len(cfg.FieldChangeEvents) == 0 {
+		return
+	}
+	changes := detectFieldChanges(previous, state)
+	if len(changes) == 0 {
+		return
+	}
+	for _, change := range changes {
+		fieldChangeEvents.Inc(change.Field, change.Change)
+	}
+	if !stateChanges.hasWatchers() {
+		return
+	}
+	labels := checkLabels(checkName, checkNamespace)
+	for i := range changes {
+		stateChanges.publish(stateChangeEvent{
+			Name:       checkName,
+			Namespace:  checkNamespace,
+			Labels:     labels,
+			PreviousOK: previous.OK,
+			OK:         state.OK,
+			Errors:     state.Errors,
+			Timestamp:  state.LastRun,
+			Change:     &changes[i],
+		})
+	}
+}
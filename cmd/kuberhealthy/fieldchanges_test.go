@@ -0,0 +1,87 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strconv"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// TestDetectFieldChanges ensures that only the enabled fields are compared, that errors that appear and clear,
+// duration spikes and annotation changes are detected and that the number of changes is bounded
+func TestDetectFieldChanges(t *testing.T) {
+	originalFields, originalMax := cfg.FieldChangeEvents, cfg.MaxFieldChangeEvents
+	defer func() { cfg.FieldChangeEvents, cfg.MaxFieldChangeEvents = originalFields, originalMax }()
+
+	previous := health.WorkloadDetails{OK: false, Errors: []string{"timeout", "dns failed"}, RunDuration: "2s", Annotations: map[string]string{"zone": "a", "node": "n1"}}
+	state := health.WorkloadDetails{OK: false, Errors: []string{"dns failed", "refused"}, RunDuration: "5s", Annotations: map[string]string{"zone": "b", "pod": "p1"}}
+
+	cfg.FieldChangeEvents = []string{fieldChangeErrors}
+	changes := detectFieldChanges(previous, state)
+	if len(changes) != 2 || changes[0] != (fieldChange{Field: fieldChangeErrors, Change: fieldChangeAdded, Value: "refused"}) ||
+		changes[1] != (fieldChange{Field: fieldChangeErrors, Change: fieldChangeCleared, Value: "timeout"}) {
+		t.Fatalf("expected only the added and cleared errors, got %+v", changes)
+	}
+
+	cfg.FieldChangeEvents = []string{fieldChangeDuration, fieldChangeAnnotations, fieldChangeStatus}
+	changes = detectFieldChanges(previous, state)
+	expected := []fieldChange{
+		{Field: fieldChangeDuration, Change: fieldChangeSpiked, Value: "5s", Previous: "2s"},
+		{Field: fieldChangeAnnotations, Key: "node", Change: fieldChangeCleared, Previous: "n1"},
+		{Field: fieldChangeAnnotations, Key: "pod", Change: fieldChangeAdded, Value: "p1"},
+		{Field: fieldChangeAnnotations, Key: "zone", Change: fieldChangeChanged, Value: "b", Previous: "a"},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("expected changes %+v, got %+v", expected, changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Fatalf("expected changes %+v, got %+v", expected, changes)
+		}
+	}
+
+	// a huge change in errors is cut down to the maximum number of events
+	cfg.FieldChangeEvents = []string{fieldChangeErrors}
+	cfg.MaxFieldChangeEvents = 5
+	many := health.WorkloadDetails{}
+	for i := 0; i < 1000; i++ {
+		many.Errors = append(many.Errors, "error "+strconv.Itoa(i))
+	}
+	changes = detectFieldChanges(health.WorkloadDetails{}, many)
+	if len(changes) != 5 || changes[4].Change != fieldChangeTruncated || changes[4].Value != "996 more changes" {
+		t.Fatalf("expected four changes and a truncated change, got %+v", changes)
+	}
+}
+
+// TestPublishFieldChanges ensures that field changes are sent to watchers as field change events
+func TestPublishFieldChanges(t *testing.T) {
+	originalFields := cfg.FieldChangeEvents
+	defer func() { cfg.FieldChangeEvents = originalFields }()
+	cfg.FieldChangeEvents = []string{fieldChangeErrors}
+
+	watcher := stateChanges.subscribe("team-a", labels.Everything())
+	defer stateChanges.unsubscribe(watcher)
+
+	publishFieldChanges("check", "team-a", health.WorkloadDetails{OK: true}, health.WorkloadDetails{OK: false, Errors: []string{"broken"}})
+	select {
+	case event := <-watcher.events:
+		if event.Change == nil || event.Change.Change != fieldChangeAdded || event.Change.Value != "broken" || event.Name != "check" {
+			t.Fatalf("expected a field change event for the new error, got %+v", event)
+		}
+	default:
+		t.Fatal("expected a field change event to be published")
+	}
+}
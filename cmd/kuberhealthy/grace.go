@@ -0,0 +1,42 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// initialGracePeriodForCheck returns the initial grace period of a check, which may be overridden per check
+func initialGracePeriodForCheck(checkName string, checkNamespace string) time.Duration {
+	if gracePeriod, ok := cfg.InitialGraceOverrides[checkNamespace+"/"+checkName]; ok {
+		return gracePeriod
+	}
+	return cfg.InitialGracePeriod
+}
+
+// suppressedByInitialGracePeriod indicates if a failure of a check should be hidden because the check is new
+func suppressedByInitialGracePeriod(checkName string, checkNamespace string, details health.WorkloadDetails, now time.Time) bool {
+	return !details.OK && details.InInitialGracePeriod(initialGracePeriodForCheck(checkName, checkNamespace), now)
+}
+
+// applyInitialGracePeriod shows a failure of a check that is still in its initial grace period as Unknown, so that
+// a check that is still stabilizing does not count against the cluster state or page anyone.  The errors are
+// kept so that they can still be seen on the status page.
+func applyInitialGracePeriod(checkName string, checkNamespace string, details *health.WorkloadDetails, now time.Time) {
+	if !suppressedByInitialGracePeriod(checkName, checkNamespace, *details, now) {
+		return
+	}
+	details.Status = health.StatusUnknown
+	details.InGracePeriod = true
+}
@@ -0,0 +1,52 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// TestApplyInitialGracePeriod ensures that only failures of checks created within their grace period are hidden
+func TestApplyInitialGracePeriod(t *testing.T) {
+	originalGracePeriod, originalOverrides := cfg.InitialGracePeriod, cfg.InitialGraceOverrides
+	defer func() {
+		cfg.InitialGracePeriod, cfg.InitialGraceOverrides = originalGracePeriod, originalOverrides
+	}()
+	cfg.InitialGracePeriod = time.Minute * 10
+	cfg.InitialGraceOverrides = durationMap{"kuberhealthy/slow-check": time.Hour}
+
+	now := time.Now()
+	tests := []struct {
+		name       string
+		check      string
+		details    health.WorkloadDetails
+		suppressed bool
+	}{
+		{name: "new failing check", check: "check", details: health.WorkloadDetails{OK: false, Created: now.Add(-time.Minute)}, suppressed: true},
+		{name: "new passing check", check: "check", details: health.WorkloadDetails{OK: true, Created: now.Add(-time.Minute)}},
+		{name: "old failing check", check: "check", details: health.WorkloadDetails{OK: false, Created: now.Add(-time.Minute * 20)}},
+		{name: "failing check without a creation time", check: "check", details: health.WorkloadDetails{OK: false}},
+		{name: "overridden failing check", check: "slow-check", details: health.WorkloadDetails{OK: false, Created: now.Add(-time.Minute * 20)}, suppressed: true},
+	}
+
+	for _, tc := range tests {
+		details := tc.details
+		details.Status = health.StatusFromOK(details.OK)
+		applyInitialGracePeriod(tc.check, "kuberhealthy", &details, now)
+		if details.InGracePeriod != tc.suppressed || (details.GetStatus() == health.StatusUnknown) != tc.suppressed {
+			t.Fatalf("%s: expected suppressed to be %t but got status %s", tc.name, tc.suppressed, details.GetStatus())
+		}
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"strings"
+
+	khjob "github.com/Comcast/kuberhealthy/v2/pkg/apis/khjob/v1"
+	"github.com/Comcast/kuberhealthy/v2/pkg/checks/external"
+)
+
+// jobErrorReason maps an error from a job run to the standard reason recorded on its khjob.  The external checker
+// only returns sentinel errors for pod deletions, so the other reasons are matched on the messages it returns.
+func jobErrorReason(err error) khjob.JobReason {
+	if errors.Is(err, external.ErrPodRemovedExpectedly) || errors.Is(err, external.ErrPodRemovedUnexpectedly) || errors.Is(err, external.ErrPodDeletedBeforeRunning) {
+		return khjob.ReasonPodDeleted
+	}
+
+	message := err.Error()
+	switch {
+	case strings.Contains(message, "failed to create pod"):
+		return khjob.ReasonPodCreationFailed
+	case strings.Contains(message, "timed out") || strings.Contains(message, "within timeout"):
+		return khjob.ReasonTimeout
+	case strings.Contains(message, "waiting for pod to start"):
+		return khjob.ReasonPodStartFailed
+	}
+	return khjob.ReasonRunFailed
+}
@@ -0,0 +1,85 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"k8s.io/client-go/rest"
+
+	khjobv1 "github.com/Comcast/kuberhealthy/v2/pkg/apis/khjob/v1"
+	"github.com/Comcast/kuberhealthy/v2/pkg/checks/external"
+)
+
+// TestJobErrorReason ensures that errors returned by job runs map to the standard khjob reasons
+func TestJobErrorReason(t *testing.T) {
+	tests := map[error]khjobv1.JobReason{
+		external.ErrPodRemovedUnexpectedly:                                                khjobv1.ReasonPodDeleted,
+		fmt.Errorf("run failed: %w", external.ErrPodDeletedBeforeRunning):                 khjobv1.ReasonPodDeleted,
+		errors.New("kuberhealthy/job: failed to create pod for checker: quota exceeded"):  khjobv1.ReasonPodCreationFailed,
+		errors.New("kuberhealthy/job: failed to see pod running within timeout"):          khjobv1.ReasonTimeout,
+		errors.New("kuberhealthy/job: timed out waiting for checker pod to report in"):    khjobv1.ReasonTimeout,
+		errors.New("kuberhealthy/job: error when waiting for pod to start: ErrImagePull"): khjobv1.ReasonPodStartFailed,
+		errors.New("kuberhealthy/job: error creating pod watcher: connection refused"):    khjobv1.ReasonRunFailed,
+	}
+	for err, expected := range tests {
+		if reason := jobErrorReason(err); reason != expected {
+			t.Fatalf("expected reason %s for %q, got %s", expected, err, reason)
+		}
+	}
+}
+
+// TestSetJobPhaseWithReason ensures that the reason and message are written with the phase and cleared by the next
+// phase
+func TestSetJobPhaseWithReason(t *testing.T) {
+	job := khjobv1.NewKuberhealthyJob("job", "kuberhealthy", khjobv1.JobConfig{Phase: khjobv1.JobRunning})
+	job.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	job.Kind = "KuberhealthyJob"
+
+	url := useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPut {
+			job = khjobv1.KuberhealthyJob{}
+			json.NewDecoder(r.Body).Decode(&job)
+		}
+		json.NewEncoder(w).Encode(job)
+	})
+	jobClient, err := khjobv1.NewForConfig(&rest.Config{Host: url})
+	if err != nil {
+		t.Fatal("failed to create khjob client for test server:", err)
+	}
+	originalJobClient := khJobClient
+	khJobClient = jobClient
+	defer func() {
+		khJobClient = originalJobClient
+	}()
+
+	err = setJobPhaseWithReason("job", "kuberhealthy", khjobv1.JobErrored, khjobv1.ReasonTimeout, "timed out waiting for checker pod to report in")
+	if err != nil {
+		t.Fatal("unexpected error setting job phase:", err)
+	}
+	if job.Spec.Phase != khjobv1.JobErrored || job.Spec.Reason != khjobv1.ReasonTimeout || job.Spec.Message == "" {
+		t.Fatalf("expected the job to be errored with a timeout reason, got %+v", job.Spec)
+	}
+
+	err = setJobPhase("job", "kuberhealthy", khjobv1.JobRunning)
+	if err != nil {
+		t.Fatal("unexpected error setting job phase:", err)
+	}
+	if job.Spec.Reason != "" || job.Spec.Message != "" {
+		t.Fatalf("expected the reason and message to be cleared, got %+v", job.Spec)
+	}
+}
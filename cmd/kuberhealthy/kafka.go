@@ -0,0 +1,116 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/segmentio/kafka-go"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// defaultKafkaBufferSize is the number of results that can wait to be published before new results are dropped
+const defaultKafkaBufferSize = 1000
+
+// errKafkaBufferFull is returned when a result is dropped because the kafka publish buffer is full
+var errKafkaBufferFull = errors.New("kafka publish buffer is full")
+
+// checkResultRecord is a single check result as it is sent to external systems
+type checkResultRecord struct {
+	Name      string
+	Namespace string
+	Details   health.WorkloadDetails
+}
+
+// kafkaStateStore publishes check results to a kafka topic keyed by check name.  Results are buffered and
+// published in the background so that a slow broker never slows down khstate writes.
+type kafkaStateStore struct {
+	writer *kafka.Writer
+	queue  chan kafka.Message
+}
+
+// newKafkaStateStore creates a kafka state store and starts publishing results in the background
+func newKafkaStateStore(brokers []string, topic string, bufferSize int) (*kafkaStateStore, error) {
+	if len(brokers) == 0 {
+		return nil, errors.New("at least one kafka broker must be configured")
+	}
+	if len(topic) == 0 {
+		return nil, errors.New("a kafka topic must be configured")
+	}
+	if bufferSize <= 0 {
+		bufferSize = defaultKafkaBufferSize
+	}
+
+	k := &kafkaStateStore{
+		queue: make(chan kafka.Message, bufferSize),
+	}
+	k.writer = &kafka.Writer{
+		Addr:       kafka.TCP(brokers...),
+		Topic:      topic,
+		Balancer:   &kafka.Hash{}, // results for the same check always land on the same partition
+		Async:      true,
+		Completion: k.deliveryComplete,
+	}
+	go k.publish()
+	return k, nil
+}
+
+// Name returns the name of the state store for logs and metrics
+func (k *kafkaStateStore) Name() string {
+	return "kafka"
+}
+
+// SetCheckState queues a check result to be published.  If the buffer is full, the result is dropped.
+func (k *kafkaStateStore) SetCheckState(checkName string, checkNamespace string, state health.WorkloadDetails) error {
+	b, err := json.Marshal(checkResultRecord{
+		Name:      checkName,
+		Namespace: checkNamespace,
+		Details:   state,
+	})
+	if err != nil {
+		return fmt.Errorf("error marshaling check result for kafka: %w", err)
+	}
+
+	select {
+	case k.queue <- kafka.Message{Key: []byte(checkNamespace + "/" + checkName), Value: b}:
+		return nil
+	default:
+		kafkaMessages.Inc("dropped")
+		return errKafkaBufferFull
+	}
+}
+
+// publish hands queued results to the kafka writer until the queue is closed
+func (k *kafkaStateStore) publish() {
+	for message := range k.queue {
+		err := k.writer.WriteMessages(context.Background(), message)
+		if err != nil {
+			log.Warningln("kafka: error publishing check result", string(message.Key)+":", err)
+			kafkaMessages.Inc("failed")
+		}
+	}
+}
+
+// deliveryComplete is called by the kafka writer when a batch of results has been delivered or has failed
+func (k *kafkaStateStore) deliveryComplete(messages []kafka.Message, err error) {
+	if err != nil {
+		log.Warningln("kafka: failed to deliver", len(messages), "check results:", err)
+		kafkaMessages.Add(float64(len(messages)), "failed")
+		return
+	}
+	kafkaMessages.Add(float64(len(messages)), "delivered")
+}
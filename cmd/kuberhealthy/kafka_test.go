@@ -0,0 +1,49 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// TestKafkaStateStoreDropsWhenFull ensures that results are keyed by check and dropped instead of blocking when the buffer is full
+func TestKafkaStateStoreDropsWhenFull(t *testing.T) {
+	k := &kafkaStateStore{queue: make(chan kafka.Message, 1)}
+
+	err := k.SetCheckState("my-check", "kuberhealthy", health.WorkloadDetails{OK: true})
+	if err != nil {
+		t.Fatal("expected result to be queued but got:", err)
+	}
+	err = k.SetCheckState("my-check", "kuberhealthy", health.WorkloadDetails{OK: false})
+	if !errors.Is(err, errKafkaBufferFull) {
+		t.Fatal("expected errKafkaBufferFull when the buffer is full but got:", err)
+	}
+
+	message := <-k.queue
+	if string(message.Key) != "kuberhealthy/my-check" {
+		t.Fatal("expected message to be keyed by check but got", string(message.Key))
+	}
+	record := checkResultRecord{}
+	err = json.Unmarshal(message.Value, &record)
+	if err != nil {
+		t.Fatal("failed to unmarshal published result:", err)
+	}
+	if record.Name != "my-check" || !record.Details.OK {
+		t.Fatal("published result did not match the written state:", record)
+	}
+}
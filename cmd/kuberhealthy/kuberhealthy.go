@@ -21,6 +21,7 @@ import (
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -72,6 +73,7 @@ type Kuberhealthy struct {
 	wg                 sync.WaitGroup     // used to track running checks
 	shutdownCtxFunc    context.CancelFunc // used to shutdown the main control select
 	stateReflector     *StateReflector    // a reflector that can cache the current state of the khState resources
+	statusSocket       *statusSocket      // serves the read API to local sidecars when a status socket is configured
 }
 
 // NewKuberhealthy creates a new kuberhealthy checker instance
@@ -81,6 +83,49 @@ func NewKuberhealthy() *Kuberhealthy {
 	return kh
 }
 
+// setCheckTimedOut records that a check did not report a result within its run timeout.  If a result was written
+// after the run started, it is newer than the timeout and is kept instead.
+func (k *Kuberhealthy) setCheckTimedOut(c KuberhealthyCheck, runStartTime time.Time) {
+	log.Warningln("Check", c.Name(), "in namespace", c.CheckNamespace(), "did not finish within its run timeout of", c.Timeout())
+
+	checkState, err := getCheckState(c)
+	if err != nil {
+		log.Errorln("Error getting check state to record timeout for check", c.Name(), "in namespace", c.CheckNamespace()+":", err)
+		return
+	}
+
+	details := health.NewWorkloadDetails(health.KHCheck)
+	details.Namespace = c.CheckNamespace()
+	details.OK = false
+	details.Errors = []string{fmt.Sprintf("check timed out after %.0fs", c.Timeout().Seconds())}
+	details.TimedOut = true
+	details.RunDuration = time.Now().Sub(runStartTime).String()
+	details.CurrentUUID = checkState.CurrentUUID
+
+	// the timeout goes through the same guards as a reported result, so that a result held by the minimum write
+	// interval is not written over it later
+	held, err := holdCheckStateWrite(c.Name(), c.CheckNamespace(), details)
+	if err != nil {
+		log.Errorln("Error recording timeout for check", c.Name(), "in namespace", c.CheckNamespace()+":", err)
+		return
+	}
+	if held {
+		log.Infoln("Holding timeout for check", c.Name(), "in namespace", c.CheckNamespace(), "until its minimum write interval has passed")
+		return
+	}
+
+	written, err := setCheckStateResourceIf(c.Name(), c.CheckNamespace(), details, func(existing health.WorkloadDetails) bool {
+		return !existing.LastRun.After(runStartTime)
+	})
+	if err != nil {
+		log.Errorln("Error recording timeout for check", c.Name(), "in namespace", c.CheckNamespace()+":", err)
+		return
+	}
+	if !written {
+		log.Infoln("Not recording timeout for check", c.Name(), "in namespace", c.CheckNamespace(), "because a result was reported during the run")
+	}
+}
+
 // setCheckExecutionError sets an execution error for a check name in
 // its crd status
 func (k *Kuberhealthy) setCheckExecutionError(checkName string, checkNamespace string, exErr error) error {
@@ -165,6 +210,19 @@ func (k *Kuberhealthy) Shutdown(doneChan chan struct{}) {
 	time.Sleep(5) // help prevent more checks from starting in a race before control system stop happens
 	log.Infoln("shutdown: stopping checks")
 	k.StopChecks() // stop all checks
+
+	// hand the checks this pod owned over to the remaining replicas
+	if !cfg.ReadOnly {
+		log.Infoln("shutdown: releasing ownership of khstates")
+		released, err := releaseOwnedCheckStates()
+		if err != nil {
+			log.Errorln("shutdown: error releasing ownership of khstates:", err)
+		}
+		log.Infoln("shutdown: released ownership of", released, "khstates")
+	}
+
+	k.stopStatusSocket()
+
 	log.Infoln("shutdown: ready for main program shutdown")
 	doneChan <- struct{}{}
 }
@@ -201,9 +259,40 @@ func (k *Kuberhealthy) StopChecks() {
 // Start inits Kuberhealthy checks and master monitoring
 func (k *Kuberhealthy) Start(ctx context.Context) {
 
+	// check that we have the permissions we need and report anything missing in one place
+	if !cfg.ReadOnly {
+		go reportMissingRBACPermissions()
+	}
+
+	// fetch the resource versions of every khstate in one list instead of one get per check on the first writes
+	if !cfg.ReadOnly {
+		err := primeStateVersionCache()
+		if err != nil {
+			log.Warningln("control:", err)
+		}
+	}
+
+	// seed the run history from prometheus so it does not start over with every restart
+	if len(cfg.PrometheusURL) > 0 && !cfg.ReadOnly {
+		go backfillRunHistory(ctx)
+	}
+
 	// start the khState reflector
 	go k.stateReflector.Start()
 
+	// watch for check results that expire because their checker went silent
+	go k.sweepExpiredResults(ctx)
+
+	// periodically write the state to a file for environments without external monitoring
+	if len(cfg.StateExportPath) > 0 {
+		go k.exportStates(ctx)
+	}
+
+	// periodically log the checks that are failing
+	if cfg.FailingSummaryInterval > 0 {
+		go logFailingChecks(ctx, cfg.FailingSummaryInterval)
+	}
+
 	// if influxdb is enabled, configure it
 	if cfg.EnableInflux == true {
 		k.configureInfluxForwarding()
@@ -212,6 +301,22 @@ func (k *Kuberhealthy) Start(ctx context.Context) {
 	// Start the web server and restart it if it crashes
 	go k.StartWebServer()
 
+	// sidecars can read check state over a unix socket instead of tcp
+	k.startStatusSocket()
+
+	// read-only replicas only serve the status API from the khstate cache
+	if cfg.ReadOnly {
+		log.Infoln("control: running in read-only mode. Checks will not be run and no state will be written.")
+		<-ctx.Done()
+		log.Infoln("control: shutting down from context abort...")
+		return
+	}
+
+	// keep the synthetic overall khstate in sync with the results of every check
+	if cfg.EnableOverallState {
+		go k.maintainOverallState(ctx)
+	}
+
 	// find all the external checks from the khcheckcrd resources on the cluster and keep them in sync.
 	// use rate limiting to avoid reconfiguration spam
 	maxUpdateInterval := time.Second * 10
@@ -238,6 +343,7 @@ func (k *Kuberhealthy) Start(ctx context.Context) {
 		case <-becameMasterChan: // we have become the current master instance and should run checks
 			// reset checks and re-add from configuration settings
 			log.Infoln("control: Became master. Reconfiguring and starting checks.")
+			resetStatesOnStartup()
 			k.StartChecks(ctx)
 			k.StartReaper(ctx)
 		case <-lostMasterChan: // we are no longer master
@@ -257,6 +363,25 @@ func (k *Kuberhealthy) Start(ctx context.Context) {
 	}
 }
 
+// resetStatesOnStartupOnce makes sure that khstates are only reset the first time this instance becomes master
+var resetStatesOnStartupOnce sync.Once
+
+// resetStatesOnStartup wipes out the results from before this restart in clean slate mode, so they can not mask a
+// problem.  It only runs the first time this instance becomes master, so that a restarting standby never resets
+// the results of the live master.
+func resetStatesOnStartup() {
+	if !cfg.ResetStatesOnStartup || cfg.ReadOnly {
+		return
+	}
+	resetStatesOnStartupOnce.Do(func() {
+		log.Warningln("control: resetStatesOnStartup is enabled. Setting the status of all khStates to", health.StatusUnknown)
+		err := resetAllCheckStatesToUnknown()
+		if err != nil {
+			log.Errorln("control: error resetting khStates on startup:", err)
+		}
+	})
+}
+
 // StartReaper starts the check reaper
 func (k *Kuberhealthy) StartReaper(ctx context.Context) {
 	reaperCtx, reaperCtxCancel := context.WithCancel(ctx)
@@ -286,9 +411,10 @@ func (k *Kuberhealthy) RestartChecks(ctx context.Context) {
 // khStateResourceReaper runs reapKHStateResources on an interval until the context for it is canceled
 func (k *Kuberhealthy) khStateResourceReaper(ctx context.Context) {
 
-	ticker := time.NewTicker(time.Minute)
+	interval := stateReaperInterval()
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
-	log.Infoln("khState reaper: starting up")
+	log.Infoln("khState reaper: starting up with an interval of", interval, "and", stateReaperShardCount(), "shards")
 
 	for {
 		select {
@@ -307,64 +433,41 @@ func (k *Kuberhealthy) khStateResourceReaper(ctx context.Context) {
 }
 
 // reapKHStateResources runs a single audit on khState resources.  Any that don't have a matching khCheck are
-// deleted.
+// deleted.  When the reaper is incremental, only the khStates in the shard of the current pass are audited, but
+// every khState, khCheck and khJob is still listed on every pass.  A pass that fails is not completed, so the next
+// pass audits the same shard.
 func (k *Kuberhealthy) reapKHStateResources() error {
+	shard := currentStateReaperShard()
 
-	// list all khStates in the cluster
-	khStates, err := khStateClient.List(metav1.ListOptions{}, stateCRDResource, "")
+	// list all khStates in the cluster.  khStates are sharded by a hash of their key, which the API server can not
+	// filter by, so the whole list is fetched and only the audit is sharded
+	khStates, err := getAllCheckStates("", projectionMetadata)
 	if err != nil {
 		return fmt.Errorf("khState reaper: error listing khStates for reaping: %w", err)
 	}
 
-	khChecks, err := listUnstructuredKHChecks()
+	desired, err := khCheckStateKeys()
 	if err != nil {
-		return fmt.Errorf("khState reaper: error listing unstructured khChecks: %w", err)
+		return fmt.Errorf("khState reaper: %w", err)
 	}
 
-	khJobs, err := khJobClient.KuberhealthyJobs(listenNamespace).List(metav1.ListOptions{})
+	jobKeys, err := khJobStateKeys()
 	if err != nil {
 		return fmt.Errorf("khState reaper: error listing khJobs for reaping: %w", err)
 	}
+	for key := range jobKeys {
+		desired[key] = true
+	}
 
-	log.Infoln("khState reaper: analyzing", len(khStates.Items), "khState resources")
-
-	// any khState that does not have a matching khCheck should be deleted (ignore errors)
-	for _, khState := range khStates.Items {
-		log.Debugln("khState reaper: analyzing khState", khState.GetName(), "in", khState.GetName())
-		var foundKHCheck bool
-		for _, kc := range khChecks.Items {
-			khCheck, err := convertUnstructuredKhCheck(kc)
-			if err != nil {
-				log.Errorln("Error converting unstructured object to khcheck:", err)
-				continue
-			}
-			log.Debugln("khState reaper:", khCheck.GetName(), "==", khState.GetName(), "&&", khCheck.GetNamespace(), "==", khState.GetNamespace())
-			if khCheck.GetName() == khState.GetName() && khCheck.GetNamespace() == khState.GetNamespace() {
-				log.Infoln("khState reaper:", khState.GetName(), "in", khState.GetNamespace(), "is still valid")
-				foundKHCheck = true
-				break
-			}
-		}
-
-		var foundKHJob bool
-		for _, kj := range khJobs.Items {
-			log.Debugln("khState reaper:", kj.GetName(), "==", khState.GetName(), "&&", kj.GetNamespace(), "==", khState.GetNamespace())
-			if kj.GetName() == khState.GetName() && kj.GetNamespace() == khState.GetNamespace() {
-				log.Infoln("khState reaper:", khState.GetName(), "in", khState.GetNamespace(), "is still valid")
-				foundKHJob = true
-				break
-			}
-		}
+	log.Infoln("khState reaper: analyzing shard", shard.index+1, "of", shard.count, "of", len(khStates), "khState resources")
 
-		// if we didn't find a matching khCheck or khJob, delete the rogue khState
-		if !foundKHCheck && !foundKHJob {
-			log.Infoln("khState reaper: removing khState", khState.GetName(), "in", khState.GetNamespace())
-			_, err := khStateClient.Delete(&khState, stateCRDResource, khState.GetName(), khState.GetNamespace())
-			if err != nil {
-				log.Errorln(fmt.Errorf("khState reaper: error when removing invalid khstate: %w", err))
-			}
-		}
+	// any khState that does not have a matching khCheck or khJob should be deleted
+	reaped, _, err := reapOrphanedStateShard(khStates, desired, shard, false)
+	if err != nil {
+		stateReaperReaped.Add(float64(reaped))
+		return fmt.Errorf("khState reaper: retrying shard %d of %d on the next pass: %w", shard.index+1, shard.count, err)
 	}
+	completeStateReaperPass(reaped, time.Now())
 
 	return nil
 
@@ -626,6 +729,18 @@ func (k *Kuberhealthy) monitorExternalChecks(ctx context.Context, notify chan st
 				foundChange = true
 			}
 
+			// check if the check was enabled or disabled
+			if !foundChange && knownSettings[mapName].IsEnabled() != i.Spec.IsEnabled() {
+				log.Debugln("The khcheck for", mapName, "was enabled or disabled.")
+				foundChange = true
+			}
+
+			// check if the priority has changed
+			if !foundChange && knownSettings[mapName].Priority != i.Spec.Priority {
+				log.Debugln("The khcheck priority for", mapName, "has changed.")
+				foundChange = true
+			}
+
 			// finally, update known settings before continuing to the next interval
 			knownSettings[mapName] = i.Spec
 		}
@@ -652,6 +767,30 @@ func (k *Kuberhealthy) addExternalChecks() error {
 	log.Debugln("Found", len(khChecks.Items), "external checks to load")
 
 	// iterate on each check CRD resource and add it as a check
+	clusterChecks := make(map[string]bool)
+	annotatedChecks := make(map[string]string)
+	dependencies := make(map[string][]string)
+	disabledChecks := make(map[string]bool)
+	priorities := make(map[string]int)
+	uids := make(map[string]string)
+	ttls := make(map[string]time.Duration)
+	stateLabels := make(map[string]map[string]string)
+	var renamable []renamableCheck
+	var registrations []checkRegistration
+	defer func() {
+		setClusterScopedChecks(clusterChecks)
+		setAnnotatedStateNamespaces(annotatedChecks)
+		setCheckDependencies(dependencies)
+		setDisabledChecks(disabledChecks)
+		setCheckPriorities(priorities)
+		setCheckUIDs(uids)
+		setResultTTLs(ttls)
+		setInjectedStateLabels(stateLabels)
+		for _, conflict := range findDuplicateRegistrations(registrations) {
+			log.Errorln("Duplicate check registration:", conflict)
+		}
+		migrateRenamedCheckStates(renamable)
+	}()
 	for _, kc := range khChecks.Items {
 		r, err := convertUnstructuredKhCheck(kc)
 		if err != nil {
@@ -666,6 +805,22 @@ func (k *Kuberhealthy) addExternalChecks() error {
 		log.Infoln("Enabling external check:", r.Name)
 		c := external.New(kubernetesClient, &r, khCheckClient, khStateClient, cfg.ExternalCheckReportingURL)
 
+		// checks annotated with a state namespace keep their khstate there, and cluster-scoped checks keep their
+		// khstate in the cluster checks namespace
+		c.StateNamespace = khCheckStateNamespace(c.Namespace, r.GetAnnotations())
+		annotatedNamespace, err := annotatedStateNamespace(r.GetAnnotations())
+		if err != nil {
+			log.Warningln("Ignoring the", stateNamespaceAnnotation, "annotation of check", r.Name, "in namespace", c.Namespace+":", err)
+		}
+		switch {
+		case len(annotatedNamespace) > 0:
+			log.Infoln("Check", r.Name, "is annotated to keep its khstate in namespace", c.StateNamespace)
+			annotatedChecks[stateRoutingKey(health.KHCheck, c.Namespace, c.CheckName)] = c.StateNamespace
+		case c.StateNamespace != c.Namespace:
+			log.Infoln("Check", r.Name, "is cluster-scoped and its khstate will be kept in namespace", c.StateNamespace)
+			clusterChecks[stateRoutingKey(health.KHCheck, c.Namespace, c.CheckName)] = true
+		}
+
 		// parse the run interval string from the custom resource and setup the run interval
 		c.RunInterval, err = time.ParseDuration(r.Spec.RunInterval)
 		if err != nil {
@@ -676,6 +831,11 @@ func (k *Kuberhealthy) addExternalChecks() error {
 
 		log.Debugln("RunInterval for check:", c.CheckName, "set to", c.RunInterval)
 
+		// results of checks with an expiry policy expire relative to how often the check runs
+		if ttl := resultTTLPolicy(c.CheckName, c.Namespace, c.RunInterval); ttl > 0 {
+			ttls[c.Namespace+"/"+c.CheckName] = ttl
+		}
+
 		// parse the user specified timeout if present
 		c.RunTimeout = khcheckcrd.DefaultTimeout
 		if len(r.Spec.Timeout) > 0 {
@@ -699,6 +859,38 @@ func (k *Kuberhealthy) addExternalChecks() error {
 		}
 		log.Debugln("External check labels and annotations:", c.ExtraLabels, c.ExtraAnnotations)
 
+		// remember the checks that must not be failing for this check to run
+		for _, dependency := range r.Spec.DependsOn {
+			key := c.Namespace + "/" + c.CheckName
+			dependencies[key] = append(dependencies[key], dependencyKey(dependency, c.Namespace))
+		}
+
+		// disabled checks are still added so that their khstate is kept, but they are not run
+		if !r.Spec.IsEnabled() {
+			log.Infoln("Check", r.Name, "in namespace", c.Namespace, "is disabled and will not be run")
+			disabledChecks[c.Namespace+"/"+c.CheckName] = true
+		}
+
+		// higher priority checks get a run slot first when the concurrent check limit is reached
+		if r.Spec.Priority != 0 {
+			log.Infoln("Check", r.Name, "in namespace", c.Namespace, "has priority", r.Spec.Priority)
+			priorities[c.Namespace+"/"+c.CheckName] = r.Spec.Priority
+		}
+
+		// configured labels are copied from the khcheck onto its khstate
+		if len(cfg.StateLabels) > 0 {
+			stateLabels[stateKey(c.CheckName, c.StateNamespace)] = resolveStateLabels(c.CheckName, c.Namespace, r.GetLabels(), r.GetAnnotations())
+		}
+
+		// checks annotated with a uid keep their khstate and run history when they are renamed
+		uid, annotated := khCheckUID(r.GetAnnotations(), string(r.GetUID()))
+		uids[c.Namespace+"/"+c.CheckName] = uid
+		if annotated {
+			renamable = append(renamable, renamableCheck{name: c.CheckName, namespace: c.Namespace, stateNamespace: c.StateNamespace, uid: uid})
+		}
+
+		registrations = append(registrations, checkRegistration{Kind: "khcheck", Name: r.Name, Namespace: c.Namespace, StateNamespace: c.StateNamespace})
+
 		// add the check into the checker
 		k.AddCheck(c)
 	}
@@ -886,7 +1078,11 @@ func (k *Kuberhealthy) masterMonitor(ctx context.Context, becameMasterChan chan
 func (k *Kuberhealthy) runJob(ctx context.Context, job khjob.KuberhealthyJob) {
 
 	log.Infoln("control: Loading job configuration...")
-	j := k.configureJob(job)
+	k.runConfiguredJob(ctx, job, k.configureJob(job))
+}
+
+// runConfiguredJob runs the checker configured for the job and sets its status
+func (k *Kuberhealthy) runConfiguredJob(ctx context.Context, job khjob.KuberhealthyJob, j KuberhealthyCheck) {
 
 	log.Println("Starting kuberhealthy job:", j.CheckNamespace(), "/", j.Name())
 	// break out if context cancels
@@ -916,10 +1112,17 @@ func (k *Kuberhealthy) runJob(ctx context.Context, job khjob.KuberhealthyJob) {
 			log.Infoln("Skipping this job due to expected pod removal before completion")
 		}
 		// set any job run errors in the CRD
-		err = k.setJobExecutionError(j.Name(), j.CheckNamespace(), err)
+		runErr := err
+		err = k.setJobExecutionError(j.Name(), j.CheckNamespace(), runErr)
 		if err != nil {
 			log.Errorln("Error setting job execution error:", err)
 		}
+
+		// record why the job errored on the khjob so that tooling does not have to parse the khstate
+		err = setJobPhaseWithReason(j.Name(), j.CheckNamespace(), khjob.JobErrored, jobErrorReason(runErr), runErr.Error())
+		if err != nil {
+			log.Errorln("Error setting job phase:", err)
+		}
 		// exit out of this runJob
 		return
 	}
@@ -940,7 +1143,15 @@ func (k *Kuberhealthy) runJob(ctx context.Context, job khjob.KuberhealthyJob) {
 	details.Namespace = j.CheckNamespace()
 	details.OK, details.Errors = j.CurrentStatus()
 	details.RunDuration = jobRunDuration.String()
-	details.CurrentUUID = jobDetails.CurrentUUID
+	keepReportedFields(&details, jobDetails)
+
+	// a khjob is scheduled to run when it is created.  recording the schedule marks the write as the one that
+	// finishes the run, so it is not skipped as a duplicate of the result reported with the same run ID
+	jobScheduled := job.CreationTimestamp.Time
+	if jobScheduled.IsZero() {
+		jobScheduled = jobStartTime
+	}
+	details.SetSchedule(jobScheduled, jobStartTime)
 
 	// send data to the metric forwarder if configured
 	if k.MetricForwarder != nil {
@@ -960,6 +1171,7 @@ func (k *Kuberhealthy) runJob(ctx context.Context, job khjob.KuberhealthyJob) {
 			"Name":            j.Name(),
 			"Errors":          strings.Join(details.Errors, ","),
 		}
+		addAnnotationTags(tags, details.Annotations)
 		metric := metrics.Metric{
 			{j.Name() + "." + j.CheckNamespace(): checkStatus},
 			{"RunDuration." + j.Name() + "." + j.CheckNamespace(): runDuration.Seconds()},
@@ -985,13 +1197,28 @@ func (k *Kuberhealthy) runJob(ctx context.Context, job khjob.KuberhealthyJob) {
 	}
 }
 
+// keepReportedFields copies the fields that the checker reported during a run from the stored result to the result
+// that finishes the run.  khchecks and khjobs both finish their runs through here, so new fields that checkers
+// report belong here too.
+func keepReportedFields(details *health.WorkloadDetails, reported health.WorkloadDetails) {
+	details.CurrentUUID = reported.CurrentUUID
+	details.Annotations = reported.Annotations     // keep the annotations the checker reported with its result
+	details.Severity = reported.Severity           // keep the severity so that warnings are not turned into critical failures
+	details.ResourceUsage = reported.ResourceUsage // keep the resource usage sampled when the checker reported
+	details.RunID = reported.RunID                 // keep the run ID so that retried reports of the run are not recorded again
+	details.LogTail = reported.LogTail             // keep the log tail recorded with a reported failure
+	details.ArtifactURLs = reported.ArtifactURLs   // keep the links to the artifacts of the run
+	details.ClockSkew = reported.ClockSkew         // keep the clock skew measured when the checker reported
+}
+
 // runCheck runs a check on an interval and sets its status each run
 func (k *Kuberhealthy) runCheck(ctx context.Context, c KuberhealthyCheck) {
 
 	log.Println("Starting check:", c.CheckNamespace(), "/", c.Name())
 
-	// run on an interval specified by the package
+	// run on an interval specified by the package.  every tick is the time the next run was scheduled for
 	ticker := time.NewTicker(c.Interval())
+	scheduled := time.Now()
 
 	// run the check forever and write its results to the kuberhealthy
 	// CRD resource for the check
@@ -1007,23 +1234,81 @@ func (k *Kuberhealthy) runCheck(ctx context.Context, c KuberhealthyCheck) {
 		default:
 		}
 
+		// disabled checks only record that they are disabled until they are enabled again
+		if isCheckDisabled(c.Name(), c.CheckNamespace()) {
+			err := k.recordCheckDisabled(c)
+			if err != nil {
+				log.Errorln("Error recording that check", c.Name(), "in namespace", c.CheckNamespace(), "is disabled:", err)
+			}
+			scheduled = <-ticker.C
+			continue
+		}
+
+		// skip this run if a check it depends on is already failing
+		if dependency := k.failingDependency(c); len(dependency) > 0 {
+			log.Infoln("Skipping run of check", c.Name(), "in namespace", c.CheckNamespace(), "because its dependency", dependency, "is failing")
+			err := k.skipCheckRun(c, dependency)
+			if err != nil {
+				log.Errorln("Error recording skipped run of check", c.Name(), "in namespace", c.CheckNamespace()+":", err)
+			}
+			scheduled = <-ticker.C
+			continue
+		}
+
+		// wait for a free run slot if too many checks are already running
+		var queued, deferred bool
+		priority := checkPriority(c.Name(), c.CheckNamespace())
+		releaseRunSlot, err := checkRunLimits.acquire(ctx, c.CheckNamespace()+"/"+c.Name(), priority, func() {
+			queued = true
+			log.Infoln("Check", c.Name(), "in namespace", c.CheckNamespace(), "is queued until a run slot is free")
+			setCheckQueued(c.Name(), c.CheckNamespace(), true)
+		}, func() {
+			deferred = true
+			log.Infoln("Check", c.Name(), "in namespace", c.CheckNamespace(), "with priority", priority, "is deferred because a higher priority check was started first")
+			setCheckDeferred(c.Name(), c.CheckNamespace(), true)
+		})
+		if err != nil {
+			log.Infoln("Shutting down queued check run due to context cancellation:", c.Name(), "in namespace", c.CheckNamespace())
+			return
+		}
+		if deferred {
+			setCheckDeferred(c.Name(), c.CheckNamespace(), false)
+		}
+		if queued {
+			setCheckQueued(c.Name(), c.CheckNamespace(), false)
+		}
+
 		// Run the check
 		log.Infoln("Running check:", c.Name())
 		// Record check run start time
 		checkStartTime := time.Now()
-		err := c.Run(ctx, kubernetesClient)
+
+		// record a timeout in the khstate if the check does not finish within its run timeout
+		var timedOut int32
+		timeoutTimer := time.AfterFunc(c.Timeout(), func() {
+			atomic.StoreInt32(&timedOut, 1)
+			k.setCheckTimedOut(c, checkStartTime)
+		})
+		err = c.Run(ctx, kubernetesClient)
+		timeoutTimer.Stop()
+		releaseRunSlot()
 		if err != nil {
 			log.Errorln("Error running check:", c.Name(), "in namespace", c.CheckNamespace()+":", err)
 			if strings.Contains(err.Error(), "pod deleted expectedly") {
 				log.Infoln("Skipping this run due to expected pod removal before completion")
-				<-ticker.C
+				scheduled = <-ticker.C
+			}
+			// a recorded timeout says more than the execution error the timeout caused
+			if atomic.LoadInt32(&timedOut) == 1 {
+				scheduled = <-ticker.C
+				continue
 			}
 			// set any check run errors in the CRD
 			err = k.setCheckExecutionError(c.Name(), c.CheckNamespace(), err)
 			if err != nil {
 				log.Errorln("Error setting check execution error:", err)
 			}
-			<-ticker.C
+			scheduled = <-ticker.C
 			continue
 		}
 		log.Debugln("Done running check:", c.Name(), "in namespace", c.CheckNamespace())
@@ -1034,8 +1319,9 @@ func (k *Kuberhealthy) runCheck(ctx context.Context, c KuberhealthyCheck) {
 		// running. Both occur before and after the checker pod completes its run.
 		checkRunDuration := time.Now().Sub(checkStartTime) - time.Second*10
 
-		// make a new state for this check and fill it from the check's current status
-		checkDetails, err := getCheckState(c)
+		// make a new state for this check and fill it from the check's current status.  a consistent read is
+		// used so that the annotations the checker just reported are not lost to a stale read
+		checkDetails, err := getCheckStateConsistent(c)
 		if err != nil {
 			log.Errorln("Error setting check state after run:", c.Name(), "in namespace", c.CheckNamespace()+":", err)
 		}
@@ -1043,7 +1329,8 @@ func (k *Kuberhealthy) runCheck(ctx context.Context, c KuberhealthyCheck) {
 		details.Namespace = c.CheckNamespace()
 		details.OK, details.Errors = c.CurrentStatus()
 		details.RunDuration = checkRunDuration.String()
-		details.CurrentUUID = checkDetails.CurrentUUID
+		keepReportedFields(&details, checkDetails)
+		details.SetSchedule(scheduled, checkStartTime)
 
 		// send data to the metric forwarder if configured
 		if k.MetricForwarder != nil {
@@ -1063,6 +1350,7 @@ func (k *Kuberhealthy) runCheck(ctx context.Context, c KuberhealthyCheck) {
 				"Name":            c.Name(),
 				"Errors":          strings.Join(details.Errors, ","),
 			}
+			addAnnotationTags(tags, details.Annotations)
 			metric := metrics.Metric{
 				{c.Name() + "." + c.CheckNamespace(): checkStatus},
 				{"RunDuration." + c.Name() + "." + c.CheckNamespace(): runDuration.Seconds()},
@@ -1082,21 +1370,31 @@ func (k *Kuberhealthy) runCheck(ctx context.Context, c KuberhealthyCheck) {
 		}
 
 		log.Infoln("Waiting for next run of check", c.Name(), "in namespace", c.CheckNamespace())
-		<-ticker.C // wait for next run
+		scheduled = <-ticker.C // wait for next run
 	}
 }
 
 // storeCheckState stores the check state in its cluster CRD
 func (k *Kuberhealthy) storeCheckState(checkName string, checkNamespace string, details health.WorkloadDetails) error {
 
-	// ensure the CRD resource exits
+	// ensure the CRD resource exits.  results of checks in namespaces that are being deleted are dropped quietly
 	err := ensureStateResourceExists(checkName, checkNamespace, details.GetKHWorkload())
+	if errors.Is(err, ErrNamespaceTerminating) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
 
-	// put the status on the CRD from the check
+	// put the status on the CRD from the check.  results held back by the write filter of the check are not errors
 	err = setCheckStateResource(checkName, checkNamespace, details)
+	if errors.Is(err, ErrWriteSuppressed) {
+		log.Debugln("Result of check", checkName, "in namespace", checkNamespace, "was suppressed by its write filter")
+		return nil
+	}
+	if errors.Is(err, ErrNamespaceTerminating) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
@@ -1115,6 +1413,59 @@ func (k *Kuberhealthy) StartWebServer() {
 		}
 	})
 
+	k.registerAdminHandlers()
+
+	// List the checks that are failing, longest failing first
+	http.HandleFunc("/failing", func(w http.ResponseWriter, r *http.Request) {
+		err := failingChecksHandler(w, r)
+		if err != nil {
+			log.Errorln("failing endpoint error:", err)
+		}
+	})
+
+	// Look up the khstate of a check by its UID, which is kept when the check is renamed
+	http.HandleFunc("/checkByUID", func(w http.ResponseWriter, r *http.Request) {
+		err := checkByUIDHandler(w, r)
+		if err != nil {
+			log.Errorln("check by uid endpoint error:", err)
+		}
+	})
+
+	// Query the khstates of checks by name glob or regular expression
+	http.HandleFunc("/checks", func(w http.ResponseWriter, r *http.Request) {
+		err := queryChecksHandler(w, r)
+		if err != nil {
+			log.Errorln("checks endpoint error:", err)
+		}
+	})
+
+	// Tell drain controllers whether the gating checks allow voluntary disruptions
+	http.HandleFunc(disruptionGatePath, k.disruptionGateHandlerFunc)
+
+	// Compare the results of all checks with a baseline to find regressions
+	http.HandleFunc("/regressions", func(w http.ResponseWriter, r *http.Request) {
+		err := regressionsHandler(w, r)
+		if err != nil {
+			log.Errorln("regressions endpoint error:", err)
+		}
+	})
+
+	// Stream changes in check results to watchers
+	http.HandleFunc("/watch", func(w http.ResponseWriter, r *http.Request) {
+		err := watchStateChangesHandler(w, r)
+		if err != nil {
+			log.Errorln("watch endpoint error:", err)
+		}
+	})
+
+	// Serve check results in the external metrics API format for autoscaling
+	http.HandleFunc(externalMetricsPath, externalMetricsHandlerFunc)
+	http.HandleFunc(externalMetricsPath+"/", externalMetricsHandlerFunc)
+
+	// Serve the enriched state of every check as a read-only aggregated API
+	http.HandleFunc(statusAPIPath, k.statusAPIHandlerFunc)
+	http.HandleFunc(statusAPIPath+"/", k.statusAPIHandlerFunc)
+
 	// Accept status reports coming from external checker pods
 	http.HandleFunc("/externalCheckStatus", func(w http.ResponseWriter, r *http.Request) {
 		err := k.externalCheckReportHandler(w, r)
@@ -1147,6 +1498,7 @@ type PodReportIPInfo struct {
 	Name      string
 	UUID      string
 	Namespace string
+	PodName   string
 }
 
 // validateExternalRequest calls the Kubernetes API to fetch details about a pod by it's source IP
@@ -1224,6 +1576,7 @@ func (k *Kuberhealthy) validateExternalRequest(remoteIPPort string) (PodReportIP
 	reportInfo.Name = podCheckName
 	reportInfo.Namespace = podCheckNamespace
 	reportInfo.UUID = podUUID
+	reportInfo.PodName = pod.GetName()
 
 	// next, we check the uuid against the check name to see if this uuid is the expected one.  if it isn't,
 	// we return an error
@@ -1345,32 +1698,21 @@ func (k *Kuberhealthy) externalCheckReportHandler(w http.ResponseWriter, r *http
 	}
 	log.Debugf("Check report after unmarshal: +%v\n", state)
 
-	// ensure that if ok is set to false, then an error is provided
-	if !state.OK {
-		if len(state.Errors) == 0 {
-			w.WriteHeader(http.StatusBadRequest)
-			k.externalCheckReportHandlerLog(requestID, "Client attempted to report OK false without any error strings")
-			return nil
-		}
-		for _, e := range state.Errors {
-			if len(e) == 0 {
-				w.WriteHeader(http.StatusBadRequest)
-				k.externalCheckReportHandlerLog(requestID, "Client attempted to report a blank error string")
-				return nil
-			}
-		}
-	}
-
 	checkRunDuration := time.Duration(0).String()
 	khWorkload := determineKHWorkload(ipReport.Name, ipReport.Namespace)
 
 	switch khWorkload {
 	case health.KHCheck:
-		checkDetails := k.stateReflector.CurrentStatus().CheckDetails
-		checkRunDuration = checkDetails[ipReport.Namespace+"/"+ipReport.Name].RunDuration
+		currentStatus := k.stateReflector.CurrentStatus()
+		key := stateKey(ipReport.Name, stateNamespaceForCheck(ipReport.Name, ipReport.Namespace))
+		checkRunDuration = currentStatus.CheckDetails[key].RunDuration
+		if isClusterScopedCheck(ipReport.Name, ipReport.Namespace) {
+			checkRunDuration = currentStatus.ClusterCheckDetails[key].RunDuration
+		}
 	case health.KHJob:
 		jobDetails := k.stateReflector.CurrentStatus().JobDetails
-		checkRunDuration = jobDetails[ipReport.Namespace+"/"+ipReport.Name].RunDuration
+		key := stateKey(ipReport.Name, stateNamespaceForWorkload(ipReport.Name, ipReport.Namespace, health.KHJob))
+		checkRunDuration = jobDetails[key].RunDuration
 	}
 
 	// create a details object from our incoming status report before storing it as a khstate custom resource
@@ -1381,15 +1723,48 @@ func (k *Kuberhealthy) externalCheckReportHandler(w http.ResponseWriter, r *http
 	details.RunDuration = checkRunDuration
 	details.Namespace = ipReport.Namespace
 	details.CurrentUUID = ipReport.UUID
+	details.Annotations = state.Annotations
+	details.Severity = health.Severity(state.Severity)
+	details.RunID = resolveRunID(ipReport.Name, ipReport.Namespace, state.RunID, ipReport.UUID)
+	details.ResourceUsage = lookupResourceUsage(ipReport.Namespace, ipReport.PodName)
+	details.ArtifactURLs = state.ArtifactURLs
+	details.ClockSkew = checkClockSkew(ipReport.Name, ipReport.Namespace, state.Timestamp, time.Now())
+	if !details.OK {
+		details.LogTail = lookupLogTail(ipReport.Namespace, ipReport.PodName)
+	}
+
+	// ensure the reported result is valid and tell the checker exactly which fields are not
+	validationErrors := details.Validate()
+	if len(validationErrors) > 0 {
+		k.externalCheckReportHandlerLog(requestID, "Client reported an invalid result:", validationErrors)
+		writeValidationErrors(w, validationErrors)
+		return nil
+	}
 
 	// since the check is validated, we can proceed to update the status now
 	k.externalCheckReportHandlerLog(requestID, "Setting check with name", ipReport.Name, "in namespace", ipReport.Namespace, "to 'OK' state:", details.OK, "uuid", details.CurrentUUID, details.GetKHWorkload())
 	err = k.storeCheckState(ipReport.Name, ipReport.Namespace, details)
+	if errors.Is(err, ErrStateTooLarge) {
+		// an error saying the result was too large was recorded in its place, so retrying would not help
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		k.externalCheckReportHandlerLog(requestID, "Client reported a result that is too large to store")
+		return nil
+	}
+	if errors.Is(err, ErrStateRejected) {
+		// an error saying the result did not match the khstate schema was recorded in its place
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		k.externalCheckReportHandlerLog(requestID, "Client reported a result that was rejected by the khstate schema")
+		return nil
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		k.externalCheckReportHandlerLog(requestID, "failed to store check state for %s: %w", ipReport.Name, err)
 		return fmt.Errorf("failed to store check state for %s: %w", ipReport.Name, err)
 	}
+	recordRunID(ipReport.Name, ipReport.Namespace, details.RunID, ipReport.UUID)
+
+	// keep a copy of the result on the checker pod in case the khstate is ever lost
+	annotatePodWithResult(ipReport.Namespace, ipReport.PodName, ipReport.Name, details)
 
 	// write ok back to caller
 	w.WriteHeader(http.StatusOK)
@@ -1397,6 +1772,35 @@ func (k *Kuberhealthy) externalCheckReportHandler(w http.ResponseWriter, r *http
 	return nil
 }
 
+// validationErrorResponse is the body returned to external checkers that report an invalid result
+type validationErrorResponse struct {
+	Errors []health.ValidationError
+}
+
+// writeValidationErrors writes a list of validation errors to the client as JSON with a 422 status code
+func writeValidationErrors(w http.ResponseWriter, validationErrors []health.ValidationError) {
+	b, err := json.Marshal(validationErrorResponse{Errors: validationErrors})
+	if err != nil {
+		log.Warningln("Error marshaling validation errors:", err)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	_, err = w.Write(b)
+	if err != nil {
+		log.Warningln("Error writing validation errors to caller:", err)
+	}
+}
+
+// addAnnotationTags adds check result annotations to the tags sent to the metric forwarder so that
+// consumers can render things like runbook links
+func addAnnotationTags(tags map[string]string, annotations map[string]string) {
+	for k, v := range annotations {
+		tags["Annotation."+k] = v
+	}
+}
+
 // writeHealthCheckError writes an error to the client when things go wrong in a health check handling
 func (k *Kuberhealthy) writeHealthCheckError(w http.ResponseWriter, r *http.Request, err error, state health.State) {
 	// if creating a CRD client fails, then write the error back to the user
@@ -1414,7 +1818,14 @@ func (k *Kuberhealthy) writeHealthCheckError(w http.ResponseWriter, r *http.Requ
 func (k *Kuberhealthy) prometheusMetricsHandler(w http.ResponseWriter, r *http.Request) error {
 	log.Infoln("Client connected to prometheus metrics endpoint from", r.RemoteAddr, r.UserAgent())
 	state := k.getCurrentState([]string{})
-	m := metrics.GenerateMetrics(state)
+	m := metrics.GenerateMetrics(state) + metrics.GenerateRegisteredMetrics()
+
+	// scrapers that accept OpenMetrics get exemplars linking check results to the run that reported them
+	if cfg.EnableExemplars && acceptsOpenMetrics(r) {
+		w.Header().Set("Content-Type", openMetricsContentType)
+		m = metrics.GenerateOpenMetrics(state) + metrics.GenerateRegisteredOpenMetrics() + "# EOF\n"
+	}
+
 	// write summarized health check results back to caller
 	_, err := w.Write([]byte(m))
 	if err != nil {
@@ -1423,6 +1834,19 @@ func (k *Kuberhealthy) prometheusMetricsHandler(w http.ResponseWriter, r *http.R
 	return err
 }
 
+// openMetricsContentType is the content type of metrics served in the OpenMetrics format
+const openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// acceptsOpenMetrics determines if a metrics scraper asked for the OpenMetrics format
+func acceptsOpenMetrics(r *http.Request) bool {
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		if strings.HasPrefix(strings.TrimSpace(accepted), "application/openmetrics-text") {
+			return true
+		}
+	}
+	return false
+}
+
 // healthCheckHandler returns the current status of checks loaded into Kuberhealthy
 // as JSON to the client. Respects namespace requests via URL query parameters (i.e. /?namespace=default)
 func (k *Kuberhealthy) healthCheckHandler(w http.ResponseWriter, r *http.Request) error {
@@ -1452,11 +1876,55 @@ func (k *Kuberhealthy) healthCheckHandler(w http.ResponseWriter, r *http.Request
 		}
 	}
 
+	// timestamps can be rendered in the timezone or format of the reader
+	presentation, renderTimestamps, err := parseTimestampPresentation(values)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return nil
+	}
+
+	// repeated reads of the same query are served from the status cache until it expires or a khstate is written
+	cacheKey := values.Encode()
+	now := time.Now()
+	if cfg.StatusCacheTTL > 0 {
+		if page, ok := statusCache.get(cacheKey, now); ok {
+			return writeStatusPage(w, r, page)
+		}
+	}
+	generation := statusCache.currentGeneration()
+
 	// fetch the current status from our khstate resources
 	state := k.getCurrentState(namespaces)
 
+	// dashboards that only need OK status and timestamps can ask for a trimmed response
+	if values.Get("projection") == "metadata" {
+		state = state.Metadata()
+	}
+
+	// render summarized health check results so that they can be tagged and cached
+	rendered := &bufferedResponse{}
+	if renderTimestamps {
+		err = writeStatusWithTimestamps(rendered, state, presentation)
+	} else {
+		err = state.WriteHTTPStatusResponse(rendered)
+	}
+	if err != nil {
+		log.Warningln("Error rendering health check results for caller:", err)
+		return err
+	}
+	page := cachedStatusPage{
+		body:       rendered.body.Bytes(),
+		etag:       statusETag(rendered.body.Bytes()),
+		generation: generation,
+		expires:    now.Add(cfg.StatusCacheTTL),
+	}
+	if cfg.StatusCacheTTL > 0 {
+		statusCache.set(cacheKey, page)
+	}
+
 	// write summarized health check results back to caller
-	err = state.WriteHTTPStatusResponse(w)
+	err = writeStatusPage(w, r, page)
 	if err != nil {
 		log.Warningln("Error writing health check results to caller:", err)
 	}
@@ -1474,17 +1942,59 @@ func (k *Kuberhealthy) getCurrentState(namespaces []string) health.State {
 		log.Errorln("Failed to calculate master:", err)
 	}
 
+	var currentState health.State
 	if len(namespaces) != 0 {
-		currentState := k.getCurrentStatusForNamespaces(namespaces)
-		currentState.CurrentMaster = currentMaster
-		return currentState
+		currentState = k.getCurrentStatusForNamespaces(namespaces)
+	} else {
+		currentState = k.stateReflector.CurrentStatus()
 	}
-
-	currentState := k.stateReflector.CurrentStatus()
 	currentState.CurrentMaster = currentMaster
+	currentState.WritesPaused = writesArePaused()
+	currentState.StateWritesFailing = stateBreaker.isOpen()
+	markStaticChecks(currentState.CheckDetails, cfg.StaticResultWindow)
+	markStaticChecks(currentState.ClusterCheckDetails, cfg.StaticResultWindow)
+	markExpiredChecks(currentState.CheckDetails, cfg.ResultTTL)
+	markExpiredChecks(currentState.ClusterCheckDetails, cfg.ResultTTL)
+	markReliability(currentState.CheckDetails)
+	markReliability(currentState.ClusterCheckDetails)
+	markAggregates(currentState.CheckDetails, cfg.AggregateRuns)
+	markAggregates(currentState.ClusterCheckDetails, cfg.AggregateRuns)
+	markTrends(currentState.CheckDetails)
+	markTrends(currentState.ClusterCheckDetails)
+	markAlerting(currentState.CheckDetails)
+	markAlerting(currentState.ClusterCheckDetails)
+	retainedStates.mark(currentState.CheckDetails)
+	retainedStates.mark(currentState.ClusterCheckDetails)
 	return currentState
 }
 
+// markExpiredChecks flags checks whose last result is older than the result TTL
+func markExpiredChecks(checkDetails map[string]health.WorkloadDetails, ttl time.Duration) {
+	now := time.Now()
+	for name, details := range checkDetails {
+		if !details.IsExpired(ttl, now) {
+			continue
+		}
+		details.Expired = true
+		checkDetails[name] = details
+	}
+}
+
+// markStaticChecks flags checks whose results have not changed within the static result window.  A check
+// that always reports the same result may be broken in a way that hides real failures.  The warning about a
+// static check is logged once when it is written, since this runs on every read of the current state.
+func markStaticChecks(checkDetails map[string]health.WorkloadDetails, window time.Duration) {
+	now := time.Now()
+	for name, details := range checkDetails {
+		if !details.IsStatic(window, now) {
+			continue
+		}
+		log.Debugln("Check", name, "has reported the same result since", details.LastResultChange)
+		details.Static = true
+		checkDetails[name] = details
+	}
+}
+
 // getCurrentState fetches the current state of all checks from the requested namespaces
 // their CRD objects and returns the summary as a health.State.
 // Failures to fetch CRD state return an error.
@@ -1493,9 +2003,11 @@ func (k *Kuberhealthy) getCurrentStatusForNamespaces(namespaces []string) health
 	states := k.stateReflector.CurrentStatus()
 	statesForNamespaces := states
 	statesForNamespaces.Errors = []string{}
+	statesForNamespaces.Warnings = nil
 	statesForNamespaces.OK = true
 	statesForNamespaces.CheckDetails = make(map[string]health.WorkloadDetails)
 	statesForNamespaces.JobDetails = make(map[string]health.WorkloadDetails)
+	statesForNamespaces.ClusterCheckDetails = make(map[string]health.WorkloadDetails) // cluster-scoped checks are not in any namespace
 	if len(namespaces) != 0 {
 		statesForNamespaces = validateCurrentStatusForNamespaces(states.CheckDetails, namespaces, statesForNamespaces, health.KHCheck)
 		statesForNamespaces = validateCurrentStatusForNamespaces(states.JobDetails, namespaces, statesForNamespaces, health.KHJob)
@@ -1508,7 +2020,10 @@ func (k *Kuberhealthy) getCurrentStatusForNamespaces(namespaces []string) health
 // validateCurrentStatusForNamespaces ranges through all CheckDetails or JobDetails to store in a new health state for namespaces
 func validateCurrentStatusForNamespaces(details map[string]health.WorkloadDetails, namespaces []string, statesForNamespaces health.State, workload health.KHWorkload) health.State {
 
-	for checkName, checkState := range details {
+	// go through the checks in a stable order so that the global error list is the same on every request
+	for _, checkName := range sortedCheckKeys(details) {
+		checkState := details[checkName]
+
 		// check if the namespace matches anything requested
 		if !containsString(checkState.Namespace, namespaces) {
 			log.Debugln("Skipping", checkName, "because it is not from the", namespaces, "namespace(s)")
@@ -1522,12 +2037,20 @@ func validateCurrentStatusForNamespaces(details map[string]health.WorkloadDetail
 			continue
 		}
 
-		// parse check status from CRD and add it to the global status of errors. Skip blank errors
+		// parse check status from CRD and add it to the global status of errors. Skip blank errors.  Checks
+		// with an unknown status have not reported yet and do not count as failures.
 		for _, e := range checkState.Errors {
+			if checkState.GetStatus() == health.StatusUnknown {
+				break
+			}
 			if len(strings.TrimSpace(e)) == 0 {
 				log.Warningln("Skipped an error that was blank when adding check details to current state.")
 				continue
 			}
+			if !failsOverallStatus(checkState) {
+				statesForNamespaces.AddWarning(e)
+				continue
+			}
 			statesForNamespaces.AddError(e)
 			log.Debugln("Status page: Setting global OK state to false due to check details not being OK")
 			statesForNamespaces.OK = false
@@ -1589,17 +2112,29 @@ func (k *Kuberhealthy) configureChecks() {
 // reporting into the status endpoint when they shouldn't be.
 func (k *Kuberhealthy) isUUIDWhitelistedForCheck(checkName string, checkNamespace string, uuid string) (bool, error) {
 
-	// get the item in question
-	checkState, err := khStateClient.Get(metav1.GetOptions{}, stateCRDResource, checkName, checkNamespace)
-	if err != nil {
-		return false, err
+	// the reporting pod may run a khcheck or a khjob of this name, and only khchecks can keep their khstate
+	// outside of their own namespace, so the khstate of the khjob is also looked at when the two differ
+	stateNamespaces := []string{stateNamespaceForWorkload(checkName, checkNamespace, health.KHCheck)}
+	if jobStateNamespace := stateNamespaceForWorkload(checkName, checkNamespace, health.KHJob); jobStateNamespace != stateNamespaces[0] {
+		stateNamespaces = append(stateNamespaces, jobStateNamespace)
 	}
 
-	log.Debugln("Validating current UUID", checkState.Spec.CurrentUUID, "vs incoming UUID:", uuid)
-	if checkState.Spec.CurrentUUID == uuid {
-		return true, nil
+	var err error
+	for _, stateNamespace := range stateNamespaces {
+		// get the item in question.  this uses the write client because the UUID was just written and a cached
+		// read could return a stale one
+		checkState, getErr := khStateClient.Get(metav1.GetOptions{}, stateCRDResource, checkName, stateNamespace)
+		err = getErr
+		if err != nil {
+			continue
+		}
+
+		log.Debugln("Validating current UUID", checkState.Spec.CurrentUUID, "vs incoming UUID:", uuid)
+		if checkState.Spec.CurrentUUID == uuid {
+			return true, nil
+		}
 	}
-	return false, nil
+	return false, err
 }
 
 // configureInfluxForwarding sets up initial influxdb metric sending
@@ -0,0 +1,104 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// logTailTimeout is how long the API server has to return the logs of a failed checker pod
+const logTailTimeout = time.Second * 3
+
+// defaultLogTailBytes is the maximum size of a recorded log tail when logTailBytes is not configured
+const defaultLogTailBytes = 2048
+
+// logTailLines is how many lines are requested from the end of a checker pod's logs
+const logTailLines = 50
+
+// maxLogTailBytes is the upper limit of the log tail size.  khstates are stored in etcd, so a misconfigured limit
+// must not let checker logs bloat them.
+const maxLogTailBytes = 16384
+
+// logTailLimit returns the maximum size in bytes of a recorded log tail
+func logTailLimit() int {
+	limit := cfg.LogTailBytes
+	if limit <= 0 {
+		limit = defaultLogTailBytes
+	}
+	if limit > maxLogTailBytes {
+		limit = maxLogTailBytes
+	}
+	return limit
+}
+
+// truncateLogTail keeps the end of a log that fits within limit bytes.  The cut is moved forward to the start of
+// the next line, or of the next whole character when there is no line break, so that the tail never starts
+// partway through a line or a character.
+func truncateLogTail(logs string, limit int) string {
+	logs = strings.TrimRight(logs, "\n")
+	if len(logs) <= limit {
+		return logs
+	}
+	tail := logs[len(logs)-limit:]
+	if i := strings.IndexByte(tail, '\n'); i >= 0 && i < len(tail)-1 {
+		return tail[i+1:]
+	}
+	for len(tail) > 0 && !utf8.RuneStart(tail[0]) {
+		tail = tail[1:]
+	}
+	return tail
+}
+
+// podLogTail fetches the last lines logged by a pod, capped to limit bytes
+func podLogTail(client kubernetes.Interface, namespace string, podName string, limit int) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), logTailTimeout)
+	defer cancel()
+
+	tailLines := int64(logTailLines)
+	stream, err := client.CoreV1().Pods(namespace).GetLogs(podName, &v1.PodLogOptions{TailLines: &tailLines}).Stream(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error fetching checker pod logs: %w", err)
+	}
+	defer stream.Close()
+
+	// lines can be arbitrarily long, so never read more than a few times the limit
+	b, err := ioutil.ReadAll(io.LimitReader(stream, int64(maxLogTailBytes*4)))
+	if err != nil {
+		return "", fmt.Errorf("error reading checker pod logs: %w", err)
+	}
+	return truncateLogTail(string(b), limit), nil
+}
+
+// lookupLogTail returns the tail of a checker pod's logs when log tail recording is enabled.  The tail only helps
+// explain a failure, so failures to fetch it are logged and a blank tail is returned so that the result of the
+// check is still recorded.
+func lookupLogTail(namespace string, podName string) string {
+	if !cfg.RecordLogTail || kubernetesClient == nil || len(podName) == 0 {
+		return ""
+	}
+	tail, err := podLogTail(kubernetesClient, namespace, podName, logTailLimit())
+	if err != nil {
+		log.Debugln(namespace, podName, "not recording checker pod log tail:", err)
+		return ""
+	}
+	return tail
+}
@@ -0,0 +1,101 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// TestTruncateLogTail ensures that log tails keep the end of the logs within the size limit without starting
+// partway through a line or a character
+func TestTruncateLogTail(t *testing.T) {
+	tests := []struct {
+		logs     string
+		limit    int
+		expected string
+	}{
+		{logs: "short\n", limit: 100, expected: "short"},
+		{logs: "first line\nsecond line\nthird line\n", limit: 20, expected: "third line"},
+		{logs: "one very long line without breaks", limit: 6, expected: "breaks"},
+		{logs: "ümlaut", limit: 6, expected: "mlaut"}, // the cut lands in the middle of ü
+	}
+	for _, test := range tests {
+		tail := truncateLogTail(test.logs, test.limit)
+		if tail != test.expected {
+			t.Fatalf("expected the tail of %q within %d bytes to be %q, got %q", test.logs, test.limit, test.expected, tail)
+		}
+		if len(tail) > test.limit {
+			t.Fatalf("expected the tail of %q to be at most %d bytes, got %d", test.logs, test.limit, len(tail))
+		}
+	}
+}
+
+// TestPodLogTail ensures that the tail of a checker pod's logs is fetched and capped
+func TestPodLogTail(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	tail, err := podLogTail(client, "kuberhealthy", "checker-pod", 4)
+	if err != nil {
+		t.Fatal("unexpected error fetching log tail:", err)
+	}
+	// the fake client always logs "fake logs"
+	if tail != "logs" {
+		t.Fatalf("expected the log tail to be capped to \"logs\", got %q", tail)
+	}
+}
+
+// TestRunCheckKeepsLogTailAndArtifacts ensures that the log tail and artifacts recorded with a failure reported
+// during a run are still stored once runCheck has written the result of the finished run
+func TestRunCheckKeepsLogTailAndArtifacts(t *testing.T) {
+	c := &FakeCheck{CheckName: "logtail-run-check", Namespace: "kuberhealthy", OK: false, Errors: []string{"connection refused"}}
+	reported := health.WorkloadDetails{
+		OK:           false,
+		Status:       health.StatusNotOK,
+		Errors:       []string{"connection refused"},
+		Namespace:    "kuberhealthy",
+		LogTail:      "dialing 10.0.0.1:53\nconnection refused",
+		ArtifactURLs: []string{"https://ci.example.com/runs/1/report.html"},
+	}
+
+	stored := runCheckOnce(t, c, reported)
+	if stored.LogTail != reported.LogTail {
+		t.Fatalf("expected the log tail %q to be kept after the run, got %q", reported.LogTail, stored.LogTail)
+	}
+	if len(stored.ArtifactURLs) != 1 || stored.ArtifactURLs[0] != reported.ArtifactURLs[0] {
+		t.Fatalf("expected the artifacts %v to be kept after the run, got %v", reported.ArtifactURLs, stored.ArtifactURLs)
+	}
+}
+
+// TestRunJobKeepsLogTailAndArtifacts ensures that the log tail and artifacts reported during the run of a khjob
+// are still stored once runJob has written the result of the finished run
+func TestRunJobKeepsLogTailAndArtifacts(t *testing.T) {
+	c := &FakeCheck{CheckName: "logtail-run-job", Namespace: "kuberhealthy", OK: false, Errors: []string{"connection refused"}}
+	reported := health.WorkloadDetails{
+		OK:           false,
+		Status:       health.StatusNotOK,
+		Errors:       []string{"connection refused"},
+		Namespace:    "kuberhealthy",
+		LogTail:      "dialing 10.0.0.1:53\nconnection refused",
+		ArtifactURLs: []string{"https://ci.example.com/runs/2/report.html"},
+	}
+
+	stored := runJobOnce(t, c, reported)
+	if stored.LogTail != reported.LogTail {
+		t.Fatalf("expected the log tail %q to be kept after the run, got %q", reported.LogTail, stored.LogTail)
+	}
+	if len(stored.ArtifactURLs) != 1 || stored.ArtifactURLs[0] != reported.ArtifactURLs[0] {
+		t.Fatalf("expected the artifacts %v to be kept after the run, got %v", reported.ArtifactURLs, stored.ArtifactURLs)
+	}
+}
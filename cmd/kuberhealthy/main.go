@@ -63,6 +63,10 @@ const KHCheckNameAnnotationKey = "comcast.github.io/check-name"
 // var externalCheckReportingURL = os.Getenv(KHExternalReportingURL)
 var khStateClient *khstatecrd.KuberhealthyStateClient
 
+// khStateReadClient is the client used for khstate reads.  It is the same as khStateClient unless
+// a separate read endpoint has been configured, such as an API server cache proxy.
+var khStateReadClient *khstatecrd.KuberhealthyStateClient
+
 // khJobClient is a client for khjob custom resources
 var khJobClient *khjobcrd.KHJobV1Client
 
@@ -89,11 +93,15 @@ func init() {
 	}
 
 	var useDebugMode bool
+	var readOnly bool
+	var stateUpdateStrategy string
 
 	// setup flaggy
 	flaggy.SetDescription("Kuberhealthy is an in-cluster synthetic health checker for Kubernetes.")
 	flaggy.String(&configPath, "c", "config", "(optional) absolute path to the kuberhealthy config file")
 	flaggy.Bool(&useDebugMode, "d", "debug", "Set to true to enable debug.")
+	flaggy.Bool(&readOnly, "", "read-only", "Only serve the status API. Checks are not run and no state is written.")
+	flaggy.String(&stateUpdateStrategy, "", "state-update-strategy", "How khstate results are written: update, merge-patch, json-patch or strategic-merge-patch.")
 	flaggy.Parse()
 
 	// attempt to load config file from disk
@@ -101,6 +109,12 @@ func init() {
 	if err != nil {
 		log.Println("WARNING: Failed to read configuration file from disk:", err)
 	}
+	if readOnly {
+		cfg.ReadOnly = true
+	}
+	if len(stateUpdateStrategy) > 0 {
+		cfg.StateUpdateStrategy = stateUpdateStrategy
+	}
 
 	// set env variables into config if specified. otherwise set external check URL to default
 	externalCheckURL, err := getEnvVar(KHExternalReportingURL)
@@ -143,6 +157,45 @@ func init() {
 		log.Fatalln("Failed to determine my hostname!")
 	}
 
+	// fall back to full updates when the khstate update strategy is not one we know
+	err = validateStateUpdateStrategy(cfg.StateUpdateStrategy)
+	if err != nil {
+		log.Errorln(err, "Using", stateUpdateStrategyUpdate, "instead.")
+		cfg.StateUpdateStrategy = stateUpdateStrategyUpdate
+	}
+
+	// sensitive khstate fields are never written in plaintext because the keys could not be loaded
+	err = loadStateCipher()
+	if err != nil {
+		log.Fatalln("Failed to load khstate encryption keys:", err)
+	}
+
+	// cap the number of checks that can run at once
+	checkRunLimits = newCheckRunLimiter(cfg.MaxConcurrentChecks)
+
+	// optionally notify a webhook when check results change
+	if len(cfg.NotificationWebhookURL) > 0 {
+		log.Infoln("Sending check state change notifications to", cfg.NotificationWebhookURL)
+		stateChangeNotifier = newWebhookNotifier(cfg.NotificationWebhookURL, cfg.NotificationTemplate)
+		stateChangeQueue = newNotificationQueue(notificationQueueSize(), notificationCoalescing(), stateChangeNotifier.send)
+		go stateChangeQueue.run()
+	}
+
+	// optionally emit cloudevents when check results change
+	if len(cfg.CloudEventsSink) > 0 {
+		log.Infoln("Emitting cloudevents for check result changes to", cfg.CloudEventsSink)
+		cloudEventEmitter, err = newCloudEventsEmitter(cfg.CloudEventsSink, cfg.CloudEventsSubject, cfg.CloudEventsBufferSize)
+		if err != nil {
+			log.Errorln("Not emitting cloudevents:", err)
+		}
+	}
+
+	// optionally trace where the time of khstate writes goes
+	if cfg.TraceStateWrites {
+		log.Infoln("Tracing khstate writes")
+		stateTracer = logTracer{}
+	}
+
 	// setup all clients
 	err = initKubernetesClients()
 	if err != nil {
@@ -152,6 +205,11 @@ func init() {
 
 func main() {
 
+	// two checks that share a khstate overwrite each other's results, so refuse to start unless told to warn
+	if !cfg.ReadOnly {
+		validateCheckRegistrationsOrExit()
+	}
+
 	// Create a new Kuberhealthy struct
 	kuberhealthy := NewKuberhealthy()
 	kuberhealthy.ListenAddr = cfg.ListenAddress
@@ -233,12 +291,79 @@ func initKubernetesClients() error {
 	}
 	khCheckClient = checkClient
 
-	// make a new crd state client
-	stateClient, err := khstatecrd.Client(stateCRDGroup, stateCRDVersion, cfg.kubeConfigFile, "")
+	// make the crd state clients.  reads can go through a separate kubeconfig, and khstates can optionally be
+	// written to a second cluster as well, such as a central management cluster
+	if len(cfg.StateReadKubeConfigFile) > 0 {
+		log.Infoln("Using separate kubeconfig for khstate reads:", cfg.StateReadKubeConfigFile)
+	}
+	if len(cfg.RemoteStateKubeConfigFile) > 0 {
+		log.Infoln("Writing khstates to remote cluster from kubeconfig:", cfg.RemoteStateKubeConfigFile)
+	}
+	stateClients, err := buildStateClients(stateStoreConfigFrom(cfg))
 	if err != nil {
 		return err
 	}
-	khStateClient = stateClient
+	khStateClient = stateClients.write
+	khStateReadClient = stateClients.read
+	if stateClients.remote != nil {
+		secondaryStateStores = append(secondaryStateStores, stateClients.remote)
+	}
+
+	// optionally publish check results to kafka
+	if len(cfg.KafkaBrokers) > 0 {
+		log.Infoln("Publishing check results to kafka topic", cfg.KafkaTopic, "on brokers", cfg.KafkaBrokers)
+		kafkaStore, err := newKafkaStateStore(cfg.KafkaBrokers, cfg.KafkaTopic, cfg.KafkaBufferSize)
+		if err != nil {
+			return err
+		}
+		secondaryStateStores = append(secondaryStateStores, kafkaStore)
+	}
+
+	// optionally append check results to postgres
+	if len(cfg.PostgresURL) > 0 {
+		log.Infoln("Appending check results to postgres")
+		postgresStore, err := newPostgresStateStore(cfg.PostgresURL, cfg.PostgresBufferSize)
+		if err != nil {
+			return err
+		}
+		secondaryStateStores = append(secondaryStateStores, postgresStore)
+	}
+
+	// optionally index check results in elasticsearch
+	if len(cfg.ElasticsearchURL) > 0 {
+		log.Infoln("Indexing check results in elasticsearch")
+		elasticsearchStore, err := newElasticsearchStateStore(elasticsearchConfig{
+			URL:        cfg.ElasticsearchURL,
+			Index:      cfg.ElasticsearchIndex,
+			Username:   cfg.ElasticsearchUsername,
+			Password:   cfg.ElasticsearchPassword,
+			APIKey:     cfg.ElasticsearchAPIKey,
+			Flush:      cfg.ElasticsearchFlush,
+			BufferSize: cfg.ElasticsearchBufferSize,
+		})
+		if err != nil {
+			return err
+		}
+		secondaryStateStores = append(secondaryStateStores, elasticsearchStore)
+	}
+
+	// optionally publish check results to cloud provider monitoring
+	if len(cfg.CloudWatchNamespace) > 0 {
+		log.Infoln("Publishing check results to cloudwatch metric namespace", cfg.CloudWatchNamespace)
+		cloudWatchStore, err := newCloudWatchStateStore(cfg.CloudWatchNamespace)
+		if err != nil {
+			return err
+		}
+		secondaryStateStores = append(secondaryStateStores, cloudWatchStore)
+	}
+	if len(cfg.GCPMonitoringProject) > 0 {
+		log.Infoln("Publishing check results to gcp monitoring in project", cfg.GCPMonitoringProject)
+		gcpStore, err := newGCPMonitoringStateStore(cfg.GCPMonitoringProject, cfg.GCPMetricPrefix)
+		if err != nil {
+			return err
+		}
+		secondaryStateStores = append(secondaryStateStores, gcpStore)
+	}
 
 	// make a new crd job client
 	jobClient, err := khjobcrd.Client(cfg.kubeConfigFile)
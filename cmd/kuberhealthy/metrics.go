@@ -0,0 +1,188 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/metrics"
+)
+
+// The counters and gauges Kuberhealthy serves on its metrics endpoint.  They are all created by registerMetrics so
+// that every metric is registered exactly once.
+var (
+	// writeConfirmations counts khstate write confirmations by result
+	writeConfirmations *metrics.CounterVec
+
+	// checkRunsActive and checkRunsQueued expose how many check runs are running and waiting on the run limiter
+	checkRunsActive *metrics.GaugeVec
+	checkRunsQueued *metrics.GaugeVec
+
+	// checkRunsQueuedByPriority and checkRunsDeferred expose how runs waiting on the run limiter are ordered by priority
+	checkRunsQueuedByPriority *metrics.GaugeVec
+	checkRunsDeferred         *metrics.CounterVec
+
+	// stateVersionCacheInvalidations counts khstates dropped from the resource version cache by the reason they were dropped
+	stateVersionCacheInvalidations *metrics.CounterVec
+
+	// throttledWrites counts khstate writes that were coalesced because they arrived faster than the minimum write interval
+	throttledWrites *metrics.CounterVec
+
+	// checkExpiredEvents counts the times a check result has expired
+	checkExpiredEvents *metrics.CounterVec
+
+	// checkExpired shows check results that are currently expired
+	checkExpired *metrics.GaugeVec
+
+	// oversizedStates counts check results that were too large to store as they were reported
+	oversizedStates *metrics.CounterVec
+
+	// stateStoreWrites counts writes to secondary state stores by store and result
+	stateStoreWrites *metrics.CounterVec
+
+	// notifications counts state change notifications by result
+	notifications *metrics.CounterVec
+
+	// kafkaMessages counts check results published to kafka by result
+	kafkaMessages *metrics.CounterVec
+
+	// postgresWrites counts check results written to postgres by result
+	postgresWrites *metrics.CounterVec
+
+	// elasticsearchDocuments counts check results indexed in elasticsearch by result
+	elasticsearchDocuments *metrics.CounterVec
+
+	// cloudEvents counts CloudEvents for check result changes by result
+	cloudEvents *metrics.CounterVec
+
+	// identicalWrites counts check results that were not written in full because they were identical to the stored result
+	identicalWrites *metrics.CounterVec
+
+	// stateWriteTimeouts counts khstate writes that did not finish within their write timeout
+	stateWriteTimeouts *metrics.CounterVec
+
+	// persistentConflicts counts khstate writes that gave up because the khstate kept changing outside of kuberhealthy
+	persistentConflicts *metrics.CounterVec
+
+	// fieldChangeEvents counts the changes in the fields of check results that field change events were sent for
+	fieldChangeEvents *metrics.CounterVec
+
+	// disruptionGateReady shows if the gating checks allowed voluntary disruptions the last time the gate was asked
+	disruptionGateReady *metrics.GaugeVec
+
+	// checkScheduleDelay observes how long after their scheduled time check runs start
+	checkScheduleDelay *metrics.HistogramVec
+
+	// partialLists counts khstate lists that failed partway and returned the khstates listed before the failure
+	partialLists *metrics.CounterVec
+
+	// stateDecryptionFailures counts encrypted khstate field values that could not be decrypted
+	stateDecryptionFailures *metrics.CounterVec
+
+	// invalidRunIDs counts reported run IDs that were invalid and replaced with generated ones
+	invalidRunIDs *metrics.CounterVec
+
+	// stateReaperLastRun shows when the khstate reaper last finished a pass as a unix timestamp
+	stateReaperLastRun *metrics.GaugeVec
+
+	// stateReaperReaped counts the orphaned khstates deleted by the khstate reaper
+	stateReaperReaped *metrics.CounterVec
+
+	// stateBreakerOpen shows 1 while khstate writes are being short-circuited and 0 while they go to the API server
+	stateBreakerOpen *metrics.GaugeVec
+
+	// foreignOwnershipRefusals counts khstate writes refused because the khstate belongs to another instance
+	foreignOwnershipRefusals *metrics.CounterVec
+
+	// checkReliability shows the percentage of runs within the reliability window that each check was OK
+	checkReliability *metrics.GaugeVec
+
+	// redactions counts matches of redaction patterns masked in check results
+	redactions *metrics.CounterVec
+
+	// stateWatchEventsDropped counts state change events that a watcher was too slow to receive
+	stateWatchEventsDropped *metrics.CounterVec
+
+	// stateWatchers tracks how many clients are watching for state changes
+	stateWatchers *metrics.GaugeVec
+
+	// checkerClockSkew shows how far the clock of each checker was from the clock of Kuberhealthy on its last report
+	checkerClockSkew *metrics.GaugeVec
+
+	// clockSkewEvents counts reports from checkers whose clock was further off than the maximum clock skew
+	clockSkewEvents *metrics.CounterVec
+
+	// suppressedWrites counts check results that were not recorded because of the write filter of the check
+	suppressedWrites *metrics.CounterVec
+
+	// schemaRejections counts check results that the API server rejected because they did not match the khstate schema
+	schemaRejections *metrics.CounterVec
+
+	// notificationsDropped counts state change notifications dropped because the notification queue was full
+	notificationsDropped *metrics.CounterVec
+
+	// stateWriteBackoffSeconds shows the current wait between khstate write retries of each khstate
+	stateWriteBackoffSeconds *metrics.GaugeVec
+)
+
+// registerMetricsOnce keeps registerMetrics from registering the metrics more than once
+var registerMetricsOnce sync.Once
+
+func init() {
+	registerMetrics()
+}
+
+// registerMetrics creates and registers every Kuberhealthy metric.  It is safe to call more than once and from
+// multiple goroutines.  Only the first call registers anything.
+func registerMetrics() {
+	registerMetricsOnce.Do(func() {
+		writeConfirmations = metrics.NewCounterVec("kuberhealthy_state_write_confirmations_total", "Counts read-back confirmations of khstate writes by result", "result")
+		checkRunsActive = metrics.NewGaugeVec("kuberhealthy_check_runs_active", "Number of checks that are currently running")
+		checkRunsQueued = metrics.NewGaugeVec("kuberhealthy_check_runs_queued", "Number of check runs waiting for a free slot under the concurrent check limit")
+		stateVersionCacheInvalidations = metrics.NewCounterVec("kuberhealthy_state_version_cache_invalidations_total", "Counts khstates removed from the resource version cache because their cached version could no longer be written", "reason")
+		throttledWrites = metrics.NewCounterVec("kuberhealthy_throttled_writes_total", "Counts khstate writes coalesced by the minimum write interval", "check", "namespace")
+		checkExpiredEvents = metrics.NewCounterVec("kuberhealthy_check_expired_events_total", "Counts the times a check result has expired because the check stopped reporting", "check", "namespace")
+		checkExpired = metrics.NewGaugeVec("kuberhealthy_check_expired", "Shows Kuberhealthy checks whose last result is older than the result TTL", "check", "namespace")
+		oversizedStates = metrics.NewCounterVec("kuberhealthy_state_oversized_total", "Counts check results that were too large to store in a khstate as they were reported", "mode")
+		stateStoreWrites = metrics.NewCounterVec("kuberhealthy_state_store_writes_total", "Counts writes of check state to secondary state stores", "store", "result")
+		notifications = metrics.NewCounterVec("kuberhealthy_notifications_total", "Counts check state change notifications by delivery result", "result")
+		kafkaMessages = metrics.NewCounterVec("kuberhealthy_kafka_messages_total", "Counts check results published to kafka by delivery result", "result")
+		postgresWrites = metrics.NewCounterVec("kuberhealthy_postgres_writes_total", "Counts check results written to postgres by result", "result")
+		elasticsearchDocuments = metrics.NewCounterVec("kuberhealthy_elasticsearch_documents_total", "Counts check results indexed in elasticsearch by result", "result")
+		cloudEvents = metrics.NewCounterVec("kuberhealthy_cloudevents_total", "Counts CloudEvents emitted for check result changes by result", "result")
+		identicalWrites = metrics.NewCounterVec("kuberhealthy_skipped_identical_writes_total", "Counts check results that were not written in full because they were identical to the stored result", "check", "namespace", "mode")
+		stateWriteTimeouts = metrics.NewCounterVec("kuberhealthy_state_write_timeouts_total", "Counts khstate writes that did not finish within their write timeout", "check", "namespace")
+		persistentConflicts = metrics.NewCounterVec("kuberhealthy_state_persistent_conflicts_total", "Counts khstate writes that gave up because the khstate kept changing outside of kuberhealthy", "check", "namespace")
+		fieldChangeEvents = metrics.NewCounterVec("kuberhealthy_field_change_events_total", "Counts the changes in the fields of check results that field change events were sent for", "field", "change")
+		disruptionGateReady = metrics.NewGaugeVec("kuberhealthy_disruption_gate_ready", "Shows if the gating checks allowed voluntary disruptions the last time the gate was asked")
+		checkScheduleDelay = metrics.NewHistogramVec("kuberhealthy_check_schedule_delay_seconds", "Observes how long after their scheduled time check runs start", scheduleDelayBuckets, "check", "namespace")
+		partialLists = metrics.NewCounterVec("kuberhealthy_state_partial_lists_total", "Counts khstate lists that failed partway and returned the khstates listed before the failure")
+		stateDecryptionFailures = metrics.NewCounterVec("kuberhealthy_state_decryption_failures_total", "Counts encrypted khstate field values that could not be decrypted", "field")
+		invalidRunIDs = metrics.NewCounterVec("kuberhealthy_invalid_run_ids_total", "Counts reported run IDs that were invalid and replaced with generated ones", "check", "namespace", "reason")
+		stateReaperLastRun = metrics.NewGaugeVec("kuberhealthy_state_reaper_last_run_timestamp_seconds", "Shows when the khstate reaper last finished a pass as a unix timestamp")
+		stateReaperReaped = metrics.NewCounterVec("kuberhealthy_state_reaper_reaped_total", "Counts the orphaned khstates deleted by the khstate reaper")
+		stateBreakerOpen = metrics.NewGaugeVec("kuberhealthy_state_circuit_breaker_open", "Shows 1 while khstate writes are short-circuited because the API server keeps failing")
+		foreignOwnershipRefusals = metrics.NewCounterVec("kuberhealthy_state_foreign_ownership_refusals_total", "Counts khstate writes refused because the khstate is owned by a Kuberhealthy instance in another namespace", "owner_namespace")
+		checkReliability = metrics.NewGaugeVec("kuberhealthy_check_reliability_percent", "Shows the percentage of runs within the reliability window that a Kuberhealthy check was OK", "check", "namespace")
+		redactions = metrics.NewCounterVec("kuberhealthy_redactions_total", "Counts matches of redaction patterns masked in check results before they were stored", "check", "namespace")
+		stateWatchEventsDropped = metrics.NewCounterVec("kuberhealthy_state_watch_events_dropped_total", "Counts state change events dropped because a watcher was not keeping up")
+		stateWatchers = metrics.NewGaugeVec("kuberhealthy_state_watchers", "The number of clients watching for check state changes")
+		checkerClockSkew = metrics.NewGaugeVec("kuberhealthy_checker_clock_skew_seconds", "Shows how far ahead, or behind when negative, the clock of a checker pod was when it last reported", "check", "namespace")
+		clockSkewEvents = metrics.NewCounterVec("kuberhealthy_checker_clock_skew_events_total", "Counts reports from checker pods whose clock was further off than the maximum clock skew", "check", "namespace")
+		suppressedWrites = metrics.NewCounterVec("kuberhealthy_suppressed_writes_total", "Counts check results that were not recorded because of the write filter of the check", "check", "namespace")
+		schemaRejections = metrics.NewCounterVec("kuberhealthy_state_schema_rejections_total", "Counts check results rejected by the API server because they did not match the khstate schema", "check", "namespace")
+		notificationsDropped = metrics.NewCounterVec("kuberhealthy_notifications_dropped_total", "Counts check state change notifications dropped because the notification queue was full")
+		checkRunsQueuedByPriority = metrics.NewGaugeVec("kuberhealthy_check_runs_queued_by_priority", "Number of check runs waiting for a free slot under the concurrent check limit by check priority", "priority")
+		checkRunsDeferred = metrics.NewCounterVec("kuberhealthy_check_runs_deferred_total", "Counts queued check runs that were passed over for a run of a higher priority check", "priority")
+		stateWriteBackoffSeconds = metrics.NewGaugeVec("kuberhealthy_state_write_backoff_seconds", "Shows the current wait between retries of conflicting khstate writes when adaptive write backoff is enabled", "check", "namespace")
+	})
+}
@@ -0,0 +1,46 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/metrics"
+)
+
+// TestRegisterMetricsTwice ensures that registering the metrics again, including concurrently, does not panic or
+// output any metric twice
+func TestRegisterMetricsTwice(t *testing.T) {
+	registered := writeConfirmations
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			registerMetrics()
+		}()
+	}
+	wg.Wait()
+
+	if writeConfirmations != registered {
+		t.Fatal("expected registering the metrics again to keep the registered collectors")
+	}
+	output := metrics.GenerateRegisteredMetrics()
+	for _, name := range []string{"kuberhealthy_state_write_confirmations_total", "kuberhealthy_check_runs_active", "kuberhealthy_state_watchers"} {
+		if strings.Count(output, "# TYPE "+name+" ") != 1 {
+			t.Fatalf("expected %s to be output once, got:\n%s", name, output)
+		}
+	}
+}
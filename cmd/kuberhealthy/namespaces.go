@@ -0,0 +1,46 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"path"
+)
+
+// ErrNamespaceExcluded is returned when khstate is written for a check in a namespace that Kuberhealthy is not
+// configured to manage state in
+var ErrNamespaceExcluded = errors.New("namespace is excluded from kuberhealthy state management")
+
+// namespaceMatches returns true if the namespace matches any of the patterns.  Patterns are namespace names with
+// optional * wildcards, so team-* matches every namespace starting with team-.
+func namespaceMatches(namespace string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if pattern == namespace {
+			return true
+		}
+		matched, err := path.Match(pattern, namespace)
+		if err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// stateNamespaceManaged returns true if Kuberhealthy manages state in the namespace.  A namespace is managed when
+// it matches the allow-list, or the allow-list is empty, and it does not match the deny-list.  The deny-list wins
+// when a namespace matches both.
+func stateNamespaceManaged(namespace string) bool {
+	if namespaceMatches(namespace, cfg.ExcludedStateNamespaces) {
+		return false
+	}
+	return len(cfg.StateNamespaces) == 0 || namespaceMatches(namespace, cfg.StateNamespaces)
+}
@@ -0,0 +1,135 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// defaultNotificationTemplate is used for notification bodies when no template is configured or the configured
+// template fails to render
+const defaultNotificationTemplate = `Kuberhealthy check {{.Namespace}}/{{.Name}} is {{if .OK}}OK{{else}}failing: {{join .Errors ", "}}{{end}}` +
+	`{{with .Trend}}{{if .IsChanging}}. It is {{.Trend}}: {{.OlderFailed}}/{{.OlderRuns}} failures before, {{.RecentFailed}}/{{.RecentRuns}} now{{end}}{{end}}`
+
+// notificationTimeout is how long a notification webhook has to respond
+const notificationTimeout = time.Second * 10
+
+// notificationTemplateFuncs are the extra functions available to notification templates
+var notificationTemplateFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+// stateChangeNotifier sends notifications when the result of a check changes.  nil when notifications are disabled.
+var stateChangeNotifier *webhookNotifier
+
+// notificationData is what notification templates are rendered with.  Every WorkloadDetails field, such as
+// .Errors, .LastRun and .Annotations, is available along with the check's .Name.
+type notificationData struct {
+	Name string
+	health.WorkloadDetails
+}
+
+// webhookNotifier posts a rendered notification body to a webhook URL
+type webhookNotifier struct {
+	url             string
+	template        *template.Template
+	defaultTemplate *template.Template
+	client          *http.Client
+}
+
+// newWebhookNotifier creates a notifier for the supplied webhook URL.  A blank templateText uses the default
+// template.  A template that can not be parsed is logged and replaced with the default template.
+func newWebhookNotifier(url string, templateText string) *webhookNotifier {
+	n := &webhookNotifier{
+		url:             url,
+		defaultTemplate: template.Must(template.New("default").Funcs(notificationTemplateFuncs).Parse(defaultNotificationTemplate)),
+		client:          &http.Client{Timeout: notificationTimeout},
+	}
+	n.template = n.defaultTemplate
+	if len(templateText) > 0 {
+		t, err := template.New("notification").Funcs(notificationTemplateFuncs).Parse(templateText)
+		if err != nil {
+			log.Errorln("notifier: error parsing notification template. Using the default template instead:", err)
+			return n
+		}
+		n.template = t
+	}
+	return n
+}
+
+// render renders the notification body for a check.  If the configured template fails, the default is used.
+func (n *webhookNotifier) render(checkName string, checkNamespace string, state health.WorkloadDetails) []byte {
+	data := notificationData{Name: checkName, WorkloadDetails: state}
+	data.Namespace = checkNamespace
+
+	var body bytes.Buffer
+	err := n.template.Execute(&body, data)
+	if err == nil {
+		return body.Bytes()
+	}
+	log.Errorln("notifier: error rendering notification template for check", checkName, "in namespace", checkNamespace+". Using the default template instead:", err)
+
+	body.Reset()
+	err = n.defaultTemplate.Execute(&body, data)
+	if err != nil {
+		log.Errorln("notifier: error rendering default notification template:", err)
+	}
+	return body.Bytes()
+}
+
+// send posts the notification body for a check to the webhook
+func (n *webhookNotifier) send(checkName string, checkNamespace string, state health.WorkloadDetails) error {
+	body := n.render(checkName, checkNamespace, state)
+
+	// templates for tools like Slack render JSON, everything else is sent as plain text
+	contentType := "text/plain"
+	if json.Valid(body) {
+		contentType = "application/json"
+	}
+
+	resp, err := n.client.Post(n.url, contentType, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error sending notification: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("notification webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// shouldNotify determines if a written state is a change worth notifying about.  The first passing result of a
+// check that has never reported is not, and neither is disabling a check.
+func shouldNotify(previous health.WorkloadDetails, state health.WorkloadDetails) bool {
+	if state.Disabled || !state.ResultChangedFrom(previous) {
+		return false
+	}
+	return !(previous.GetStatus() == health.StatusUnknown && state.OK)
+}
+
+// notifyStateChange queues a state change notification for a check when notifications are enabled
+func notifyStateChange(checkName string, checkNamespace string, state health.WorkloadDetails) {
+	if stateChangeQueue == nil {
+		return
+	}
+	stateChangeQueue.push(checkName, checkNamespace, state, time.Now())
+}
@@ -0,0 +1,95 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// TestNotificationTemplate ensures that notification bodies are rendered from the configured template and fall back
+// to the default template when it is broken
+func TestNotificationTemplate(t *testing.T) {
+	state := health.WorkloadDetails{
+		OK:          false,
+		Errors:      []string{"lookup timed out", "no answer"},
+		Annotations: map[string]string{"runbook": "https://example.com/runbook"},
+	}
+	defaultBody := "Kuberhealthy check kuberhealthy/dns is failing: lookup timed out, no answer"
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{name: "default", expected: defaultBody},
+		{
+			name:     "custom",
+			template: `{"text": "{{.Namespace}}/{{.Name}} OK: {{.OK}} {{join .Errors ", "}} {{index .Annotations "runbook"}}"}`,
+			expected: `{"text": "kuberhealthy/dns OK: false lookup timed out, no answer https://example.com/runbook"}`,
+		},
+		{name: "parse error", template: "{{.Name", expected: defaultBody},
+		{name: "render error", template: "{{.Missing}}", expected: defaultBody},
+	}
+
+	for _, tc := range tests {
+		n := newWebhookNotifier("http://localhost", tc.template)
+		body := string(n.render("dns", "kuberhealthy", state))
+		if body != tc.expected {
+			t.Fatalf("%s: expected notification body %q but got %q", tc.name, tc.expected, body)
+		}
+	}
+}
+
+// TestWebhookNotifierSend ensures that rendered notifications are posted with a content type matching the body
+func TestWebhookNotifierSend(t *testing.T) {
+	var contentType, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+	}))
+	defer server.Close()
+
+	n := newWebhookNotifier(server.URL, `{"text": "{{.Name}}"}`)
+	err := n.send("dns", "kuberhealthy", health.WorkloadDetails{OK: true})
+	if err != nil {
+		t.Fatal("unexpected error sending notification:", err)
+	}
+	if contentType != "application/json" || body != `{"text": "dns"}` {
+		t.Fatalf("expected a JSON notification but got %q with content type %q", body, contentType)
+	}
+}
+
+// TestShouldNotify ensures that only result changes are announced, except for the first passing result
+func TestShouldNotify(t *testing.T) {
+	unknown := health.WorkloadDetails{Status: health.StatusUnknown}
+	passing := health.WorkloadDetails{OK: true, Status: health.StatusOK}
+	failing := health.WorkloadDetails{OK: false, Status: health.StatusNotOK, Errors: []string{"broken"}}
+
+	if shouldNotify(unknown, passing) {
+		t.Fatal("first passing result was announced")
+	}
+	if !shouldNotify(unknown, failing) {
+		t.Fatal("first failing result was not announced")
+	}
+	if !shouldNotify(passing, failing) || !shouldNotify(failing, passing) {
+		t.Fatal("result change was not announced")
+	}
+	if shouldNotify(passing, passing) {
+		t.Fatal("unchanged result was announced")
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// defaultNotificationQueueSize is the most notifications waiting to be sent when no queue size is configured
+const defaultNotificationQueueSize = 100
+
+// defaultNotificationCoalescing is how long notifications wait to be sent when no coalescing window is configured
+const defaultNotificationCoalescing = time.Second * 10
+
+// notificationQueueSize returns the configured notification queue size
+func notificationQueueSize() int {
+	if cfg.NotificationQueueSize <= 0 {
+		return defaultNotificationQueueSize
+	}
+	return cfg.NotificationQueueSize
+}
+
+// notificationCoalescing returns the configured notification coalescing window
+func notificationCoalescing() time.Duration {
+	if cfg.NotificationCoalescing <= 0 {
+		return defaultNotificationCoalescing
+	}
+	return cfg.NotificationCoalescing
+}
+
+// stateChangeQueue holds the state change notifications waiting to be sent.  nil when notifications are disabled.
+var stateChangeQueue *notificationQueue
+
+// queuedNotification is a state change notification waiting to be sent
+type queuedNotification struct {
+	checkName      string
+	checkNamespace string
+	state          health.WorkloadDetails
+	sendAfter      time.Time // the end of the coalescing window
+}
+
+// notificationQueue is a bounded queue of state change notifications that are sent one at a time, so that a slow
+// notification webhook can not pile up goroutines while many checks change at once.  A notification waits for the
+// coalescing window before it is sent, and any notification for the same check in the meantime replaces it.  When
+// the queue is full, the oldest notification is dropped.
+type notificationQueue struct {
+	lock    sync.Mutex
+	size    int
+	window  time.Duration
+	pending []*queuedNotification
+	byCheck map[string]*queuedNotification // the pending notification of each check by namespace/name
+	wake    chan struct{}                  // signaled when a notification is queued
+	send    func(checkName string, checkNamespace string, state health.WorkloadDetails) error
+}
+
+// newNotificationQueue creates a notification queue that sends notifications with the supplied func
+func newNotificationQueue(size int, window time.Duration, send func(checkName string, checkNamespace string, state health.WorkloadDetails) error) *notificationQueue {
+	return &notificationQueue{
+		size:    size,
+		window:  window,
+		byCheck: make(map[string]*queuedNotification),
+		wake:    make(chan struct{}, 1),
+		send:    send,
+	}
+}
+
+// push queues a notification without waiting on it to be sent
+func (q *notificationQueue) push(checkName string, checkNamespace string, state health.WorkloadDetails, now time.Time) {
+	key := checkNamespace + "/" + checkName
+
+	q.lock.Lock()
+	if queued, exists := q.byCheck[key]; exists {
+		queued.state = state
+		q.lock.Unlock()
+		log.Debugln(checkNamespace, checkName, "coalesced state change notification with one already queued")
+		notifications.Inc("coalesced")
+		return
+	}
+	if len(q.pending) >= q.size {
+		oldest := q.pending[0]
+		q.pending = q.pending[1:]
+		delete(q.byCheck, oldest.checkNamespace+"/"+oldest.checkName)
+		log.Warningln("notifier: queue is full. Dropping the oldest notification, for check", oldest.checkName, "in namespace", oldest.checkNamespace)
+		notificationsDropped.Inc()
+	}
+	queued := &queuedNotification{checkName: checkName, checkNamespace: checkNamespace, state: state, sendAfter: now.Add(q.window)}
+	q.pending = append(q.pending, queued)
+	q.byCheck[key] = queued
+	q.lock.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next removes and returns the oldest notification once its coalescing window is over.  When no notification is
+// ready, it returns how long to wait before trying again, or zero if the queue is empty.
+func (q *notificationQueue) next(now time.Time) (*queuedNotification, time.Duration) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if len(q.pending) == 0 {
+		return nil, 0
+	}
+	oldest := q.pending[0]
+	if wait := oldest.sendAfter.Sub(now); wait > 0 {
+		return nil, wait
+	}
+	q.pending = q.pending[1:]
+	delete(q.byCheck, oldest.checkNamespace+"/"+oldest.checkName)
+	return oldest, 0
+}
+
+// run sends queued notifications one at a time for the life of the process
+func (q *notificationQueue) run() {
+	for {
+		queued, wait := q.next(time.Now())
+		switch {
+		case queued != nil:
+			q.deliver(queued)
+		case wait > 0:
+			select {
+			case <-time.After(wait):
+			case <-q.wake:
+			}
+		default:
+			<-q.wake
+		}
+	}
+}
+
+// deliver sends a single notification and records the result
+func (q *notificationQueue) deliver(queued *queuedNotification) {
+	err := q.send(queued.checkName, queued.checkNamespace, queued.state)
+	if err != nil {
+		log.Warningln(queued.checkNamespace, queued.checkName, "failed to send state change notification:", err)
+		notifications.Inc("failure")
+		return
+	}
+	log.Debugln(queued.checkNamespace, queued.checkName, "sent state change notification")
+	notifications.Inc("success")
+}
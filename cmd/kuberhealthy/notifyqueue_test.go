@@ -0,0 +1,49 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// TestNotificationQueue ensures that repeated notifications for a check are coalesced, that the oldest
+// notification is dropped when the queue is full and that notifications wait out the coalescing window
+func TestNotificationQueue(t *testing.T) {
+	q := newNotificationQueue(2, time.Second*10, func(string, string, health.WorkloadDetails) error { return nil })
+	now := time.Now()
+
+	q.push("dns", "kuberhealthy", health.WorkloadDetails{OK: false}, now)
+	q.push("dns", "kuberhealthy", health.WorkloadDetails{OK: true}, now.Add(time.Second))
+	q.push("deployment", "kuberhealthy", health.WorkloadDetails{OK: false}, now.Add(time.Second*2))
+	if len(q.pending) != 2 {
+		t.Fatal("expected repeated notifications for a check to be coalesced, got", len(q.pending), "queued")
+	}
+
+	queued, wait := q.next(now.Add(time.Second * 5))
+	if queued != nil || wait != time.Second*5 {
+		t.Fatal("expected the oldest notification to wait out its coalescing window, got a wait of", wait)
+	}
+	queued, _ = q.next(now.Add(time.Second * 10))
+	if queued == nil || queued.checkName != "dns" || !queued.state.OK {
+		t.Fatalf("expected the latest notification for the dns check to be sent first, got %+v", queued)
+	}
+
+	// a full queue makes room by dropping its oldest notification
+	q.push("daemonset", "kuberhealthy", health.WorkloadDetails{OK: false}, now.Add(time.Second*11))
+	q.push("pod-restarts", "kuberhealthy", health.WorkloadDetails{OK: false}, now.Add(time.Second*12))
+	if len(q.pending) != 2 || q.pending[0].checkName != "daemonset" || q.byCheck["kuberhealthy/deployment"] != nil {
+		t.Fatalf("expected the oldest notification to be dropped from the full queue, got %+v", q.pending)
+	}
+}
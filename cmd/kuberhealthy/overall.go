@@ -0,0 +1,91 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// overallStateName is the name of the synthetic khstate that holds the combined result of every check
+const overallStateName = "kuberhealthy-overall"
+
+// overallStateInterval is how often the overall khstate is compared against the current check results
+const overallStateInterval = time.Second * 10
+
+// isOverallStateKey determines if a namespace/name khstate key is the synthetic overall khstate
+func isOverallStateKey(key string) bool {
+	return cfg.EnableOverallState && key == stateKey(overallStateName, podNamespace)
+}
+
+// overallStateDetails combines the current results of all checks into the details of the overall khstate.  It
+// is OK only when the overall status is OK and no check result has expired.  Severities, unknown results and
+// initial grace periods are applied the same way as on the status page.
+func overallStateDetails(state health.State, ttl time.Duration, now time.Time) health.WorkloadDetails {
+	details := health.NewWorkloadDetails(health.KHCheck)
+	details.Namespace = podNamespace
+	details.Synthetic = true
+	details.OK = state.OK
+	details.Errors = append(details.Errors, state.Errors...)
+
+	// a check that stopped reporting can not be trusted to still be passing
+	for _, checkDetails := range []map[string]health.WorkloadDetails{state.CheckDetails, state.ClusterCheckDetails} {
+		for _, key := range sortedCheckKeys(checkDetails) {
+			d := checkDetails[key]
+			if d.IsExpired(ttl, now) {
+				details.OK = false
+				details.Errors = append(details.Errors, "Result of check "+key+" has expired")
+			}
+		}
+	}
+	details.Status = health.StatusFromOK(details.OK)
+	return details
+}
+
+// maintainOverallState keeps the synthetic overall khstate in sync with the results of all checks until the
+// context is canceled.  Only the master writes it, and only when the combined result changes.
+func (k *Kuberhealthy) maintainOverallState(ctx context.Context) {
+	ticker := time.NewTicker(overallStateInterval)
+	defer ticker.Stop()
+
+	var written *health.WorkloadDetails
+	for {
+		select {
+		case <-ctx.Done():
+			log.Infoln("overall state: shutting down")
+			return
+		case <-ticker.C:
+		}
+
+		if !isMaster {
+			written = nil
+			continue
+		}
+
+		details := overallStateDetails(k.stateReflector.CurrentStatus(), cfg.ResultTTL, time.Now())
+		if written != nil && !details.ResultChangedFrom(*written) {
+			continue
+		}
+
+		log.Infoln("overall state: setting", overallStateName, "to OK:", details.OK, "with", len(details.Errors), "errors")
+		err := k.storeCheckState(overallStateName, podNamespace, details)
+		if err != nil {
+			log.Errorln("overall state: error writing", overallStateName+":", err)
+			continue
+		}
+		written = &details
+	}
+}
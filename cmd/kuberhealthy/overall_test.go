@@ -0,0 +1,66 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// TestOverallStateDetails ensures that the overall khstate is OK only when every check is OK and current
+func TestOverallStateDetails(t *testing.T) {
+	now := time.Now()
+	passing := health.WorkloadDetails{OK: true, LastRun: now.Add(-time.Minute)}
+	stale := health.WorkloadDetails{OK: true, LastRun: now.Add(-time.Hour)}
+
+	state := health.NewState()
+	state.CheckDetails["kuberhealthy/dns"] = passing
+	details := overallStateDetails(state, time.Minute*30, now)
+	if !details.OK || details.Status != health.StatusOK || !details.Synthetic {
+		t.Fatalf("expected a synthetic OK overall state when every check passes, got %+v", details)
+	}
+
+	state.OK = false
+	state.Errors = []string{"deployment failed"}
+	details = overallStateDetails(state, time.Minute*30, now)
+	if details.OK || len(details.Errors) != 1 || details.Errors[0] != "deployment failed" {
+		t.Fatalf("expected the overall state to fail with the errors of the overall status, got %+v", details)
+	}
+
+	state = health.NewState()
+	state.ClusterCheckDetails["kuberhealthy-cluster/dns"] = stale
+	details = overallStateDetails(state, time.Minute*30, now)
+	if details.OK || len(details.Errors) != 1 {
+		t.Fatalf("expected an expired result to fail the overall state, got %+v", details)
+	}
+	details = overallStateDetails(state, 0, now)
+	if !details.OK {
+		t.Fatalf("expected results to never expire without a result TTL, got %+v", details)
+	}
+}
+
+// TestReapSkipsSyntheticStates ensures that synthetic khstates are never reaped as orphans
+func TestReapSkipsSyntheticStates(t *testing.T) {
+	existing := map[string]health.WorkloadDetails{
+		"kuberhealthy/" + overallStateName: {Synthetic: true},
+		"kuberhealthy/orphan":              {},
+	}
+	deleted, kept, err := reapOrphanedStateResources(existing, map[string]bool{}, true)
+	if err != nil {
+		t.Fatal("unexpected error reaping khstates:", err)
+	}
+	if deleted != 1 || kept != 1 {
+		t.Fatalf("expected only the orphan to be reaped, got %d deleted and %d kept", deleted, kept)
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// ErrForeignOwnership is returned by state writes that would overwrite a khstate owned by a Kuberhealthy instance
+// in another namespace
+var ErrForeignOwnership = errors.New("khstate is owned by a kuberhealthy instance in another namespace")
+
+// isForeignOwned determines if a khstate was last written by a Kuberhealthy instance in another namespace and may
+// not be taken over.  khstates written before owner namespaces were recorded have no owner and are never foreign.
+func isForeignOwned(details health.WorkloadDetails) bool {
+	if cfg.AllowForeignTakeover {
+		return false
+	}
+	return len(details.AuthoritativeNamespace) > 0 && details.AuthoritativeNamespace != podNamespace
+}
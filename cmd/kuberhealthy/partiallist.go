@@ -0,0 +1,80 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// The ways a paged khstate list that fails after some pages were fetched is handled
+const (
+	partialListModeFail    = "fail"    // nothing is returned.  This is the default
+	partialListModePartial = "partial" // the khstates listed before the failure are returned with ErrPartialList
+)
+
+// ErrPartialList is returned alongside the khstates that were listed before a later page of a khstate list failed
+var ErrPartialList = errors.New("khstate list incomplete")
+
+// partialListError is the error returned with a partial khstate list.  It is ErrPartialList and wraps the error
+// of the page that failed.
+type partialListError struct {
+	listed    int    // how many khstates were listed before the failure
+	remaining *int64 // how many more khstates the API server estimated there were.  nil when it did not say
+	err       error
+}
+
+// Error implements error
+func (e *partialListError) Error() string {
+	return e.summary() + ": " + e.err.Error()
+}
+
+// Is makes partial list errors match ErrPartialList
+func (e *partialListError) Is(target error) bool {
+	return target == ErrPartialList
+}
+
+// Unwrap returns the error of the page that failed
+func (e *partialListError) Unwrap() error {
+	return e.err
+}
+
+// summary describes how much of the list was fetched, such as showing 400 of ~500 checks, list incomplete
+func (e *partialListError) summary() string {
+	if e.remaining == nil {
+		return fmt.Sprintf("showing %d checks, list incomplete", e.listed)
+	}
+	return fmt.Sprintf("showing %d of ~%d checks, list incomplete", e.listed, e.listed+int(*e.remaining))
+}
+
+// returnsPartialLists returns true if khstate lists that fail partway return the khstates listed so far
+func returnsPartialLists() bool {
+	return cfg.PartialListMode == partialListModePartial
+}
+
+// newPartialListError returns the error for a khstate list that failed after some khstates were listed
+func newPartialListError(listed int, remaining *int64, err error) error {
+	partialLists.Inc()
+	return &partialListError{listed: listed, remaining: remaining, err: err}
+}
+
+// warnListIncomplete adds a Warning header describing a partial khstate list to a response, so that clients can
+// tell a partial result from a complete one without the shape of the response changing
+func warnListIncomplete(w http.ResponseWriter, err error) {
+	var partial *partialListError
+	if !errors.As(err, &partial) {
+		return
+	}
+	w.Header().Add("Warning", "299 kuberhealthy "+strconv.Quote(partial.summary()))
+}
@@ -0,0 +1,76 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// TestPartialList ensures that a khstate list whose second page fails returns nothing by default and the first page
+// with ErrPartialList in partial mode, and that the failing endpoint shows the partial list with a warning
+func TestPartialList(t *testing.T) {
+	original := cfg.PartialListMode
+	defer func() { cfg.PartialListMode = original }()
+
+	useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		if len(r.URL.Query().Get("continue")) > 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		remaining := int64(1)
+		list := khstatecrd.KuberhealthyStateList{}
+		list.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+		list.Kind = "KuberhealthyStateList"
+		list.Continue = "page-2"
+		list.RemainingItemCount = &remaining
+		for _, name := range []string{"partial-passing", "partial-failing"} {
+			s := khstatecrd.NewKuberhealthyState(name, health.WorkloadDetails{OK: name == "partial-passing", Errors: []string{}})
+			s.Namespace = "kuberhealthy"
+			list.Items = append(list.Items, s)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	})
+
+	cfg.PartialListMode = ""
+	states, err := getAllCheckStates("", projectionMetadata)
+	if err == nil || errors.Is(err, ErrPartialList) || len(states) != 0 {
+		t.Fatalf("expected the list to fail without results by default, got %d khstates and %v", len(states), err)
+	}
+
+	cfg.PartialListMode = partialListModePartial
+	states, err = getAllCheckStates("", projectionMetadata)
+	if !errors.Is(err, ErrPartialList) || len(states) != 2 {
+		t.Fatalf("expected the first page with a partial list error, got %d khstates and %v", len(states), err)
+	}
+
+	recorder := httptest.NewRecorder()
+	err = failingChecksHandler(recorder, httptest.NewRequest(http.MethodGet, "/failing", nil))
+	if err != nil || recorder.Code != http.StatusOK {
+		t.Fatalf("expected the failing checks of the partial list, got %d and %v", recorder.Code, err)
+	}
+	if warning := recorder.Header().Get("Warning"); warning != `299 kuberhealthy "showing 2 of ~3 checks, list incomplete"` {
+		t.Fatal("expected a warning that the list is incomplete, got", warning)
+	}
+	var failing []failingCheck
+	json.NewDecoder(recorder.Body).Decode(&failing)
+	if len(failing) != 1 || failing[0].Name != "partial-failing" {
+		t.Fatalf("expected the failing check from the first page, got %+v", failing)
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// The strategies that can be used to write khstate results
+const (
+	stateUpdateStrategyUpdate         = "update"                // replace the whole resource
+	stateUpdateStrategyMergePatch     = "merge-patch"           // send only the changed fields as a JSON merge patch
+	stateUpdateStrategyJSONPatch      = "json-patch"            // send only the changed fields as JSON patch operations
+	stateUpdateStrategyStrategicPatch = "strategic-merge-patch" // send only the changed fields as a strategic merge patch
+)
+
+// statePatchTypes are the patch types tried for each update strategy, in order.  When the API server does not
+// support any of them, the whole resource is updated instead.  Custom resources do not support strategic merge
+// patches, so that strategy falls back to a JSON merge patch first.
+var statePatchTypes = map[string][]types.PatchType{
+	stateUpdateStrategyMergePatch:     {types.MergePatchType},
+	stateUpdateStrategyJSONPatch:      {types.JSONPatchType},
+	stateUpdateStrategyStrategicPatch: {types.StrategicMergePatchType, types.MergePatchType},
+}
+
+// unsupportedPatchTypes holds the patch types that the API server has rejected for khstates so that they are not
+// tried again on every write
+var unsupportedPatchTypes = struct {
+	sync.RWMutex
+	types map[types.PatchType]bool
+}{types: make(map[types.PatchType]bool)}
+
+// validateStateUpdateStrategy ensures that a configured update strategy is known.  Blank uses update.
+func validateStateUpdateStrategy(strategy string) error {
+	if strategy == "" || strategy == stateUpdateStrategyUpdate {
+		return nil
+	}
+	if _, ok := statePatchTypes[strategy]; !ok {
+		return fmt.Errorf("unknown khstate update strategy %s.  Must be one of %s, %s, %s or %s", strategy, stateUpdateStrategyUpdate, stateUpdateStrategyMergePatch, stateUpdateStrategyJSONPatch, stateUpdateStrategyStrategicPatch)
+	}
+	return nil
+}
+
+// patchTypeIsUnsupported indicates if the API server has already rejected a patch type for khstates
+func patchTypeIsUnsupported(patchType types.PatchType) bool {
+	unsupportedPatchTypes.RLock()
+	defer unsupportedPatchTypes.RUnlock()
+	return unsupportedPatchTypes.types[patchType]
+}
+
+// setPatchTypeUnsupported remembers that the API server does not support a patch type for khstates
+func setPatchTypeUnsupported(patchType types.PatchType) {
+	unsupportedPatchTypes.Lock()
+	defer unsupportedPatchTypes.Unlock()
+	unsupportedPatchTypes.types[patchType] = true
+}
+
+// writeStateResource writes a modified khstate with the configured update strategy.  original is the JSON of the
+// khstate before it was modified, which is used to send only the fields that changed.  The resource version of the
+// original is part of every patch, so patches conflict just like updates do when the khstate changed underneath them.
+func writeStateResource(ctx context.Context, name string, namespace string, original []byte, modified *khstatecrd.KuberhealthyState) (*khstatecrd.KuberhealthyState, error) {
+	// patches only carry the spec and status, so label changes are sent with a full update
+	if stateLabelsChanged(original, modified.GetLabels()) {
+		return khStateClient.UpdateContext(ctx, modified, stateCRDResource, name, namespace)
+	}
+	for _, patchType := range statePatchTypes[cfg.StateUpdateStrategy] {
+		if patchTypeIsUnsupported(patchType) {
+			continue
+		}
+		patch, err := buildStatePatch(patchType, original, modified)
+		if err != nil {
+			log.Warningln(namespace, name, "failed to build khstate patch. Updating the whole khstate instead:", err)
+			break
+		}
+		log.Debugln(namespace, name, "patching khstate with", patchType+":", string(patch))
+		updatedState, err := khStateClient.PatchContext(ctx, patchType, patch, stateCRDResource, name, namespace)
+		if k8sErrors.IsUnsupportedMediaType(err) {
+			log.Warningln("The API server does not support", patchType, "patches of khstates. Falling back to the next strategy.")
+			setPatchTypeUnsupported(patchType)
+			continue
+		}
+		return updatedState, err
+	}
+
+	return khStateClient.UpdateContext(ctx, modified, stateCRDResource, name, namespace)
+}
+
+// statePatchSections are the parts of a khstate that result writes change
+var statePatchSections = []string{"spec", "status"}
+
+// buildStatePatch builds a patch of the supplied type that changes the original khstate JSON into the modified
+// khstate.  Only the fields of the spec and status that changed are included.
+func buildStatePatch(patchType types.PatchType, original []byte, modified *khstatecrd.KuberhealthyState) ([]byte, error) {
+	modifiedJSON, err := json.Marshal(modified)
+	if err != nil {
+		return nil, err
+	}
+	before, err := decodeStatePatchSections(original)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding original khstate: %w", err)
+	}
+	after, err := decodeStatePatchSections(modifiedJSON)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding modified khstate: %w", err)
+	}
+	resourceVersion := modified.GetResourceVersion()
+
+	switch patchType {
+	case types.MergePatchType, types.StrategicMergePatchType:
+		// removed fields are set to null and everything that is not mentioned is left alone
+		patch := map[string]interface{}{
+			"metadata": map[string]string{"resourceVersion": resourceVersion},
+		}
+		for _, section := range statePatchSections {
+			changes := make(map[string]json.RawMessage)
+			for field, value := range after[section] {
+				if !bytes.Equal(before[section][field], value) {
+					changes[field] = value
+				}
+			}
+			for field := range before[section] {
+				if _, ok := after[section][field]; !ok {
+					changes[field] = json.RawMessage("null")
+				}
+			}
+			if len(changes) > 0 {
+				patch[section] = changes
+			}
+		}
+		return json.Marshal(patch)
+
+	case types.JSONPatchType:
+		type operation struct {
+			Op    string          `json:"op"`
+			Path  string          `json:"path"`
+			Value json.RawMessage `json:"value,omitempty"`
+		}
+		rv, err := json.Marshal(resourceVersion)
+		if err != nil {
+			return nil, err
+		}
+		patch := []operation{{Op: "replace", Path: "/metadata/resourceVersion", Value: rv}}
+		for _, section := range statePatchSections {
+			// a section that did not exist before is added whole, because operations can not add to a missing parent
+			if before[section] == nil {
+				if after[section] != nil {
+					value, err := json.Marshal(after[section])
+					if err != nil {
+						return nil, err
+					}
+					patch = append(patch, operation{Op: "add", Path: "/" + section, Value: value})
+				}
+				continue
+			}
+			for _, field := range sortedFields(after[section]) {
+				if !bytes.Equal(before[section][field], after[section][field]) {
+					patch = append(patch, operation{Op: "add", Path: "/" + section + "/" + escapeJSONPointer(field), Value: after[section][field]})
+				}
+			}
+			for _, field := range sortedFields(before[section]) {
+				if _, ok := after[section][field]; !ok {
+					patch = append(patch, operation{Op: "remove", Path: "/" + section + "/" + escapeJSONPointer(field)})
+				}
+			}
+		}
+		return json.Marshal(patch)
+	}
+	return nil, fmt.Errorf("unsupported patch type %s", patchType)
+}
+
+// decodeStatePatchSections decodes the fields of each patched section of a khstate.  Sections that are missing
+// are left out of the returned map.
+func decodeStatePatchSections(state []byte) (map[string]map[string]json.RawMessage, error) {
+	var object map[string]json.RawMessage
+	err := json.Unmarshal(state, &object)
+	if err != nil {
+		return nil, err
+	}
+	sections := make(map[string]map[string]json.RawMessage)
+	for _, section := range statePatchSections {
+		raw, ok := object[section]
+		if !ok || string(raw) == "null" {
+			continue
+		}
+		var fields map[string]json.RawMessage
+		err = json.Unmarshal(raw, &fields)
+		if err != nil {
+			return nil, err
+		}
+		sections[section] = fields
+	}
+	return sections, nil
+}
+
+// sortedFields returns the field names of a JSON object in sorted order so that patches are deterministic
+func sortedFields(fields map[string]json.RawMessage) []string {
+	var names []string
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// escapeJSONPointer escapes a field name for use in a JSON patch path
+func escapeJSONPointer(field string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(field, "~", "~0"), "/", "~1")
+}
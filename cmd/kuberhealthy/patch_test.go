@@ -0,0 +1,141 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// TestStateUpdateStrategies ensures that each update strategy writes khstates with the expected request and that
+// patch types the API server does not support fall back to the next strategy
+func TestStateUpdateStrategies(t *testing.T) {
+	originalStrategy := cfg.StateUpdateStrategy
+	defer func() {
+		cfg.StateUpdateStrategy = originalStrategy
+	}()
+
+	existing := khstatecrd.NewKuberhealthyState("check", health.WorkloadDetails{OK: true, Namespace: "kuberhealthy"})
+	existing.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	existing.Kind = "KuberhealthyState"
+	existing.Namespace = "kuberhealthy"
+	existing.ResourceVersion = "7"
+
+	tests := []struct {
+		strategy string
+		requests []string // the method and content type of every write
+	}{
+		{strategy: "", requests: []string{"PUT application/json"}},
+		{strategy: stateUpdateStrategyUpdate, requests: []string{"PUT application/json"}},
+		{strategy: stateUpdateStrategyMergePatch, requests: []string{"PATCH " + string(types.MergePatchType)}},
+		{strategy: stateUpdateStrategyJSONPatch, requests: []string{"PATCH " + string(types.JSONPatchType)}},
+		{strategy: stateUpdateStrategyStrategicPatch, requests: []string{"PATCH " + string(types.StrategicMergePatchType), "PATCH " + string(types.MergePatchType)}},
+	}
+
+	for _, test := range tests {
+		cfg.StateUpdateStrategy = test.strategy
+		unsupportedPatchTypes.types = make(map[types.PatchType]bool)
+
+		var requests []string
+		var patches [][]byte
+		useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			if r.Method == http.MethodGet {
+				json.NewEncoder(w).Encode(existing)
+				return
+			}
+			requests = append(requests, r.Method+" "+r.Header.Get("Content-Type"))
+			b, _ := ioutil.ReadAll(r.Body)
+			if r.Method == http.MethodPatch {
+				// custom resources do not support strategic merge patches
+				if r.Header.Get("Content-Type") == string(types.StrategicMergePatchType) {
+					w.WriteHeader(http.StatusUnsupportedMediaType)
+					return
+				}
+				patches = append(patches, b)
+				json.NewEncoder(w).Encode(existing)
+				return
+			}
+			w.Write(b)
+		})
+
+		write := func() {
+			err := updateCheckStateResource("check", "kuberhealthy", func(details *health.WorkloadDetails) bool {
+				details.OK = false
+				details.Errors = []string{"broken"}
+				return true
+			})
+			if err != nil {
+				t.Fatalf("%q: unexpected error writing khstate: %v", test.strategy, err)
+			}
+		}
+		write()
+		if !reflect.DeepEqual(requests, test.requests) {
+			t.Fatalf("%q: expected requests %v, got %v", test.strategy, test.requests, requests)
+		}
+		for _, patch := range patches {
+			if !strings.Contains(string(patch), `"7"`) || !strings.Contains(string(patch), "broken") {
+				t.Fatalf("%q: expected the patch to carry the resource version and the changed errors, got %s", test.strategy, patch)
+			}
+			if strings.Contains(string(patch), "kuberhealthy") {
+				t.Fatalf("%q: expected the patch to leave unchanged fields out, got %s", test.strategy, patch)
+			}
+		}
+
+		// a rejected patch type is not tried again
+		requests = nil
+		stateVersions.reset()
+		write()
+		if strings.Contains(strings.Join(requests, ","), string(types.StrategicMergePatchType)) {
+			t.Fatalf("%q: expected an unsupported patch type to be skipped, got %v", test.strategy, requests)
+		}
+	}
+}
+
+// TestBuildStatePatchRemovedFields ensures that fields removed from a khstate are removed by the patch
+func TestBuildStatePatchRemovedFields(t *testing.T) {
+	before := khstatecrd.NewKuberhealthyState("check", health.WorkloadDetails{OK: true, Annotations: map[string]string{"runbook": "x"}})
+	before.ResourceVersion = "3"
+	original, err := json.Marshal(before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := before
+	after.Spec.Annotations = nil
+
+	patch, err := buildStatePatch(types.MergePatchType, original, &after)
+	if err != nil {
+		t.Fatal("unexpected error building merge patch:", err)
+	}
+	expected := `{"metadata":{"resourceVersion":"3"},"spec":{"Annotations":null}}`
+	if string(patch) != expected {
+		t.Fatalf("expected merge patch %s, got %s", expected, patch)
+	}
+
+	patch, err = buildStatePatch(types.JSONPatchType, original, &after)
+	if err != nil {
+		t.Fatal("unexpected error building JSON patch:", err)
+	}
+	expected = `[{"op":"replace","path":"/metadata/resourceVersion","value":"3"},{"op":"remove","path":"/spec/Annotations"}]`
+	if string(patch) != expected {
+		t.Fatalf("expected JSON patch %s, got %s", expected, patch)
+	}
+}
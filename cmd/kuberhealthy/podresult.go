@@ -0,0 +1,188 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// podResultAnnotation is the checker pod annotation that holds the last result the pod reported
+const podResultAnnotation = "comcast.github.io/last-result"
+
+// checkerPodLabel is the label Kuberhealthy puts on every checker pod with the name of its check
+const checkerPodLabel = "kuberhealthy-check-name"
+
+// maxPodResultErrors is the most errors recorded in a pod result annotation.  Annotations are limited in size, so
+// very long error lists are cut short.
+const maxPodResultErrors = 10
+
+// podResult is the last result reported by a checker pod, as recorded in its annotation
+type podResult struct {
+	Name     string            // the name of the check or job
+	Workload health.KHWorkload // KHCheck or KHJob
+	OK       bool
+	Errors   []string        `json:",omitempty"`
+	Severity health.Severity `json:",omitempty"`
+	RunID    string          `json:",omitempty"`
+	UUID     string          `json:",omitempty"`
+	LastRun  time.Time
+}
+
+// newPodResult creates the pod result annotation of a stored result
+func newPodResult(name string, details health.WorkloadDetails, now time.Time) podResult {
+	errs := details.Errors
+	if len(errs) > maxPodResultErrors {
+		errs = append(append([]string{}, errs[:maxPodResultErrors-1]...), fmt.Sprintf("and %d more errors", len(errs)-maxPodResultErrors+1))
+	}
+	return podResult{
+		Name:     name,
+		Workload: details.GetKHWorkload(),
+		OK:       details.OK,
+		Errors:   errs,
+		Severity: details.Severity,
+		RunID:    details.RunID,
+		UUID:     details.CurrentUUID,
+		LastRun:  now,
+	}
+}
+
+// annotatePodWithResult records a result on the checker pod that reported it, so that the khstate can be rebuilt
+// from the pod if it is lost.  This is skipped unless checker pods are annotated.
+func annotatePodWithResult(namespace string, podName string, name string, details health.WorkloadDetails) {
+	if !cfg.AnnotateCheckerPods || kubernetesClient == nil || len(podName) == 0 {
+		return
+	}
+	result, err := json.Marshal(newPodResult(name, details, time.Now()))
+	if err != nil {
+		log.Warningln(namespace, podName, "failed to encode result for checker pod annotation:", err)
+		return
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{podResultAnnotation: string(result)},
+		},
+	})
+	if err != nil {
+		log.Warningln(namespace, podName, "failed to encode checker pod annotation patch:", err)
+		return
+	}
+	_, err = kubernetesClient.CoreV1().Pods(namespace).Patch(context.TODO(), podName, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		log.Warningln(namespace, podName, "failed to annotate checker pod with its result:", err)
+	}
+}
+
+// rebuildStateFromPods recreates the khstates of checks and jobs from the result annotations of their checker pods
+// in the namespace, or every namespace when it is blank.  This is meant for when khstates were lost but checker pods
+// are still around.  When a check has several annotated pods, the latest result is used, and khstates that already
+// hold a result that is as recent are left alone.  The returned count is the khstates that were rebuilt.
+func rebuildStateFromPods(namespace string) (int, error) {
+	pods, err := kubernetesClient.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: checkerPodLabel})
+	if err != nil {
+		return 0, fmt.Errorf("error listing checker pods: %w", err)
+	}
+
+	// the latest result of each check by namespace/name
+	latest := make(map[string]podResult)
+	var keys []string
+	for _, pod := range pods.Items {
+		annotation, exists := pod.GetAnnotations()[podResultAnnotation]
+		if !exists {
+			continue
+		}
+		var result podResult
+		err := json.Unmarshal([]byte(annotation), &result)
+		if err != nil || len(result.Name) == 0 {
+			log.Warningln("Ignoring invalid result annotation on checker pod", pod.GetName(), "in namespace", pod.GetNamespace()+":", err)
+			continue
+		}
+		key := pod.GetNamespace() + "/" + result.Name
+		previous, seen := latest[key]
+		if !seen {
+			keys = append(keys, key)
+		}
+		if !seen || result.LastRun.After(previous.LastRun) {
+			latest[key] = result
+		}
+	}
+
+	var rebuilt int
+	var rebuildErrors []string
+	for _, key := range keys {
+		checkNamespace, _ := splitCheckKey(key)
+		written, err := rebuildStateFromPodResult(checkNamespace, latest[key])
+		if err != nil {
+			rebuildErrors = append(rebuildErrors, key+": "+err.Error())
+			continue
+		}
+		if written {
+			rebuilt++
+		}
+	}
+	log.Infoln("Rebuilt", rebuilt, "khstates from", len(keys), "annotated checks")
+	if len(rebuildErrors) > 0 {
+		return rebuilt, errors.New("failed to rebuild khStates: " + strings.Join(rebuildErrors, ", "))
+	}
+	return rebuilt, nil
+}
+
+// rebuildStateFromPodResult writes a pod result to the khstate of its check unless the khstate already holds a
+// result that is as recent.  The returned bool indicates if the khstate was written.
+func rebuildStateFromPodResult(checkNamespace string, result podResult) (bool, error) {
+	workload := result.Workload
+	if workload != health.KHJob {
+		workload = health.KHCheck
+	}
+	err := ensureStateResourceExists(result.Name, checkNamespace, workload)
+	if err != nil {
+		return false, err
+	}
+
+	var written bool
+	stateNamespace := stateNamespaceForWorkload(result.Name, checkNamespace, workload)
+	err = updateCheckStateResource(result.Name, stateNamespace, func(details *health.WorkloadDetails) bool {
+		if details.GetStatus() != health.StatusUnknown && !details.LastRun.Before(result.LastRun) {
+			return false
+		}
+		details.OK = result.OK
+		details.Status = health.StatusFromOK(result.OK)
+		details.Errors = result.Errors
+		details.Severity = result.Severity
+		details.RunID = result.RunID
+		details.CurrentUUID = result.UUID
+		details.LastRun = result.LastRun
+		details.LastResultChange = result.LastRun
+		details.ErrorsSince = time.Time{}
+		if !result.OK {
+			details.ErrorsSince = result.LastRun
+		}
+		if result.OK {
+			details.LastSuccess = result.LastRun
+		}
+		details.Namespace = checkNamespace
+		details.ClusterScoped = stateNamespace != checkNamespace
+		written = true
+		return true
+	})
+	return written, err
+}
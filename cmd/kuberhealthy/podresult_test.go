@@ -0,0 +1,128 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// TestRebuildStateFromPods ensures that the latest result annotated on the checker pods of each check is written to
+// its khstate, and that khstates holding a more recent result are left alone
+func TestRebuildStateFromPods(t *testing.T) {
+	lastRun := time.Now().Add(-time.Hour).Truncate(time.Second)
+	annotatedPod := func(name string, result podResult) v1.Pod {
+		b, _ := json.Marshal(result)
+		return v1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "kuberhealthy",
+			Labels:      map[string]string{checkerPodLabel: result.Name},
+			Annotations: map[string]string{podResultAnnotation: string(b)},
+		}}
+	}
+	pods := v1.PodList{Items: []v1.Pod{
+		annotatedPod("dns-1", podResult{Name: "dns", Workload: health.KHCheck, OK: true, LastRun: lastRun.Add(-time.Minute)}),
+		annotatedPod("dns-2", podResult{Name: "dns", Workload: health.KHCheck, OK: false, Errors: []string{"lookup timed out"}, LastRun: lastRun}),
+		annotatedPod("deployment-1", podResult{Name: "deployment", Workload: health.KHCheck, OK: false, LastRun: lastRun}),
+		{ObjectMeta: metav1.ObjectMeta{Name: "unannotated", Namespace: "kuberhealthy", Labels: map[string]string{checkerPodLabel: "daemonset"}}},
+	}}
+	pods.Kind, pods.APIVersion = "PodList", "v1"
+
+	states := map[string]*khstatecrd.KuberhealthyState{}
+	for name, details := range map[string]health.WorkloadDetails{
+		"dns":        {Status: health.StatusUnknown},
+		"deployment": {OK: true, Status: health.StatusOK, LastRun: time.Now()},
+	} {
+		state := khstatecrd.NewKuberhealthyState(name, details)
+		state.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+		state.Kind = "KuberhealthyState"
+		state.Namespace = "kuberhealthy"
+		states[name] = &state
+	}
+
+	var patched string
+	url := useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/api/v1/pods":
+			json.NewEncoder(w).Encode(pods)
+		case r.Method == http.MethodPatch:
+			b, _ := ioutil.ReadAll(r.Body)
+			patched = string(b)
+			json.NewEncoder(w).Encode(v1.Pod{TypeMeta: metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"}})
+		case strings.Contains(r.URL.Path, "/khstates/"):
+			state := states[path.Base(r.URL.Path)]
+			if r.Method == http.MethodPut {
+				*state = khstatecrd.KuberhealthyState{}
+				json.NewDecoder(r.Body).Decode(state)
+			}
+			json.NewEncoder(w).Encode(state)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	originalClient := kubernetesClient
+	kubernetesClient = client
+	defer func() {
+		kubernetesClient = originalClient
+	}()
+
+	rebuilt, err := rebuildStateFromPods("")
+	if err != nil {
+		t.Fatal("unexpected error rebuilding khstates from checker pods:", err)
+	}
+	if rebuilt != 1 {
+		t.Fatal("expected one khstate to be rebuilt, got", rebuilt)
+	}
+	dns := states["dns"].Spec
+	if dns.OK || dns.GetStatus() != health.StatusNotOK || len(dns.Errors) != 1 || !dns.LastRun.Equal(lastRun) {
+		t.Fatalf("expected the latest pod result to be rebuilt, got %+v", dns)
+	}
+	if !states["deployment"].Spec.OK {
+		t.Fatal("expected a khstate with a more recent result to be left alone")
+	}
+
+	// reported results are only annotated on checker pods when enabled
+	originalAnnotate := cfg.AnnotateCheckerPods
+	defer func() {
+		cfg.AnnotateCheckerPods = originalAnnotate
+	}()
+	details := health.NewWorkloadDetails(health.KHCheck)
+	details.Errors = []string{"broken"}
+	cfg.AnnotateCheckerPods = false
+	annotatePodWithResult("kuberhealthy", "dns-3", "dns", details)
+	if len(patched) != 0 {
+		t.Fatal("expected checker pods to not be annotated unless enabled")
+	}
+	cfg.AnnotateCheckerPods = true
+	annotatePodWithResult("kuberhealthy", "dns-3", "dns", details)
+	if !strings.Contains(patched, podResultAnnotation) || !strings.Contains(patched, "broken") {
+		t.Fatal("expected the result to be annotated on the checker pod, got", patched)
+	}
+}
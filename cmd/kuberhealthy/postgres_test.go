@@ -0,0 +1,188 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// fakePostgresStatement is a statement run against fakePostgres with its arguments
+type fakePostgresStatement struct {
+	query string
+	args  []driver.NamedValue
+}
+
+// fakePostgres is a database/sql connector that records every statement run on it.  Queries return a single
+// row with a zero count.
+type fakePostgres struct {
+	statements chan fakePostgresStatement
+}
+
+// Connect implements driver.Connector
+func (f *fakePostgres) Connect(ctx context.Context) (driver.Conn, error) {
+	return f, nil
+}
+
+// Driver implements driver.Connector
+func (f *fakePostgres) Driver() driver.Driver {
+	return nil
+}
+
+// Prepare implements driver.Conn.  Statements are only run through ExecContext and QueryContext.
+func (f *fakePostgres) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("fake postgres does not prepare statements")
+}
+
+// Close implements driver.Conn
+func (f *fakePostgres) Close() error {
+	return nil
+}
+
+// Begin implements driver.Conn
+func (f *fakePostgres) Begin() (driver.Tx, error) {
+	return f, nil
+}
+
+// Commit implements driver.Tx
+func (f *fakePostgres) Commit() error {
+	f.statements <- fakePostgresStatement{query: "COMMIT"}
+	return nil
+}
+
+// Rollback implements driver.Tx
+func (f *fakePostgres) Rollback() error {
+	return nil
+}
+
+// ExecContext implements driver.ExecerContext
+func (f *fakePostgres) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	f.statements <- fakePostgresStatement{query: query, args: args}
+	return driver.RowsAffected(1), nil
+}
+
+// QueryContext implements driver.QueryerContext
+func (f *fakePostgres) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	f.statements <- fakePostgresStatement{query: query, args: args}
+	return &fakePostgresRows{}, nil
+}
+
+// fakePostgresRows is a single row with a zero count
+type fakePostgresRows struct {
+	done bool
+}
+
+// Columns implements driver.Rows
+func (r *fakePostgresRows) Columns() []string {
+	return []string{"count"}
+}
+
+// Close implements driver.Rows
+func (r *fakePostgresRows) Close() error {
+	return nil
+}
+
+// Next implements driver.Rows
+func (r *fakePostgresRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = int64(0)
+	return nil
+}
+
+// TestPostgresStateStore ensures that the schema is migrated on connect and that results are appended to the
+// results table with parameters
+func TestPostgresStateStore(t *testing.T) {
+	fake := &fakePostgres{statements: make(chan fakePostgresStatement, 20)}
+	store := startPostgresStateStore(sql.OpenDB(fake), 10)
+
+	lastRun := time.Date(2020, 11, 10, 15, 4, 5, 0, time.UTC)
+	err := store.SetCheckState("dns", "kuberhealthy", health.WorkloadDetails{OK: false, Errors: []string{"can't resolve"}, LastRun: lastRun})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var received []fakePostgresStatement
+	for len(received) < 7 {
+		select {
+		case statement := <-fake.statements:
+			received = append(received, statement)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for postgres statements, got", received)
+		}
+	}
+	expected := []string{
+		"CREATE TABLE IF NOT EXISTS kuberhealthy_schema_migrations",
+		"SELECT pg_advisory_xact_lock($1)",
+		"SELECT count(*) FROM kuberhealthy_schema_migrations",
+		"CREATE TABLE IF NOT EXISTS kuberhealthy_check_results",
+		"INSERT INTO kuberhealthy_schema_migrations",
+		"COMMIT",
+		"INSERT INTO kuberhealthy_check_results",
+	}
+	for i, prefix := range expected {
+		if !strings.HasPrefix(strings.TrimSpace(received[i].query), prefix) {
+			t.Fatalf("expected statement %d to start with %s, got %s", i, prefix, received[i].query)
+		}
+	}
+
+	insert := received[6]
+	if !strings.Contains(insert.query, "VALUES ($1, $2, $3, $4, $5, $6, $7, $8)") || strings.Contains(insert.query, "dns") {
+		t.Fatal("expected the result to be passed as parameters, got", insert.query)
+	}
+	if len(insert.args) != 8 {
+		t.Fatal("expected 8 parameters, got", insert.args)
+	}
+	if insert.args[0].Value != "dns" || insert.args[1].Value != "kuberhealthy" || insert.args[3].Value != false ||
+		insert.args[5].Value != `["can't resolve"]` {
+		t.Fatal("expected the check, namespace, result and errors as parameters, got", insert.args)
+	}
+	if reportedAt, ok := insert.args[2].Value.(time.Time); !ok || !reportedAt.Equal(lastRun) {
+		t.Fatal("expected the last run as the report time, got", insert.args[2].Value)
+	}
+}
+
+// TestPostgresDSN ensures that connection strings are validated and get a timeout and application name
+func TestPostgresDSN(t *testing.T) {
+	dsn, err := postgresDSN("postgres://kuberhealthy@db.example.com/results?sslmode=verify-full")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	if u.Host != "db.example.com" || u.Path != "/results" || query.Get("sslmode") != "verify-full" ||
+		query.Get("connect_timeout") != "10" || query.Get("fallback_application_name") != "kuberhealthy" {
+		t.Fatal("expected the connection string to be kept with a timeout and application name, got", dsn)
+	}
+	for _, invalid := range []string{"mysql://user@host", "postgres://host/db", "postgres://user@host?sslmode=prefer"} {
+		if _, err := postgresDSN(invalid); err == nil {
+			t.Fatal("expected an error parsing", invalid)
+		}
+	}
+	if pgText("check\x00name") != "checkname" {
+		t.Fatal("expected NUL bytes to be dropped, got", pgText("check\x00name"))
+	}
+}
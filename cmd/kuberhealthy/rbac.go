@@ -0,0 +1,209 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// requiredPermission is a resource and the verbs Kuberhealthy needs on it
+type requiredPermission struct {
+	Group       string
+	Resource    string
+	Subresource string
+	Verbs       []string
+}
+
+// name returns the resource of the permission as it is written in RBAC rules, such as pods/log
+func (p requiredPermission) name() string {
+	if len(p.Subresource) == 0 {
+		return p.Resource
+	}
+	return p.Resource + "/" + p.Subresource
+}
+
+// requiredCRDPermissions are the verbs Kuberhealthy needs on each of its custom resources.  khstates are watched by
+// the state reflector and written with patches by the patch write strategies, and khchecks are watched for changes
+// and updated when they are disabled or given a stable UID.
+var requiredCRDPermissions = []requiredPermission{
+	{Group: stateCRDGroup, Resource: stateCRDResource, Verbs: []string{"get", "list", "watch", "create", "update", "patch", "delete"}},
+	{Group: checkCRDGroup, Resource: checkCRDResource, Verbs: []string{"get", "list", "watch", "update"}},
+	{Group: stateCRDGroup, Resource: "khjobs", Verbs: []string{"get", "list", "watch", "create", "update", "delete"}},
+}
+
+// requiredPermissions returns every permission Kuberhealthy needs with the current configuration.  Pod logs and pod
+// metrics are only needed when log tails and resource usage are recorded.
+func requiredPermissions() []requiredPermission {
+	permissions := append([]requiredPermission{}, requiredCRDPermissions...)
+	if cfg.RecordLogTail {
+		permissions = append(permissions, requiredPermission{Group: "", Resource: "pods", Subresource: "log", Verbs: []string{"get"}})
+	}
+	if cfg.RecordResourceUsage {
+		permissions = append(permissions, requiredPermission{Group: "metrics.k8s.io", Resource: "pods", Verbs: []string{"get"}})
+	}
+	return permissions
+}
+
+// missingPermission is a single verb on a resource that Kuberhealthy is not allowed to use in a namespace
+type missingPermission struct {
+	Namespace   string
+	Group       string
+	Resource    string
+	Subresource string
+	Verb        string
+}
+
+// probeRBACPermissions uses SelfSubjectAccessReviews to determine if Kuberhealthy has all the supplied permissions
+// in the supplied namespaces.  A blank namespace checks cluster-wide access.
+func probeRBACPermissions(namespaces []string, permissions []requiredPermission) ([]missingPermission, error) {
+
+	var missing []missingPermission
+	for _, namespace := range namespaces {
+		for _, permission := range permissions {
+			for _, verb := range permission.Verbs {
+				review := &authorizationv1.SelfSubjectAccessReview{
+					Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+						ResourceAttributes: &authorizationv1.ResourceAttributes{
+							Namespace:   namespace,
+							Verb:        verb,
+							Group:       permission.Group,
+							Resource:    permission.Resource,
+							Subresource: permission.Subresource,
+						},
+					},
+				}
+				result, err := kubernetesClient.AuthorizationV1().SelfSubjectAccessReviews().Create(context.TODO(), review, metav1.CreateOptions{})
+				if err != nil {
+					return missing, fmt.Errorf("error creating self subject access review for %s %s in namespace %s: %w", verb, permission.name(), namespace, err)
+				}
+				if !result.Status.Allowed {
+					missing = append(missing, missingPermission{
+						Namespace:   namespace,
+						Group:       permission.Group,
+						Resource:    permission.Resource,
+						Subresource: permission.Subresource,
+						Verb:        verb,
+					})
+				}
+			}
+		}
+	}
+
+	return missing, nil
+}
+
+// formatMissingPermissionsReport turns a list of missing permissions into a single report that shows the
+// exact RBAC rules needed to fix them, grouped by namespace and resource.
+func formatMissingPermissionsReport(missing []missingPermission) string {
+
+	// group the missing verbs by namespace and then by api group and resource
+	type rule struct {
+		group    string
+		resource string
+	}
+	grouped := make(map[string]map[rule][]string)
+	for _, m := range missing {
+		if grouped[m.Namespace] == nil {
+			grouped[m.Namespace] = make(map[rule][]string)
+		}
+		r := rule{group: m.Group, resource: requiredPermission{Resource: m.Resource, Subresource: m.Subresource}.name()}
+		grouped[m.Namespace][r] = append(grouped[m.Namespace][r], m.Verb)
+	}
+
+	var namespaces []string
+	for namespace := range grouped {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	report := "Kuberhealthy is missing the following permissions. Add these rules to its Role or ClusterRole:\n"
+	for _, namespace := range namespaces {
+		scope := "namespace " + namespace + " (Role)"
+		if namespace == "" {
+			scope = "all namespaces (ClusterRole)"
+		}
+		report += "# " + scope + "\n"
+
+		var rules []rule
+		for r := range grouped[namespace] {
+			rules = append(rules, r)
+		}
+		sort.Slice(rules, func(i, j int) bool {
+			if rules[i].group != rules[j].group {
+				return rules[i].group < rules[j].group
+			}
+			return rules[i].resource < rules[j].resource
+		})
+
+		for _, r := range rules {
+			report += "- apiGroups: [\"" + r.group + "\"]\n"
+			report += "  resources: [\"" + r.resource + "\"]\n"
+			report += "  verbs: [\"" + strings.Join(grouped[namespace][r], "\", \"") + "\"]\n"
+		}
+	}
+	return report
+}
+
+// rbacProbeNamespaces determines which namespaces Kuberhealthy needs access to.  If Kuberhealthy is limited to a
+// single namespace, only that namespace is probed.  Otherwise, cluster-wide access is probed along with the
+// namespace of every khcheck.
+func rbacProbeNamespaces() []string {
+
+	if len(listenNamespace) > 0 {
+		return []string{listenNamespace}
+	}
+
+	namespaces := []string{""}
+	khChecks, err := listUnstructuredKHChecks()
+	if err != nil {
+		log.Warningln("rbac probe: error listing khchecks to determine namespaces to probe:", err)
+		return namespaces
+	}
+	for _, kc := range khChecks.Items {
+		if !containsString(kc.GetNamespace(), namespaces) {
+			namespaces = append(namespaces, kc.GetNamespace())
+		}
+	}
+	return namespaces
+}
+
+// reportMissingRBACPermissions probes for all the permissions Kuberhealthy needs and logs a single consolidated
+// report of anything that is missing
+func reportMissingRBACPermissions() {
+
+	namespaces := rbacProbeNamespaces()
+	permissions := requiredPermissions()
+	var resources []string
+	for _, permission := range permissions {
+		resources = append(resources, permission.name())
+	}
+	log.Infoln("rbac probe: checking permissions on", resources, "in", len(namespaces), "namespace scope(s)")
+
+	missing, err := probeRBACPermissions(namespaces, permissions)
+	if err != nil {
+		log.Errorln("rbac probe: error checking permissions:", err)
+		return
+	}
+	if len(missing) == 0 {
+		log.Infoln("rbac probe: all required permissions are present")
+		return
+	}
+
+	log.Errorln("rbac probe:", formatMissingPermissionsReport(missing))
+}
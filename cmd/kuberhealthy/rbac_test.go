@@ -0,0 +1,116 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// TestFormatMissingPermissionsReport ensures missing verbs are grouped into one rule per namespace and resource
+func TestFormatMissingPermissionsReport(t *testing.T) {
+	missing := []missingPermission{
+		{Namespace: "team-a", Group: stateCRDGroup, Resource: "khstates", Verb: "create"},
+		{Namespace: "team-a", Group: stateCRDGroup, Resource: "khstates", Verb: "update"},
+		{Namespace: "", Group: stateCRDGroup, Resource: "khjobs", Verb: "list"},
+	}
+
+	report := formatMissingPermissionsReport(missing)
+	t.Log(report)
+
+	if !strings.Contains(report, `verbs: ["create", "update"]`) {
+		t.Fatal("Expected create and update to be grouped into a single rule")
+	}
+	if !strings.Contains(report, "# namespace team-a (Role)") {
+		t.Fatal("Expected the team-a namespace to be reported")
+	}
+	if !strings.Contains(report, "# all namespaces (ClusterRole)") {
+		t.Fatal("Expected cluster-wide permissions to be reported")
+	}
+	if strings.Index(report, "all namespaces") > strings.Index(report, "team-a") {
+		t.Fatal("Expected cluster-wide permissions to be reported first")
+	}
+}
+
+// TestProbeRBACPermissions ensures that every verb the state reflector and patch strategies use is probed, and
+// that pod logs and pod metrics are only probed when they are recorded
+func TestProbeRBACPermissions(t *testing.T) {
+	denied := map[string]bool{"khstates watch": true, "khstates patch": true, "khchecks update": true, "pods/log get": true}
+	var probed []string
+	var tailGroup string
+	url := useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		review := authorizationv1.SelfSubjectAccessReview{}
+		json.NewDecoder(r.Body).Decode(&review)
+		attributes := review.Spec.ResourceAttributes
+		resource := requiredPermission{Resource: attributes.Resource, Subresource: attributes.Subresource}.name()
+		if attributes.Subresource == "log" {
+			tailGroup = attributes.Group
+		}
+		probed = append(probed, resource+" "+attributes.Verb)
+		review.Status.Allowed = !denied[resource+" "+attributes.Verb]
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(review)
+	})
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: url, QPS: 1000, Burst: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	originalClient := kubernetesClient
+	kubernetesClient = client
+	originalLogTail, originalResourceUsage := cfg.RecordLogTail, cfg.RecordResourceUsage
+	defer func() {
+		kubernetesClient = originalClient
+		cfg.RecordLogTail, cfg.RecordResourceUsage = originalLogTail, originalResourceUsage
+	}()
+
+	cfg.RecordLogTail, cfg.RecordResourceUsage = false, false
+	_, err = probeRBACPermissions([]string{""}, requiredPermissions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"khstates watch", "khstates patch", "khchecks list", "khchecks watch", "khjobs watch"} {
+		if !containsString(p, probed) {
+			t.Fatalf("expected %s to be probed, got %v", p, probed)
+		}
+	}
+	if containsString("pods/log get", probed) || containsString("pods get", probed) {
+		t.Fatalf("expected pod logs and metrics not to be probed when they are not recorded, got %v", probed)
+	}
+
+	probed = nil
+	cfg.RecordLogTail, cfg.RecordResourceUsage = true, true
+	missing, err := probeRBACPermissions([]string{""}, requiredPermissions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !containsString("pods/log get", probed) || !containsString("pods get", probed) || tailGroup != "" {
+		t.Fatalf("expected pod logs and metrics to be probed when they are recorded, got %v", probed)
+	}
+	if len(missing) != len(denied) {
+		t.Fatalf("expected %d missing permissions, got %+v", len(denied), missing)
+	}
+
+	report := formatMissingPermissionsReport(missing)
+	t.Log(report)
+	if !strings.Contains(report, `verbs: ["watch", "patch"]`) || !strings.Contains(report, `resources: ["pods/log"]`) {
+		t.Fatal("expected the missing khstate verbs and pod logs to be reported")
+	}
+	if !strings.Contains(report, "- apiGroups: [\"\"]\n  resources: [\"pods/log\"]") {
+		t.Fatal("expected pod logs to be reported in the core api group")
+	}
+}
@@ -0,0 +1,30 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+)
+
+// ErrReadOnly is returned by every state write when Kuberhealthy is running as a read-only replica
+var ErrReadOnly = errors.New("kuberhealthy is running in read-only mode and does not write state")
+
+// stateWritesBlocked returns the reason khstate and khjob writes are not allowed right now, or nil if they are
+func stateWritesBlocked() error {
+	if cfg.ReadOnly {
+		return ErrReadOnly
+	}
+	if writesArePaused() {
+		return ErrWritesPaused
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// TestReadOnlyModeRejectsWrites ensures that no state write reaches the API server in read-only mode
+func TestReadOnlyModeRejectsWrites(t *testing.T) {
+	originalReadOnly := cfg.ReadOnly
+	defer func() {
+		cfg.ReadOnly = originalReadOnly
+	}()
+	cfg.ReadOnly = true
+
+	putCount := useFakeKHStateServer(t, nil)
+
+	err := setCheckStateResource("check", "kuberhealthy", health.WorkloadDetails{OK: true})
+	if !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected setCheckStateResource to return ErrReadOnly, got: %v", err)
+	}
+	_, err = setCheckStateResourceIf("check", "kuberhealthy", health.WorkloadDetails{OK: true}, nil)
+	if !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected setCheckStateResourceIf to return ErrReadOnly, got: %v", err)
+	}
+	check := NewFakeCheck()
+	check.CheckName, check.Namespace = "check", "kuberhealthy"
+	NewKuberhealthy().setCheckTimedOut(check, time.Now())
+	err = updateCheckStateResource("check", "kuberhealthy", func(details *health.WorkloadDetails) bool {
+		return true
+	})
+	if !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected updateCheckStateResource to return ErrReadOnly, got: %v", err)
+	}
+	err = ensureStateResourceExists("check", "kuberhealthy", health.KHCheck)
+	if !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected ensureStateResourceExists to return ErrReadOnly, got: %v", err)
+	}
+	err = setJobPhase("job", "kuberhealthy", "Running")
+	if !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected setJobPhase to return ErrReadOnly, got: %v", err)
+	}
+	_, err = reconcileStateResources(nil, false)
+	if !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected reconcileStateResources to return ErrReadOnly, got: %v", err)
+	}
+	if putCount() != 0 {
+		t.Fatalf("expected no khstate writes in read-only mode, got %d", putCount())
+	}
+}
@@ -151,6 +151,13 @@ func deleteFilteredCheckerPods(ctx context.Context, client *kubernetes.Clientset
 
 	for k, v := range reapCheckerPods {
 
+		// pods in namespaces excluded from state management are left alone
+		if !stateNamespaceManaged(v.Namespace) {
+			log.Infoln("checkReaper: Skipping pod", k, "because namespace", v.Namespace, "is excluded from state management")
+			delete(reapCheckerPods, k)
+			continue
+		}
+
 		// Delete pods older than 5 hours and is in status Succeeded
 		if time.Now().Sub(v.CreationTimestamp.Time).Hours() > 5 && v.Status.Phase == v1.PodSucceeded {
 			log.Infoln("checkReaper: Found pod older than 5 hours in status `Succeeded`. Deleting pod:", k)
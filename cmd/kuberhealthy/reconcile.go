@@ -0,0 +1,287 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// stateReconcileSummary reports what a reconcile of khstate resources did, or would have done in a dry run
+type stateReconcileSummary struct {
+	DryRun  bool
+	Created int
+	Deleted int
+	Kept    int
+}
+
+// stateKey returns the namespace/name key of the khstate for a check or job, as used by getAllCheckStates
+func stateKey(name string, stateNamespace string) string {
+	return stateNamespace + "/" + sanitizeResourceName(name)
+}
+
+// khJobStateKeys returns the khstate keys of every khjob so that their khstates are not reaped
+func khJobStateKeys() (map[string]bool, error) {
+	khJobs, err := khJobClient.KuberhealthyJobs(listenNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing khJobs: %w", err)
+	}
+	keys := make(map[string]bool)
+	for _, kj := range khJobs.Items {
+		keys[stateKey(kj.GetName(), kj.GetNamespace())] = true
+	}
+	return keys, nil
+}
+
+// khCheckStateKeys returns the khstate keys of every khcheck in the cluster, which may be in the cluster checks
+// namespace, so that their khstates are not reaped.  The khchecks are listed instead of taken from the configured
+// checks, which are empty on standby replicas and before checks are first configured.
+func khCheckStateKeys() (map[string]bool, error) {
+	khChecks, err := listUnstructuredKHChecks()
+	if err != nil {
+		return nil, fmt.Errorf("error listing unstructured khChecks: %w", err)
+	}
+	keys := make(map[string]bool)
+	for _, kc := range khChecks.Items {
+		khCheck, err := convertUnstructuredKhCheck(kc)
+		if err != nil {
+			log.Errorln("Error converting unstructured object to khcheck:", err)
+			continue
+		}
+		keys[stateKey(khCheck.GetName(), khCheckStateNamespace(khCheck.GetNamespace(), khCheck.GetAnnotations()))] = true
+	}
+	return keys, nil
+}
+
+// retainedStateKeys is the set of khstates that belong to no check, but are kept for the orphaned state retention
+// window.  The khstates of a shard are replaced on every pass of the khstate reaper over that shard.
+type retainedStateKeys struct {
+	sync.RWMutex
+	keys map[string]bool
+}
+
+// retainedStates holds the khstates the reaper retained on its last pass
+var retainedStates = &retainedStateKeys{}
+
+// set replaces the retained khstates of a shard.  Retained khstates of other shards are kept.
+func (r *retainedStateKeys) set(shard stateShard, keys map[string]bool) {
+	r.Lock()
+	defer r.Unlock()
+	if keys == nil {
+		keys = make(map[string]bool)
+	}
+	for key := range r.keys {
+		if !shard.contains(key) {
+			keys[key] = true
+		}
+	}
+	r.keys = keys
+}
+
+// mark flags the details of every retained khstate so that the status page shows which checks were deleted
+func (r *retainedStateKeys) mark(checkDetails map[string]health.WorkloadDetails) {
+	r.RLock()
+	defer r.RUnlock()
+	for key := range r.keys {
+		details, ok := checkDetails[key]
+		if !ok {
+			continue
+		}
+		details.Retained = true
+		checkDetails[key] = details
+	}
+}
+
+// reapOrphanedStateResources deletes every existing khstate whose key is not desired.  Orphaned khstates whose last
+// run is within the orphaned state retention window are kept and marked as retained.  When dryRun is set, the
+// orphaned khstates are only logged.  The number of deleted and kept khstates is returned.
+func reapOrphanedStateResources(existing map[string]health.WorkloadDetails, desired map[string]bool, dryRun bool) (int, int, error) {
+	return reapOrphanedStateShard(existing, desired, allStates, dryRun)
+}
+
+// reapOrphanedStateShard is reapOrphanedStateResources for only the existing khstates in a shard.  khstates outside
+// of the shard are not counted.
+func reapOrphanedStateShard(existing map[string]health.WorkloadDetails, desired map[string]bool, shard stateShard, dryRun bool) (int, int, error) {
+
+	var keys []string
+	for key := range existing {
+		if shard.contains(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	now := time.Now()
+	retained := make(map[string]bool)
+	var deleted, kept int
+	var deleteErrors []string
+	for _, key := range keys {
+		if desired[key] {
+			log.Debugln("khState reaper:", key, "is still valid")
+			kept++
+			continue
+		}
+
+		// synthetic khstates belong to no check, but are maintained by Kuberhealthy itself
+		if existing[key].Synthetic || isOverallStateKey(key) {
+			log.Debugln("khState reaper:", key, "is synthetic and will not be reaped")
+			kept++
+			continue
+		}
+
+		stateNamespace, name := splitCheckKey(key)
+		if !stateNamespaceManaged(stateNamespace) {
+			log.Infoln("khState reaper:", key, "is in a namespace excluded from state management and will not be reaped")
+			kept++
+			continue
+		}
+		details := existing[key]
+		if details.IsRetained(cfg.OrphanedStateRetention, now) {
+			log.Infoln("khState reaper:", key, "belongs to no check and is retained until", details.LastRun.Add(cfg.OrphanedStateRetention))
+			retained[key] = true
+			kept++
+			continue
+		}
+		if dryRun {
+			log.Infoln("khState reaper: would remove khState", name, "in", stateNamespace)
+			deleted++
+			continue
+		}
+
+		log.Infoln("khState reaper: removing khState", name, "in", stateNamespace)
+		_, err := khStateClient.Delete(nil, stateCRDResource, name, stateNamespace)
+		stateVersions.invalidate(name, stateNamespace, "deleted")
+		if err != nil {
+			deleteErrors = append(deleteErrors, key+": "+err.Error())
+			continue
+		}
+		deleted++
+	}
+	if !dryRun {
+		retainedStates.set(shard, retained)
+	}
+
+	if len(deleteErrors) > 0 {
+		return deleted, kept, errors.New("error removing invalid khstates: " + strings.Join(deleteErrors, ", "))
+	}
+	return deleted, kept, nil
+}
+
+// reconcileStateResources syncs khstate resources with the supplied checks in a single pass.  A khstate is
+// created for every check that does not have one, khstates that belong to no khcheck or khjob in the cluster are
+// deleted, and everything else is left alone.  Running it again without changes to the checks does nothing.  When dryRun is
+// set, nothing is written and the summary reports what would have been done.
+func reconcileStateResources(desired []KuberhealthyCheck, dryRun bool) (stateReconcileSummary, error) {
+	summary := stateReconcileSummary{DryRun: dryRun}
+
+	if !dryRun {
+		if err := stateWritesBlocked(); err != nil {
+			return summary, err
+		}
+	}
+
+	existing, err := getAllCheckStates("", projectionMetadata)
+	if err != nil {
+		return summary, fmt.Errorf("error listing khStates to reconcile: %w", err)
+	}
+
+	// every khcheck in the cluster keeps its khstate, even when it is not one of the supplied checks
+	wanted, err := khCheckStateKeys()
+	if err != nil {
+		return summary, fmt.Errorf("error listing khChecks to reconcile: %w", err)
+	}
+
+	// khjobs are not configured checks, but their khstates are still valid
+	jobKeys, err := khJobStateKeys()
+	if err != nil {
+		return summary, fmt.Errorf("error listing khJobs to reconcile: %w", err)
+	}
+	for key := range jobKeys {
+		wanted[key] = true
+	}
+
+	var createErrors []string
+	for _, c := range desired {
+		key := stateKey(c.Name(), stateNamespaceForCheck(c.Name(), c.CheckNamespace()))
+		wanted[key] = true
+		if _, ok := existing[key]; ok {
+			continue
+		}
+
+		summary.Created++
+		if dryRun {
+			log.Infoln("khState reconcile: would create khState for check", c.Name(), "in namespace", c.CheckNamespace())
+			continue
+		}
+		err := ensureStateResourceExists(c.Name(), c.CheckNamespace(), health.KHCheck)
+		if err != nil {
+			summary.Created--
+			createErrors = append(createErrors, key+": "+err.Error())
+		}
+	}
+
+	summary.Deleted, summary.Kept, err = reapOrphanedStateResources(existing, wanted, dryRun)
+	if err != nil {
+		createErrors = append(createErrors, err.Error())
+	}
+
+	log.Infoln("khState reconcile: created", summary.Created, "deleted", summary.Deleted, "and kept", summary.Kept, "khStates. dry run:", dryRun)
+	if len(createErrors) > 0 {
+		return summary, errors.New("failed to reconcile khStates: " + strings.Join(createErrors, ", "))
+	}
+	return summary, nil
+}
+
+// reconcileHandler reconciles khstates with the configured checks and writes the summary as JSON.  Only the master
+// has configured checks, so other replicas refuse with 409 instead of creating nothing.
+func (k *Kuberhealthy) reconcileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+	log.Infoln("admin: khstate reconcile with dry run", dryRun, "requested by", r.RemoteAddr)
+
+	if !isMaster {
+		log.Warningln("admin: refusing khstate reconcile because this instance is not the master")
+		http.Error(w, "khstates can only be reconciled by the master kuberhealthy instance", http.StatusConflict)
+		return
+	}
+
+	summary, err := reconcileStateResources(k.Checks, dryRun)
+	if err != nil {
+		log.Errorln("admin: error reconciling khstates:", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	b, err := json.Marshal(summary)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(b)
+	if err != nil {
+		log.Warningln("admin: error writing reconcile summary to caller:", err)
+	}
+}
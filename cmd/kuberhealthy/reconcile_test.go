@@ -0,0 +1,167 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+
+	khjobv1 "github.com/Comcast/kuberhealthy/v2/pkg/apis/khjob/v1"
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// khCheckList is a list of khchecks as served by the API server to the dynamic client
+func khCheckList(names ...string) map[string]interface{} {
+	var items []interface{}
+	for _, name := range names {
+		items = append(items, map[string]interface{}{
+			"apiVersion": checkCRDGroup + "/" + checkCRDVersion,
+			"kind":       "KuberhealthyCheck",
+			"metadata":   map[string]interface{}{"name": name, "namespace": "kuberhealthy"},
+		})
+	}
+	return map[string]interface{}{"apiVersion": checkCRDGroup + "/" + checkCRDVersion, "kind": "KuberhealthyCheckList", "items": items}
+}
+
+// useFakeKHCheckClient points the dynamic client that lists khchecks at a test server.  The original client is
+// restored when the test ends.
+func useFakeKHCheckClient(t *testing.T, url string) {
+	client, err := dynamic.NewForConfig(&rest.Config{Host: url})
+	if err != nil {
+		t.Fatal("failed to create dynamic client for test server:", err)
+	}
+	original := dynamicClient
+	dynamicClient = client
+	t.Cleanup(func() {
+		dynamicClient = original
+	})
+}
+
+// TestReconcileStateResources ensures that missing khstates are created, orphaned khstates are deleted and khstates
+// of checks, khchecks that are not configured yet and khjobs are kept, and that a dry run writes nothing
+func TestReconcileStateResources(t *testing.T) {
+	newState := func(name string) khstatecrd.KuberhealthyState {
+		s := khstatecrd.NewKuberhealthyState(name, health.WorkloadDetails{OK: true})
+		s.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+		s.Kind = "KuberhealthyState"
+		s.Namespace = "kuberhealthy"
+		return s
+	}
+	states := khstatecrd.KuberhealthyStateList{Items: []khstatecrd.KuberhealthyState{newState("kept"), newState("orphan"), newState("job"), newState("unconfigured")}}
+	states.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	states.Kind = "KuberhealthyStateList"
+	jobs := khjobv1.KuberhealthyJobList{Items: []khjobv1.KuberhealthyJob{khjobv1.NewKuberhealthyJob("job", "kuberhealthy", khjobv1.JobConfig{})}}
+	jobs.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	jobs.Kind = "KuberhealthyJobList"
+
+	var created, deleted []string
+	url := useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.Contains(r.URL.Path, "/khjobs"):
+			json.NewEncoder(w).Encode(jobs)
+		case strings.Contains(r.URL.Path, "/khchecks"):
+			json.NewEncoder(w).Encode(khCheckList("kept", "unconfigured"))
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/khstates"):
+			json.NewEncoder(w).Encode(states)
+		case r.Method == http.MethodGet:
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodPost:
+			b, _ := ioutil.ReadAll(r.Body)
+			created = append(created, string(b))
+			w.WriteHeader(http.StatusCreated)
+			w.Write(b)
+		case r.Method == http.MethodDelete:
+			deleted = append(deleted, path.Base(r.URL.Path))
+			json.NewEncoder(w).Encode(newState(path.Base(r.URL.Path)))
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+	jobClient, err := khjobv1.NewForConfig(&rest.Config{Host: url})
+	if err != nil {
+		t.Fatal("failed to create khjob client for test server:", err)
+	}
+	originalJobClient := khJobClient
+	khJobClient = jobClient
+	defer func() {
+		khJobClient = originalJobClient
+	}()
+	useFakeKHCheckClient(t, url)
+
+	desired := []KuberhealthyCheck{
+		&FakeCheck{CheckName: "kept", Namespace: "kuberhealthy"},
+		&FakeCheck{CheckName: "missing", Namespace: "kuberhealthy"},
+	}
+	expected := stateReconcileSummary{Created: 1, Deleted: 1, Kept: 3}
+
+	// a dry run reports the changes without making them
+	summary, err := reconcileStateResources(desired, true)
+	if err != nil {
+		t.Fatal("unexpected error in dry run reconcile:", err)
+	}
+	expected.DryRun = true
+	if summary != expected {
+		t.Fatalf("expected dry run summary %+v but got %+v", expected, summary)
+	}
+	if len(created) != 0 || len(deleted) != 0 {
+		t.Fatalf("dry run created %d and deleted %d khstates", len(created), len(deleted))
+	}
+
+	summary, err = reconcileStateResources(desired, false)
+	if err != nil {
+		t.Fatal("unexpected error reconciling:", err)
+	}
+	expected.DryRun = false
+	if summary != expected {
+		t.Fatalf("expected summary %+v but got %+v", expected, summary)
+	}
+	if len(created) != 1 || !strings.Contains(created[0], `"name":"missing"`) {
+		t.Fatalf("expected only the missing khstate to be created but created %v", created)
+	}
+	if len(deleted) != 1 || deleted[0] != "orphan" {
+		t.Fatalf("expected only the orphaned khstate to be deleted but deleted %v", deleted)
+	}
+}
+
+// TestReconcileHandlerOnStandby ensures that a replica that is not the master refuses to reconcile, since it has
+// no configured checks and would otherwise treat every khstate as an orphan
+func TestReconcileHandlerOnStandby(t *testing.T) {
+	originalMaster := isMaster
+	defer func() { isMaster = originalMaster }()
+	isMaster = false
+
+	var requests int
+	useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	})
+
+	k := &Kuberhealthy{}
+	w := httptest.NewRecorder()
+	k.reconcileHandler(w, httptest.NewRequest(http.MethodPost, "/admin/reconcile", nil))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected a standby to refuse to reconcile with %d, got %d", http.StatusConflict, w.Code)
+	}
+	if requests != 0 {
+		t.Fatalf("expected a standby to not touch any khstates, made %d requests", requests)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"regexp"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// redactionMask replaces every match of a redaction pattern
+const redactionMask = "***"
+
+// compiledRedactions caches compiled redaction patterns by their source.  Patterns that do not compile are cached
+// as nil so that the error is only logged once.
+var compiledRedactions = struct {
+	sync.Mutex
+	patterns map[string]*regexp.Regexp
+}{patterns: make(map[string]*regexp.Regexp)}
+
+// redactPatternsForCheck returns the redaction patterns of a check, which may be overridden per check
+func redactPatternsForCheck(checkName string, checkNamespace string) []string {
+	if patterns, ok := cfg.RedactPatternOverrides[checkNamespace+"/"+checkName]; ok {
+		return patterns
+	}
+	return cfg.RedactPatterns
+}
+
+// compileRedactions returns the compiled form of the supplied patterns, skipping patterns that are not valid
+func compileRedactions(patterns []string) []*regexp.Regexp {
+	compiledRedactions.Lock()
+	defer compiledRedactions.Unlock()
+
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, ok := compiledRedactions.patterns[pattern]
+		if !ok {
+			var err error
+			re, err = regexp.Compile(pattern)
+			if err != nil {
+				log.Errorln("Ignoring invalid redaction pattern", pattern+":", err)
+			}
+			compiledRedactions.patterns[pattern] = re
+		}
+		if re != nil {
+			compiled = append(compiled, re)
+		}
+	}
+	return compiled
+}
+
+// redact replaces every match of the supplied patterns in s and returns the number of replaced matches
+func redact(s string, patterns []*regexp.Regexp) (string, int) {
+	var count int
+	for _, re := range patterns {
+		matches := len(re.FindAllStringIndex(s, -1))
+		if matches == 0 {
+			continue
+		}
+		count += matches
+		s = re.ReplaceAllLiteralString(s, redactionMask)
+	}
+	return s, count
+}
+
+// redactCheckState masks sensitive data in the errors, annotations and log tail of a check result before it is
+// stored.  The slices and maps of the supplied state are copied instead of changed in place.
+func redactCheckState(checkName string, checkNamespace string, state health.WorkloadDetails) health.WorkloadDetails {
+	patterns := compileRedactions(redactPatternsForCheck(checkName, checkNamespace))
+	if len(patterns) == 0 {
+		return state
+	}
+
+	var total, count int
+	if len(state.Errors) > 0 {
+		errs := make([]string, len(state.Errors))
+		for i, e := range state.Errors {
+			errs[i], count = redact(e, patterns)
+			total += count
+		}
+		state.Errors = errs
+	}
+	if len(state.Annotations) > 0 {
+		annotations := make(map[string]string, len(state.Annotations))
+		for k, v := range state.Annotations {
+			annotations[k], count = redact(v, patterns)
+			total += count
+		}
+		state.Annotations = annotations
+	}
+	state.LogTail, count = redact(state.LogTail, patterns)
+	total += count
+
+	if total > 0 {
+		log.Debugln(checkNamespace, checkName, "redacted", total, "matches from check result")
+		redactions.Add(float64(total), checkName, checkNamespace)
+	}
+	return state
+}
@@ -0,0 +1,56 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/metrics"
+)
+
+// TestRedactCheckState ensures that matches of the global and per-check redaction patterns are masked in errors,
+// annotations and log tails without changing the reported state
+func TestRedactCheckState(t *testing.T) {
+	originalPatterns, originalOverrides := cfg.RedactPatterns, cfg.RedactPatternOverrides
+	defer func() {
+		cfg.RedactPatterns, cfg.RedactPatternOverrides = originalPatterns, originalOverrides
+	}()
+	cfg.RedactPatterns = []string{`password=\S+`, `(unclosed`}
+	cfg.RedactPatternOverrides = patternMap{"kuberhealthy/tokens": {`token [a-f0-9]+`}}
+
+	reported := health.WorkloadDetails{
+		Errors:      []string{"login failed with password=hunter2", "password=a and password=b"},
+		Annotations: map[string]string{"request": "GET /?password=hunter2"},
+		LogTail:     "using token abc123 and password=hunter2",
+	}
+	redacted := redactCheckState("login", "kuberhealthy", reported)
+	if redacted.Errors[0] != "login failed with ***" || redacted.Errors[1] != "*** and ***" {
+		t.Fatal("expected passwords to be masked in errors, got", redacted.Errors)
+	}
+	if redacted.Annotations["request"] != "GET /?***" || redacted.LogTail != "using token abc123 and ***" {
+		t.Fatalf("expected passwords to be masked in annotations and the log tail, got %+v", redacted)
+	}
+	if !strings.Contains(reported.Errors[0], "hunter2") || !strings.Contains(reported.Annotations["request"], "hunter2") {
+		t.Fatal("expected the reported state to be left alone")
+	}
+	if !strings.Contains(metrics.GenerateRegisteredMetrics(), `kuberhealthy_redactions_total{check="login",namespace="kuberhealthy"} 5`) {
+		t.Fatal("expected the 5 redactions to be counted")
+	}
+
+	// per-check patterns replace the global patterns
+	redacted = redactCheckState("tokens", "kuberhealthy", reported)
+	if redacted.LogTail != "using *** and password=hunter2" {
+		t.Fatal("expected only the per-check pattern to be applied, got", redacted.LogTail)
+	}
+}
@@ -33,7 +33,7 @@ func NewStateReflector() *StateReflector {
 	sr.resyncPeriod = time.Minute * 5
 
 	// structure the reflector and its required elements
-	khStateListWatch := cache.NewListWatchFromClient(khStateClient.RestClient(), stateCRDResource, listenNamespace, fields.Everything())
+	khStateListWatch := cache.NewListWatchFromClient(khStateReadClient.RestClient(), stateCRDResource, listenNamespace, fields.Everything())
 	sr.store = cache.NewStore(cache.MetaNamespaceKeyFunc)
 	sr.reflector = cache.NewReflector(khStateListWatch, &khstatecrd.KuberhealthyState{}, sr.store, sr.resyncPeriod)
 
@@ -67,6 +67,10 @@ func (sr *StateReflector) CurrentStatus() health.State {
 
 	// list all objects from the storage cache
 	khStateList := sr.store.List()
+
+	// the store lists khstates in no particular order.  sorting them keeps the global error list in the same
+	// order on every request
+	sortKHStates(khStateList)
 	for i, khStateUndefined := range khStateList {
 		log.Debugln("state reflector store item from listing:", i, khStateUndefined)
 		khState, ok := khStateUndefined.(*khstatecrd.KuberhealthyState)
@@ -84,23 +88,49 @@ func (sr *StateReflector) CurrentStatus() health.State {
 			continue
 		}
 
-		// parse check status from CRD and add it to the global status of errors. Skip blank errors
-		for _, e := range khState.Spec.Errors {
+		// synthetic khstates are derived from the results of checks and are not checks themselves
+		if khState.Spec.Synthetic {
+			log.Debugln("Output for", khState.GetName(), khState.GetNamespace(), "hidden from status page because it is synthetic")
+			continue
+		}
+
+		// failures of new checks are hidden until their initial grace period is over.  the details are copied so
+		// that the cached khstate is not modified
+		details := decryptStateFields(khState.Spec)
+		applyInitialGracePeriod(khState.GetName(), khState.GetNamespace(), &details, time.Now())
+
+		// parse check status from CRD and add it to the global status of errors. Skip blank errors.  Checks
+		// with an unknown status have not reported yet and do not count as failures.
+		for _, e := range details.Errors {
+			if details.GetStatus() == health.StatusUnknown {
+				log.Debugln("Status of", khState.GetName(), khState.GetNamespace(), "is unknown and will not affect the global OK state")
+				break
+			}
 			if len(strings.TrimSpace(e)) == 0 {
 				log.Warningln("Skipped an error that was blank when adding check details to current state.")
 				continue
 			}
+			if !failsOverallStatus(details) {
+				state.AddWarning(e)
+				continue
+			}
 			state.AddError(e)
 			log.Debugln("Status page: Setting global OK state to false due to check details not being OK")
 			state.OK = false
 		}
 
+		// cluster-scoped checks are grouped separately from checks that belong to a namespace
+		if details.ClusterScoped {
+			state.ClusterCheckDetails[khState.GetNamespace()+"/"+khState.GetName()] = details
+			continue
+		}
+
 		khWorkload := determineKHWorkload(khState.Name, khState.Namespace)
 		switch khWorkload {
 		case health.KHCheck:
-			state.CheckDetails[khState.GetNamespace()+"/"+khState.GetName()] = khState.Spec
+			state.CheckDetails[khState.GetNamespace()+"/"+khState.GetName()] = details
 		case health.KHJob:
-			state.JobDetails[khState.GetNamespace()+"/"+khState.GetName()] = khState.Spec
+			state.JobDetails[khState.GetNamespace()+"/"+khState.GetName()] = details
 		}
 	}
 
@@ -0,0 +1,165 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// stateChange is a check whose result differs between a baseline and now
+type stateChange struct {
+	Name      string
+	Namespace string
+	Baseline  health.CheckStatus `json:",omitempty"` // the result at the baseline.  Blank when the check did not exist
+	Current   health.CheckStatus `json:",omitempty"` // the result now.  Blank when the check no longer exists
+	Errors    []string           `json:",omitempty"` // the current errors of the check
+}
+
+// stateDiff is the difference between the results of all checks at a baseline and now
+type stateDiff struct {
+	Regressed   []stateChange // checks that were OK and are now failing
+	Recovered   []stateChange // checks that were failing and are now OK
+	Appeared    []stateChange // checks that did not exist at the baseline
+	Disappeared []stateChange // checks that existed at the baseline but no longer do
+	Unknown     []stateChange // checks whose result at the baseline is not known, so they can not be compared
+}
+
+// diffCheckStates compares the results of checks at a baseline, keyed by namespace/name, with their current
+// khstate details.  A baseline of Unknown means the check existed but its result at the time is not known.
+// Synthetic khstates are not checks and are left out.
+func diffCheckStates(baseline map[string]health.CheckStatus, current map[string]health.WorkloadDetails) stateDiff {
+	diff := stateDiff{
+		Regressed:   []stateChange{},
+		Recovered:   []stateChange{},
+		Appeared:    []stateChange{},
+		Disappeared: []stateChange{},
+		Unknown:     []stateChange{},
+	}
+
+	for _, key := range sortedCheckKeys(current) {
+		details := current[key]
+		if details.Synthetic {
+			continue
+		}
+		namespace, name := splitCheckKey(key)
+		change := stateChange{
+			Name:      name,
+			Namespace: namespace,
+			Current:   details.GetStatus(),
+			Errors:    details.Errors,
+		}
+		was, existed := baseline[key]
+		change.Baseline = was
+		switch {
+		case !existed:
+			diff.Appeared = append(diff.Appeared, change)
+		case was == health.StatusUnknown:
+			diff.Unknown = append(diff.Unknown, change)
+		case was == health.StatusOK && change.Current == health.StatusNotOK:
+			diff.Regressed = append(diff.Regressed, change)
+		case was == health.StatusNotOK && change.Current == health.StatusOK:
+			diff.Recovered = append(diff.Recovered, change)
+		}
+	}
+
+	var keys []string
+	for key := range baseline {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, exists := current[key]; exists {
+			continue
+		}
+		namespace, name := splitCheckKey(key)
+		diff.Disappeared = append(diff.Disappeared, stateChange{Name: name, Namespace: namespace, Baseline: baseline[key]})
+	}
+	return diff
+}
+
+// baselineFromSnapshot returns the result of every check in a status page snapshot
+func baselineFromSnapshot(snapshot health.State) map[string]health.CheckStatus {
+	baseline := make(map[string]health.CheckStatus)
+	for _, checkDetails := range []map[string]health.WorkloadDetails{snapshot.CheckDetails, snapshot.ClusterCheckDetails} {
+		for key, details := range checkDetails {
+			if details.Synthetic {
+				continue
+			}
+			baseline[key] = details.GetStatus()
+		}
+	}
+	return baseline
+}
+
+// baselineFromRunHistory returns the result of every check in the run history at the supplied time, which is the
+// result of its last run at or before then.  Checks with no run at or before then in the history are Unknown,
+// because the history is kept in memory and only reaches back to the reliability window or the last restart.
+// Checks that are not in the history at all are left out.
+func baselineFromRunHistory(at time.Time) map[string]health.CheckStatus {
+	runHistory.Lock()
+	defer runHistory.Unlock()
+
+	baseline := make(map[string]health.CheckStatus)
+	for key, history := range runHistory.checks {
+		baseline[key] = health.StatusUnknown
+		for _, run := range history.runs {
+			if run.Time.After(at) {
+				break
+			}
+			baseline[key] = health.StatusFromOK(run.OK)
+		}
+	}
+	return baseline
+}
+
+// regressionsHandler writes the difference between the results of all checks at a baseline and now as JSON.  A
+// GET with a since query parameter in RFC3339 format compares with the results at that time from the run history.
+// A POST compares with a snapshot of the status page in the request body, such as one saved before a rollout.
+func regressionsHandler(w http.ResponseWriter, r *http.Request) error {
+	var baseline map[string]health.CheckStatus
+	switch r.Method {
+	case http.MethodGet:
+		since, err := time.Parse(time.RFC3339, r.URL.Query().Get("since"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "the since query parameter must be a time in RFC3339 format, such as 2020-11-10T15:04:05Z")
+			return nil
+		}
+		baseline = baselineFromRunHistory(since)
+	case http.MethodPost:
+		snapshot := health.State{}
+		err := json.NewDecoder(r.Body).Decode(&snapshot)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "the request body must be a snapshot of the status page:", err)
+			return nil
+		}
+		baseline = baselineFromSnapshot(snapshot)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return nil
+	}
+
+	current, err := getAllCheckStates("", projectionFull)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return fmt.Errorf("error listing khstates to compare with the baseline: %w", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(diffCheckStates(baseline, current))
+}
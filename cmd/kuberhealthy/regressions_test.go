@@ -0,0 +1,80 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// TestDiffCheckStates ensures that checks are sorted into regressed, recovered, appeared, disappeared and unknown
+// and that unchanged and synthetic checks are left out
+func TestDiffCheckStates(t *testing.T) {
+	baseline := map[string]health.CheckStatus{
+		"kuberhealthy/dns":        health.StatusOK,
+		"kuberhealthy/deployment": health.StatusNotOK,
+		"kuberhealthy/daemonset":  health.StatusOK,
+		"kuberhealthy/pod-status": health.StatusUnknown,
+		"kuberhealthy/removed":    health.StatusOK,
+	}
+	current := map[string]health.WorkloadDetails{
+		"kuberhealthy/dns":        {OK: false, Status: health.StatusNotOK, Errors: []string{"timeout"}},
+		"kuberhealthy/deployment": {OK: true, Status: health.StatusOK},
+		"kuberhealthy/daemonset":  {OK: true, Status: health.StatusOK},
+		"kuberhealthy/pod-status": {OK: false, Status: health.StatusNotOK, Errors: []string{"crashloop"}},
+		"kuberhealthy/new":        {OK: true, Status: health.StatusOK},
+		"kuberhealthy/overall":    {OK: false, Status: health.StatusNotOK, Synthetic: true},
+	}
+
+	diff := diffCheckStates(baseline, current)
+	expected := map[string][]stateChange{
+		"regressed":   diff.Regressed,
+		"recovered":   diff.Recovered,
+		"appeared":    diff.Appeared,
+		"disappeared": diff.Disappeared,
+		"unknown":     diff.Unknown,
+	}
+	names := map[string]string{"regressed": "dns", "recovered": "deployment", "appeared": "new", "disappeared": "removed", "unknown": "pod-status"}
+	for kind, changes := range expected {
+		if len(changes) != 1 || changes[0].Name != names[kind] || changes[0].Namespace != "kuberhealthy" {
+			t.Fatalf("expected %s to only list %s, got %+v", kind, names[kind], changes)
+		}
+	}
+	if diff.Regressed[0].Errors[0] != "timeout" || diff.Regressed[0].Baseline != health.StatusOK {
+		t.Fatalf("expected the regression to include its baseline and errors, got %+v", diff.Regressed[0])
+	}
+}
+
+// TestBaselineFromRunHistory ensures that the result of a check at a baseline time is its last run at or before
+// then, and is unknown when the run history does not reach back that far
+func TestBaselineFromRunHistory(t *testing.T) {
+	start := time.Now().Add(-time.Hour)
+	recordRun("baseline-check", "baseline", true, start)
+	recordRun("baseline-check", "baseline", false, start.Add(time.Minute*30))
+
+	tests := []struct {
+		at       time.Time
+		expected health.CheckStatus
+	}{
+		{at: start.Add(-time.Minute), expected: health.StatusUnknown},
+		{at: start.Add(time.Minute), expected: health.StatusOK},
+		{at: start.Add(time.Minute * 31), expected: health.StatusNotOK},
+	}
+	for _, test := range tests {
+		status := baselineFromRunHistory(test.at)["baseline/baseline-check"]
+		if status != test.expected {
+			t.Fatalf("at %s: expected %s, got %s", test.at, test.expected, status)
+		}
+	}
+}
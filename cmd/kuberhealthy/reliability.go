@@ -0,0 +1,204 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// defaultReliabilityWindow is the window reliability is reported over when none is configured
+const defaultReliabilityWindow = time.Hour * 24
+
+// maxRunHistory is the most runs kept in the history of a single check
+const maxRunHistory = 10000
+
+// runRecord is the result of a single check run
+type runRecord struct {
+	Time time.Time
+	OK   bool
+}
+
+// checkRunHistory is the run history of a single check
+type checkRunHistory struct {
+	since time.Time // the earliest time the history is complete from
+	runs  []runRecord
+}
+
+// runHistory holds the recent run results of every check this instance has written, keyed by namespace/name.
+// The history is kept in memory only, so it starts over when Kuberhealthy restarts or the master changes.
+var runHistory = struct {
+	sync.Mutex
+	checks map[string]*checkRunHistory
+}{checks: make(map[string]*checkRunHistory)}
+
+// reliabilityWindow returns the configured reliability window
+func reliabilityWindow() time.Duration {
+	if cfg.ReliabilityWindow > 0 {
+		return cfg.ReliabilityWindow
+	}
+	return defaultReliabilityWindow
+}
+
+// isRunResult determines if a written state is the result of a check run.  Queued, skipped, disabled, synthetic
+// and unknown states say nothing about the reliability of the check.
+func isRunResult(state health.WorkloadDetails) bool {
+	return !state.Queued && !state.Skipped && !state.Disabled && !state.Synthetic && state.GetStatus() != health.StatusUnknown
+}
+
+// recordRun adds a run result to the history of a check and prunes runs that are older than the reliability
+// window.  The reliability gauge of the check is updated to match.
+func recordRun(name string, namespace string, ok bool, at time.Time) {
+	key := stateKey(name, namespace)
+	window := reliabilityWindow()
+
+	runHistory.Lock()
+	history, exists := runHistory.checks[key]
+	if !exists {
+		history = &checkRunHistory{since: at}
+		runHistory.checks[key] = history
+	}
+	history.runs = append(history.runs, runRecord{Time: at, OK: ok})
+
+	// runs dropped because of the size limit leave a gap, so the history is only complete from the oldest run kept
+	if len(history.runs) > maxRunHistory {
+		history.runs = history.runs[len(history.runs)-maxRunHistory:]
+		history.since = history.runs[0].Time
+	}
+
+	// runs older than the window are never needed again
+	cutoff := at.Add(-window)
+	var keep int
+	for keep < len(history.runs) && history.runs[keep].Time.Before(cutoff) {
+		keep++
+	}
+	history.runs = history.runs[keep:]
+	runHistory.Unlock()
+
+	percent, sufficient := computeReliabilityAt(name, namespace, window, at)
+	if !sufficient {
+		checkReliability.Delete(name, namespace)
+		return
+	}
+	checkReliability.Set(percent, name, namespace)
+}
+
+// computeReliability returns the percentage of runs within the window that a check was OK.  The returned bool is
+// false when the run history does not cover the whole window, such as when Kuberhealthy has not been running for
+// that long, because a percentage of a shorter period would be misleading.
+func computeReliability(name string, namespace string, window time.Duration) (float64, bool) {
+	return computeReliabilityAt(name, namespace, window, time.Now())
+}
+
+// computeReliabilityAt is computeReliability at the supplied time
+func computeReliabilityAt(name string, namespace string, window time.Duration, now time.Time) (float64, bool) {
+	runHistory.Lock()
+	defer runHistory.Unlock()
+
+	// runs older than the reliability window are pruned, so longer windows can never be covered
+	history, exists := runHistory.checks[stateKey(name, namespace)]
+	if !exists || window <= 0 || window > reliabilityWindow() {
+		return 0, false
+	}
+	start := now.Add(-window)
+	if history.since.After(start) {
+		return 0, false
+	}
+
+	var runs, okRuns int
+	for _, run := range history.runs {
+		if run.Time.Before(start) || run.Time.After(now) {
+			continue
+		}
+		runs++
+		if run.OK {
+			okRuns++
+		}
+	}
+	if runs == 0 {
+		return 0, false
+	}
+	return float64(okRuns) / float64(runs) * 100, true
+}
+
+// markReliability sets the reliability of every check over the reliability window.  Checks without enough run
+// history are left without a reliability.
+func markReliability(checkDetails map[string]health.WorkloadDetails) {
+	window := reliabilityWindow()
+	for key, details := range checkDetails {
+		namespace, name := splitCheckKey(key)
+		percent, sufficient := computeReliability(name, namespace, window)
+		if !sufficient {
+			continue
+		}
+		details.Reliability = &percent
+		checkDetails[key] = details
+	}
+}
+
+// aggregateFailThreshold returns the number of failed runs out of n that make a rollup failing.  A majority of the
+// runs is used when no threshold is configured.
+func aggregateFailThreshold(n int) int {
+	if cfg.AggregateFailThreshold > 0 {
+		return cfg.AggregateFailThreshold
+	}
+	return n/2 + 1
+}
+
+// aggregateWindow rolls up the last n runs of a check into pass and fail counts and a status.  Fewer runs are rolled
+// up when the run history is shorter, such as after a restart, because runs are only kept for the reliability
+// window.  The returned bool is false when the check has no runs to roll up.
+func aggregateWindow(name string, namespace string, n int) (health.RunAggregate, bool) {
+	runHistory.Lock()
+	defer runHistory.Unlock()
+
+	history, exists := runHistory.checks[stateKey(name, namespace)]
+	if !exists || n <= 0 || len(history.runs) == 0 {
+		return health.RunAggregate{}, false
+	}
+
+	runs := history.runs
+	if len(runs) > n {
+		runs = runs[len(runs)-n:]
+	}
+	aggregate := health.RunAggregate{Runs: len(runs), Status: health.StatusOK}
+	for _, run := range runs {
+		if run.OK {
+			aggregate.Passed++
+			continue
+		}
+		aggregate.Failed++
+	}
+	if aggregate.Failed >= aggregateFailThreshold(n) {
+		aggregate.Status = health.StatusNotOK
+	}
+	return aggregate, true
+}
+
+// markAggregates sets the rollup of the last n runs of every check.  Nothing is set when n is 0 or for checks
+// without run history.
+func markAggregates(checkDetails map[string]health.WorkloadDetails, n int) {
+	if n <= 0 {
+		return
+	}
+	for key, details := range checkDetails {
+		namespace, name := splitCheckKey(key)
+		aggregate, ok := aggregateWindow(name, namespace, n)
+		if !ok {
+			continue
+		}
+		details.Aggregate = &aggregate
+		checkDetails[key] = details
+	}
+}
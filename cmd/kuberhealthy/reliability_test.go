@@ -0,0 +1,101 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// TestComputeReliability ensures that reliability is the percentage of OK runs within the window and that windows
+// the run history does not cover report insufficient data
+func TestComputeReliability(t *testing.T) {
+	originalWindow := cfg.ReliabilityWindow
+	defer func() {
+		cfg.ReliabilityWindow = originalWindow
+	}()
+	cfg.ReliabilityWindow = time.Hour * 2
+
+	start := time.Now().Add(-time.Hour * 3)
+	for i := 0; i < 12; i++ {
+		// fail one run in four
+		recordRun("reliability-check", "kuberhealthy", i%4 != 0, start.Add(time.Minute*15*time.Duration(i)))
+	}
+	now := start.Add(time.Minute * 15 * 11)
+
+	tests := []struct {
+		window     time.Duration
+		sufficient bool
+		percent    float64
+	}{
+		{window: time.Hour, sufficient: true, percent: 80},
+		{window: time.Hour * 2, sufficient: true, percent: 7.0 / 9.0 * 100},
+		{window: time.Hour * 3, sufficient: false}, // longer than the retained history
+	}
+	for _, test := range tests {
+		percent, sufficient := computeReliabilityAt("reliability-check", "kuberhealthy", test.window, now)
+		if sufficient != test.sufficient {
+			t.Fatalf("window %s: expected sufficient data to be %t, got %t", test.window, test.sufficient, sufficient)
+		}
+		if sufficient && math.Abs(percent-test.percent) > 0.001 {
+			t.Fatalf("window %s: expected reliability %f, got %f", test.window, test.percent, percent)
+		}
+	}
+
+	// a check that has only just started running does not claim to be 100% reliable
+	recordRun("new-check", "kuberhealthy", true, now)
+	_, sufficient := computeReliabilityAt("new-check", "kuberhealthy", time.Hour, now)
+	if sufficient {
+		t.Fatal("expected a new check to have insufficient data")
+	}
+}
+
+// TestAggregateWindow ensures that the last runs of a check are rolled up and that the rollup fails once the failed
+// runs reach the threshold
+func TestAggregateWindow(t *testing.T) {
+	originalThreshold := cfg.AggregateFailThreshold
+	defer func() {
+		cfg.AggregateFailThreshold = originalThreshold
+	}()
+	cfg.AggregateFailThreshold = 0
+
+	start := time.Now()
+	for i, ok := range []bool{false, false, false, true, false, true, true, false, true, true, true, false} {
+		recordRun("flapping-check", "kuberhealthy", ok, start.Add(time.Minute*time.Duration(i)))
+	}
+
+	aggregate, ok := aggregateWindow("flapping-check", "kuberhealthy", 10)
+	if !ok || aggregate.Runs != 10 || aggregate.Passed != 6 || aggregate.Failed != 4 {
+		t.Fatalf("expected 6 of the last 10 runs to have passed, got %+v", aggregate)
+	}
+	if aggregate.Status != health.StatusOK {
+		t.Fatal("expected a minority of failed runs to roll up as OK, got", aggregate.Status)
+	}
+
+	cfg.AggregateFailThreshold = 3
+	aggregate, _ = aggregateWindow("flapping-check", "kuberhealthy", 10)
+	if aggregate.Status != health.StatusNotOK {
+		t.Fatal("expected 4 failed runs to reach a threshold of 3, got", aggregate.Status)
+	}
+
+	// checks with fewer runs than requested roll up what they have
+	aggregate, _ = aggregateWindow("flapping-check", "kuberhealthy", 50)
+	if aggregate.Runs != 12 {
+		t.Fatal("expected every run to be rolled up when fewer runs were recorded, got", aggregate.Runs)
+	}
+	if _, ok := aggregateWindow("unknown-check", "kuberhealthy", 10); ok {
+		t.Fatal("expected a check without runs to have no rollup")
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/rest"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// resourceUsageTimeout is how long metrics-server has to return the resource usage of a checker pod
+const resourceUsageTimeout = time.Second * 5
+
+// podMetrics is the part of a metrics-server PodMetrics resource that resource usage is read from
+type podMetrics struct {
+	Timestamp  time.Time `json:"timestamp"`
+	Containers []struct {
+		Name  string                       `json:"name"`
+		Usage map[string]resource.Quantity `json:"usage"`
+	} `json:"containers"`
+}
+
+// podResourceUsage fetches the CPU and memory used by all containers of a pod from metrics-server
+func podResourceUsage(client rest.Interface, namespace string, podName string) (*health.ResourceUsage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), resourceUsageTimeout)
+	defer cancel()
+
+	b, err := client.Get().AbsPath("/apis/metrics.k8s.io/v1beta1/namespaces", namespace, "pods", podName).DoRaw(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching pod metrics from metrics-server: %w", err)
+	}
+	metrics := podMetrics{}
+	err = json.Unmarshal(b, &metrics)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding pod metrics from metrics-server: %w", err)
+	}
+
+	cpu := resource.Quantity{}
+	memory := resource.Quantity{}
+	for _, container := range metrics.Containers {
+		if q, ok := container.Usage["cpu"]; ok {
+			cpu.Add(q)
+		}
+		if q, ok := container.Usage["memory"]; ok {
+			memory.Add(q)
+		}
+	}
+	return &health.ResourceUsage{
+		CPU:       cpu.String(),
+		Memory:    memory.String(),
+		PodName:   podName,
+		Timestamp: metrics.Timestamp,
+	}, nil
+}
+
+// lookupResourceUsage returns the resource usage of a checker pod when resource usage recording is enabled.
+// metrics-server is not installed in every cluster and may not have sampled short-lived pods yet, so failures
+// are logged and nil is returned so that the result of the check is still recorded.
+func lookupResourceUsage(namespace string, podName string) *health.ResourceUsage {
+	if !cfg.RecordResourceUsage || kubernetesClient == nil {
+		return nil
+	}
+	usage, err := podResourceUsage(kubernetesClient.CoreV1().RESTClient(), namespace, podName)
+	if err != nil {
+		log.Debugln(namespace, podName, "not recording checker pod resource usage:", err)
+		return nil
+	}
+	return usage
+}
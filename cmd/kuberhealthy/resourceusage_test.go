@@ -0,0 +1,83 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// TestPodResourceUsage ensures that the usage of every container in a checker pod is added up and that a missing
+// metrics-server is an error instead of a panic
+func TestPodResourceUsage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/apis/metrics.k8s.io/v1beta1/namespaces/kuberhealthy/pods/checker-pod" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"timestamp":"2020-10-01T12:00:00Z","containers":[
+			{"name":"main","usage":{"cpu":"10m","memory":"20Mi"}},
+			{"name":"sidecar","usage":{"cpu":"5m","memory":"4Mi"}}]}`))
+	}))
+	defer server.Close()
+
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usage, err := podResourceUsage(client.CoreV1().RESTClient(), "kuberhealthy", "checker-pod")
+	if err != nil {
+		t.Fatal("unexpected error fetching pod resource usage:", err)
+	}
+	if usage.CPU != "15m" || usage.Memory != "24Mi" || usage.PodName != "checker-pod" || usage.Timestamp.IsZero() {
+		t.Fatalf("expected 15m of CPU and 24Mi of memory for checker-pod, got %+v", usage)
+	}
+
+	_, err = podResourceUsage(client.CoreV1().RESTClient(), "kuberhealthy", "missing-pod")
+	if err == nil {
+		t.Fatal("expected an error when metrics-server has no metrics for the pod")
+	}
+}
+
+// TestRunCheckKeepsResourceUsage ensures that the resource usage sampled when the checker reported is still stored
+// once runCheck has written the result of the finished run
+func TestRunCheckKeepsResourceUsage(t *testing.T) {
+	c := &FakeCheck{CheckName: "usage-check", Namespace: "kuberhealthy", OK: true}
+	usage := &health.ResourceUsage{CPU: "15m", Memory: "24Mi", PodName: "usage-check-1234"}
+	reported := health.WorkloadDetails{OK: true, Status: health.StatusOK, Namespace: "kuberhealthy", ResourceUsage: usage}
+
+	stored := runCheckOnce(t, c, reported)
+	if stored.ResourceUsage == nil || *stored.ResourceUsage != *usage {
+		t.Fatalf("expected the resource usage %+v to be kept after the run, got %+v", usage, stored.ResourceUsage)
+	}
+}
+
+// TestRunJobKeepsResourceUsage ensures that the resource usage sampled when a khjob reported is still stored once
+// runJob has written the result of the finished run
+func TestRunJobKeepsResourceUsage(t *testing.T) {
+	c := &FakeCheck{CheckName: "usage-job", Namespace: "kuberhealthy", OK: true}
+	usage := &health.ResourceUsage{CPU: "20m", Memory: "32Mi", PodName: "usage-job-1234"}
+	reported := health.WorkloadDetails{OK: true, Status: health.StatusOK, Namespace: "kuberhealthy", ResourceUsage: usage}
+
+	stored := runJobOnce(t, c, reported)
+	if stored.ResourceUsage == nil || *stored.ResourceUsage != *usage {
+		t.Fatalf("expected the resource usage %+v to be kept after the run, got %+v", usage, stored.ResourceUsage)
+	}
+}
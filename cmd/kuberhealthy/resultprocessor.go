@@ -0,0 +1,160 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// ResultProcessor transforms a check result before it is written to its khstate.  Process returns the result to
+// write in place of the one it was given.  A returned error does not stop the result from being written: the
+// returned result is still passed down the chain and written, and the error is returned by the write afterwards.
+type ResultProcessor interface {
+	Name() string
+	Process(checkName string, checkNamespace string, state health.WorkloadDetails) (health.WorkloadDetails, error)
+}
+
+// The names of the built-in result processors
+const (
+	resultProcessorRedact       = "redact"       // masks sensitive data with the redaction patterns of the check
+	resultProcessorSize         = "size"         // keeps the result within the size limits of a khstate
+	resultProcessorDedupeErrors = "dedupeErrors" // drops errors that were already reported in the same result
+)
+
+// defaultResultProcessors is the chain check results are run through when no chain is configured
+var defaultResultProcessors = []string{resultProcessorRedact, resultProcessorSize}
+
+// resultProcessors are the result processors that can be named in the chain, keyed by name
+var resultProcessors = struct {
+	sync.RWMutex
+	processors map[string]ResultProcessor
+	unknown    map[string]bool // names in the chain that were logged as unknown
+}{
+	processors: map[string]ResultProcessor{
+		resultProcessorRedact:       redactProcessor{},
+		resultProcessorSize:         sizeProcessor{},
+		resultProcessorDedupeErrors: dedupeErrorsProcessor{},
+	},
+	unknown: make(map[string]bool),
+}
+
+// registerResultProcessor makes a result processor available to the chain under its name, replacing any processor
+// already registered with the same name
+func registerResultProcessor(processor ResultProcessor) {
+	resultProcessors.Lock()
+	defer resultProcessors.Unlock()
+	resultProcessors.processors[processor.Name()] = processor
+	delete(resultProcessors.unknown, processor.Name())
+}
+
+// resultProcessorChain returns the configured result processors in the order they run.  Unknown names are logged
+// once and left out of the chain.
+func resultProcessorChain() []ResultProcessor {
+	names := cfg.ResultProcessors
+	if names == nil {
+		names = defaultResultProcessors
+	}
+
+	resultProcessors.Lock()
+	defer resultProcessors.Unlock()
+	chain := make([]ResultProcessor, 0, len(names))
+	for _, name := range names {
+		processor, exists := resultProcessors.processors[name]
+		if !exists {
+			if !resultProcessors.unknown[name] {
+				log.Warningln("Skipping unknown result processor", name, "in the result processor chain")
+				resultProcessors.unknown[name] = true
+			}
+			continue
+		}
+		chain = append(chain, processor)
+	}
+	return chain
+}
+
+// processCheckResult runs a check result through the result processor chain.  The first error returned by a
+// processor is returned along with the processed result.
+func processCheckResult(checkName string, checkNamespace string, state health.WorkloadDetails) (health.WorkloadDetails, error) {
+	var firstErr error
+	for _, processor := range resultProcessorChain() {
+		var err error
+		state, err = processor.Process(checkName, checkNamespace, state)
+		if err != nil {
+			log.Debugln(checkNamespace, checkName, "result processor", processor.Name(), "returned an error:", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return state, firstErr
+}
+
+// redactProcessor masks sensitive data in check results
+type redactProcessor struct{}
+
+// Name returns the name of the processor in the chain
+func (redactProcessor) Name() string {
+	return resultProcessorRedact
+}
+
+// Process masks sensitive data in the result with the redaction patterns of the check
+func (redactProcessor) Process(checkName string, checkNamespace string, state health.WorkloadDetails) (health.WorkloadDetails, error) {
+	return redactCheckState(checkName, checkNamespace, state), nil
+}
+
+// sizeProcessor keeps check results within the size limits of a khstate
+type sizeProcessor struct{}
+
+// Name returns the name of the processor in the chain
+func (sizeProcessor) Name() string {
+	return resultProcessorSize
+}
+
+// Process truncates or rejects a result that is too large to store.  ErrStateTooLarge is returned when the result
+// was replaced with a failure saying that it was too large.
+func (sizeProcessor) Process(checkName string, checkNamespace string, state health.WorkloadDetails) (health.WorkloadDetails, error) {
+	state, tooLarge := boundStateSize(checkName, stateNamespaceForWorkload(checkName, checkNamespace, workloadOf(state)), state)
+	if tooLarge {
+		return state, ErrStateTooLarge
+	}
+	return state, nil
+}
+
+// dedupeErrorsProcessor drops repeated errors from check results
+type dedupeErrorsProcessor struct{}
+
+// Name returns the name of the processor in the chain
+func (dedupeErrorsProcessor) Name() string {
+	return resultProcessorDedupeErrors
+}
+
+// Process keeps the first of every error that is reported more than once, in the order they were reported
+func (dedupeErrorsProcessor) Process(checkName string, checkNamespace string, state health.WorkloadDetails) (health.WorkloadDetails, error) {
+	if len(state.Errors) < 2 {
+		return state, nil
+	}
+	seen := make(map[string]bool, len(state.Errors))
+	errs := make([]string, 0, len(state.Errors))
+	for _, e := range state.Errors {
+		if seen[e] {
+			continue
+		}
+		seen[e] = true
+		errs = append(errs, e)
+	}
+	state.Errors = errs
+	return state, nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// suffixProcessor is a result processor that appends a suffix to every error
+type suffixProcessor struct {
+	suffix string
+	err    error
+}
+
+// Name returns the name of the processor in the chain
+func (p suffixProcessor) Name() string {
+	return "suffix" + p.suffix
+}
+
+// Process appends the suffix to every error of the result
+func (p suffixProcessor) Process(checkName string, checkNamespace string, state health.WorkloadDetails) (health.WorkloadDetails, error) {
+	errs := make([]string, len(state.Errors))
+	for i, e := range state.Errors {
+		errs[i] = e + p.suffix
+	}
+	state.Errors = errs
+	return state, p.err
+}
+
+// TestProcessCheckResult ensures that check results run through the configured processors in order, that unknown
+// processors are skipped and that the first processor error is returned with the processed result
+func TestProcessCheckResult(t *testing.T) {
+	originalProcessors := cfg.ResultProcessors
+	defer func() {
+		cfg.ResultProcessors = originalProcessors
+	}()
+	failed := errors.New("suffix failed")
+	registerResultProcessor(suffixProcessor{suffix: "-a", err: failed})
+	registerResultProcessor(suffixProcessor{suffix: "-b"})
+
+	state := health.WorkloadDetails{Errors: []string{"broken", "broken", "timed out"}}
+	cfg.ResultProcessors = []string{resultProcessorDedupeErrors, "suffix-b", "missing", "suffix-a"}
+	processed, err := processCheckResult("processed-check", "kuberhealthy", state)
+	if err != failed {
+		t.Fatal("expected the error of the failing processor, got", err)
+	}
+	if strings.Join(processed.Errors, ",") != "broken-b-a,timed out-b-a" {
+		t.Fatal("expected the processors to run in the configured order, got", processed.Errors)
+	}
+	if len(state.Errors) != 3 {
+		t.Fatal("expected the supplied result to be left alone, got", state.Errors)
+	}
+
+	// an empty chain writes results as they are reported, and no chain uses the default processors
+	var loaded Config
+	err = yaml.Unmarshal([]byte("resultProcessors: []"), &loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ResultProcessors = loaded.ResultProcessors
+	if len(resultProcessorChain()) != 0 {
+		t.Fatal("expected an empty chain to have no processors, got", resultProcessorChain())
+	}
+	cfg.ResultProcessors = nil
+	chain := resultProcessorChain()
+	if len(chain) != 2 || chain[0].Name() != resultProcessorRedact || chain[1].Name() != resultProcessorSize {
+		t.Fatal("expected the default chain to redact and then bound the size of results, got", chain)
+	}
+}
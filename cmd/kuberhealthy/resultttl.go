@@ -0,0 +1,75 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// resultTTLs holds the result TTL of every loaded khcheck with an expiry policy, keyed by namespace/name
+var resultTTLs = struct {
+	sync.RWMutex
+	ttls map[string]time.Duration
+}{ttls: make(map[string]time.Duration)}
+
+// setResultTTLs replaces the result TTLs
+func setResultTTLs(ttls map[string]time.Duration) {
+	resultTTLs.Lock()
+	defer resultTTLs.Unlock()
+	resultTTLs.ttls = ttls
+}
+
+// resultTTLFor returns the result TTL of a loaded check.  Zero means the check has no expiry policy of its own and
+// its results expire with the global result TTL.
+func resultTTLFor(checkName string, checkNamespace string) time.Duration {
+	resultTTLs.RLock()
+	defer resultTTLs.RUnlock()
+	return resultTTLs.ttls[checkNamespace+"/"+checkName]
+}
+
+// resultExpiryEnabled determines if any check results can expire
+func resultExpiryEnabled() bool {
+	return cfg.ResultTTL > 0 || cfg.ResultTTLMultiplier > 0 || len(cfg.ResultTTLOverrides) > 0
+}
+
+// resultTTLPolicy returns the result TTL of a check.  An override for the check takes precedence, otherwise the TTL
+// is the run interval of the check times the result TTL multiplier.  Zero means the check has no expiry policy of its
+// own.  A result is expected once every interval, so a TTL shorter than the run interval would expire results that
+// are on time and is raised to the run interval.
+func resultTTLPolicy(checkName string, checkNamespace string, runInterval time.Duration) time.Duration {
+	ttl, overridden := cfg.ResultTTLOverrides[checkNamespace+"/"+checkName]
+	if !overridden && cfg.ResultTTLMultiplier > 0 {
+		ttl = time.Duration(float64(runInterval) * cfg.ResultTTLMultiplier)
+	}
+	if ttl <= 0 {
+		return 0
+	}
+	if ttl < runInterval {
+		log.Warningln("Result TTL of", ttl, "for check", checkNamespace+"/"+checkName, "is shorter than its run interval. Using the run interval of", runInterval, "instead.")
+		return runInterval
+	}
+	return ttl
+}
+
+// stampResultTTL stores the result TTL of a check with its result, so that readers can tell when the result expires
+// without knowing the configuration of the check
+func stampResultTTL(checkName string, checkNamespace string, state *health.WorkloadDetails) {
+	state.ResultTTL = ""
+	if ttl := resultTTLFor(checkName, checkNamespace); ttl > 0 {
+		state.ResultTTL = ttl.String()
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// TestResultTTLPolicy ensures that result TTLs are derived from the run interval, that overrides take precedence and
+// that no TTL is shorter than the run interval
+func TestResultTTLPolicy(t *testing.T) {
+	originalMultiplier, originalOverrides := cfg.ResultTTLMultiplier, cfg.ResultTTLOverrides
+	defer func() { cfg.ResultTTLMultiplier, cfg.ResultTTLOverrides = originalMultiplier, originalOverrides }()
+
+	cfg.ResultTTLMultiplier = 0
+	cfg.ResultTTLOverrides = nil
+	if ttl := resultTTLPolicy("dns", "kuberhealthy", time.Minute); ttl != 0 {
+		t.Fatal("expected no result TTL without an expiry policy, got", ttl)
+	}
+
+	cfg.ResultTTLMultiplier = 3
+	cfg.ResultTTLOverrides = durationMap{"kuberhealthy/nightly": time.Hour * 36, "kuberhealthy/too-short": time.Second}
+	tests := []struct {
+		name     string
+		interval time.Duration
+		ttl      time.Duration
+	}{
+		{name: "dns", interval: time.Minute, ttl: time.Minute * 3},
+		{name: "nightly", interval: time.Hour * 24, ttl: time.Hour * 36},
+		{name: "too-short", interval: time.Minute * 5, ttl: time.Minute * 5},
+	}
+	for _, tc := range tests {
+		if ttl := resultTTLPolicy(tc.name, "kuberhealthy", tc.interval); ttl != tc.ttl {
+			t.Fatalf("expected a result TTL of %s for %s, got %s", tc.ttl, tc.name, ttl)
+		}
+	}
+}
+
+// TestResultTTLStoredWithResult ensures that the result TTL of a check is stored with its results and that reading
+// the check state marks expiry with the stored TTL
+func TestResultTTLStoredWithResult(t *testing.T) {
+	defer setResultTTLs(make(map[string]time.Duration))
+	setResultTTLs(map[string]time.Duration{"kuberhealthy/ttl-check": time.Minute * 3})
+
+	stored := khstatecrd.NewKuberhealthyState("ttl-check", health.WorkloadDetails{Status: health.StatusUnknown, Errors: []string{}})
+	stored.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	stored.Kind = "KuberhealthyState"
+	stored.Namespace = "kuberhealthy"
+	useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPut {
+			stored = khstatecrd.KuberhealthyState{}
+			json.NewDecoder(r.Body).Decode(&stored)
+		}
+		json.NewEncoder(w).Encode(stored)
+	})
+
+	err := setCheckStateResource("ttl-check", "kuberhealthy", health.WorkloadDetails{OK: true, Errors: []string{}, Expired: true})
+	if err != nil {
+		t.Fatal("unexpected error writing the khstate:", err)
+	}
+	if stored.Spec.ResultTTL != "3m0s" || stored.Spec.Expired {
+		t.Fatalf("expected the result TTL to be stored without the expired flag, got %+v", stored.Spec)
+	}
+
+	// a result older than the stored TTL is expired when it is read, even without a global result TTL
+	stored.Spec.LastRun = time.Now().Add(-time.Minute * 5)
+	stateVersions.reset()
+	state, err := getCheckState(&FakeCheck{CheckName: "ttl-check", Namespace: "kuberhealthy"})
+	if err != nil {
+		t.Fatal("unexpected error reading the khstate:", err)
+	}
+	if !state.Expired {
+		t.Fatalf("expected the result to be expired by its stored TTL, got %+v", state)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"regexp"
+	"sync"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultRunIDPattern is the format run IDs must have when no pattern is configured.  UUIDs, ULIDs and most
+// other generated IDs match it.
+const defaultRunIDPattern = `^[A-Za-z0-9][A-Za-z0-9._:-]{0,127}$`
+
+// generatedRunIDPrefix starts the run IDs Kuberhealthy generates in place of invalid ones
+const generatedRunIDPrefix = "kuberhealthy-"
+
+// The reasons a reported run ID is invalid
+const (
+	invalidRunIDEmpty    = "empty"    // no run ID was reported
+	invalidRunIDFormat   = "format"   // the run ID does not match the run ID pattern of the check
+	invalidRunIDConstant = "constant" // the run ID was already reported by an earlier run of the check
+)
+
+// runIDPatterns holds the compiled run ID patterns by their expression.  Patterns that do not compile are held
+// as nil so that they are only logged once.
+var runIDPatterns = struct {
+	sync.Mutex
+	patterns map[string]*regexp.Regexp
+}{patterns: map[string]*regexp.Regexp{defaultRunIDPattern: regexp.MustCompile(defaultRunIDPattern)}}
+
+// recordedRun is the run ID last recorded for a check and the UUID of the checker run that reported it
+type recordedRun struct {
+	runID string
+	uuid  string
+}
+
+// recordedRunIDs holds the run ID last recorded for each check, keyed by namespace/name of the check
+var recordedRunIDs = struct {
+	sync.RWMutex
+	runs map[string]recordedRun
+}{runs: make(map[string]recordedRun)}
+
+// recordRunID remembers the run ID recorded for a check and the checker run that reported it
+func recordRunID(checkName string, checkNamespace string, runID string, runUUID string) {
+	recordedRunIDs.Lock()
+	defer recordedRunIDs.Unlock()
+	recordedRunIDs.runs[checkNamespace+"/"+checkName] = recordedRun{runID: runID, uuid: runUUID}
+}
+
+// recordedRunFor returns the run ID last recorded for a check
+func recordedRunFor(checkName string, checkNamespace string) (recordedRun, bool) {
+	recordedRunIDs.RLock()
+	defer recordedRunIDs.RUnlock()
+	run, ok := recordedRunIDs.runs[checkNamespace+"/"+checkName]
+	return run, ok
+}
+
+// runIDPatternForCheck returns the pattern the run IDs of a check must match.  Patterns that do not compile are
+// logged and fall back to the default pattern, so that a typo in the configuration never loses results.
+func runIDPatternForCheck(checkName string, checkNamespace string) *regexp.Regexp {
+	expression := cfg.RunIDPattern
+	if override, ok := cfg.RunIDPatternOverrides[checkNamespace+"/"+checkName]; ok {
+		expression = override
+	}
+	if expression == "" {
+		expression = defaultRunIDPattern
+	}
+
+	runIDPatterns.Lock()
+	defer runIDPatterns.Unlock()
+	pattern, compiled := runIDPatterns.patterns[expression]
+	if !compiled {
+		var err error
+		pattern, err = regexp.Compile(expression)
+		if err != nil {
+			log.Errorln("Invalid run ID pattern", expression, "configured for", checkNamespace+"/"+checkName+". Using the default pattern:", err)
+			pattern = nil
+		}
+		runIDPatterns.patterns[expression] = pattern
+	}
+	if pattern == nil {
+		return runIDPatterns.patterns[defaultRunIDPattern]
+	}
+	return pattern
+}
+
+// validateRunID returns why a run ID reported by a checker run is invalid, or nothing when it is valid.  A run ID
+// is constant when an earlier checker run of the check already reported it.  Reports from the same checker run,
+// such as retries, may repeat their run ID.
+func validateRunID(checkName string, checkNamespace string, runID string, runUUID string) string {
+	if len(runID) == 0 {
+		return invalidRunIDEmpty
+	}
+	if !runIDPatternForCheck(checkName, checkNamespace).MatchString(runID) {
+		return invalidRunIDFormat
+	}
+	if run, ok := recordedRunFor(checkName, checkNamespace); ok && run.runID == runID && run.uuid != runUUID {
+		return invalidRunIDConstant
+	}
+	return ""
+}
+
+// resolveRunID returns the run ID to record a reported result with.  Invalid run IDs are replaced with one
+// generated by Kuberhealthy, so that a checker with a broken run ID still has its results recorded instead of
+// dropped as duplicates.
+func resolveRunID(checkName string, checkNamespace string, runID string, runUUID string) string {
+	reason := validateRunID(checkName, checkNamespace, runID, runUUID)
+	if reason == "" {
+		return runID
+	}
+	invalidRunIDs.Inc(checkName, checkNamespace, reason)
+
+	generated := generatedRunIDPrefix + uuid.New().String()
+	switch reason {
+	case invalidRunIDEmpty:
+		log.Debugln(checkNamespace, checkName, "reported no run ID. Recording the result as run", generated)
+	case invalidRunIDFormat:
+		log.Warningln(checkNamespace, checkName, "reported run ID", runID, "which does not match the run ID pattern of the check. Recording the result as run", generated)
+	case invalidRunIDConstant:
+		log.Warningln(checkNamespace, checkName, "reported run ID", runID, "which an earlier run of the check already reported. Recording the result as run", generated)
+	}
+	return generated
+}
@@ -0,0 +1,92 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// TestResolveRunID ensures that empty, malformed and constant run IDs are replaced with generated ones, that
+// retried reports of the same run keep their run ID and that per-check patterns override the default
+func TestResolveRunID(t *testing.T) {
+	originalPattern, originalOverrides := cfg.RunIDPattern, cfg.RunIDPatternOverrides
+	defer func() { cfg.RunIDPattern, cfg.RunIDPatternOverrides = originalPattern, originalOverrides }()
+	cfg.RunIDPattern = ""
+	cfg.RunIDPatternOverrides = stringMap{"kuberhealthy/numbered-runs": `^run-[0-9]+$`, "kuberhealthy/broken-pattern": `([`}
+
+	isGenerated := func(runID string) bool {
+		return strings.HasPrefix(runID, generatedRunIDPrefix)
+	}
+
+	if runID := resolveRunID("runid-check", "kuberhealthy", "5a4fa2b4-3b7e-4bd5-9d6c-64f1c1d2e4a1", "uuid-1"); runID != "5a4fa2b4-3b7e-4bd5-9d6c-64f1c1d2e4a1" {
+		t.Fatal("expected a valid run ID to be kept, got", runID)
+	}
+	if runID := resolveRunID("runid-check", "kuberhealthy", "", "uuid-1"); !isGenerated(runID) || runID == resolveRunID("runid-check", "kuberhealthy", "", "uuid-1") {
+		t.Fatal("expected a unique generated run ID in place of an empty one, got", runID)
+	}
+	if runID := resolveRunID("runid-check", "kuberhealthy", "run id with spaces", "uuid-1"); !isGenerated(runID) {
+		t.Fatal("expected a generated run ID in place of a malformed one, got", runID)
+	}
+
+	// a run ID may be repeated by retries of the same checker run but not by the next run
+	recordRunID("runid-check", "kuberhealthy", "constant", "uuid-1")
+	if runID := resolveRunID("runid-check", "kuberhealthy", "constant", "uuid-1"); runID != "constant" {
+		t.Fatal("expected a retried report to keep its run ID, got", runID)
+	}
+	if runID := resolveRunID("runid-check", "kuberhealthy", "constant", "uuid-2"); !isGenerated(runID) {
+		t.Fatal("expected a generated run ID in place of one reported by an earlier run, got", runID)
+	}
+
+	// per-check patterns replace the default, and broken ones fall back to it
+	if runID := resolveRunID("numbered-runs", "kuberhealthy", "run-42", "uuid-1"); runID != "run-42" {
+		t.Fatal("expected a run ID matching the check pattern to be kept, got", runID)
+	}
+	if runID := resolveRunID("numbered-runs", "kuberhealthy", "5a4fa2b4", "uuid-1"); !isGenerated(runID) {
+		t.Fatal("expected a run ID not matching the check pattern to be replaced, got", runID)
+	}
+	if runID := resolveRunID("broken-pattern", "kuberhealthy", "5a4fa2b4", "uuid-1"); runID != "5a4fa2b4" {
+		t.Fatal("expected a broken pattern to fall back to the default, got", runID)
+	}
+}
+
+// TestRunCheckKeepsRunID ensures that the run ID reported during a run is still stored once runCheck has written
+// the result of the finished run, so that retried reports of the run are not recorded again
+func TestRunCheckKeepsRunID(t *testing.T) {
+	c := &FakeCheck{CheckName: "runid-run-check", Namespace: "kuberhealthy", OK: true}
+	reported := health.WorkloadDetails{OK: true, Status: health.StatusOK, Namespace: "kuberhealthy", RunID: "run-42"}
+
+	stored := runCheckOnce(t, c, reported)
+	if stored.RunID != "run-42" {
+		t.Fatalf("expected run ID run-42 to be kept after the run, got %q", stored.RunID)
+	}
+	if len(stored.RunDuration) == 0 {
+		t.Fatal("expected the run duration to be written along with the reported run ID")
+	}
+}
+
+// TestRunJobKeepsRunID ensures that the run ID reported during the run of a khjob is still stored once runJob has
+// written the result of the finished run
+func TestRunJobKeepsRunID(t *testing.T) {
+	c := &FakeCheck{CheckName: "runid-run-job", Namespace: "kuberhealthy", OK: true}
+	reported := health.WorkloadDetails{OK: true, Status: health.StatusOK, Namespace: "kuberhealthy", RunID: "run-43"}
+
+	stored := runJobOnce(t, c, reported)
+	if stored.RunID != "run-43" {
+		t.Fatalf("expected run ID run-43 to be kept after the run, got %q", stored.RunID)
+	}
+	if len(stored.RunDuration) == 0 {
+		t.Fatal("expected the run duration to be written along with the reported run ID")
+	}
+}
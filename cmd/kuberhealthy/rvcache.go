@@ -0,0 +1,146 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// stateVersionCache holds the khstates most recently written by this instance so that the next write of a check
+// can skip fetching the current resource version from the API server
+type stateVersionCache struct {
+	sync.Mutex
+	states map[string]*khstatecrd.KuberhealthyState // keyed by namespace/name
+}
+
+// stateVersions is the resource version cache used by updateCheckStateResource
+var stateVersions = newStateVersionCache()
+
+func newStateVersionCache() *stateVersionCache {
+	return &stateVersionCache{
+		states: make(map[string]*khstatecrd.KuberhealthyState),
+	}
+}
+
+// get returns a copy of the cached khstate for a check, or nil if it is not cached
+func (c *stateVersionCache) get(name string, namespace string) *khstatecrd.KuberhealthyState {
+	c.Lock()
+	defer c.Unlock()
+	cached, ok := c.states[namespace+"/"+name]
+	if !ok {
+		return nil
+	}
+	out := khstatecrd.KuberhealthyState{}
+	cached.DeepCopyInto(&out)
+	return &out
+}
+
+// set caches the khstate returned by the API server after a successful write
+func (c *stateVersionCache) set(state *khstatecrd.KuberhealthyState) {
+	c.Lock()
+	defer c.Unlock()
+	c.states[state.GetNamespace()+"/"+state.GetName()] = state
+}
+
+// invalidate removes a check from the cache so that its next write fetches a fresh copy from the API server
+func (c *stateVersionCache) invalidate(name string, namespace string, reason string) {
+	c.Lock()
+	defer c.Unlock()
+	key := namespace + "/" + name
+	if _, ok := c.states[key]; !ok {
+		return
+	}
+	delete(c.states, key)
+	stateVersionCacheInvalidations.Inc(reason)
+}
+
+// prime caches the listed khstates that are not cached yet and returns how many were added.  A khstate that is
+// already cached was written after the list was taken, so its cached copy is newer and is kept.
+func (c *stateVersionCache) prime(states []khstatecrd.KuberhealthyState) int {
+	c.Lock()
+	defer c.Unlock()
+	var primed int
+	for i := range states {
+		key := states[i].GetNamespace() + "/" + states[i].GetName()
+		if _, ok := c.states[key]; ok {
+			continue
+		}
+		primedState := khstatecrd.KuberhealthyState{}
+		states[i].DeepCopyInto(&primedState)
+		c.states[key] = &primedState
+		primed++
+	}
+	return primed
+}
+
+// reset removes every check from the cache
+func (c *stateVersionCache) reset() {
+	c.Lock()
+	defer c.Unlock()
+	c.states = make(map[string]*khstatecrd.KuberhealthyState)
+}
+
+// isResourceVersionTooOld determines if a write failed because the resource version it used has been compacted
+// away, which happens when the API server or etcd restarts
+func isResourceVersionTooOld(err error) bool {
+	return k8sErrors.IsGone(err) || k8sErrors.IsResourceExpired(err)
+}
+
+// stateVersionCacheInvalidationReason determines why a failed write should drop a cached khstate.  A blank reason
+// means the error has nothing to do with the cached version.
+func stateVersionCacheInvalidationReason(err error) string {
+	switch {
+	case isResourceVersionTooOld(err):
+		return "expired"
+	case k8sErrors.IsConflict(err):
+		return "conflict"
+	case k8sErrors.IsNotFound(err):
+		return "not-found"
+	}
+	return ""
+}
+
+// primeStateVersionCache lists every khstate with the write client and caches their resource versions, so that the
+// first write of each check after a restart goes straight to an update.  A version that goes stale before the
+// first write fails that write with a conflict, which drops it from the cache and retries with a fresh copy.
+func primeStateVersionCache() error {
+	listOptions := metav1.ListOptions{Limit: stateListPageSize}
+	var primed int
+	for {
+		khStates, err := khStateClient.List(listOptions, stateCRDResource, "")
+		if err != nil {
+			return fmt.Errorf("error listing khstates to prime the resource version cache: %w", err)
+		}
+
+		var managed []khstatecrd.KuberhealthyState
+		for _, khState := range khStates.Items {
+			if stateNamespaceManaged(khState.GetNamespace()) {
+				managed = append(managed, khState)
+			}
+		}
+		primed += stateVersions.prime(managed)
+
+		if len(khStates.GetContinue()) == 0 {
+			break
+		}
+		listOptions.Continue = khStates.GetContinue()
+	}
+	log.Infoln("control: primed the resource version cache with", primed, "khstates")
+	return nil
+}
@@ -0,0 +1,171 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+	"github.com/Comcast/kuberhealthy/v2/pkg/metrics"
+)
+
+// TestStateVersionCacheRecoversFromExpiredVersion ensures that a cached resource version that the API server no
+// longer accepts is dropped and replaced with a freshly fetched one instead of being retried forever
+func TestStateVersionCacheRecoversFromExpiredVersion(t *testing.T) {
+	existing := khstatecrd.NewKuberhealthyState("cached-check", health.WorkloadDetails{OK: true})
+	existing.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	existing.Kind = "KuberhealthyState"
+	existing.Namespace = "kuberhealthy"
+	existing.ResourceVersion = "1"
+
+	var gets, updates int32
+	var expireNextUpdate int32
+	useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodGet:
+			atomic.AddInt32(&gets, 1)
+			json.NewEncoder(w).Encode(existing)
+		case http.MethodPut:
+			atomic.AddInt32(&updates, 1)
+			if atomic.CompareAndSwapInt32(&expireNextUpdate, 1, 0) {
+				w.WriteHeader(http.StatusGone)
+				json.NewEncoder(w).Encode(metav1.Status{
+					TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+					Status:   metav1.StatusFailure,
+					Code:     http.StatusGone,
+					Reason:   metav1.StatusReasonExpired,
+					Message:  "resource version too old",
+				})
+				return
+			}
+			b, _ := ioutil.ReadAll(r.Body)
+			w.Write(b)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
+	write := func() {
+		err := setCheckStateResource("cached-check", "kuberhealthy", health.WorkloadDetails{OK: true})
+		if err != nil {
+			t.Fatal("unexpected error writing khstate:", err)
+		}
+	}
+
+	// the first write fetches the state and the second uses the version cached by the first
+	write()
+	write()
+	if gets != 1 || updates != 2 {
+		t.Fatalf("expected 1 get and 2 updates with a cached version but got %d gets and %d updates", gets, updates)
+	}
+
+	// simulate the API server compacting the cached version away
+	atomic.StoreInt32(&expireNextUpdate, 1)
+	write()
+	if gets != 2 || updates != 4 {
+		t.Fatalf("expected the expired version to be refetched and written but got %d gets and %d updates", gets, updates)
+	}
+	if !strings.Contains(metrics.GenerateRegisteredMetrics(), `kuberhealthy_state_version_cache_invalidations_total{reason="expired"} 1`) {
+		t.Fatal("expected the cache invalidation to be counted")
+	}
+
+	// the version fetched after the invalidation is cached again
+	write()
+	if gets != 2 {
+		t.Fatalf("expected the refetched version to be cached but got %d gets", gets)
+	}
+}
+
+// TestPrimeStateVersionCache ensures that priming the cache lets the first write of a check skip fetching its
+// khstate and that a primed version that went stale is recovered through the conflict retry
+func TestPrimeStateVersionCache(t *testing.T) {
+	newState := func(name string, resourceVersion string) khstatecrd.KuberhealthyState {
+		state := khstatecrd.NewKuberhealthyState(name, health.WorkloadDetails{OK: true})
+		state.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+		state.Kind = "KuberhealthyState"
+		state.Namespace = "kuberhealthy"
+		state.ResourceVersion = resourceVersion
+		return state
+	}
+	stored := map[string]khstatecrd.KuberhealthyState{
+		"fresh": newState("fresh", "1"),
+		"stale": newState("stale", "1"),
+	}
+
+	var gets, updates int32
+	useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		name := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		switch {
+		case r.Method == http.MethodGet && name == stateCRDResource:
+			list := khstatecrd.KuberhealthyStateList{Items: []khstatecrd.KuberhealthyState{stored["fresh"], stored["stale"]}}
+			list.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+			list.Kind = "KuberhealthyStateList"
+			json.NewEncoder(w).Encode(list)
+
+			// another writer updates the stale check right after the list
+			stale := stored["stale"]
+			stale.ResourceVersion = "2"
+			stored["stale"] = stale
+		case r.Method == http.MethodGet:
+			atomic.AddInt32(&gets, 1)
+			json.NewEncoder(w).Encode(stored[name])
+		case r.Method == http.MethodPut:
+			atomic.AddInt32(&updates, 1)
+			written := khstatecrd.KuberhealthyState{}
+			json.NewDecoder(r.Body).Decode(&written)
+			if written.ResourceVersion != stored[name].ResourceVersion {
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).Encode(metav1.Status{
+					TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+					Status:   metav1.StatusFailure,
+					Code:     http.StatusConflict,
+					Reason:   metav1.StatusReasonConflict,
+				})
+				return
+			}
+			json.NewEncoder(w).Encode(written)
+		}
+	})
+
+	err := primeStateVersionCache()
+	if err != nil {
+		t.Fatal("unexpected error priming the resource version cache:", err)
+	}
+
+	// the fresh check goes straight to an update
+	err = setCheckStateResource("fresh", "kuberhealthy", health.WorkloadDetails{OK: true})
+	if err != nil {
+		t.Fatal("unexpected error writing khstate:", err)
+	}
+	if gets != 0 || updates != 1 {
+		t.Fatalf("expected 0 gets and 1 update with a primed version but got %d gets and %d updates", gets, updates)
+	}
+
+	// the stale check conflicts, is refetched and written
+	err = setCheckStateResource("stale", "kuberhealthy", health.WorkloadDetails{OK: true})
+	if err != nil {
+		t.Fatal("unexpected error writing khstate with a stale primed version:", err)
+	}
+	if gets != 1 || updates != 3 {
+		t.Fatalf("expected the stale version to be refetched and written but got %d gets and %d updates", gets, updates)
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// scheduleDelayBuckets are the upper bounds, in seconds, of the buckets of the schedule delay histogram
+var scheduleDelayBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 600}
+
+// stampScheduleDelay stores how long after its scheduled time the run that reported a result started with the
+// result, and observes the delay in the schedule delay histogram.  Results that were not reported by a scheduled
+// run, such as timeouts, have no schedule delay.
+func stampScheduleDelay(checkName string, checkNamespace string, state *health.WorkloadDetails) {
+	scheduled, started := state.Schedule()
+	if scheduled.IsZero() || started.IsZero() {
+		return
+	}
+	delay := started.Sub(scheduled)
+	if delay < 0 {
+		delay = 0
+	}
+	state.ScheduleDelay = delay.String()
+	checkScheduleDelay.Observe(delay.Seconds(), checkName, checkNamespace)
+}
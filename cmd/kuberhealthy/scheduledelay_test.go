@@ -0,0 +1,63 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+	"github.com/Comcast/kuberhealthy/v2/pkg/metrics"
+)
+
+// TestScheduleDelay ensures that the scheduling delay of a run is written with its result and observed in the
+// schedule delay histogram, and that results not reported by a scheduled run have no delay
+func TestScheduleDelay(t *testing.T) {
+	stored := khstatecrd.NewKuberhealthyState("delayed-check", health.WorkloadDetails{Status: health.StatusUnknown, Errors: []string{}})
+	stored.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	stored.Kind = "KuberhealthyState"
+	stored.Namespace = "kuberhealthy"
+	useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPut {
+			stored = khstatecrd.KuberhealthyState{}
+			json.NewDecoder(r.Body).Decode(&stored)
+		}
+		json.NewEncoder(w).Encode(stored)
+	})
+
+	scheduled := time.Now().Add(-time.Minute)
+	details := health.WorkloadDetails{OK: true, Errors: []string{}}
+	details.SetSchedule(scheduled, scheduled.Add(time.Second*12))
+	err := setCheckStateResource("delayed-check", "kuberhealthy", details)
+	if err != nil {
+		t.Fatal("unexpected error writing the khstate:", err)
+	}
+	if stored.Spec.ScheduleDelay != "12s" {
+		t.Fatal("expected a schedule delay of 12s to be written, got", stored.Spec.ScheduleDelay)
+	}
+	if !strings.Contains(metrics.GenerateRegisteredMetrics(), `kuberhealthy_check_schedule_delay_seconds_bucket{check="delayed-check",namespace="kuberhealthy",le="30"} 1`) {
+		t.Fatal("expected the schedule delay to be observed in the histogram")
+	}
+
+	err = setCheckStateResource("delayed-check", "kuberhealthy", health.WorkloadDetails{OK: false, Errors: []string{"timed out"}})
+	if err != nil {
+		t.Fatal("unexpected error writing the khstate:", err)
+	}
+	if len(stored.Spec.ScheduleDelay) > 0 {
+		t.Fatal("expected no schedule delay for a result without a scheduled run, got", stored.Spec.ScheduleDelay)
+	}
+}
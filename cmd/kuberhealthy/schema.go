@@ -0,0 +1,68 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"strings"
+
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// ErrStateRejected is returned when the API server rejected a check result because it did not match the schema of
+// the khstate CRD and an error saying so was stored in its place
+var ErrStateRejected = errors.New("check result was rejected by the khstate schema")
+
+// schemaViolations describes the fields of a khstate that the API server rejected, such as
+// "spec.Severity: Unsupported value: \"fatal\"".  The message of the error is used when it does not list any fields.
+func schemaViolations(err error) string {
+	var statusErr k8sErrors.APIStatus
+	if !errors.As(err, &statusErr) || statusErr.Status().Details == nil || len(statusErr.Status().Details.Causes) == 0 {
+		return err.Error()
+	}
+
+	var violations []string
+	for _, cause := range statusErr.Status().Details.Causes {
+		if len(cause.Field) == 0 {
+			violations = append(violations, cause.Message)
+			continue
+		}
+		violations = append(violations, cause.Field+": "+cause.Message)
+	}
+	return strings.Join(violations, ", ")
+}
+
+// schemaViolationState replaces a check result that the khstate schema rejected with a failure saying which fields
+// were rejected.  Only the fields that identify the run are kept, so that nothing of the rejected result is
+// written again.
+func schemaViolationState(checkName string, stateNamespace string, state health.WorkloadDetails, err error) health.WorkloadDetails {
+	violations := schemaViolations(err)
+	log.Errorln(stateNamespace, checkName, "check result was rejected by the khstate schema. Writing a schema violation error instead:", violations)
+	schemaRejections.Inc(checkName, stateNamespace)
+
+	return health.WorkloadDetails{
+		OK:                     false,
+		Status:                 health.StatusNotOK,
+		Errors:                 truncateErrors([]string{"check result was rejected by the khstate schema: " + violations}, maxErrorLength()),
+		Namespace:              state.Namespace,
+		CurrentUUID:            state.CurrentUUID,
+		RunID:                  state.RunID,
+		LastRun:                state.LastRun,
+		AuthoritativePod:       state.AuthoritativePod,
+		AuthoritativeNamespace: state.AuthoritativeNamespace,
+		ClusterScoped:          state.ClusterScoped,
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// TestSchemaRejectionFallback ensures that a result rejected by the khstate schema is replaced with a failure that
+// says which fields were rejected
+func TestSchemaRejectionFallback(t *testing.T) {
+	stored := khstatecrd.NewKuberhealthyState("schema-check", health.WorkloadDetails{OK: true})
+	stored.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	stored.Kind = "KuberhealthyState"
+	stored.Namespace = "kuberhealthy"
+
+	var recorded khstatecrd.KuberhealthyState
+	useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodGet:
+			json.NewEncoder(w).Encode(stored)
+		case http.MethodPut:
+			var state khstatecrd.KuberhealthyState
+			json.NewDecoder(r.Body).Decode(&state)
+			if state.Spec.Severity == "fatal" {
+				invalid := k8sErrors.NewInvalid(schema.GroupKind{Group: stateCRDGroup, Kind: "KuberhealthyState"}, "schema-check", field.ErrorList{
+					field.NotSupported(field.NewPath("spec", "Severity"), "fatal", []string{"info", "warning", "critical"}),
+				})
+				status := invalid.Status()
+				status.APIVersion, status.Kind = "v1", "Status"
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(w).Encode(status)
+				return
+			}
+			recorded = state
+			json.NewEncoder(w).Encode(recorded)
+		}
+	})
+
+	report := health.WorkloadDetails{OK: false, Errors: []string{"broken"}, Severity: "fatal", RunID: "run-1"}
+	written, err := setCheckStateResourceIf("schema-check", "kuberhealthy", report, nil)
+	if !written || !errors.Is(err, ErrStateRejected) {
+		t.Fatal("expected the schema violation to be written in place of the result, got", err)
+	}
+	if recorded.Spec.OK || recorded.Spec.RunID != "run-1" || recorded.Spec.Severity != "" || len(recorded.Spec.Errors) != 1 {
+		t.Fatalf("expected a minimal failure to be written, got %+v", recorded.Spec)
+	}
+	if !strings.Contains(recorded.Spec.Errors[0], "spec.Severity") {
+		t.Fatal("expected the error to name the rejected field, got", recorded.Spec.Errors[0])
+	}
+}
@@ -0,0 +1,25 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// failsOverallStatus determines if the errors of a workload fail the overall status.  Errors at any other
+// severity are shown as warnings instead.
+func failsOverallStatus(details health.WorkloadDetails) bool {
+	if len(cfg.FailingSeverities) == 0 {
+		return details.GetSeverity() == health.SeverityCritical
+	}
+	return containsString(string(details.GetSeverity()), cfg.FailingSeverities)
+}
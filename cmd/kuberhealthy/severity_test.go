@@ -0,0 +1,71 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// TestSeverityAffectsOverallStatus ensures that only errors at a failing severity fail the overall status
+func TestSeverityAffectsOverallStatus(t *testing.T) {
+	originalSeverities := cfg.FailingSeverities
+	defer func() {
+		cfg.FailingSeverities = originalSeverities
+	}()
+
+	details := map[string]health.WorkloadDetails{
+		"kuberhealthy/critical": {OK: false, Errors: []string{"down"}, Namespace: "kuberhealthy", AuthoritativePod: "kuberhealthy-1"},
+		"kuberhealthy/warning":  {OK: true, Errors: []string{"slow"}, Severity: health.SeverityWarning, Namespace: "kuberhealthy", AuthoritativePod: "kuberhealthy-1"},
+	}
+	namespaces := []string{"kuberhealthy"}
+
+	cfg.FailingSeverities = nil
+	state := validateCurrentStatusForNamespaces(details, namespaces, health.NewState(), health.KHCheck)
+	if state.OK || len(state.Errors) != 1 || state.Errors[0] != "down" {
+		t.Fatalf("expected only the critical error to fail the overall status, got OK %t and errors %v", state.OK, state.Errors)
+	}
+	if len(state.Warnings) != 1 || state.Warnings[0] != "slow" {
+		t.Fatalf("expected the warning to be shown as a warning, got %v", state.Warnings)
+	}
+
+	cfg.FailingSeverities = []string{string(health.SeverityWarning), string(health.SeverityCritical)}
+	state = validateCurrentStatusForNamespaces(details, namespaces, health.NewState(), health.KHCheck)
+	if len(state.Errors) != 2 || len(state.Warnings) != 0 {
+		t.Fatalf("expected warnings to fail the overall status when configured, got errors %v and warnings %v", state.Errors, state.Warnings)
+	}
+}
+
+// TestRunCheckKeepsWarnings ensures that a warning reported during a run is still a warning once runCheck has
+// written the result of the finished run
+func TestRunCheckKeepsWarnings(t *testing.T) {
+	c := &FakeCheck{CheckName: "warning-check", Namespace: "kuberhealthy", OK: true, Errors: []string{"slow"}}
+	reported := health.WorkloadDetails{OK: true, Status: health.StatusOK, Errors: []string{"slow"}, Severity: health.SeverityWarning, Namespace: "kuberhealthy"}
+
+	stored := runCheckOnce(t, c, reported)
+	if !stored.OK || stored.GetSeverity() != health.SeverityWarning || failsOverallStatus(stored) {
+		t.Fatalf("expected the warning to be kept after the run, got OK %t with severity %s", stored.OK, stored.GetSeverity())
+	}
+}
+
+// TestRunJobKeepsWarnings ensures that a warning reported during the run of a khjob is still a warning once
+// runJob has written the result of the finished run
+func TestRunJobKeepsWarnings(t *testing.T) {
+	c := &FakeCheck{CheckName: "warning-job", Namespace: "kuberhealthy", OK: true, Errors: []string{"slow"}}
+	reported := health.WorkloadDetails{OK: true, Status: health.StatusOK, Errors: []string{"slow"}, Severity: health.SeverityWarning, Namespace: "kuberhealthy"}
+
+	stored := runJobOnce(t, c, reported)
+	if !stored.OK || stored.GetSeverity() != health.SeverityWarning || failsOverallStatus(stored) {
+		t.Fatalf("expected the warning to be kept after the run, got OK %t with severity %s", stored.OK, stored.GetSeverity())
+	}
+}
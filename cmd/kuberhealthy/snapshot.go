@@ -0,0 +1,183 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// stateSnapshotVersion is the format version of state snapshots.  It must be bumped whenever the
+// format changes in a way that older versions of Kuberhealthy can not restore.
+const stateSnapshotVersion = "v1"
+
+// restoreMode determines what happens when a state being restored already exists
+type restoreMode string
+
+const (
+	restoreSkipExisting restoreMode = "skip"      // leave states that already exist alone
+	restoreOverwrite    restoreMode = "overwrite" // replace states that already exist with the snapshot
+)
+
+// stateSnapshot is a serializable bundle of khstates used for disaster recovery or moving between clusters
+type stateSnapshot struct {
+	Version  string
+	Created  time.Time
+	Checksum string // the sha256 of the JSON encoded States
+	States   []snapshotState
+}
+
+// snapshotState is a single khstate in a snapshot with all server-managed metadata removed
+type snapshotState struct {
+	Name      string
+	Namespace string
+	Spec      health.WorkloadDetails
+}
+
+// checksumSnapshotStates calculates the checksum of the states in a snapshot
+func checksumSnapshotStates(states []snapshotState) (string, error) {
+	b, err := json.Marshal(states)
+	if err != nil {
+		return "", fmt.Errorf("error marshaling snapshot states: %w", err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// snapshotAllStates fetches every khstate in the supplied namespace, or all namespaces when blank, and
+// returns them as a snapshot that can be restored later with restoreStates.  Encrypted fields are kept
+// encrypted, so a snapshot is no more sensitive than the khstates it was made from.
+func snapshotAllStates(namespace string) (stateSnapshot, error) {
+	snapshot := stateSnapshot{
+		Version: stateSnapshotVersion,
+		Created: time.Now(),
+	}
+
+	khStates, err := getAllCheckStates(namespace, projectionStored)
+	if err != nil {
+		return snapshot, fmt.Errorf("error listing khStates to snapshot: %w", err)
+	}
+
+	// sort the states so that snapshots of the same states are identical
+	var keys []string
+	for key := range khStates {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		stateNamespace, name := splitCheckKey(key)
+		snapshot.States = append(snapshot.States, snapshotState{
+			Name:      name,
+			Namespace: stateNamespace,
+			Spec:      khStates[key],
+		})
+	}
+
+	snapshot.Checksum, err = checksumSnapshotStates(snapshot.States)
+	if err != nil {
+		return snapshot, err
+	}
+
+	log.Infoln("Created snapshot of", len(snapshot.States), "khState resources")
+	return snapshot, nil
+}
+
+// validateStateSnapshot ensures that a snapshot was made by a compatible version of Kuberhealthy and
+// has not been modified or truncated since it was made
+func validateStateSnapshot(snapshot stateSnapshot) error {
+	if snapshot.Version != stateSnapshotVersion {
+		return fmt.Errorf("snapshot version %s is not compatible with version %s", snapshot.Version, stateSnapshotVersion)
+	}
+	checksum, err := checksumSnapshotStates(snapshot.States)
+	if err != nil {
+		return err
+	}
+	if checksum != snapshot.Checksum {
+		return fmt.Errorf("snapshot checksum %s does not match the checksum of its states %s", snapshot.Checksum, checksum)
+	}
+	return nil
+}
+
+// restoreStates recreates every khstate in a snapshot.  Existing states are skipped or overwritten
+// depending on the restore mode.
+func restoreStates(snapshot stateSnapshot, mode restoreMode) error {
+
+	if mode != restoreSkipExisting && mode != restoreOverwrite {
+		return fmt.Errorf("unknown restore mode: %s", mode)
+	}
+	if err := stateWritesBlocked(); err != nil {
+		return err
+	}
+	err := validateStateSnapshot(snapshot)
+	if err != nil {
+		return fmt.Errorf("refusing to restore snapshot: %w", err)
+	}
+
+	log.Infoln("Restoring", len(snapshot.States), "khState resources from snapshot created at", snapshot.Created, "with mode", mode)
+	var restoreErrors []string
+	for _, s := range snapshot.States {
+		err := restoreState(s, mode)
+		if err != nil {
+			restoreErrors = append(restoreErrors, s.Namespace+"/"+s.Name+": "+err.Error())
+		}
+	}
+
+	if len(restoreErrors) > 0 {
+		return errors.New("failed to restore khStates: " + strings.Join(restoreErrors, ", "))
+	}
+	return nil
+}
+
+// restoreState recreates a single khstate from a snapshot.  Fields that were encrypted in the snapshot must be
+// decryptable with the loaded keys to be restored in plaintext, and are otherwise restored encrypted.
+func restoreState(s snapshotState, mode restoreMode) error {
+
+	_, err := khStateClient.Get(metav1.GetOptions{}, stateCRDResource, s.Name, s.Namespace)
+	if k8sErrors.IsNotFound(err) {
+		log.Infoln("Restoring khState", s.Namespace+"/"+s.Name)
+		spec, err := encryptStateFields(s.Spec)
+		if err != nil {
+			return err
+		}
+		newState := khstatecrd.NewKuberhealthyState(s.Name, spec)
+		newState.Namespace = s.Namespace
+		newState.SyncReadyCondition()
+		return createStateResource(&newState, s.Namespace)
+	}
+	if err != nil {
+		return err
+	}
+
+	if mode == restoreSkipExisting {
+		log.Infoln("Skipping restore of khState", s.Namespace+"/"+s.Name, "because it already exists")
+		return nil
+	}
+
+	log.Infoln("Overwriting khState", s.Namespace+"/"+s.Name, "from snapshot")
+	return updateCheckStateResource(s.Name, s.Namespace, func(details *health.WorkloadDetails) bool {
+		*details = decryptStateFields(s.Spec)
+		return true
+	})
+}
@@ -0,0 +1,146 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultStatusSocketMode is the file mode of the status socket when none is configured.  Only the user and group
+// Kuberhealthy runs as can connect.
+const defaultStatusSocketMode os.FileMode = 0660
+
+// statusSocket serves the read API on a Unix domain socket for sidecars running next to Kuberhealthy
+type statusSocket struct {
+	path     string
+	server   *http.Server
+	listener net.Listener
+}
+
+// statusSocketMode parses the configured file mode of the status socket, which is given in octal such as 0660
+func statusSocketMode() (os.FileMode, error) {
+	if len(cfg.StatusSocketMode) == 0 {
+		return defaultStatusSocketMode, nil
+	}
+	mode, err := strconv.ParseUint(cfg.StatusSocketMode, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid status socket mode %q: %w", cfg.StatusSocketMode, err)
+	}
+	return os.FileMode(mode), nil
+}
+
+// readAPIHandler returns a handler for the read API that is served on the status socket.  The status page is served
+// at / and the check status API at its group version path, by the same handlers that serve them over HTTP, so
+// responses are identical on both.
+func (k *Kuberhealthy) readAPIHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(statusAPIPath, k.statusAPIHandlerFunc)
+	mux.HandleFunc(statusAPIPath+"/", k.statusAPIHandlerFunc)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		err := k.healthCheckHandler(w, r)
+		if err != nil {
+			log.Errorln("status socket error:", err)
+		}
+	})
+	return mux
+}
+
+// listenStatusSocket starts serving a handler on a Unix domain socket at the supplied path with the supplied file
+// mode.  A socket left behind at the path by a previous run is removed first.
+func listenStatusSocket(path string, mode os.FileMode, handler http.Handler) (*statusSocket, error) {
+	info, err := os.Lstat(path)
+	if err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("not replacing %s with the status socket because it is not a socket", path)
+		}
+		err = os.Remove(path)
+		if err != nil {
+			return nil, fmt.Errorf("error removing stale status socket %s: %w", path, err)
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on status socket %s: %w", path, err)
+	}
+	err = os.Chmod(path, mode)
+	if err != nil {
+		listener.Close()
+		os.Remove(path)
+		return nil, fmt.Errorf("error setting the mode of status socket %s: %w", path, err)
+	}
+
+	s := &statusSocket{path: path, listener: listener, server: &http.Server{Handler: handler}}
+	go func() {
+		err := s.server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Errorln("status socket error:", err)
+		}
+	}()
+	return s, nil
+}
+
+// Close stops serving on the status socket, waiting briefly for requests in flight, and removes the socket file
+func (s *statusSocket) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	err := s.server.Shutdown(ctx)
+	removeErr := os.Remove(s.path)
+	if removeErr != nil && !os.IsNotExist(removeErr) && err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+// startStatusSocket serves the read API on the configured status socket, if one is configured
+func (k *Kuberhealthy) startStatusSocket() {
+	if len(cfg.StatusSocketPath) == 0 {
+		return
+	}
+	mode, err := statusSocketMode()
+	if err != nil {
+		log.Errorln("Not serving the status socket:", err)
+		return
+	}
+	socket, err := listenStatusSocket(cfg.StatusSocketPath, mode, k.readAPIHandler())
+	if err != nil {
+		log.Errorln("Not serving the status socket:", err)
+		return
+	}
+	log.Infoln("Serving the status page on unix socket", cfg.StatusSocketPath)
+	k.statusSocket = socket
+}
+
+// stopStatusSocket stops serving the status socket and removes it
+func (k *Kuberhealthy) stopStatusSocket() {
+	if k.statusSocket == nil {
+		return
+	}
+	err := k.statusSocket.Close()
+	if err != nil {
+		log.Errorln("shutdown: error closing the status socket:", err)
+	}
+	k.statusSocket = nil
+}
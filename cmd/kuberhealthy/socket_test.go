@@ -0,0 +1,101 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// TestStatusSocket ensures that the read API is served on the status socket with the configured mode, that a stale
+// socket is replaced and that the socket is removed when it is closed
+func TestStatusSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "khsocket")
+	if err != nil {
+		t.Fatal("failed to create a directory for the socket:", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "status.sock")
+
+	// a socket left behind by a previous run is replaced
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal("failed to create a stale socket:", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	state := health.State{CheckDetails: map[string]health.WorkloadDetails{"kuberhealthy/dns": {OK: true, Status: health.StatusOK}}}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		statusAPIHandler(w, r, func() health.State { return state })
+	})
+	socket, err := listenStatusSocket(path, 0600, handler)
+	if err != nil {
+		t.Fatal("unexpected error serving the status socket:", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatal("expected the status socket to have mode 0600, got", info, err)
+	}
+
+	client := http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "unix", path)
+	}}}
+	resp, err := client.Get("http://kuberhealthy" + statusAPIPath + "/namespaces/kuberhealthy/kuberhealthystatuses/dns")
+	if err != nil {
+		t.Fatal("unexpected error reading from the status socket:", err)
+	}
+	var status kuberhealthyStatus
+	json.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+	if status.Name != "dns" || !status.Status.OK {
+		t.Fatalf("expected the dns check status over the socket, got %+v", status)
+	}
+
+	err = socket.Close()
+	if err != nil {
+		t.Fatal("unexpected error closing the status socket:", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("expected the status socket to be removed when closed, got", err)
+	}
+
+	// files that are not sockets are never replaced
+	err = ioutil.WriteFile(path, []byte("data"), 0644)
+	if err != nil {
+		t.Fatal("failed to write a file at the socket path:", err)
+	}
+	_, err = listenStatusSocket(path, 0600, handler)
+	if err == nil {
+		t.Fatal("expected a file that is not a socket to be refused")
+	}
+
+	originalMode := cfg.StatusSocketMode
+	defer func() { cfg.StatusSocketMode = originalMode }()
+	cfg.StatusSocketMode = "0640"
+	if mode, err := statusSocketMode(); err != nil || mode != 0640 {
+		t.Fatal("expected the configured octal mode, got", mode, err)
+	}
+	cfg.StatusSocketMode = "rw-rw----"
+	if _, err := statusSocketMode(); err == nil {
+		t.Fatal("expected an invalid mode to be refused")
+	}
+}
@@ -0,0 +1,302 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// The khstate fields that can be encrypted before they are written
+const (
+	encryptedFieldErrors      = "errors"
+	encryptedFieldLogTail     = "logTail"
+	encryptedFieldAnnotations = "annotations" // the values of the annotations.  Their keys stay plaintext
+)
+
+// encryptedValuePrefix starts every encrypted value.  The ID of the key it was encrypted with follows, then the
+// base64 of the nonce and ciphertext, such as khenc:v1:2021-06:c2VjcmV0
+const encryptedValuePrefix = "khenc:v1:"
+
+// ErrNoStateEncryptionKeys is returned when khstate fields are configured to be encrypted but no keys are loaded.
+// Nothing is written rather than writing the fields in plaintext.
+var ErrNoStateEncryptionKeys = errors.New("khstate fields are configured to be encrypted but no encryption keys are loaded")
+
+// stateEncryptionKeySize is the size of the AES-256 keys that khstate fields are encrypted with
+const stateEncryptionKeySize = 32
+
+// fieldCipher encrypts and decrypts khstate field values with AES-GCM.  Values are encrypted with the current key
+// and decrypted with whichever key they name, so that keys can be rotated without rewriting every khstate first.
+type fieldCipher struct {
+	keyID string                 // the ID of the key new values are encrypted with
+	keys  map[string]cipher.AEAD // every loaded key by ID
+}
+
+// stateCipher is the cipher khstate fields are encrypted and decrypted with.  nil when no keys are configured.
+var stateCipher = struct {
+	sync.RWMutex
+	cipher *fieldCipher
+}{}
+
+// setStateCipher replaces the cipher khstate fields are encrypted and decrypted with
+func setStateCipher(c *fieldCipher) {
+	stateCipher.Lock()
+	defer stateCipher.Unlock()
+	stateCipher.cipher = c
+}
+
+// currentStateCipher returns the cipher khstate fields are encrypted and decrypted with
+func currentStateCipher() *fieldCipher {
+	stateCipher.RLock()
+	defer stateCipher.RUnlock()
+	return stateCipher.cipher
+}
+
+// loadStateCipher loads the khstate encryption keys from the configured key directory.  Nothing is loaded when
+// no key directory is configured.  Fields can not be encrypted without keys, so configuring encrypted fields
+// without a key directory is an error.
+func loadStateCipher() error {
+	if len(cfg.StateEncryptionKeyDir) == 0 {
+		if len(cfg.StateEncryptionFields) > 0 {
+			return errors.New("khstate fields can not be encrypted without a stateEncryptionKeyDir")
+		}
+		setStateCipher(nil)
+		return nil
+	}
+	c, err := loadFieldCipher(cfg.StateEncryptionKeyDir, cfg.StateEncryptionKeyID)
+	if err != nil {
+		return err
+	}
+	setStateCipher(c)
+	log.Infoln("Loaded", len(c.keys), "khstate encryption keys. Encrypting", cfg.StateEncryptionFields, "with key", c.keyID)
+	return nil
+}
+
+// loadFieldCipher loads every key in a directory, usually a mounted secret.  Each file is a key named by its key
+// ID and holds 32 bytes, either raw or encoded as base64.  The hidden files and directories that secret volumes are
+// made of are skipped.  The current key defaults to the only key when there is just one.
+func loadFieldCipher(dir string, keyID string) (*fieldCipher, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading khstate encryption keys: %w", err)
+	}
+
+	c := &fieldCipher{keyID: keyID, keys: make(map[string]cipher.AEAD)}
+	for _, file := range files {
+		id := file.Name()
+		if strings.HasPrefix(id, ".") {
+			continue
+		}
+		path := filepath.Join(dir, id)
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading khstate encryption key %s: %w", id, err)
+		}
+		if info.IsDir() {
+			continue
+		}
+		if strings.Contains(id, ":") {
+			return nil, fmt.Errorf("khstate encryption key ID %s can not contain a colon", id)
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading khstate encryption key %s: %w", id, err)
+		}
+		key, err := parseStateEncryptionKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("invalid khstate encryption key %s: %w", id, err)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid khstate encryption key %s: %w", id, err)
+		}
+		c.keys[id], err = cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("invalid khstate encryption key %s: %w", id, err)
+		}
+	}
+
+	if len(c.keys) == 0 {
+		return nil, fmt.Errorf("no khstate encryption keys found in %s", dir)
+	}
+	if len(c.keyID) == 0 {
+		if len(c.keys) > 1 {
+			ids := make([]string, 0, len(c.keys))
+			for id := range c.keys {
+				ids = append(ids, id)
+			}
+			sort.Strings(ids)
+			return nil, fmt.Errorf("stateEncryptionKeyID must name the current khstate encryption key when there is more than one: %s", strings.Join(ids, ", "))
+		}
+		for id := range c.keys {
+			c.keyID = id
+		}
+	}
+	if _, exists := c.keys[c.keyID]; !exists {
+		return nil, fmt.Errorf("khstate encryption key %s not found in %s", c.keyID, dir)
+	}
+	return c, nil
+}
+
+// parseStateEncryptionKey returns the key in the content of a key file.  Surrounding whitespace is ignored.
+func parseStateEncryptionKey(b []byte) ([]byte, error) {
+	trimmed := strings.TrimSpace(string(b))
+	decoded, err := base64.StdEncoding.DecodeString(trimmed)
+	if err == nil && len(decoded) == stateEncryptionKeySize {
+		return decoded, nil
+	}
+	if len(b) == stateEncryptionKeySize {
+		return b, nil
+	}
+	return nil, fmt.Errorf("keys must be %d bytes, or %d bytes encoded as base64", stateEncryptionKeySize, stateEncryptionKeySize)
+}
+
+// encrypt encrypts the value of a field with the current key.  The field name is authenticated with the value, so
+// an encrypted value can not be moved to another field.
+func (c *fieldCipher) encrypt(field string, value string) (string, error) {
+	aead := c.keys[c.keyID]
+	nonce := make([]byte, aead.NonceSize())
+	_, err := io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return "", fmt.Errorf("error generating nonce to encrypt %s: %w", field, err)
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(field))
+	return encryptedValuePrefix + c.keyID + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt decrypts the value of a field with the key it names.  Values that are not encrypted are returned as they
+// are, so khstates written before encryption was enabled can still be read.
+func (c *fieldCipher) decrypt(field string, value string) (string, error) {
+	if !isEncryptedValue(value) {
+		return value, nil
+	}
+	parts := strings.SplitN(strings.TrimPrefix(value, encryptedValuePrefix), ":", 2)
+	if len(parts) != 2 {
+		return value, fmt.Errorf("encrypted %s is missing its key ID", field)
+	}
+	aead, exists := c.keys[parts[0]]
+	if !exists {
+		return value, fmt.Errorf("%s was encrypted with unknown key %s", field, parts[0])
+	}
+	sealed, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return value, fmt.Errorf("encrypted %s is malformed", field)
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(field))
+	if err != nil {
+		return value, fmt.Errorf("error decrypting %s with key %s: %w", field, parts[0], err)
+	}
+	return string(plain), nil
+}
+
+// isEncryptedValue returns true if a field value was encrypted
+func isEncryptedValue(value string) bool {
+	return strings.HasPrefix(value, encryptedValuePrefix)
+}
+
+// encryptStateFields returns a copy of the details with the configured fields encrypted.  The details are returned
+// as they are when no fields are configured.  Values that are already encrypted are left alone.
+func encryptStateFields(details health.WorkloadDetails) (health.WorkloadDetails, error) {
+	if len(cfg.StateEncryptionFields) == 0 {
+		return details, nil
+	}
+	c := currentStateCipher()
+	if c == nil {
+		return details, ErrNoStateEncryptionKeys
+	}
+
+	encrypt := func(field string, value string) (string, error) {
+		if len(value) == 0 || isEncryptedValue(value) {
+			return value, nil
+		}
+		return c.encrypt(field, value)
+	}
+
+	var err error
+	if containsString(encryptedFieldErrors, cfg.StateEncryptionFields) && len(details.Errors) > 0 {
+		encrypted := make([]string, len(details.Errors))
+		for i, e := range details.Errors {
+			encrypted[i], err = encrypt(encryptedFieldErrors, e)
+			if err != nil {
+				return details, err
+			}
+		}
+		details.Errors = encrypted
+	}
+	if containsString(encryptedFieldLogTail, cfg.StateEncryptionFields) {
+		details.LogTail, err = encrypt(encryptedFieldLogTail, details.LogTail)
+		if err != nil {
+			return details, err
+		}
+	}
+	if containsString(encryptedFieldAnnotations, cfg.StateEncryptionFields) && len(details.Annotations) > 0 {
+		encrypted := make(map[string]string, len(details.Annotations))
+		for key, value := range details.Annotations {
+			encrypted[key], err = encrypt(encryptedFieldAnnotations, value)
+			if err != nil {
+				return details, err
+			}
+		}
+		details.Annotations = encrypted
+	}
+	return details, nil
+}
+
+// decryptStateFields returns a copy of the details with every encrypted field decrypted, whether or not the field
+// is still configured to be encrypted.  Values that can not be decrypted are left encrypted.
+func decryptStateFields(details health.WorkloadDetails) health.WorkloadDetails {
+	c := currentStateCipher()
+	if c == nil {
+		return details
+	}
+
+	decrypt := func(field string, value string) string {
+		plain, err := c.decrypt(field, value)
+		if err != nil {
+			log.Warningln("Failed to decrypt khstate field:", err)
+			stateDecryptionFailures.Inc(field)
+		}
+		return plain
+	}
+
+	if len(details.Errors) > 0 {
+		decrypted := make([]string, len(details.Errors))
+		for i, e := range details.Errors {
+			decrypted[i] = decrypt(encryptedFieldErrors, e)
+		}
+		details.Errors = decrypted
+	}
+	details.LogTail = decrypt(encryptedFieldLogTail, details.LogTail)
+	if len(details.Annotations) > 0 {
+		decrypted := make(map[string]string, len(details.Annotations))
+		for key, value := range details.Annotations {
+			decrypted[key] = decrypt(encryptedFieldAnnotations, value)
+		}
+		details.Annotations = decrypted
+	}
+	return details
+}
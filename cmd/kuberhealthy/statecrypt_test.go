@@ -0,0 +1,220 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// writeStateEncryptionKey writes a key file of the supplied key ID to a key directory
+func writeStateEncryptionKey(t *testing.T, dir string, id string, content []byte) {
+	err := ioutil.WriteFile(filepath.Join(dir, id), content, 0600)
+	if err != nil {
+		t.Fatal("failed to write the encryption key:", err)
+	}
+}
+
+// TestFieldCipher ensures that keys are loaded from a mounted secret, that values decrypt with the key they were
+// encrypted with after a rotation and that encrypted values can not be moved to another field
+func TestFieldCipher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "khstate-keys")
+	if err != nil {
+		t.Fatal("failed to create the key directory:", err)
+	}
+	defer os.RemoveAll(dir)
+	writeStateEncryptionKey(t, dir, "2021-05", []byte(base64.StdEncoding.EncodeToString([]byte(strings.Repeat("a", 32)))+"\n"))
+	os.Mkdir(filepath.Join(dir, "..data"), 0700)
+
+	old, err := loadFieldCipher(dir, "")
+	if err != nil || old.keyID != "2021-05" {
+		t.Fatalf("expected the only key to be current, got %+v and %v", old, err)
+	}
+	encrypted, err := old.encrypt(encryptedFieldErrors, "password=hunter2")
+	if err != nil || !strings.HasPrefix(encrypted, encryptedValuePrefix+"2021-05:") || strings.Contains(encrypted, "hunter2") {
+		t.Fatalf("expected a value encrypted with key 2021-05, got %q and %v", encrypted, err)
+	}
+
+	// a second key needs to be named as the current key, and old values still decrypt after the rotation
+	writeStateEncryptionKey(t, dir, "2021-06", []byte(strings.Repeat("b", 32)))
+	_, err = loadFieldCipher(dir, "")
+	if err == nil {
+		t.Fatal("expected the current key to be required when there is more than one key")
+	}
+	rotated, err := loadFieldCipher(dir, "2021-06")
+	if err != nil {
+		t.Fatal("unexpected error loading the rotated keys:", err)
+	}
+	decrypted, err := rotated.decrypt(encryptedFieldErrors, encrypted)
+	if err != nil || decrypted != "password=hunter2" {
+		t.Fatalf("expected the old value to decrypt with the old key, got %q and %v", decrypted, err)
+	}
+	if reencrypted, _ := rotated.encrypt(encryptedFieldErrors, decrypted); !strings.HasPrefix(reencrypted, encryptedValuePrefix+"2021-06:") {
+		t.Fatal("expected new values to be encrypted with the current key, got", reencrypted)
+	}
+
+	if _, err := rotated.decrypt(encryptedFieldLogTail, encrypted); err == nil {
+		t.Fatal("expected a value encrypted for another field to fail to decrypt")
+	}
+	if plain, err := rotated.decrypt(encryptedFieldErrors, "not encrypted"); err != nil || plain != "not encrypted" {
+		t.Fatalf("expected plaintext values to be returned as they are, got %q and %v", plain, err)
+	}
+
+	writeStateEncryptionKey(t, dir, "short", []byte("too short"))
+	if _, err := loadFieldCipher(dir, "2021-06"); err == nil {
+		t.Fatal("expected a key of the wrong size to be refused")
+	}
+}
+
+// TestStateFieldEncryption ensures that the configured fields are written encrypted, that other fields stay
+// plaintext and that reads decrypt them
+func TestStateFieldEncryption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "khstate-keys")
+	if err != nil {
+		t.Fatal("failed to create the key directory:", err)
+	}
+	defer os.RemoveAll(dir)
+	writeStateEncryptionKey(t, dir, "current", []byte(strings.Repeat("c", 32)))
+
+	originalFields, originalDir := cfg.StateEncryptionFields, cfg.StateEncryptionKeyDir
+	defer func() {
+		cfg.StateEncryptionFields, cfg.StateEncryptionKeyDir = originalFields, originalDir
+		setStateCipher(nil)
+	}()
+	cfg.StateEncryptionFields = []string{encryptedFieldErrors, encryptedFieldLogTail}
+	cfg.StateEncryptionKeyDir = ""
+	if loadStateCipher() == nil {
+		t.Fatal("expected encrypted fields without a key directory to be refused")
+	}
+	if _, err := encryptStateFields(health.WorkloadDetails{Errors: []string{"secret"}}); err != ErrNoStateEncryptionKeys {
+		t.Fatal("expected nothing to be encrypted without keys, got", err)
+	}
+	cfg.StateEncryptionKeyDir = dir
+	err = loadStateCipher()
+	if err != nil {
+		t.Fatal("unexpected error loading the encryption keys:", err)
+	}
+	stateVersions.reset()
+	defer stateVersions.reset()
+
+	stored := khstatecrd.NewKuberhealthyState("encrypted-check", health.WorkloadDetails{Status: health.StatusUnknown, Errors: []string{}})
+	stored.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	stored.Kind = "KuberhealthyState"
+	stored.Namespace = "kuberhealthy"
+	useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPut {
+			stored = khstatecrd.KuberhealthyState{}
+			json.NewDecoder(r.Body).Decode(&stored)
+		}
+		json.NewEncoder(w).Encode(stored)
+	})
+
+	errs := []string{"token abc123 rejected"}
+	err = setCheckStateResource("encrypted-check", "kuberhealthy", health.WorkloadDetails{OK: false, Errors: errs, LogTail: "using token abc123", Annotations: map[string]string{"runbook": "https://example.com"}})
+	if err != nil {
+		t.Fatal("unexpected error writing the khstate:", err)
+	}
+	if errs[0] != "token abc123 rejected" {
+		t.Fatal("expected the written result to be left alone, got", errs)
+	}
+	if len(stored.Spec.Errors) != 1 || !isEncryptedValue(stored.Spec.Errors[0]) || !isEncryptedValue(stored.Spec.LogTail) {
+		t.Fatalf("expected the errors and log tail to be stored encrypted, got %+v", stored.Spec)
+	}
+	if stored.Spec.OK || stored.Spec.Status != health.StatusNotOK || stored.Spec.Annotations["runbook"] != "https://example.com" {
+		t.Fatalf("expected the other fields to be stored in plaintext, got %+v", stored.Spec)
+	}
+	for _, condition := range stored.Status.Conditions {
+		if strings.Contains(condition.Message, "abc123") {
+			t.Fatal("expected the Ready condition to not leak the errors, got", condition.Message)
+		}
+	}
+
+	state, err := getCheckState(&FakeCheck{CheckName: "encrypted-check", Namespace: "kuberhealthy"})
+	if err != nil {
+		t.Fatal("unexpected error reading the khstate:", err)
+	}
+	if len(state.Errors) != 1 || state.Errors[0] != "token abc123 rejected" || state.LogTail != "using token abc123" {
+		t.Fatalf("expected the encrypted fields to be decrypted on read, got %+v", state)
+	}
+}
+
+// capturingStateStore is a secondary state store that hands every state written to it to a channel
+type capturingStateStore struct {
+	written chan health.WorkloadDetails
+}
+
+// Name returns the name of the state store for logs and metrics
+func (c *capturingStateStore) Name() string {
+	return "capturing"
+}
+
+// SetCheckState hands the state to the channel of the store
+func (c *capturingStateStore) SetCheckState(checkName string, checkNamespace string, state health.WorkloadDetails) error {
+	c.written <- state
+	return nil
+}
+
+// TestSecondaryStateStoreEncryption ensures that secondary state stores only ever receive the configured fields
+// encrypted, and receive nothing when they can not be encrypted
+func TestSecondaryStateStoreEncryption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "khstate-keys")
+	if err != nil {
+		t.Fatal("failed to create the key directory:", err)
+	}
+	defer os.RemoveAll(dir)
+	writeStateEncryptionKey(t, dir, "current", []byte(strings.Repeat("c", 32)))
+
+	originalFields, originalDir, originalStores := cfg.StateEncryptionFields, cfg.StateEncryptionKeyDir, secondaryStateStores
+	defer func() {
+		cfg.StateEncryptionFields, cfg.StateEncryptionKeyDir, secondaryStateStores = originalFields, originalDir, originalStores
+		setStateCipher(nil)
+	}()
+	store := &capturingStateStore{written: make(chan health.WorkloadDetails, 1)}
+	secondaryStateStores = []StateStore{store}
+	cfg.StateEncryptionFields = []string{encryptedFieldErrors, encryptedFieldLogTail}
+	details := health.WorkloadDetails{OK: false, Errors: []string{"token abc123 rejected"}, LogTail: "using token abc123"}
+
+	// without keys nothing is written rather than falling back to plaintext
+	setStateCipher(nil)
+	writeToSecondaryStateStores("encrypted-check", "kuberhealthy", details)
+	select {
+	case written := <-store.written:
+		t.Fatalf("expected nothing to be written without encryption keys, got %+v", written)
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	cfg.StateEncryptionKeyDir = dir
+	err = loadStateCipher()
+	if err != nil {
+		t.Fatal("unexpected error loading the encryption keys:", err)
+	}
+	writeToSecondaryStateStores("encrypted-check", "kuberhealthy", details)
+	select {
+	case written := <-store.written:
+		if len(written.Errors) != 1 || !isEncryptedValue(written.Errors[0]) || !isEncryptedValue(written.LogTail) {
+			t.Fatalf("expected the errors and log tail to be written encrypted, got %+v", written)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("expected the state to be written to the secondary state store")
+	}
+}
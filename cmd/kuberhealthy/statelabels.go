@@ -0,0 +1,137 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// managedStateLabelPrefixes are the label prefixes that Kuberhealthy reserves for itself.  Labels with these prefixes
+// are never injected onto khstates, so injected labels can not clobber labels Kuberhealthy manages.
+var managedStateLabelPrefixes = []string{"kuberhealthy.io/", "comcast.github.io/", "kuberhealthy-"}
+
+// injectedStateLabels holds the labels injected onto the khstate of every loaded khcheck, keyed by the
+// namespace/name of the khstate
+var injectedStateLabels = struct {
+	sync.RWMutex
+	labels map[string]map[string]string
+}{labels: make(map[string]map[string]string)}
+
+// setInjectedStateLabels replaces the injected labels
+func setInjectedStateLabels(labels map[string]map[string]string) {
+	injectedStateLabels.Lock()
+	defer injectedStateLabels.Unlock()
+	injectedStateLabels.labels = labels
+}
+
+// injectedStateLabelsFor returns the labels injected onto a khstate
+func injectedStateLabelsFor(name string, stateNamespace string) map[string]string {
+	injectedStateLabels.RLock()
+	defer injectedStateLabels.RUnlock()
+	return injectedStateLabels.labels[stateNamespace+"/"+name]
+}
+
+// isManagedStateLabel returns true if a label is reserved for Kuberhealthy
+func isManagedStateLabel(key string) bool {
+	for _, prefix := range managedStateLabelPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveStateLabels resolves the configured state labels from the labels and annotations of a khcheck.  Each
+// configured label takes its value from the khcheck label of the configured name, or from the annotation of that
+// name when there is no such label.  Labels that Kuberhealthy manages and values that are not valid label values
+// are left out.
+func resolveStateLabels(checkName string, checkNamespace string, labels map[string]string, annotations map[string]string) map[string]string {
+	resolved := make(map[string]string)
+	for key, source := range cfg.StateLabels {
+		if isManagedStateLabel(key) {
+			log.Warningln("Not injecting label", key, "onto khstates because Kuberhealthy manages it")
+			continue
+		}
+		value, exists := labels[source]
+		if !exists {
+			value, exists = annotations[source]
+		}
+		if !exists {
+			continue
+		}
+		if problems := validation.IsValidLabelValue(value); len(problems) > 0 {
+			log.Warningln("Not injecting label", key, "onto the khstate of check", checkNamespace+"/"+checkName, "because", source, "is not a valid label value:", strings.Join(problems, ", "))
+			continue
+		}
+		resolved[key] = value
+	}
+	return resolved
+}
+
+// injectStateLabels sets the injected labels of a khstate on its metadata.  Configured labels that do not resolve
+// for the check are removed, and every other label is left alone.  It returns true if the labels changed.
+func injectStateLabels(meta *metav1.ObjectMeta, name string, stateNamespace string) bool {
+	if len(cfg.StateLabels) == 0 {
+		return false
+	}
+	injected := injectedStateLabelsFor(name, stateNamespace)
+
+	var changed bool
+	for key := range cfg.StateLabels {
+		if isManagedStateLabel(key) {
+			continue
+		}
+		value, resolved := injected[key]
+		current, exists := meta.Labels[key]
+		switch {
+		case resolved && (!exists || current != value):
+			if meta.Labels == nil {
+				meta.Labels = make(map[string]string)
+			}
+			meta.Labels[key] = value
+			changed = true
+		case !resolved && exists:
+			delete(meta.Labels, key)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// stateLabelsChanged returns true if the labels of a modified khstate differ from the labels in the JSON of the
+// original khstate
+func stateLabelsChanged(original []byte, labels map[string]string) bool {
+	var object struct {
+		Metadata struct {
+			Labels map[string]string `json:"labels"`
+		} `json:"metadata"`
+	}
+	err := json.Unmarshal(original, &object)
+	if err != nil {
+		return true
+	}
+	if len(object.Metadata.Labels) != len(labels) {
+		return true
+	}
+	for key, value := range labels {
+		if current, exists := object.Metadata.Labels[key]; !exists || current != value {
+			return true
+		}
+	}
+	return false
+}
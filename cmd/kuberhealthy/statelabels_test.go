@@ -0,0 +1,105 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// TestResolveStateLabels ensures that state labels are taken from khcheck labels before annotations and that
+// managed labels and invalid values are not injected
+func TestResolveStateLabels(t *testing.T) {
+	original := cfg.StateLabels
+	defer func() { cfg.StateLabels = original }()
+	cfg.StateLabels = stringMap{
+		"team":                 "team",
+		"cost-center":          "example.com/cost-center",
+		"tier":                 "tier",
+		"owner":                "owner",
+		"kuberhealthy.io/team": "team",
+	}
+
+	resolved := resolveStateLabels("dns", "kuberhealthy",
+		map[string]string{"team": "network", "tier": "gold"},
+		map[string]string{"team": "ignored", "example.com/cost-center": "1234", "owner": "not a valid label value!"},
+	)
+	expected := map[string]string{"team": "network", "cost-center": "1234", "tier": "gold"}
+	if len(resolved) != len(expected) {
+		t.Fatalf("expected labels %v, got %v", expected, resolved)
+	}
+	for key, value := range expected {
+		if resolved[key] != value {
+			t.Fatalf("expected labels %v, got %v", expected, resolved)
+		}
+	}
+}
+
+// TestInjectStateLabels ensures that injected labels are written to khstates, that labels that no longer resolve
+// are removed and that other labels are left alone
+func TestInjectStateLabels(t *testing.T) {
+	originalLabels, originalStrategy := cfg.StateLabels, cfg.StateUpdateStrategy
+	defer func() {
+		cfg.StateLabels, cfg.StateUpdateStrategy = originalLabels, originalStrategy
+		setInjectedStateLabels(make(map[string]map[string]string))
+	}()
+	cfg.StateLabels = stringMap{"team": "team", "tier": "tier"}
+	stateVersions.reset()
+	defer stateVersions.reset()
+
+	meta := metav1.ObjectMeta{Labels: map[string]string{"app": "dns", "kuberhealthy.io/owner": "kh", "tier": "bronze"}}
+	setInjectedStateLabels(map[string]map[string]string{"kuberhealthy/labeled-check": {"team": "network"}})
+	if !injectStateLabels(&meta, "labeled-check", "kuberhealthy") {
+		t.Fatal("expected the labels to change")
+	}
+	if meta.Labels["team"] != "network" || meta.Labels["app"] != "dns" || meta.Labels["kuberhealthy.io/owner"] != "kh" {
+		t.Fatal("expected the team label to be added and other labels kept, got", meta.Labels)
+	}
+	if _, exists := meta.Labels["tier"]; exists {
+		t.Fatal("expected the tier label that no longer resolves to be removed, got", meta.Labels)
+	}
+	if injectStateLabels(&meta, "labeled-check", "kuberhealthy") {
+		t.Fatal("expected no change when the labels are already injected")
+	}
+
+	// label changes are written with a full update even when khstates are patched
+	cfg.StateUpdateStrategy = stateUpdateStrategyMergePatch
+	stored := khstatecrd.NewKuberhealthyState("labeled-check", health.WorkloadDetails{Status: health.StatusUnknown, Errors: []string{}})
+	stored.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	stored.Kind = "KuberhealthyState"
+	stored.Namespace = "kuberhealthy"
+	var methods []string
+	useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodGet {
+			methods = append(methods, r.Method)
+		}
+		if r.Method == http.MethodPut {
+			stored = khstatecrd.KuberhealthyState{}
+			json.NewDecoder(r.Body).Decode(&stored)
+		}
+		json.NewEncoder(w).Encode(stored)
+	})
+	err := setCheckStateResource("labeled-check", "kuberhealthy", health.WorkloadDetails{OK: true, Errors: []string{}})
+	if err != nil {
+		t.Fatal("unexpected error writing the khstate:", err)
+	}
+	if len(methods) != 1 || methods[0] != http.MethodPut || stored.Labels["team"] != "network" {
+		t.Fatalf("expected a full update with the injected labels, got %v and labels %v", methods, stored.Labels)
+	}
+}
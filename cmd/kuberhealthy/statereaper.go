@@ -0,0 +1,81 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"hash/fnv"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// defaultStateReaperInterval is how often the khstate reaper runs when no interval is configured
+const defaultStateReaperInterval = time.Minute
+
+// stateShard is a slice of the khstates that the reaper examines in a single pass.  Every khstate belongs to
+// exactly one shard by a hash of its key, so it is examined on every pass over its shard no matter when it was
+// created or its check was deleted.
+type stateShard struct {
+	index int
+	count int
+}
+
+// allStates is the shard of every khstate
+var allStates = stateShard{index: 0, count: 1}
+
+// contains returns true if the khstate with the supplied namespace/name key is in the shard
+func (s stateShard) contains(key string) bool {
+	if s.count <= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32()%uint32(s.count)) == s.index
+}
+
+// stateReaperPasses counts the passes of the khstate reaper.  It is kept across reaper restarts so that restarts
+// do not send the reaper back to the first shard.
+var stateReaperPasses uint64
+
+// stateReaperInterval returns how often the khstate reaper runs
+func stateReaperInterval() time.Duration {
+	if cfg.StateReaperInterval <= 0 {
+		return defaultStateReaperInterval
+	}
+	return cfg.StateReaperInterval
+}
+
+// stateReaperShardCount returns how many shards the khstates are split into so that each reaper pass examines the
+// configured fraction of them.  A fraction that is not between 0 and 1 examines every khstate on every pass.
+func stateReaperShardCount() int {
+	fraction := cfg.StateReaperFraction
+	if fraction <= 0 || fraction >= 1 {
+		return 1
+	}
+	return int(math.Ceil(1 / fraction))
+}
+
+// currentStateReaperShard returns the shard for the current pass of the khstate reaper.  The shards are visited in
+// turn as passes complete, so every khstate is examined at least once every shard count passes.  A pass that fails
+// is retried on the same shard instead of skipping it.
+func currentStateReaperShard() stateShard {
+	count := stateReaperShardCount()
+	pass := atomic.LoadUint64(&stateReaperPasses)
+	return stateShard{index: int(pass % uint64(count)), count: count}
+}
+
+// completeStateReaperPass moves the khstate reaper on to the next shard and records the pass
+func completeStateReaperPass(reaped int, now time.Time) {
+	atomic.AddUint64(&stateReaperPasses, 1)
+	stateReaperLastRun.Set(float64(now.Unix()))
+	stateReaperReaped.Add(float64(reaped))
+}
@@ -0,0 +1,155 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/rest"
+
+	khjobv1 "github.com/Comcast/kuberhealthy/v2/pkg/apis/khjob/v1"
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// TestStateReaperShards ensures that every khstate is in exactly one shard, that the shards are visited in turn
+// as passes complete and that the configured fraction sets the number of shards
+func TestStateReaperShards(t *testing.T) {
+	originalFraction := cfg.StateReaperFraction
+	defer func() { cfg.StateReaperFraction = originalFraction }()
+
+	for fraction, count := range map[float64]int{0: 1, 1: 1, 2: 1, 0.5: 2, 0.3: 4, 0.1: 10} {
+		cfg.StateReaperFraction = fraction
+		if got := stateReaperShardCount(); got != count {
+			t.Fatalf("expected a fraction of %v to use %d shards, got %d", fraction, count, got)
+		}
+	}
+
+	cfg.StateReaperFraction = 0.25
+	for i := 0; i < 100; i++ {
+		key := "kuberhealthy/check-" + strconv.Itoa(i)
+		var shards int
+		for index := 0; index < 4; index++ {
+			if (stateShard{index: index, count: 4}).contains(key) {
+				shards++
+			}
+		}
+		if shards != 1 {
+			t.Fatalf("expected %s to be in exactly one shard, got %d", key, shards)
+		}
+	}
+
+	// a deleted check is examined within one pass over every shard no matter which shard is current
+	visited := make(map[int]bool)
+	for i := 0; i < 4; i++ {
+		shard := currentStateReaperShard()
+		if shard != currentStateReaperShard() {
+			t.Fatal("expected the shard to stay the same until the pass completes")
+		}
+		visited[shard.index] = true
+		completeStateReaperPass(0, time.Now())
+	}
+	if len(visited) != 4 {
+		t.Fatalf("expected four passes to visit every shard, got %v", visited)
+	}
+}
+
+// TestRetainedStatesPerShard ensures that a pass over one shard does not forget the retained khstates of others
+func TestRetainedStatesPerShard(t *testing.T) {
+	defer retainedStates.set(allStates, nil)
+
+	keys := map[string]bool{}
+	for i := 0; i < 20; i++ {
+		keys["kuberhealthy/check-"+strconv.Itoa(i)] = true
+	}
+	retainedStates.set(allStates, keys)
+
+	shard := stateShard{index: 0, count: 2}
+	retainedStates.set(shard, nil)
+
+	retainedStates.RLock()
+	defer retainedStates.RUnlock()
+	for key := range keys {
+		_, retained := retainedStates.keys[key]
+		if retained == shard.contains(key) {
+			t.Fatalf("expected %s to be retained only when it is outside of the reaped shard, got %v", key, retained)
+		}
+	}
+}
+
+// TestFailedReaperPassRetriesShard ensures that a reaper pass that fails to delete an orphaned khstate does not
+// move on to the next shard, and that the shard moves on once a pass succeeds
+func TestFailedReaperPassRetriesShard(t *testing.T) {
+	orphan := khstatecrd.NewKuberhealthyState("orphan", health.WorkloadDetails{OK: true})
+	orphan.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	orphan.Kind = "KuberhealthyState"
+	orphan.Namespace = "kuberhealthy"
+	states := khstatecrd.KuberhealthyStateList{Items: []khstatecrd.KuberhealthyState{orphan}}
+	states.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	states.Kind = "KuberhealthyStateList"
+	jobs := khjobv1.KuberhealthyJobList{}
+	jobs.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	jobs.Kind = "KuberhealthyJobList"
+
+	var failDeletes int32 = 1
+	url := useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.Contains(r.URL.Path, "/khjobs"):
+			json.NewEncoder(w).Encode(jobs)
+		case strings.Contains(r.URL.Path, "/khchecks"):
+			json.NewEncoder(w).Encode(khCheckList())
+		case r.Method == http.MethodGet:
+			json.NewEncoder(w).Encode(states)
+		case r.Method == http.MethodDelete && atomic.LoadInt32(&failDeletes) == 1:
+			w.WriteHeader(http.StatusForbidden)
+		case r.Method == http.MethodDelete:
+			json.NewEncoder(w).Encode(orphan)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+	jobClient, err := khjobv1.NewForConfig(&rest.Config{Host: url})
+	if err != nil {
+		t.Fatal("failed to create khjob client for test server:", err)
+	}
+	originalJobClient := khJobClient
+	khJobClient = jobClient
+	defer func() {
+		khJobClient = originalJobClient
+	}()
+	useFakeKHCheckClient(t, url)
+
+	passes := atomic.LoadUint64(&stateReaperPasses)
+	err = (&Kuberhealthy{}).reapKHStateResources()
+	if err == nil {
+		t.Fatal("expected an error when the orphaned khstate can not be deleted")
+	}
+	if atomic.LoadUint64(&stateReaperPasses) != passes {
+		t.Fatal("expected a failed pass to retry the same shard")
+	}
+
+	atomic.StoreInt32(&failDeletes, 0)
+	err = (&Kuberhealthy{}).reapKHStateResources()
+	if err != nil {
+		t.Fatal("unexpected error reaping khstates:", err)
+	}
+	if atomic.LoadUint64(&stateReaperPasses) != passes+1 {
+		t.Fatal("expected a successful pass to move on to the next shard")
+	}
+}
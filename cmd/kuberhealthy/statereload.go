@@ -0,0 +1,179 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// stateClientsLock guards swapping the khstate clients and secondary state stores.  Writes hold it for reading,
+// so a swap waits for in-flight writes to finish with the clients they started with.
+var stateClientsLock sync.RWMutex
+
+// stateStoreConfig is the part of the configuration that the khstate clients and the remote state store are
+// built from.  When it changes on a config reload, the clients are rebuilt and swapped in.
+type stateStoreConfig struct {
+	ReadKubeConfigFile   string
+	RemoteKubeConfigFile string
+	RemoteNamespace      string
+	RemoteClusterID      string
+	QPS                  float32
+	Burst                int
+}
+
+// stateStoreConfigFrom returns the state store settings of a configuration
+func stateStoreConfigFrom(c *Config) stateStoreConfig {
+	return stateStoreConfig{
+		ReadKubeConfigFile:   c.StateReadKubeConfigFile,
+		RemoteKubeConfigFile: c.RemoteStateKubeConfigFile,
+		RemoteNamespace:      c.RemoteStateNamespace,
+		RemoteClusterID:      c.RemoteStateClusterID,
+		QPS:                  c.StateClientQPS,
+		Burst:                c.StateClientBurst,
+	}
+}
+
+// apply sets the state store settings of a configuration.  This is used to roll back a rejected reload.
+func (s stateStoreConfig) apply(c *Config) {
+	c.StateReadKubeConfigFile = s.ReadKubeConfigFile
+	c.RemoteStateKubeConfigFile = s.RemoteKubeConfigFile
+	c.RemoteStateNamespace = s.RemoteNamespace
+	c.RemoteStateClusterID = s.RemoteClusterID
+	c.StateClientQPS = s.QPS
+	c.StateClientBurst = s.Burst
+}
+
+// stateClients are the clients built from a state store configuration
+type stateClients struct {
+	write  *khstatecrd.KuberhealthyStateClient
+	read   *khstatecrd.KuberhealthyStateClient
+	remote *remoteKHStateStore // nil when no remote cluster is configured
+}
+
+// stateRestConfig returns the rest config that khstates are written with.  The in-cluster config is used when
+// available, otherwise the kubeconfig file is.  The configured client rate limits are applied to it.
+func stateRestConfig(s stateStoreConfig) (*rest.Config, error) {
+	c, err := rest.InClusterConfig()
+	if err != nil {
+		c, err = clientcmd.BuildConfigFromFlags("", cfg.kubeConfigFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.QPS > 0 {
+		c.QPS = s.QPS
+	}
+	if s.Burst > 0 {
+		c.Burst = s.Burst
+	}
+	return c, nil
+}
+
+// buildStateClients builds the khstate clients and the remote state store for a state store configuration
+func buildStateClients(s stateStoreConfig) (stateClients, error) {
+	var clients stateClients
+
+	writeConfig, err := stateRestConfig(s)
+	if err != nil {
+		return clients, fmt.Errorf("error loading khstate client config: %w", err)
+	}
+	clients.write, err = khstatecrd.ClientForConfig(stateCRDGroup, stateCRDVersion, writeConfig)
+	if err != nil {
+		return clients, err
+	}
+
+	// by default, reads and writes go through the same client
+	clients.read = clients.write
+	if len(s.ReadKubeConfigFile) > 0 {
+		readConfig, err := clientcmd.BuildConfigFromFlags("", s.ReadKubeConfigFile)
+		if err != nil {
+			return clients, fmt.Errorf("error loading khstate read kubeconfig %s: %w", s.ReadKubeConfigFile, err)
+		}
+		clients.read, err = khstatecrd.ClientForConfig(stateCRDGroup, stateCRDVersion, readConfig)
+		if err != nil {
+			return clients, err
+		}
+	}
+
+	if len(s.RemoteKubeConfigFile) > 0 {
+		clients.remote, err = newRemoteKHStateStore(s.RemoteKubeConfigFile, s.RemoteNamespace, s.RemoteClusterID)
+		if err != nil {
+			return clients, err
+		}
+	}
+	return clients, nil
+}
+
+// validateStateClients ensures that newly built khstate clients can list khstates before they are swapped in
+func validateStateClients(clients stateClients) error {
+	_, err := clients.write.List(metav1.ListOptions{Limit: 1}, stateCRDResource, podNamespace)
+	if err != nil {
+		return fmt.Errorf("khstate write client can not list khstates: %w", err)
+	}
+	_, err = clients.read.List(metav1.ListOptions{Limit: 1}, stateCRDResource, podNamespace)
+	if err != nil {
+		return fmt.Errorf("khstate read client can not list khstates: %w", err)
+	}
+	return nil
+}
+
+// swapStateClients replaces the khstate clients and the remote state store once in-flight writes are done
+func swapStateClients(clients stateClients) {
+	stateClientsLock.Lock()
+	defer stateClientsLock.Unlock()
+
+	khStateClient = clients.write
+	khStateReadClient = clients.read
+
+	// other secondary state stores, such as kafka, are kept as they are
+	var stores []StateStore
+	for _, store := range secondaryStateStores {
+		if _, ok := store.(*remoteKHStateStore); ok {
+			retireStateStoreQueue(store)
+			continue
+		}
+		stores = append(stores, store)
+	}
+	if clients.remote != nil {
+		stores = append(stores, clients.remote)
+	}
+	secondaryStateStores = stores
+}
+
+// reloadStateStores rebuilds the khstate clients and the remote state store from the current configuration and
+// swaps them in.  If the new configuration can not be used, the previous clients are kept and the state store
+// settings of the configuration are rolled back to previous.
+func reloadStateStores(previous stateStoreConfig) error {
+	current := stateStoreConfigFrom(cfg)
+	log.Infoln("configReloader: reloading state store configuration from", fmt.Sprintf("%+v", previous), "to", fmt.Sprintf("%+v", current))
+
+	clients, err := buildStateClients(current)
+	if err == nil {
+		err = validateStateClients(clients)
+	}
+	if err != nil {
+		previous.apply(cfg)
+		return fmt.Errorf("rolled back state store configuration: %w", err)
+	}
+
+	swapStateClients(clients)
+	log.Infoln("configReloader: state store configuration reloaded")
+	return nil
+}
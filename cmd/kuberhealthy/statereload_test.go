@@ -0,0 +1,99 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// writeTestKubeConfig writes a kubeconfig that points at the supplied server URL and returns its path
+func writeTestKubeConfig(t *testing.T, serverURL string) string {
+	kubeConfig := `apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: ` + serverURL + `
+contexts:
+- name: test
+  context:
+    cluster: test
+current-context: test
+`
+	dir, err := ioutil.TempDir("", "kuberhealthy-kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	path := filepath.Join(dir, "config")
+	err = ioutil.WriteFile(path, []byte(kubeConfig), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestReloadStateStores ensures that a changed state store configuration swaps in new khstate clients and that a
+// configuration that can not be used is rolled back with the previous clients kept
+func TestReloadStateStores(t *testing.T) {
+	serverURL := useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		list := khstatecrd.KuberhealthyStateList{}
+		list.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+		list.Kind = "KuberhealthyStateList"
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	})
+
+	originalConfig := *cfg
+	defer func() {
+		*cfg = originalConfig
+	}()
+	cfg.kubeConfigFile = writeTestKubeConfig(t, serverURL)
+	previous := stateStoreConfigFrom(cfg)
+
+	// a state read kubeconfig that does not exist is rolled back
+	originalClient := khStateClient
+	cfg.StateReadKubeConfigFile = "/does/not/exist"
+	err := reloadStateStores(previous)
+	if err == nil {
+		t.Fatal("expected an error reloading a state store configuration that can not be used")
+	}
+	if stateStoreConfigFrom(cfg) != previous {
+		t.Fatalf("expected the state store configuration to be rolled back to %+v, got %+v", previous, stateStoreConfigFrom(cfg))
+	}
+	if khStateClient != originalClient {
+		t.Fatal("expected the previous khstate client to be kept")
+	}
+
+	// a working configuration is swapped in
+	cfg.StateReadKubeConfigFile = cfg.kubeConfigFile
+	cfg.StateClientQPS = 50
+	err = reloadStateStores(previous)
+	if err != nil {
+		t.Fatal("unexpected error reloading state store configuration:", err)
+	}
+	if khStateClient == originalClient || khStateReadClient == khStateClient {
+		t.Fatal("expected new khstate write and read clients to be swapped in")
+	}
+	if cfg.StateClientQPS != 50 {
+		t.Fatal("expected the new state store configuration to be kept")
+	}
+}
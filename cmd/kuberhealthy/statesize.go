@@ -0,0 +1,119 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// The ways a check result that is too large for a khstate can be handled
+const (
+	stateSizeModeTruncate = "truncate" // cut the result down until it fits.  This is the default
+	stateSizeModeReject   = "reject"   // record an error saying the result was too large instead of the result
+)
+
+// ErrStateTooLarge is returned when a check result was too large to store and an error saying so was stored in
+// its place
+var ErrStateTooLarge = errors.New("check result is too large to store in a khstate")
+
+// maxErrorLength returns the configured maximum length in bytes of a single error stored on a khstate
+func maxErrorLength() int {
+	if cfg.MaxErrorLength <= 0 {
+		return defaultMaxErrorLength
+	}
+	return cfg.MaxErrorLength
+}
+
+// stateSize returns the size in bytes of a check result when it is stored in a khstate
+func stateSize(state health.WorkloadDetails) int {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return 0
+	}
+	return len(b)
+}
+
+// stateSizeViolation describes why a check result is too large to store as it was reported.  It is blank when the
+// result fits.
+func stateSizeViolation(state health.WorkloadDetails) string {
+	for i, e := range state.Errors {
+		if len(e) > maxErrorLength() {
+			return fmt.Sprintf("error %d is %d bytes, which is more than the maximum of %d", i, len(e), maxErrorLength())
+		}
+	}
+	var annotationBytes int
+	for k, v := range state.Annotations {
+		annotationBytes += len(k) + len(v)
+	}
+	if annotationBytes > maxStateAnnotationBytes {
+		return fmt.Sprintf("annotations are %d bytes, which is more than the maximum of %d", annotationBytes, maxStateAnnotationBytes)
+	}
+	if cfg.MaxStateBytes > 0 {
+		if size := stateSize(state); size > cfg.MaxStateBytes {
+			return fmt.Sprintf("the result is %d bytes, which is more than the maximum of %d", size, cfg.MaxStateBytes)
+		}
+	}
+	return ""
+}
+
+// shrinkToSize drops the log tail and then errors, last first, until a check result fits within maxBytes.  The
+// first error is always kept so that a failing check still says why.
+func shrinkToSize(state health.WorkloadDetails, maxBytes int) health.WorkloadDetails {
+	if maxBytes <= 0 || stateSize(state) <= maxBytes {
+		return state
+	}
+	state.LogTail = ""
+	reported := state.Errors
+	for kept := len(reported) - 1; kept > 0 && stateSize(state) > maxBytes; kept-- {
+		state.Errors = append(append([]string{}, reported[:kept]...), fmt.Sprintf("%d more errors dropped to fit the maximum khstate size", len(reported)-kept))
+	}
+	return state
+}
+
+// boundStateSize keeps a check result within the size limits of a khstate.  In truncate mode, oversized errors and
+// annotations are cut down and errors are dropped until the result fits.  In reject mode, an oversized result is
+// replaced with a failure saying that it was too large, and the returned bool is true.
+func boundStateSize(checkName string, stateNamespace string, state health.WorkloadDetails) (health.WorkloadDetails, bool) {
+	violation := stateSizeViolation(state)
+	if len(violation) == 0 {
+		return state, false
+	}
+
+	if cfg.StateSizeMode == stateSizeModeReject {
+		log.Warningln(stateNamespace, checkName, "rejecting check result because it is too large to store:", violation)
+		oversizedStates.Inc(stateSizeModeReject)
+
+		// only the fields that identify the run are kept, so that nothing of the oversized result is stored
+		rejected := state
+		rejected.OK = false
+		rejected.Status = health.StatusNotOK
+		rejected.Errors = []string{"check result was rejected because it is too large to store: " + violation}
+		rejected.Severity = ""
+		rejected.Annotations = nil
+		rejected.LogTail = ""
+		rejected.ArtifactURLs = nil
+		rejected.ResourceUsage = nil
+		return rejected, true
+	}
+
+	log.Warningln(stateNamespace, checkName, "truncating check result because it is too large to store:", violation)
+	oversizedStates.Inc(stateSizeModeTruncate)
+	state.Annotations = boundAnnotations(state.Annotations, maxStateAnnotationBytes)
+	state.Errors = truncateErrors(state.Errors, maxErrorLength())
+	return shrinkToSize(state, cfg.MaxStateBytes), false
+}
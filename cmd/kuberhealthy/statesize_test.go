@@ -0,0 +1,80 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// TestStateSizeModes ensures that oversized results are cut down to fit in truncate mode and replaced with an
+// error in reject mode
+func TestStateSizeModes(t *testing.T) {
+	originalMaxBytes, originalMode := cfg.MaxStateBytes, cfg.StateSizeMode
+	defer func() {
+		cfg.MaxStateBytes, cfg.StateSizeMode = originalMaxBytes, originalMode
+	}()
+	cfg.MaxStateBytes = 600
+
+	var errs []string
+	for i := 0; i < 20; i++ {
+		errs = append(errs, strings.Repeat("x", 50))
+	}
+	oversized := health.WorkloadDetails{OK: false, Errors: errs, LogTail: "panic: broken", Annotations: map[string]string{"runbook": "https://example.com"}}
+
+	// truncate mode keeps as much of the result as fits
+	cfg.StateSizeMode = stateSizeModeTruncate
+	truncated, rejected := boundStateSize("check", "kuberhealthy", oversized)
+	if rejected {
+		t.Fatal("expected truncate mode to not reject the result")
+	}
+	if stateSize(truncated) > cfg.MaxStateBytes || len(truncated.LogTail) > 0 || truncated.Errors[0] != errs[0] {
+		t.Fatalf("expected the result to be cut down to %d bytes keeping the first error, got %d bytes: %+v", cfg.MaxStateBytes, stateSize(truncated), truncated)
+	}
+	if !strings.Contains(truncated.Errors[len(truncated.Errors)-1], "more errors dropped") {
+		t.Fatal("expected the truncated result to say that errors were dropped, got", truncated.Errors)
+	}
+
+	// reject mode writes an error in place of the result and returns ErrStateTooLarge
+	cfg.StateSizeMode = stateSizeModeReject
+	existing := khstatecrd.NewKuberhealthyState("check", health.WorkloadDetails{OK: true})
+	existing.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	existing.Kind = "KuberhealthyState"
+	existing.Namespace = "kuberhealthy"
+	var written khstatecrd.KuberhealthyState
+	useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodGet:
+			json.NewEncoder(w).Encode(existing)
+		case http.MethodPut:
+			json.NewDecoder(r.Body).Decode(&written)
+			json.NewEncoder(w).Encode(written)
+		}
+	})
+	_, err := setCheckStateResourceIf("check", "kuberhealthy", oversized, nil)
+	if !errors.Is(err, ErrStateTooLarge) {
+		t.Fatal("expected ErrStateTooLarge, got", err)
+	}
+	if written.Spec.OK || len(written.Spec.Errors) != 1 || !strings.Contains(written.Spec.Errors[0], "too large") {
+		t.Fatalf("expected a single error saying the result was too large to be written, got %+v", written.Spec)
+	}
+	if len(written.Spec.LogTail) > 0 || len(written.Spec.Annotations) > 0 {
+		t.Fatalf("expected nothing of the oversized result to be written, got %+v", written.Spec)
+	}
+}
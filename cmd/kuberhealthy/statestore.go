@@ -0,0 +1,268 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// StateStore is a destination that check state is written to in addition to the local khstate resources
+type StateStore interface {
+	Name() string
+	SetCheckState(checkName string, checkNamespace string, state health.WorkloadDetails) error
+}
+
+// secondaryStateStores are written to on a best-effort basis after every successful local khstate write
+var secondaryStateStores []StateStore
+
+// stateStoreQueueSize is the number of writes that can wait for a secondary state store before new writes are
+// dropped
+const stateStoreQueueSize = 1000
+
+// stateStoreWrite is a write of check state waiting for a secondary state store
+type stateStoreWrite struct {
+	checkName      string
+	checkNamespace string
+	state          health.WorkloadDetails
+}
+
+// stateStoreQueues hold the writes waiting for each secondary state store.  Every store has a single writer, so
+// writes reach a store in the order they were made and an older result can not overwrite a newer one.  Stores
+// that were removed by a config reload keep a nil queue so that late writes to them are dropped.
+var stateStoreQueues = map[StateStore]chan stateStoreWrite{}
+
+// stateStoreQueuesLock guards stateStoreQueues
+var stateStoreQueuesLock sync.Mutex
+
+// queueStateStoreWrite queues a write for a secondary state store, starting the writer of the store on its first
+// write.  If the queue of the store is full, the write is dropped.
+func queueStateStoreWrite(store StateStore, write stateStoreWrite) {
+	stateStoreQueuesLock.Lock()
+	defer stateStoreQueuesLock.Unlock()
+
+	queue, ok := stateStoreQueues[store]
+	if !ok {
+		queue = make(chan stateStoreWrite, stateStoreQueueSize)
+		stateStoreQueues[store] = queue
+		go writeStateStoreQueue(store, queue)
+	}
+	if queue == nil {
+		log.Debugln(write.checkNamespace, write.checkName, "not writing check state to removed secondary state store", store.Name())
+		return
+	}
+
+	select {
+	case queue <- write:
+	default:
+		log.Warningln(write.checkNamespace, write.checkName, "dropped check state because the queue of secondary state store", store.Name(), "is full")
+		stateStoreWrites.Inc(store.Name(), "dropped")
+	}
+}
+
+// retireStateStoreQueue stops the writer of a secondary state store that was removed once its queued writes are
+// done
+func retireStateStoreQueue(store StateStore) {
+	stateStoreQueuesLock.Lock()
+	defer stateStoreQueuesLock.Unlock()
+
+	queue := stateStoreQueues[store]
+	if queue != nil {
+		close(queue)
+	}
+	stateStoreQueues[store] = nil
+}
+
+// writeStateStoreQueue writes queued check state to a secondary state store one write at a time until the queue
+// is closed.  Failures are retried and logged.
+func writeStateStoreQueue(store StateStore, queue chan stateStoreWrite) {
+	for write := range queue {
+		err := retry.OnError(retry.DefaultBackoff, isTransientStateError, func() error {
+			return store.SetCheckState(write.checkName, write.checkNamespace, write.state)
+		})
+		if err != nil {
+			log.Warningln(write.checkNamespace, write.checkName, "failed to write check state to secondary state store", store.Name()+":", err)
+			stateStoreWrites.Inc(store.Name(), "failure")
+			continue
+		}
+		log.Debugln(write.checkNamespace, write.checkName, "wrote check state to secondary state store", store.Name())
+		stateStoreWrites.Inc(store.Name(), "success")
+	}
+}
+
+// isTransientStateError determines if an error from the kubernetes API is likely to succeed when retried
+func isTransientStateError(err error) bool {
+	return k8sErrors.IsConflict(err) ||
+		k8sErrors.IsServerTimeout(err) ||
+		k8sErrors.IsTimeout(err) ||
+		k8sErrors.IsTooManyRequests(err) ||
+		k8sErrors.IsInternalError(err) ||
+		k8sErrors.IsServiceUnavailable(err) ||
+		k8sErrors.IsUnexpectedServerError(err)
+}
+
+// writeToSecondaryStateStores writes check state to every secondary state store in the background.  Failures
+// are retried and logged, but never returned, so that secondary stores can not block local writes.  Secondary
+// stores keep check state at rest just like khstates, so the fields configured for encryption are encrypted
+// before any store sees them.
+func writeToSecondaryStateStores(checkName string, checkNamespace string, state health.WorkloadDetails) {
+	stateClientsLock.RLock()
+	stores := secondaryStateStores
+	stateClientsLock.RUnlock()
+	if len(stores) == 0 {
+		return
+	}
+
+	// state that can not be encrypted is never written in plaintext instead
+	state, err := encryptStateFields(state)
+	if err != nil {
+		log.Errorln(checkNamespace, checkName, "not writing check state to secondary state stores because it could not be encrypted:", err)
+		for _, store := range stores {
+			stateStoreWrites.Inc(store.Name(), "failure")
+		}
+		return
+	}
+
+	for _, store := range stores {
+		queueStateStoreWrite(store, stateStoreWrite{checkName: checkName, checkNamespace: checkNamespace, state: state})
+	}
+}
+
+// remoteStateClusterLabel, remoteStateNamespaceLabel and remoteStateCheckLabel record where a remote khstate was
+// written from, so that the khstates of one cluster or namespace can be selected in the remote cluster
+const (
+	remoteStateClusterLabel   = "kuberhealthy-source-cluster"
+	remoteStateNamespaceLabel = "kuberhealthy-source-namespace"
+	remoteStateCheckLabel     = "kuberhealthy-check-name"
+)
+
+// remoteKHStateStore writes check state to the khstate resources of another cluster
+type remoteKHStateStore struct {
+	client    *khstatecrd.KuberhealthyStateClient
+	namespace string // if set, all khstates are written to this namespace instead of the check's namespace
+	clusterID string // identifies this cluster in the names and labels of its remote khstates
+}
+
+// newRemoteKHStateStore creates a state store for the cluster in the supplied kubeconfig file
+func newRemoteKHStateStore(kubeConfigFile string, namespace string, clusterID string) (*remoteKHStateStore, error) {
+	remoteConfig, err := clientcmd.BuildConfigFromFlags("", kubeConfigFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading remote state kubeconfig %s: %w", kubeConfigFile, err)
+	}
+	client, err := khstatecrd.ClientForConfig(stateCRDGroup, stateCRDVersion, remoteConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &remoteKHStateStore{
+		client:    client,
+		namespace: namespace,
+		clusterID: clusterID,
+	}, nil
+}
+
+// Name returns the name of the state store for logs and metrics
+func (r *remoteKHStateStore) Name() string {
+	return "remote-khstate"
+}
+
+// SetCheckState creates or updates the khstate for a check in the remote cluster
+func (r *remoteKHStateStore) SetCheckState(checkName string, checkNamespace string, state health.WorkloadDetails) error {
+
+	name := r.remoteName(checkName, checkNamespace)
+	namespace := checkNamespace
+	if len(r.namespace) > 0 {
+		namespace = r.namespace
+	}
+
+	// the state was already encrypted the same way as the local khstate before it was handed to the store
+	existingState, err := r.client.Get(metav1.GetOptions{}, stateCRDResource, name, namespace)
+	if k8sErrors.IsNotFound(err) {
+		newState := khstatecrd.NewKuberhealthyState(name, state)
+		newState.Namespace = namespace
+		r.setSourceLabels(&newState, checkName, checkNamespace)
+		newState.SyncReadyCondition()
+		_, err = r.client.Create(&newState, stateCRDResource, namespace)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	existingState.Spec = state
+	r.setSourceLabels(existingState, checkName, checkNamespace)
+	existingState.SyncReadyCondition()
+	_, err = r.client.Update(existingState, stateCRDResource, name, namespace)
+	return err
+}
+
+// remoteName returns the name of the remote khstate of a check.  Without a remote namespace or cluster ID, remote
+// khstates are named and placed like the local ones.  Otherwise khstates of different namespaces and clusters
+// share the remote namespace, so the cluster ID and namespace of the check are part of the name, along with a
+// hash of all three that keeps names from colliding when they are shortened or contain dashes.
+func (r *remoteKHStateStore) remoteName(checkName string, checkNamespace string) string {
+	if len(r.namespace) == 0 && len(r.clusterID) == 0 {
+		return sanitizeResourceName(checkName)
+	}
+
+	sum := sha256.Sum256([]byte(r.clusterID + "/" + checkNamespace + "/" + checkName))
+	hash := hex.EncodeToString(sum[:])[:resourceNameHashLength]
+	var parts []string
+	for _, part := range []string{r.clusterID, checkNamespace, checkName} {
+		if len(part) > 0 {
+			parts = append(parts, part)
+		}
+	}
+	readable := invalidResourceNameChars.ReplaceAllString(strings.ToLower(strings.Join(parts, "-")), "-")
+	maxReadableLength := validation.DNS1123SubdomainMaxLength - resourceNameHashLength - 1
+	if len(readable) > maxReadableLength {
+		readable = readable[:maxReadableLength]
+	}
+	readable = strings.Trim(readable, "-")
+	if len(readable) == 0 {
+		return hash
+	}
+	return readable + "-" + hash
+}
+
+// setSourceLabels labels a remote khstate with the cluster, namespace and name of the check it was written for.
+// Values that are not valid label values are left out.
+func (r *remoteKHStateStore) setSourceLabels(state *khstatecrd.KuberhealthyState, checkName string, checkNamespace string) {
+	labels := state.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	for label, value := range map[string]string{
+		remoteStateClusterLabel:   r.clusterID,
+		remoteStateNamespaceLabel: checkNamespace,
+		remoteStateCheckLabel:     checkName,
+	} {
+		if len(value) == 0 || len(validation.IsValidLabelValue(value)) > 0 {
+			delete(labels, label)
+			continue
+		}
+		labels[label] = value
+	}
+	state.SetLabels(labels)
+}
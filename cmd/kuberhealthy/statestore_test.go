@@ -0,0 +1,152 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/rest"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// TestRemoteKHStateStoreNames ensures that checks with the same name in different namespaces and clusters get
+// their own khstate in a shared remote namespace, labeled with where they were written from
+func TestRemoteKHStateStoreNames(t *testing.T) {
+	var lock sync.Mutex
+	created := map[string]khstatecrd.KuberhealthyState{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		state := khstatecrd.KuberhealthyState{}
+		json.NewDecoder(r.Body).Decode(&state)
+		created[state.Namespace+"/"+state.Name] = state
+		json.NewEncoder(w).Encode(state)
+	}))
+	defer server.Close()
+	client, err := khstatecrd.ClientForConfig(stateCRDGroup, stateCRDVersion, &rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal("failed to create khstate client for test server:", err)
+	}
+
+	east := &remoteKHStateStore{client: client, namespace: "central", clusterID: "east"}
+	west := &remoteKHStateStore{client: client, namespace: "central", clusterID: "west"}
+	writes := []struct {
+		store     *remoteKHStateStore
+		namespace string
+	}{{east, "team-a"}, {east, "team-b"}, {west, "team-a"}}
+	for _, write := range writes {
+		err = write.store.SetCheckState("dns", write.namespace, health.WorkloadDetails{OK: true})
+		if err != nil {
+			t.Fatal("unexpected error writing remote khstate:", err)
+		}
+	}
+
+	if len(created) != len(writes) {
+		t.Fatalf("expected %d remote khstates, got %d: %v", len(writes), len(created), created)
+	}
+	for key, state := range created {
+		if state.Namespace != "central" || len(validation.IsDNS1123Subdomain(state.Name)) > 0 {
+			t.Fatalf("expected a valid khstate name in the remote namespace, got %s", key)
+		}
+		labels := state.GetLabels()
+		if labels[remoteStateCheckLabel] != "dns" || len(labels[remoteStateClusterLabel]) == 0 || len(labels[remoteStateNamespaceLabel]) == 0 {
+			t.Fatalf("expected %s to be labeled with its source, got %v", key, labels)
+		}
+	}
+
+	// without a remote namespace or cluster ID, remote khstates mirror the local ones
+	mirror := &remoteKHStateStore{client: client}
+	if name := mirror.remoteName("dns", "team-a"); name != "dns" {
+		t.Fatalf("expected the remote khstate to be named after the check, got %s", name)
+	}
+}
+
+// orderedStateStore is a secondary state store that is slow to write its first state and records the order that
+// states were written in
+type orderedStateStore struct {
+	lock    sync.Mutex
+	written []string
+}
+
+// Name returns the name of the state store for logs and metrics
+func (o *orderedStateStore) Name() string {
+	return "ordered"
+}
+
+// SetCheckState records the errors of the state
+func (o *orderedStateStore) SetCheckState(checkName string, checkNamespace string, state health.WorkloadDetails) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if len(o.written) == 0 {
+		time.Sleep(time.Millisecond * 50)
+	}
+	o.written = append(o.written, state.Errors[0])
+	return nil
+}
+
+// TestSecondaryStateStoreWriteOrder ensures that writes reach a secondary state store in the order they were made,
+// so that an older result never overwrites a newer one
+func TestSecondaryStateStoreWriteOrder(t *testing.T) {
+	originalStores := secondaryStateStores
+	defer func() {
+		secondaryStateStores = originalStores
+	}()
+	store := &orderedStateStore{}
+	secondaryStateStores = []StateStore{store}
+
+	expected := []string{"first", "second", "third"}
+	for _, result := range expected {
+		writeToSecondaryStateStores("ordered-check", "kuberhealthy", health.WorkloadDetails{Errors: []string{result}})
+	}
+
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		store.lock.Lock()
+		written := append([]string{}, store.written...)
+		store.lock.Unlock()
+		if len(written) == len(expected) {
+			for i := range expected {
+				if written[i] != expected[i] {
+					t.Fatalf("expected writes in order %v, got %v", expected, written)
+				}
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for writes, got %v", written)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// writes to a store that was removed are dropped instead of restarting its writer
+	retireStateStoreQueue(store)
+	writeToSecondaryStateStores("ordered-check", "kuberhealthy", health.WorkloadDetails{Errors: []string{"late"}})
+	time.Sleep(time.Millisecond * 100)
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if len(store.written) != len(expected) {
+		t.Fatalf("expected no writes to a removed store, got %v", store.written)
+	}
+}
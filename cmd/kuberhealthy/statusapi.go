@@ -0,0 +1,149 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// statusAPIGroupVersion is the API group and version that check statuses are served as through the API aggregator
+const statusAPIGroupVersion = "status.comcast.github.io/v1"
+
+// statusAPIPath is where the check status API is served
+const statusAPIPath = "/apis/" + statusAPIGroupVersion
+
+// The resource served by the check status API
+const (
+	statusAPIResource = "kuberhealthystatuses"
+	statusAPISingular = "kuberhealthystatus"
+	statusAPIKind     = "KuberhealthyStatus"
+)
+
+// kuberhealthyStatus is the status of a single check as served by the check status API
+type kuberhealthyStatus struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Status            health.WorkloadDetails `json:"status"`
+}
+
+// kuberhealthyStatusList is a list of check statuses as served by the check status API
+type kuberhealthyStatusList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+	Items           []kuberhealthyStatus `json:"items"`
+}
+
+// kuberhealthyStatuses converts the checks and cluster-scoped checks of the state into check status API objects,
+// sorted by namespace and name.  Only checks in the supplied namespace are included unless it is blank.
+func kuberhealthyStatuses(state health.State, namespace string) []kuberhealthyStatus {
+	statuses := []kuberhealthyStatus{}
+	for _, checkDetails := range []map[string]health.WorkloadDetails{state.CheckDetails, state.ClusterCheckDetails} {
+		for _, key := range sortedCheckKeys(checkDetails) {
+			checkNamespace, name := splitCheckKey(key)
+			if len(namespace) > 0 && checkNamespace != namespace {
+				continue
+			}
+			status := kuberhealthyStatus{Status: checkDetails[key]}
+			status.Kind = statusAPIKind
+			status.APIVersion = statusAPIGroupVersion
+			status.Name = name
+			status.Namespace = checkNamespace
+			status.CreationTimestamp = metav1.NewTime(status.Status.Created)
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses
+}
+
+// statusAPIHandler serves the enriched state of every check, including whether it is static, expired or trending,
+// in the format of a read-only Kubernetes API so that it can be registered with the API aggregator and read with
+// kubectl get kuberhealthystatus.  The group version path lists the resource, /kuberhealthystatuses and
+// /namespaces/<namespace>/kuberhealthystatuses list check statuses and
+// /namespaces/<namespace>/kuberhealthystatuses/<name> gets the status of a single check.
+func statusAPIHandler(w http.ResponseWriter, r *http.Request, getState func() health.State) error {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, statusAPIPath), "/")
+	if len(path) == 0 {
+		resources := metav1.APIResourceList{GroupVersion: statusAPIGroupVersion}
+		resources.Kind = "APIResourceList"
+		resources.APIVersion = "v1"
+		resources.APIResources = []metav1.APIResource{{
+			Name:         statusAPIResource,
+			SingularName: statusAPISingular,
+			Namespaced:   true,
+			Kind:         statusAPIKind,
+			Verbs:        []string{"get", "list"},
+			ShortNames:   []string{"khstatus"},
+		}}
+		return json.NewEncoder(w).Encode(resources)
+	}
+
+	var namespace, name string
+	parts := strings.Split(path, "/")
+	switch {
+	case len(parts) == 1 && parts[0] == statusAPIResource:
+	case len(parts) == 3 && parts[0] == "namespaces" && parts[2] == statusAPIResource:
+		namespace = parts[1]
+	case len(parts) == 4 && parts[0] == "namespaces" && parts[2] == statusAPIResource:
+		namespace, name = parts[1], parts[3]
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	statuses := kuberhealthyStatuses(getState(), namespace)
+	if len(name) == 0 {
+		list := kuberhealthyStatusList{Items: statuses}
+		list.Kind = statusAPIKind + "List"
+		list.APIVersion = statusAPIGroupVersion
+		return json.NewEncoder(w).Encode(list)
+	}
+
+	for _, status := range statuses {
+		if status.Name == name {
+			return json.NewEncoder(w).Encode(status)
+		}
+	}
+	notFound := metav1.Status{
+		Status:  metav1.StatusFailure,
+		Reason:  metav1.StatusReasonNotFound,
+		Message: statusAPIResource + " \"" + name + "\" not found",
+		Details: &metav1.StatusDetails{Name: name, Kind: statusAPIResource},
+		Code:    http.StatusNotFound,
+	}
+	notFound.Kind = "Status"
+	notFound.APIVersion = "v1"
+	w.WriteHeader(http.StatusNotFound)
+	return json.NewEncoder(w).Encode(notFound)
+}
+
+// statusAPIHandlerFunc serves the check status API from the current state and logs the errors of statusAPIHandler
+func (k *Kuberhealthy) statusAPIHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	err := statusAPIHandler(w, r, func() health.State {
+		return k.getCurrentState([]string{})
+	})
+	if err != nil {
+		log.Errorln("status API endpoint error:", err)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// TestStatusAPIHandler ensures that check statuses are listed and fetched in the format of a Kubernetes API
+func TestStatusAPIHandler(t *testing.T) {
+	state := health.State{
+		CheckDetails: map[string]health.WorkloadDetails{
+			"kuberhealthy/dns": {OK: true, Status: health.StatusOK, Trend: &health.RunTrend{Trend: health.TrendStable}},
+			"team-a/ingress":   {OK: false, Status: health.StatusNotOK, Expired: true},
+		},
+		ClusterCheckDetails: map[string]health.WorkloadDetails{"team-b/deployment": {OK: true, Status: health.StatusOK, ClusterScoped: true}},
+	}
+	serve := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		err := statusAPIHandler(recorder, httptest.NewRequest(http.MethodGet, path, nil), func() health.State { return state })
+		if err != nil {
+			t.Fatal("unexpected error serving", path+":", err)
+		}
+		return recorder
+	}
+
+	var list kuberhealthyStatusList
+	json.NewDecoder(serve(statusAPIPath + "/kuberhealthystatuses").Body).Decode(&list)
+	if list.Kind != "KuberhealthyStatusList" || len(list.Items) != 3 || list.Items[2].Name != "deployment" {
+		t.Fatalf("expected every check to be listed, got %+v", list)
+	}
+
+	list = kuberhealthyStatusList{}
+	json.NewDecoder(serve(statusAPIPath + "/namespaces/team-a/kuberhealthystatuses").Body).Decode(&list)
+	if len(list.Items) != 1 || list.Items[0].Name != "ingress" || !list.Items[0].Status.Expired {
+		t.Fatalf("expected only the checks in team-a to be listed, got %+v", list)
+	}
+
+	var status kuberhealthyStatus
+	json.NewDecoder(serve(statusAPIPath + "/namespaces/kuberhealthy/kuberhealthystatuses/dns").Body).Decode(&status)
+	if status.Kind != statusAPIKind || status.Namespace != "kuberhealthy" || status.Status.Trend == nil {
+		t.Fatalf("expected the dns check status with its trend, got %+v", status)
+	}
+
+	recorder := serve(statusAPIPath + "/namespaces/kuberhealthy/kuberhealthystatuses/missing")
+	var notFound metav1.Status
+	json.NewDecoder(recorder.Body).Decode(&notFound)
+	if recorder.Code != http.StatusNotFound || notFound.Reason != metav1.StatusReasonNotFound {
+		t.Fatalf("expected a missing check to not be found, got %d %+v", recorder.Code, notFound)
+	}
+
+	var resources metav1.APIResourceList
+	json.NewDecoder(serve(statusAPIPath).Body).Decode(&resources)
+	if len(resources.APIResources) != 1 || resources.APIResources[0].SingularName != statusAPISingular {
+		t.Fatalf("expected the status resource to be advertised, got %+v", resources)
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statusCacheMaxEntries bounds the number of distinct status page queries that are cached at once
+const statusCacheMaxEntries = 100
+
+// cachedStatusPage is a rendered status page response
+type cachedStatusPage struct {
+	body       []byte
+	etag       string
+	generation uint64
+	expires    time.Time
+}
+
+// statusPageCache holds rendered status page responses by query so that dashboards polling the status page do not
+// cause the state to be gathered and serialized on every read.  Responses are kept for the status cache TTL or
+// until a khstate is written, whichever comes first.
+type statusPageCache struct {
+	sync.Mutex
+	generation uint64 // incremented on every khstate write to drop every cached response
+	pages      map[string]cachedStatusPage
+}
+
+// statusCache holds the rendered status page responses
+var statusCache = &statusPageCache{pages: make(map[string]cachedStatusPage)}
+
+// statusETag returns the entity tag of a rendered status page
+func statusETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// get returns the cached response for a query if it is still fresh
+func (c *statusPageCache) get(key string, now time.Time) (cachedStatusPage, bool) {
+	c.Lock()
+	defer c.Unlock()
+	page, ok := c.pages[key]
+	if !ok || page.generation != c.generation || !now.Before(page.expires) {
+		return cachedStatusPage{}, false
+	}
+	return page, true
+}
+
+// set caches the response for a query.  The generation is the one from before the response was rendered, so that
+// a response rendered while a khstate was written is never served as fresh.
+func (c *statusPageCache) set(key string, page cachedStatusPage) {
+	c.Lock()
+	defer c.Unlock()
+	if page.generation != c.generation {
+		return
+	}
+	if len(c.pages) >= statusCacheMaxEntries {
+		for k, cached := range c.pages {
+			if cached.generation != c.generation || time.Now().After(cached.expires) {
+				delete(c.pages, k)
+			}
+		}
+	}
+	if _, ok := c.pages[key]; !ok && len(c.pages) >= statusCacheMaxEntries {
+		return
+	}
+	c.pages[key] = page
+}
+
+// currentGeneration returns the generation that responses rendered now belong to
+func (c *statusPageCache) currentGeneration() uint64 {
+	c.Lock()
+	defer c.Unlock()
+	return c.generation
+}
+
+// invalidate drops every cached response
+func (c *statusPageCache) invalidate() {
+	c.Lock()
+	defer c.Unlock()
+	c.generation++
+	c.pages = make(map[string]cachedStatusPage)
+}
+
+// etagMatches determines if an If-None-Match header matches the supplied entity tag
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeStatusPage writes a rendered status page with its entity tag.  Readers that already hold the same response
+// get a 304 Not Modified without a body.
+func writeStatusPage(w http.ResponseWriter, r *http.Request, page cachedStatusPage) error {
+	w.Header().Set("ETag", page.etag)
+	if etagMatches(r.Header.Get("If-None-Match"), page.etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	_, err := w.Write(page.body)
+	return err
+}
+
+// bufferedResponse collects a response body so that it can be cached before it is written
+type bufferedResponse struct {
+	header http.Header
+	body   bytes.Buffer
+}
+
+// Header implements http.ResponseWriter
+func (b *bufferedResponse) Header() http.Header {
+	if b.header == nil {
+		b.header = make(http.Header)
+	}
+	return b.header
+}
+
+// Write implements http.ResponseWriter
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
+
+// WriteHeader implements http.ResponseWriter.  Status pages are always rendered as 200 OK.
+func (b *bufferedResponse) WriteHeader(int) {}
@@ -0,0 +1,63 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestStatusPageCache ensures that cached status pages are served until they expire or a khstate is written, and
+// that readers holding the current entity tag get a 304
+func TestStatusPageCache(t *testing.T) {
+	c := &statusPageCache{pages: make(map[string]cachedStatusPage)}
+	now := time.Now()
+	body := []byte(`{"OK": true}`)
+	page := cachedStatusPage{body: body, etag: statusETag(body), generation: c.currentGeneration(), expires: now.Add(time.Minute)}
+	c.set("namespace=kuberhealthy", page)
+
+	if _, ok := c.get("namespace=kuberhealthy", now.Add(time.Second)); !ok {
+		t.Fatal("expected the page to be served from the cache within its TTL")
+	}
+	if _, ok := c.get("namespace=kuberhealthy", now.Add(time.Minute)); ok {
+		t.Fatal("expected the page to expire after its TTL")
+	}
+	if _, ok := c.get("", now); ok {
+		t.Fatal("expected other queries to not be served the cached page")
+	}
+
+	// a khstate write drops every cached page, including pages rendered before the write that are cached after it
+	c.invalidate()
+	if _, ok := c.get("namespace=kuberhealthy", now); ok {
+		t.Fatal("expected a khstate write to invalidate the cache")
+	}
+	c.set("namespace=kuberhealthy", page)
+	if _, ok := c.get("namespace=kuberhealthy", now); ok {
+		t.Fatal("expected a page rendered before a khstate write to not be cached")
+	}
+
+	// readers that already hold the page get a 304 without a body
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("If-None-Match", `"other", `+page.etag)
+	w := httptest.NewRecorder()
+	writeStatusPage(w, req, page)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("ETag") != page.etag {
+		t.Fatalf("expected a 304 with the entity tag, got %d with %q", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	writeStatusPage(w, httptest.NewRequest(http.MethodGet, "/", nil), page)
+	if w.Code != http.StatusOK || w.Body.String() != string(body) {
+		t.Fatalf("expected the page to be written, got %d with %q", w.Code, w.Body.String())
+	}
+}
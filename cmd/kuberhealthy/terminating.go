@@ -0,0 +1,138 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// ErrNamespaceTerminating is returned when khstate is written for a check in a namespace that is being deleted
+var ErrNamespaceTerminating = errors.New("namespace is being deleted")
+
+// The ways khstates of checks in namespaces that are being deleted are handled
+const (
+	terminatingNamespaceModeSkip  = "skip"  // writes are skipped and the khstate is marked.  This is the default
+	terminatingNamespaceModeWrite = "write" // writes are attempted as usual
+)
+
+// namespacePhaseTTL is how long the phase of a namespace is remembered before it is looked up again
+const namespacePhaseTTL = time.Second * 30
+
+// namespacePhaseTimeout is how long looking up the phase of a namespace may take
+const namespacePhaseTimeout = time.Second * 5
+
+// namespacePhase is the last looked up phase of a namespace
+type namespacePhase struct {
+	terminating bool
+	checked     time.Time
+}
+
+// terminatingNamespaces remembers the phases of the namespaces khstates are written in, and the khstates that were
+// marked as being in a terminating namespace, so that neither is looked up or written on every write
+var terminatingNamespaces = struct {
+	sync.Mutex
+	phases map[string]namespacePhase
+	marked map[string]bool
+}{phases: make(map[string]namespacePhase), marked: make(map[string]bool)}
+
+// skipsTerminatingNamespaces returns true if writes to namespaces that are being deleted are skipped
+func skipsTerminatingNamespaces() bool {
+	return cfg.TerminatingNamespaceMode != terminatingNamespaceModeWrite
+}
+
+// lookupNamespaceTerminating looks up if a namespace is being deleted.  A namespace that is already gone is treated
+// as terminating, because nothing can be written to it either.  Other errors are treated as an active namespace so
+// that writes are attempted and fail as usual.
+func lookupNamespaceTerminating(namespace string) bool {
+	if kubernetesClient == nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), namespacePhaseTimeout)
+	defer cancel()
+	ns, err := kubernetesClient.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if k8sErrors.IsNotFound(err) {
+		return true
+	}
+	if err != nil {
+		log.Debugln("Failed to look up the phase of namespace", namespace+":", err)
+		return false
+	}
+	return ns.Status.Phase == v1.NamespaceTerminating || ns.DeletionTimestamp != nil
+}
+
+// namespaceTerminating returns true if the namespace is being deleted.  Phases are remembered for a short while and
+// a message is logged once when a namespace is first seen terminating.
+func namespaceTerminating(namespace string, now time.Time) bool {
+	terminatingNamespaces.Lock()
+	phase, known := terminatingNamespaces.phases[namespace]
+	terminatingNamespaces.Unlock()
+	if known && now.Sub(phase.checked) < namespacePhaseTTL {
+		return phase.terminating
+	}
+
+	terminating := lookupNamespaceTerminating(namespace)
+
+	terminatingNamespaces.Lock()
+	defer terminatingNamespaces.Unlock()
+	if terminating && !phase.terminating {
+		log.Warningln("Namespace", namespace, "is being deleted. Skipping khstate writes in it until it is gone or active again.")
+	}
+	if !terminating && phase.terminating {
+		log.Infoln("Namespace", namespace, "is no longer being deleted. Resuming khstate writes in it.")
+		for key := range terminatingNamespaces.marked {
+			if ns, _ := splitCheckKey(key); ns == namespace {
+				delete(terminatingNamespaces.marked, key)
+			}
+		}
+	}
+	terminatingNamespaces.phases[namespace] = namespacePhase{terminating: terminating, checked: now}
+	return terminating
+}
+
+// skipWriteToTerminatingNamespace returns true if the khstate of a check should not be written because its
+// namespace is being deleted.  The khstate is marked as being in a terminating namespace once if it still exists.
+func skipWriteToTerminatingNamespace(checkName string, stateNamespace string) bool {
+	if !skipsTerminatingNamespaces() || !namespaceTerminating(stateNamespace, time.Now()) {
+		return false
+	}
+
+	key := stateKey(checkName, stateNamespace)
+	terminatingNamespaces.Lock()
+	marked := terminatingNamespaces.marked[key]
+	terminatingNamespaces.marked[key] = true
+	terminatingNamespaces.Unlock()
+	if marked {
+		return true
+	}
+
+	err := updateCheckStateResource(checkName, stateNamespace, func(details *health.WorkloadDetails) bool {
+		if details.NamespaceTerminating {
+			return false
+		}
+		details.NamespaceTerminating = true
+		return true
+	})
+	if err != nil {
+		log.Debugln(stateNamespace, checkName, "could not mark khstate as being in a terminating namespace:", err)
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// TestTerminatingNamespace ensures that khstate writes in a namespace that is being deleted are skipped, that the
+// khstate is marked once and that writes resume when the namespace is active again
+func TestTerminatingNamespace(t *testing.T) {
+	stateVersions.reset()
+	defer stateVersions.reset()
+
+	stored := khstatecrd.NewKuberhealthyState("doomed-check", health.WorkloadDetails{OK: true, Status: health.StatusOK, Errors: []string{}})
+	stored.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	stored.Kind = "KuberhealthyState"
+	stored.Namespace = "doomed"
+
+	phase := v1.NamespaceTerminating
+	var writes int
+	url := useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/api/v1/namespaces/doomed":
+			json.NewEncoder(w).Encode(v1.Namespace{
+				TypeMeta:   metav1.TypeMeta{Kind: "Namespace", APIVersion: "v1"},
+				ObjectMeta: metav1.ObjectMeta{Name: "doomed"},
+				Status:     v1.NamespaceStatus{Phase: phase},
+			})
+		case strings.HasSuffix(r.URL.Path, "/khstates/doomed-check"):
+			if r.Method == http.MethodPut {
+				writes++
+				stored = khstatecrd.KuberhealthyState{}
+				json.NewDecoder(r.Body).Decode(&stored)
+			}
+			json.NewEncoder(w).Encode(stored)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	originalClient := kubernetesClient
+	kubernetesClient = client
+	defer func() {
+		kubernetesClient = originalClient
+	}()
+
+	failing := health.WorkloadDetails{OK: false, Errors: []string{"broken"}}
+	for i := 0; i < 3; i++ {
+		_, err := setCheckStateResourceIf("doomed-check", "doomed", failing, nil)
+		if !errors.Is(err, ErrNamespaceTerminating) {
+			t.Fatal("expected the write to be skipped because the namespace is being deleted, got", err)
+		}
+	}
+	if writes != 1 || !stored.Spec.NamespaceTerminating || !stored.Spec.OK {
+		t.Fatalf("expected the khstate to be marked once and keep its result, got %d writes of %+v", writes, stored.Spec)
+	}
+
+	// writes resume once the namespace is seen active again, and clear the mark
+	phase = v1.NamespaceActive
+	if namespaceTerminating("doomed", time.Now().Add(namespacePhaseTTL)) {
+		t.Fatal("expected the namespace to be active again")
+	}
+	_, err = setCheckStateResourceIf("doomed-check", "doomed", failing, nil)
+	if err != nil {
+		t.Fatal("unexpected error writing the khstate:", err)
+	}
+	if stored.Spec.NamespaceTerminating || stored.Spec.OK {
+		t.Fatalf("expected the result to be written without the mark, got %+v", stored.Spec)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// checkWriteThrottle tracks the last khstate write of a check and any newer write waiting to be flushed
+type checkWriteThrottle struct {
+	lastWrite time.Time
+	lastOK    bool
+	pending   *health.WorkloadDetails
+	timer     *time.Timer
+}
+
+// writeThrottles holds the write throttle of every check keyed by namespace/name
+var writeThrottles = struct {
+	sync.Mutex
+	checks map[string]*checkWriteThrottle
+}{checks: make(map[string]*checkWriteThrottle)}
+
+// minWriteIntervalForCheck returns the minimum time between khstate writes for a check.  Per-check overrides
+// take precedence over the global setting.
+func minWriteIntervalForCheck(checkName string, checkNamespace string) time.Duration {
+	if interval, ok := cfg.MinWriteIntervalOverrides[checkNamespace+"/"+checkName]; ok {
+		return interval
+	}
+	return cfg.MinWriteInterval
+}
+
+// throttleCheckStateWrite decides if a khstate write can happen now.  When a write arrives sooner than the minimum
+// write interval after the last one, it is held and true is returned.  Only the most recent held write is kept and
+// it is written once the interval has passed.  Writes that change the OK value of a check are never held.
+func throttleCheckStateWrite(checkName string, checkNamespace string, state health.WorkloadDetails) bool {
+	interval := minWriteIntervalForCheck(checkName, checkNamespace)
+	if interval <= 0 {
+		return false
+	}
+
+	key := checkNamespace + "/" + checkName
+	writeThrottles.Lock()
+	defer writeThrottles.Unlock()
+
+	throttle, ok := writeThrottles.checks[key]
+	if !ok {
+		writeThrottles.checks[key] = &checkWriteThrottle{lastWrite: time.Now(), lastOK: state.OK}
+		return false
+	}
+
+	sinceLastWrite := time.Since(throttle.lastWrite)
+	if sinceLastWrite >= interval || state.OK != throttle.lastOK {
+		// this write supersedes anything that was waiting
+		if throttle.timer != nil {
+			throttle.timer.Stop()
+			throttle.timer = nil
+		}
+		throttle.pending = nil
+		throttle.lastWrite = time.Now()
+		throttle.lastOK = state.OK
+		return false
+	}
+
+	log.Debugln(checkNamespace, checkName, "khstate write is being held by the minimum write interval of", interval)
+	throttledWrites.Inc(checkName, checkNamespace)
+	throttle.pending = &state
+	if throttle.timer == nil {
+		throttle.timer = time.AfterFunc(interval-sinceLastWrite, func() {
+			flushThrottledCheckStateWrite(checkName, checkNamespace)
+		})
+	}
+	return true
+}
+
+// flushThrottledCheckStateWrite writes the most recent held write of a check, if there is one
+func flushThrottledCheckStateWrite(checkName string, checkNamespace string) {
+	key := checkNamespace + "/" + checkName
+	writeThrottles.Lock()
+	throttle, ok := writeThrottles.checks[key]
+	if !ok || throttle.pending == nil {
+		writeThrottles.Unlock()
+		return
+	}
+	state := *throttle.pending
+	throttle.pending = nil
+	throttle.timer = nil
+	throttle.lastWrite = time.Now()
+	throttle.lastOK = state.OK
+	writeThrottles.Unlock()
+
+	log.Debugln(checkNamespace, checkName, "writing khstate held by the minimum write interval")
+	_, err := setCheckStateResourceIf(checkName, checkNamespace, state, nil)
+	if err != nil {
+		log.Errorln(checkNamespace, checkName, "error writing khstate held by the minimum write interval:", err)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// TestThrottleCheckStateWrite ensures that fast writes are held while OK transitions and overridden checks are not
+func TestThrottleCheckStateWrite(t *testing.T) {
+	originalInterval, originalOverrides := cfg.MinWriteInterval, cfg.MinWriteIntervalOverrides
+	cfg.MinWriteInterval = time.Hour
+	cfg.MinWriteIntervalOverrides = durationMap{"kuberhealthy/unthrottled": 0}
+	defer func() {
+		cfg.MinWriteInterval, cfg.MinWriteIntervalOverrides = originalInterval, originalOverrides
+		writeThrottles.Lock()
+		for _, throttle := range writeThrottles.checks {
+			if throttle.timer != nil {
+				throttle.timer.Stop()
+			}
+		}
+		writeThrottles.checks = make(map[string]*checkWriteThrottle)
+		writeThrottles.Unlock()
+	}()
+
+	if throttleCheckStateWrite("noisy", "kuberhealthy", health.WorkloadDetails{OK: true}) {
+		t.Fatal("the first write of a check was held")
+	}
+	if !throttleCheckStateWrite("noisy", "kuberhealthy", health.WorkloadDetails{OK: true}) {
+		t.Fatal("a write inside the minimum write interval was not held")
+	}
+	latest := health.WorkloadDetails{OK: true, RunDuration: "2s"}
+	throttleCheckStateWrite("noisy", "kuberhealthy", latest)
+	if writeThrottles.checks["kuberhealthy/noisy"].pending.RunDuration != "2s" {
+		t.Fatal("the most recent held write was not kept")
+	}
+	if throttleCheckStateWrite("noisy", "kuberhealthy", health.WorkloadDetails{OK: false, Errors: []string{"down"}}) {
+		t.Fatal("a write that changed the OK value was held")
+	}
+	if writeThrottles.checks["kuberhealthy/noisy"].pending != nil {
+		t.Fatal("a held write was kept after a newer write went through")
+	}
+
+	throttleCheckStateWrite("unthrottled", "kuberhealthy", health.WorkloadDetails{OK: true})
+	if throttleCheckStateWrite("unthrottled", "kuberhealthy", health.WorkloadDetails{OK: true}) {
+		t.Fatal("a write was held for a check with a per-check override of 0")
+	}
+}
+
+// TestTimeoutGoesThroughThrottle ensures that a timeout replaces a result held by the minimum write interval
+// instead of being written before it, where the held result would be written over it later
+func TestTimeoutGoesThroughThrottle(t *testing.T) {
+	originalInterval := cfg.MinWriteInterval
+	cfg.MinWriteInterval = time.Hour
+	defer func() {
+		cfg.MinWriteInterval = originalInterval
+		writeThrottles.Lock()
+		for _, throttle := range writeThrottles.checks {
+			if throttle.timer != nil {
+				throttle.timer.Stop()
+			}
+		}
+		writeThrottles.checks = make(map[string]*checkWriteThrottle)
+		writeThrottles.Unlock()
+	}()
+
+	existing := khstatecrd.NewKuberhealthyState("slow", health.WorkloadDetails{OK: false, Errors: []string{"down"}})
+	putCount := useFakeKHStateServer(t, &existing)
+
+	failing := health.WorkloadDetails{OK: false, Errors: []string{"down"}}
+	throttleCheckStateWrite("slow", "kuberhealthy", failing)
+	if !throttleCheckStateWrite("slow", "kuberhealthy", failing) {
+		t.Fatal("a write inside the minimum write interval was not held")
+	}
+
+	check := NewFakeCheck()
+	check.CheckName, check.Namespace = "slow", "kuberhealthy"
+	NewKuberhealthy().setCheckTimedOut(check, time.Now())
+
+	pending := writeThrottles.checks["kuberhealthy/slow"].pending
+	if pending == nil || !pending.TimedOut {
+		t.Fatalf("expected the timeout to replace the held result, got %+v", pending)
+	}
+	if putCount() != 0 {
+		t.Fatalf("expected the timeout to be held with the result it replaced, got %d writes", putCount())
+	}
+}
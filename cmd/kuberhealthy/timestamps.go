@@ -0,0 +1,128 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// The formats timestamps can be rendered in on the status page
+const (
+	timeFormatRFC3339 = "rfc3339" // RFC3339 in the requested timezone.  This is the default
+	timeFormatUnix    = "unix"    // seconds since the Unix epoch
+)
+
+// timestampPresentation is how the timestamps of a status page response are rendered.  Stored timestamps are
+// always UTC, only the response changes.
+type timestampPresentation struct {
+	format   string
+	location *time.Location
+}
+
+// parseTimestampPresentation reads the timeFormat and timezone query parameters.  The returned bool is false when
+// neither was supplied, so the status page is rendered as it is stored.
+func parseTimestampPresentation(values url.Values) (timestampPresentation, bool, error) {
+	p := timestampPresentation{format: values.Get("timeFormat"), location: time.UTC}
+	timezone := values.Get("timezone")
+	if len(p.format) == 0 && len(timezone) == 0 {
+		return p, false, nil
+	}
+
+	if len(p.format) == 0 {
+		p.format = timeFormatRFC3339
+	}
+	if p.format != timeFormatRFC3339 && p.format != timeFormatUnix {
+		return p, false, fmt.Errorf("timeFormat %s is not one of %s or %s", p.format, timeFormatRFC3339, timeFormatUnix)
+	}
+	if len(timezone) > 0 {
+		location, err := time.LoadLocation(timezone)
+		if err != nil {
+			return p, false, fmt.Errorf("timezone %s is not a known timezone such as America/New_York: %w", timezone, err)
+		}
+		p.location = location
+	}
+	return p, true, nil
+}
+
+// render returns a timestamp in the requested format.  Timestamps that were never set stay zero.
+func (p timestampPresentation) render(t time.Time) interface{} {
+	if p.format == timeFormatUnix {
+		if t.IsZero() {
+			return 0
+		}
+		return t.Unix()
+	}
+	if t.IsZero() {
+		return t
+	}
+	return t.In(p.location)
+}
+
+// renderedDetails is the result of a check with its timestamps rendered.  The timestamp fields shadow the ones of
+// the embedded details when marshaled.
+type renderedDetails struct {
+	health.WorkloadDetails
+	LastRun          interface{}
+	LastResultChange interface{}
+	ErrorsSince      interface{}
+	Created          interface{}
+	LastSuccess      interface{}
+}
+
+// renderedState is a status page response with the timestamps of every check and job rendered
+type renderedState struct {
+	health.State
+	CheckDetails        map[string]renderedDetails
+	JobDetails          map[string]renderedDetails
+	ClusterCheckDetails map[string]renderedDetails `json:",omitempty"`
+}
+
+// renderDetails renders the timestamps of every entry in a map of workload details
+func (p timestampPresentation) renderDetails(details map[string]health.WorkloadDetails) map[string]renderedDetails {
+	if details == nil {
+		return nil
+	}
+	rendered := make(map[string]renderedDetails, len(details))
+	for k, d := range details {
+		rendered[k] = renderedDetails{
+			WorkloadDetails:  d,
+			LastRun:          p.render(d.LastRun),
+			LastResultChange: p.render(d.LastResultChange),
+			ErrorsSince:      p.render(d.ErrorsSince),
+			Created:          p.render(d.Created),
+			LastSuccess:      p.render(d.LastSuccess),
+		}
+	}
+	return rendered
+}
+
+// writeStatusWithTimestamps writes a status page response with its timestamps rendered
+func writeStatusWithTimestamps(w http.ResponseWriter, state health.State, p timestampPresentation) error {
+	rendered := renderedState{
+		State:               state,
+		CheckDetails:        p.renderDetails(state.CheckDetails),
+		JobDetails:          p.renderDetails(state.JobDetails),
+		ClusterCheckDetails: p.renderDetails(state.ClusterCheckDetails),
+	}
+	b, err := json.MarshalIndent(rendered, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling status page with rendered timestamps: %w", err)
+	}
+	_, err = w.Write(b)
+	return err
+}
@@ -0,0 +1,79 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// TestWriteStatusWithTimestamps ensures that status page timestamps are rendered in the requested format and
+// timezone while the stored details are left alone
+func TestWriteStatusWithTimestamps(t *testing.T) {
+	lastRun := time.Date(2020, 11, 10, 15, 4, 5, 0, time.UTC)
+	state := health.NewState()
+	state.CheckDetails["kuberhealthy/dns"] = health.WorkloadDetails{OK: true, LastRun: lastRun}
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{query: "timeFormat=unix", expected: `"LastRun": 1605020645`},
+		{query: "timezone=America/New_York", expected: `"LastRun": "2020-11-10T10:04:05-05:00"`},
+		{query: "timeFormat=rfc3339&timezone=UTC", expected: `"LastRun": "2020-11-10T15:04:05Z"`},
+	}
+	for _, test := range tests {
+		values, _ := url.ParseQuery(test.query)
+		presentation, render, err := parseTimestampPresentation(values)
+		if err != nil || !render {
+			t.Fatalf("%s: expected timestamps to be rendered, got error %v", test.query, err)
+		}
+		w := httptest.NewRecorder()
+		err = writeStatusWithTimestamps(w, state, presentation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := w.Body.String()
+		if !strings.Contains(body, test.expected) || strings.Count(body, `"LastRun"`) != 1 {
+			t.Fatalf("%s: expected a single %s, got %s", test.query, test.expected, body)
+		}
+
+		// the rest of the details are kept
+		rendered := struct{ CheckDetails map[string]struct{ OK bool } }{}
+		json.Unmarshal(w.Body.Bytes(), &rendered)
+		if !rendered.CheckDetails["kuberhealthy/dns"].OK {
+			t.Fatalf("%s: expected the check details to be kept, got %s", test.query, body)
+		}
+	}
+	if !state.CheckDetails["kuberhealthy/dns"].LastRun.Equal(lastRun) || state.CheckDetails["kuberhealthy/dns"].LastRun.Location() != time.UTC {
+		t.Fatal("expected the stored timestamps to stay UTC")
+	}
+
+	// unknown formats and timezones are rejected, and no parameters render the status as it is stored
+	for _, query := range []string{"timeFormat=kitchen", "timezone=Mars/Olympus_Mons"} {
+		values, _ := url.ParseQuery(query)
+		_, _, err := parseTimestampPresentation(values)
+		if err == nil {
+			t.Fatalf("%s: expected an error", query)
+		}
+	}
+	_, render, _ := parseTimestampPresentation(url.Values{})
+	if render {
+		t.Fatal("expected timestamps to not be rendered without parameters")
+	}
+}
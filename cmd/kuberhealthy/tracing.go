@@ -0,0 +1,104 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// tracer starts spans around the steps of a khstate write.  It mirrors the shape of an OpenTelemetry tracer so
+// that one can be adapted to it and assigned to stateTracer.
+type tracer interface {
+	Start(ctx context.Context, name string) (context.Context, traceSpan)
+}
+
+// traceSpan is a single traced step of a khstate write
+type traceSpan interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+// stateTracer traces every khstate write.  It does nothing unless state write tracing is enabled.
+var stateTracer tracer = noopTracer{}
+
+// noopTracer is a tracer that records nothing
+type noopTracer struct{}
+
+// Start returns a span that records nothing
+func (noopTracer) Start(ctx context.Context, name string) (context.Context, traceSpan) {
+	return ctx, noopSpan{}
+}
+
+// noopSpan is a span that records nothing
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value interface{}) {}
+func (noopSpan) RecordError(err error)                      {}
+func (noopSpan) End()                                       {}
+
+// logTracer is a tracer that logs every span with its duration and attributes when it ends
+type logTracer struct{}
+
+// Start starts a span that is logged when it ends
+func (logTracer) Start(ctx context.Context, name string) (context.Context, traceSpan) {
+	return ctx, &logSpan{name: name, start: time.Now(), attributes: make(log.Fields)}
+}
+
+// logSpan is a span that is logged when it ends
+type logSpan struct {
+	sync.Mutex
+	name       string
+	start      time.Time
+	attributes log.Fields
+}
+
+// SetAttribute sets an attribute that is logged with the span
+func (s *logSpan) SetAttribute(key string, value interface{}) {
+	s.Lock()
+	defer s.Unlock()
+	s.attributes[key] = value
+}
+
+// RecordError records an error that is logged with the span
+func (s *logSpan) RecordError(err error) {
+	s.SetAttribute("error", err.Error())
+}
+
+// End logs the span
+func (s *logSpan) End() {
+	s.Lock()
+	defer s.Unlock()
+	log.WithFields(s.attributes).Infoln("trace:", s.name, "took", time.Since(s.start))
+}
+
+// endSpan records the error of a traced step, if any, and ends its span
+func endSpan(span traceSpan, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// traceStateAPICall runs an API call of a khstate write in a span that records the latency and error of the call
+func traceStateAPICall(ctx context.Context, name string, call func() error) error {
+	_, span := stateTracer.Start(ctx, name)
+	start := time.Now()
+	err := call()
+	span.SetAttribute("latency_ms", time.Since(start).Milliseconds())
+	endSpan(span, err)
+	return err
+}
@@ -0,0 +1,108 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// recordedSpan is a span kept by recordingTracer
+type recordedSpan struct {
+	name       string
+	attributes map[string]interface{}
+	err        error
+}
+
+func (s *recordedSpan) SetAttribute(key string, value interface{}) { s.attributes[key] = value }
+func (s *recordedSpan) RecordError(err error)                      { s.err = err }
+func (s *recordedSpan) End()                                       {}
+
+// recordingTracer keeps every span it starts
+type recordingTracer struct {
+	spans []*recordedSpan
+}
+
+func (r *recordingTracer) Start(ctx context.Context, name string) (context.Context, traceSpan) {
+	span := &recordedSpan{name: name, attributes: make(map[string]interface{})}
+	r.spans = append(r.spans, span)
+	return ctx, span
+}
+
+// TestStateWriteTracing ensures that every attempt and API call of a khstate write is traced, including attempts
+// retried after a conflict
+func TestStateWriteTracing(t *testing.T) {
+	stateVersions.reset()
+	stored := khstatecrd.NewKuberhealthyState("traced-check", health.WorkloadDetails{OK: true})
+	stored.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	stored.Kind = "KuberhealthyState"
+	stored.Namespace = "kuberhealthy"
+
+	var conflicted bool
+	useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPut {
+			if !conflicted {
+				conflicted = true
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).Encode(metav1.Status{Status: metav1.StatusFailure, Reason: metav1.StatusReasonConflict, Code: http.StatusConflict})
+				return
+			}
+			stored = khstatecrd.KuberhealthyState{}
+			json.NewDecoder(r.Body).Decode(&stored)
+		}
+		json.NewEncoder(w).Encode(stored)
+	})
+
+	recorder := &recordingTracer{}
+	originalTracer := stateTracer
+	stateTracer = recorder
+	defer func() {
+		stateTracer = originalTracer
+	}()
+
+	_, err := setCheckStateResourceIf("traced-check", "kuberhealthy", health.WorkloadDetails{OK: false, Errors: []string{"broken"}}, nil)
+	if err != nil {
+		t.Fatal("unexpected error writing khstate:", err)
+	}
+
+	var names []string
+	for _, span := range recorder.spans {
+		names = append(names, span.name)
+	}
+	expected := "khstate.write,khstate.write.attempt,khstate.get,khstate.update,khstate.write.attempt,khstate.get,khstate.update"
+	if strings.Join(names, ",") != expected {
+		t.Fatalf("expected the spans %s, got %s", expected, strings.Join(names, ","))
+	}
+
+	write, firstAttempt, firstUpdate, secondAttempt := recorder.spans[0], recorder.spans[1], recorder.spans[3], recorder.spans[4]
+	if write.attributes["attempts"] != 2 || write.err != nil {
+		t.Fatalf("expected the write to take 2 attempts without an error, got %+v", write)
+	}
+	if firstAttempt.attributes["attempt"] != 1 || firstAttempt.attributes["conflict"] != true || firstUpdate.err == nil {
+		t.Fatalf("expected the first attempt to conflict, got %+v", firstAttempt)
+	}
+	if secondAttempt.attributes["attempt"] != 2 || secondAttempt.attributes["conflict"] != false {
+		t.Fatalf("expected the second attempt to succeed, got %+v", secondAttempt)
+	}
+	if _, ok := firstUpdate.attributes["latency_ms"]; !ok {
+		t.Fatal("expected the latency of the update to be recorded")
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// ErrTransactionRolledBack is returned when one of the writes of an atomic set of check states failed and the writes
+// that had already been applied were rolled back
+var ErrTransactionRolledBack = errors.New("atomic khstate writes failed and were rolled back")
+
+// ErrTransactionPartiallyApplied is returned when one of the writes of an atomic set of check states failed and some
+// of the writes that had already been applied could not be rolled back
+var ErrTransactionPartiallyApplied = errors.New("atomic khstate writes failed and could not all be rolled back")
+
+// stagedStateWrite is a write of an atomic set of check states along with the details it replaces
+type stagedStateWrite struct {
+	key            string
+	name           string
+	namespace      string
+	stateNamespace string
+	state          health.WorkloadDetails
+	prior          health.WorkloadDetails
+}
+
+// setCheckStatesAtomic writes the states of several checks, keyed by namespace/name, so that either all of them or
+// none of them are recorded.  khstates have no transactions, so this is best effort: the current details of every
+// khstate are staged first, the states are written one at a time in key order and, when a write fails, the states
+// already written are written back with the details they replaced.  Readers can see some of the states written
+// before a failure is rolled back, and notifications, state change events and run history of the writes that were
+// rolled back are not taken back.  A rollback also replaces anything written to those khstates in the meantime.
+// ErrTransactionRolledBack is returned when every applied write was rolled back and ErrTransactionPartiallyApplied
+// names the checks that were left with their new state when rolling back failed too.
+func setCheckStatesAtomic(states map[string]health.WorkloadDetails) error {
+	if cfg.ReadOnly {
+		return ErrReadOnly
+	}
+
+	// stage every write before anything is applied, so that a check that can not be written fails the whole set
+	keys := make([]string, 0, len(states))
+	for key := range states {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	staged := make([]stagedStateWrite, 0, len(keys))
+	for _, key := range keys {
+		namespace, name := splitCheckKey(key)
+		if len(namespace) == 0 || len(name) == 0 {
+			return fmt.Errorf("check state key %s is not of the form namespace/name", key)
+		}
+		stateNamespace := stateNamespaceForCheck(name, namespace)
+		if !stateNamespaceManaged(stateNamespace) {
+			return fmt.Errorf("error staging khstate write for %s: %w", key, ErrNamespaceExcluded)
+		}
+		err := ensureStateResourceExists(name, namespace, health.KHCheck)
+		if err != nil {
+			return fmt.Errorf("error staging khstate write for %s: %w", key, err)
+		}
+		existing, err := khStateClient.Get(metav1.GetOptions{}, stateCRDResource, sanitizeResourceName(name), stateNamespace)
+		if err != nil {
+			return fmt.Errorf("error staging khstate write for %s: %w", key, err)
+		}
+		staged = append(staged, stagedStateWrite{
+			key:            key,
+			name:           name,
+			namespace:      namespace,
+			stateNamespace: stateNamespace,
+			state:          states[key],
+			prior:          decryptStateFields(existing.Spec),
+		})
+	}
+
+	// apply the writes in order, stopping at the first one that fails
+	var applied []stagedStateWrite
+	var failed string
+	var failure error
+	for _, write := range staged {
+		written, err := setCheckStateResourceIf(write.name, write.namespace, write.state, nil)
+		if written {
+			applied = append(applied, write)
+			continue
+		}
+		// a result that was already recorded leaves nothing to roll back
+		if err == nil {
+			continue
+		}
+		failed, failure = write.key, err
+		break
+	}
+	if failure == nil {
+		return nil
+	}
+	log.Warningln("Atomic khstate write of", failed, "failed. Rolling back", len(applied), "khstates:", failure)
+
+	// roll back the applied writes newest first, so that the khstates end up as they were before the set was written
+	var unreverted []string
+	for i := len(applied) - 1; i >= 0; i-- {
+		write := applied[i]
+		err := updateCheckStateResource(write.name, write.stateNamespace, func(details *health.WorkloadDetails) bool {
+			*details = write.prior
+			return true
+		})
+		if err != nil {
+			log.Errorln("Failed to roll back the khstate of", write.key, "after a failed atomic write:", err)
+			unreverted = append(unreverted, write.key)
+		}
+	}
+	if len(unreverted) > 0 {
+		sort.Strings(unreverted)
+		return fmt.Errorf("%w: writing %s failed with %v and %s kept their new state", ErrTransactionPartiallyApplied, failed, failure, strings.Join(unreverted, ", "))
+	}
+	return fmt.Errorf("%w: writing %s failed with %v", ErrTransactionRolledBack, failed, failure)
+}
@@ -0,0 +1,112 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// TestSetCheckStatesAtomic ensures that a set of check states is written together, that the applied writes are
+// rolled back when one of them fails and that a failed rollback is reported
+func TestSetCheckStatesAtomic(t *testing.T) {
+	stateVersions.reset()
+	defer stateVersions.reset()
+
+	states := map[string]*khstatecrd.KuberhealthyState{}
+	for _, name := range []string{"composite-a", "composite-b", "composite-c"} {
+		state := khstatecrd.NewKuberhealthyState(name, health.WorkloadDetails{OK: true, Status: health.StatusOK, Errors: []string{}, Namespace: "kuberhealthy"})
+		state.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+		state.Kind = "KuberhealthyState"
+		state.Namespace = "kuberhealthy"
+		states[name] = &state
+	}
+	rejected := map[string]bool{}          // khstates that every write is rejected for
+	rejectedRollbacks := map[string]bool{} // khstates that writes of an OK state are rejected for
+	var writes []string
+	useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		name := path.Base(r.URL.Path)
+		state := states[name]
+		if r.Method == http.MethodPut {
+			var written khstatecrd.KuberhealthyState
+			json.NewDecoder(r.Body).Decode(&written)
+			if rejected[name] || (rejectedRollbacks[name] && written.Spec.OK) {
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(metav1.Status{TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"}, Status: metav1.StatusFailure, Message: "forbidden", Reason: metav1.StatusReasonForbidden, Code: http.StatusForbidden})
+				return
+			}
+			writes = append(writes, name)
+			*state = written
+			state.ResourceVersion += "1"
+		}
+		json.NewEncoder(w).Encode(state)
+	})
+
+	failing := func() map[string]health.WorkloadDetails {
+		return map[string]health.WorkloadDetails{
+			"kuberhealthy/composite-a": {OK: false, Errors: []string{"a failed"}},
+			"kuberhealthy/composite-b": {OK: false, Errors: []string{"b failed"}},
+			"kuberhealthy/composite-c": {OK: false, Errors: []string{"c failed"}},
+		}
+	}
+
+	// the last write fails, so the first two are written back with their prior details
+	rejected["composite-c"] = true
+	err := setCheckStatesAtomic(failing())
+	if !errors.Is(err, ErrTransactionRolledBack) {
+		t.Fatal("expected the writes to be rolled back, got", err)
+	}
+	for name, state := range states {
+		if !state.Spec.OK {
+			t.Fatalf("expected %s to be rolled back to OK, got %+v", name, state.Spec)
+		}
+	}
+	if len(writes) != 4 || writes[2] != "composite-b" || writes[3] != "composite-a" {
+		t.Fatal("expected two writes rolled back newest first, got", writes)
+	}
+
+	// a failed rollback names the checks that kept their new state
+	writes = nil
+	stateVersions.reset()
+	rejectedRollbacks["composite-b"] = true
+	err = setCheckStatesAtomic(failing())
+	if !errors.Is(err, ErrTransactionPartiallyApplied) {
+		t.Fatal("expected the writes to be partially applied, got", err)
+	}
+	if !states["composite-a"].Spec.OK || states["composite-b"].Spec.OK || !strings.Contains(err.Error(), "kuberhealthy/composite-b kept") {
+		t.Fatal("expected only composite-b to keep its new state, got", err)
+	}
+
+	// every write succeeds
+	writes = nil
+	stateVersions.reset()
+	rejected["composite-c"] = false
+	err = setCheckStatesAtomic(failing())
+	if err != nil {
+		t.Fatal("unexpected error writing the check states:", err)
+	}
+	for name, state := range states {
+		if state.Spec.OK {
+			t.Fatalf("expected %s to be written, got %+v", name, state.Spec)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// defaultTrendRuns is the number of recent runs the trend of a check is found from when none is configured
+const defaultTrendRuns = 20
+
+// trendChangeThreshold is how much the failure rate of the recent runs has to differ from the older runs for a
+// check to be improving or worsening instead of stable
+const trendChangeThreshold = 0.2
+
+// trendRuns returns the configured number of runs the trend of a check is found from
+func trendRuns() int {
+	if cfg.TrendRuns < 2 {
+		return defaultTrendRuns
+	}
+	return cfg.TrendRuns
+}
+
+// computeTrend splits the last n runs of a check in half and compares the failure rate of the recent half with the
+// older half.  The trend is unknown until the run history holds n runs.
+func computeTrend(name string, namespace string, n int) health.RunTrend {
+	runHistory.Lock()
+	defer runHistory.Unlock()
+
+	history, exists := runHistory.checks[stateKey(name, namespace)]
+	if !exists || n < 2 || len(history.runs) < n {
+		return health.RunTrend{Trend: health.TrendUnknown}
+	}
+
+	runs := history.runs[len(history.runs)-n:]
+	older, recent := runs[:n/2], runs[n/2:]
+	trend := health.RunTrend{Trend: health.TrendStable, OlderRuns: len(older), RecentRuns: len(recent)}
+	for _, run := range older {
+		if !run.OK {
+			trend.OlderFailed++
+		}
+	}
+	for _, run := range recent {
+		if !run.OK {
+			trend.RecentFailed++
+		}
+	}
+
+	change := float64(trend.RecentFailed)/float64(trend.RecentRuns) - float64(trend.OlderFailed)/float64(trend.OlderRuns)
+	switch {
+	case change >= trendChangeThreshold:
+		trend.Trend = health.TrendWorsening
+	case change <= -trendChangeThreshold:
+		trend.Trend = health.TrendImproving
+	}
+	return trend
+}
+
+// markTrends sets the trend of every check.  Checks without enough run history have an unknown trend.
+func markTrends(checkDetails map[string]health.WorkloadDetails) {
+	n := trendRuns()
+	for key, details := range checkDetails {
+		namespace, name := splitCheckKey(key)
+		trend := computeTrend(name, namespace, n)
+		details.Trend = &trend
+		checkDetails[key] = details
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// TestComputeTrend ensures that the failure rate of recent runs is compared against older runs and that a short
+// run history has an unknown trend
+func TestComputeTrend(t *testing.T) {
+	start := time.Now().Add(-time.Hour)
+	record := func(name string, failures ...bool) {
+		for i, failed := range failures {
+			recordRun(name, "kuberhealthy", !failed, start.Add(time.Minute*time.Duration(i)))
+		}
+	}
+	record("worsening-check", false, false, false, false, true, true, false, true, true, false)
+	record("improving-check", true, true, false, true, true, false, false, false, false, false)
+	record("stable-check", true, false, false, false, false, false, true, false, false, false)
+	record("short-check", true, true)
+
+	tests := map[string]health.RunTrend{
+		"worsening-check": {Trend: health.TrendWorsening, OlderRuns: 5, OlderFailed: 1, RecentRuns: 5, RecentFailed: 3},
+		"improving-check": {Trend: health.TrendImproving, OlderRuns: 5, OlderFailed: 4, RecentRuns: 5, RecentFailed: 0},
+		"stable-check":    {Trend: health.TrendStable, OlderRuns: 5, OlderFailed: 1, RecentRuns: 5, RecentFailed: 1},
+		"short-check":     {Trend: health.TrendUnknown},
+	}
+	for name, expected := range tests {
+		trend := computeTrend(name, "kuberhealthy", 10)
+		if trend != expected {
+			t.Fatalf("%s: expected trend %+v, got %+v", name, expected, trend)
+		}
+	}
+
+	n := newWebhookNotifier("http://localhost", "")
+	trend := tests["worsening-check"]
+	body := string(n.render("dns", "kuberhealthy", health.WorkloadDetails{OK: false, Errors: []string{"lookup timed out"}, Trend: &trend}))
+	expected := "Kuberhealthy check kuberhealthy/dns is failing: lookup timed out. It is worsening: 1/5 failures before, 3/5 now"
+	if body != expected {
+		t.Fatalf("expected the notification %q, got %q", expected, body)
+	}
+}
@@ -14,10 +14,14 @@ package main
 import (
 	"errors"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
 )
 
 // getEnvVar attempts to retrieve and then validates an environmental variable
@@ -80,3 +84,36 @@ func containsString(s string, list []string) bool {
 	}
 	return false
 }
+
+// sortedCheckKeys returns the namespace/name keys of a map of check details sorted by namespace and then name
+func sortedCheckKeys(details map[string]health.WorkloadDetails) []string {
+	keys := make([]string, 0, len(details))
+	for key := range details {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		namespaceI, nameI := splitCheckKey(keys[i])
+		namespaceJ, nameJ := splitCheckKey(keys[j])
+		if namespaceI != namespaceJ {
+			return namespaceI < namespaceJ
+		}
+		return nameI < nameJ
+	})
+	return keys
+}
+
+// sortKHStates sorts a list of khstates from the reflector store by namespace and then name.  Items that are not
+// khstates are moved to the end.
+func sortKHStates(khStates []interface{}) {
+	sort.SliceStable(khStates, func(i, j int) bool {
+		a, aOK := khStates[i].(*khstatecrd.KuberhealthyState)
+		b, bOK := khStates[j].(*khstatecrd.KuberhealthyState)
+		if !aOK || !bOK {
+			return aOK && !bOK
+		}
+		if a.GetNamespace() != b.GetNamespace() {
+			return a.GetNamespace() < b.GetNamespace()
+		}
+		return a.GetName() < b.GetName()
+	})
+}
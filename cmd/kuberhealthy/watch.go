@@ -0,0 +1,202 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// stateWatchBuffer is how many state change events are held for a watcher that is not keeping up.  Events beyond
+// that are dropped for that watcher.
+const stateWatchBuffer = 100
+
+// stateWatchKeepalive is how often a comment is sent to idle watchers so that proxies do not close the stream
+const stateWatchKeepalive = time.Second * 30
+
+// stateChangeEvent is sent to watchers when the result of a check changes
+type stateChangeEvent struct {
+	Name       string
+	Namespace  string
+	Labels     map[string]string `json:",omitempty"` // the labels of the khcheck
+	PreviousOK bool
+	OK         bool
+	Errors     []string
+	Timestamp  time.Time
+	Change     *fieldChange `json:",omitempty"` // the field that changed, for field change events
+}
+
+// stateWatcher is a single client watching for state changes
+type stateWatcher struct {
+	namespace string          // only events for checks in this namespace are sent.  Blank means all namespaces
+	selector  labels.Selector // only events for checks with matching labels are sent
+	events    chan stateChangeEvent
+}
+
+// wants determines if an event matches the filters of the watcher
+func (w *stateWatcher) wants(event stateChangeEvent) bool {
+	if len(w.namespace) > 0 && w.namespace != event.Namespace {
+		return false
+	}
+	return w.selector.Matches(labels.Set(event.Labels))
+}
+
+// stateChangeBroadcaster fans state change events out to every watcher
+type stateChangeBroadcaster struct {
+	lock     sync.Mutex
+	watchers map[*stateWatcher]bool
+}
+
+// stateChanges is the broadcaster that khstate writes publish result changes to
+var stateChanges = &stateChangeBroadcaster{watchers: make(map[*stateWatcher]bool)}
+
+// subscribe adds a watcher for the supplied namespace and label selector
+func (b *stateChangeBroadcaster) subscribe(namespace string, selector labels.Selector) *stateWatcher {
+	w := &stateWatcher{
+		namespace: namespace,
+		selector:  selector,
+		events:    make(chan stateChangeEvent, stateWatchBuffer),
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.watchers[w] = true
+	stateWatchers.Set(float64(len(b.watchers)))
+	return w
+}
+
+// unsubscribe removes a watcher.  No events are sent to it afterwards.
+func (b *stateChangeBroadcaster) unsubscribe(w *stateWatcher) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.watchers, w)
+	stateWatchers.Set(float64(len(b.watchers)))
+}
+
+// hasWatchers determines if anyone is watching for state changes
+func (b *stateChangeBroadcaster) hasWatchers() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return len(b.watchers) > 0
+}
+
+// publish sends an event to every watcher that wants it without waiting on any of them
+func (b *stateChangeBroadcaster) publish(event stateChangeEvent) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for w := range b.watchers {
+		if !w.wants(event) {
+			continue
+		}
+		select {
+		case w.events <- event:
+		default:
+			stateWatchEventsDropped.Inc()
+		}
+	}
+}
+
+// checkLabels returns the labels of a khcheck.  Labels are not required to publish an event, so errors only mean
+// the event has no labels.
+func checkLabels(checkName string, checkNamespace string) map[string]string {
+	if khCheckClient == nil {
+		return nil
+	}
+	check, err := khCheckClient.Get(metav1.GetOptions{}, checkCRDResource, checkNamespace, checkName)
+	if err != nil {
+		log.Debugln("state watch: unable to fetch labels of khcheck", checkNamespace+"/"+checkName+":", err)
+		return nil
+	}
+	return check.Labels
+}
+
+// publishStateChange publishes a change in the result of a check to watchers.  Nothing is fetched when there are no
+// watchers.
+func publishStateChange(checkName string, checkNamespace string, previous health.WorkloadDetails, state health.WorkloadDetails) {
+	if !stateChanges.hasWatchers() {
+		return
+	}
+	stateChanges.publish(stateChangeEvent{
+		Name:       checkName,
+		Namespace:  checkNamespace,
+		Labels:     checkLabels(checkName, checkNamespace),
+		PreviousOK: previous.OK,
+		OK:         state.OK,
+		Errors:     state.Errors,
+		Timestamp:  state.LastRun,
+	})
+}
+
+// watchStateChangesHandler streams state change events to the client as server-sent events until the client
+// disconnects.  The namespace query parameter limits events to a single namespace and the labelSelector query
+// parameter limits them to checks with matching labels.
+func watchStateChangesHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return nil
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return errors.New("response writer does not support streaming")
+	}
+	selector, err := labels.Parse(r.URL.Query().Get("labelSelector"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "invalid label selector:", err)
+		return nil
+	}
+
+	watcher := stateChanges.subscribe(r.URL.Query().Get("namespace"), selector)
+	defer stateChanges.unsubscribe(watcher)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(stateWatchKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-keepalive.C:
+			_, err = fmt.Fprint(w, ": keepalive\n\n")
+		case event := <-watcher.events:
+			var b []byte
+			b, err = json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			name := "stateChange"
+			if event.Change != nil {
+				name = "fieldChange"
+			}
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, b)
+		}
+		if err != nil {
+			log.Debugln("state watch: client went away:", err)
+			return nil
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestWatchStateChanges ensures that watchers receive the state changes that match their filters as server-sent
+// events and are removed when they disconnect
+func TestWatchStateChanges(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := watchStateChangesHandler(w, r)
+		if err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?namespace=team-a&labelSelector=tier%3Dgold")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatal("expected an event stream, got", resp.Header.Get("Content-Type"))
+	}
+	if !stateChanges.hasWatchers() {
+		t.Fatal("expected the client to be watching")
+	}
+
+	// only the last event matches both filters
+	stateChanges.publish(stateChangeEvent{Name: "other-namespace", Namespace: "team-b", Labels: map[string]string{"tier": "gold"}})
+	stateChanges.publish(stateChangeEvent{Name: "other-labels", Namespace: "team-a", Labels: map[string]string{"tier": "silver"}})
+	stateChanges.publish(stateChangeEvent{Name: "check", Namespace: "team-a", Labels: map[string]string{"tier": "gold"}, PreviousOK: true, Errors: []string{"broken"}})
+
+	reader := bufio.NewReader(resp.Body)
+	var data string
+	for len(data) == 0 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal("error reading event stream:", err)
+		}
+		if strings.HasPrefix(line, "data: ") {
+			data = strings.TrimPrefix(strings.TrimSpace(line), "data: ")
+		}
+	}
+	event := stateChangeEvent{}
+	err = json.Unmarshal([]byte(data), &event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Name != "check" || !event.PreviousOK || event.OK {
+		t.Fatalf("expected the change of check from OK to failing, got %+v", event)
+	}
+
+	// disconnecting removes the watcher
+	resp.Body.Close()
+	deadline := time.Now().Add(time.Second * 5)
+	for stateChanges.hasWatchers() {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the watcher to be removed after the client disconnected")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
@@ -162,3 +162,40 @@ func TestWebServerNotOK(t *testing.T) {
 	}
 
 }
+
+// TestStatusSerializationIsStable ensures that serializing the same states repeatedly produces identical output and
+// that the errors of each check keep the order they were reported in
+func TestStatusSerializationIsStable(t *testing.T) {
+	details := make(map[string]health.WorkloadDetails)
+	for _, namespace := range []string{"team-b", "team", "kuberhealthy"} {
+		for _, name := range []string{"dns", "deployment", "daemonset", "pod-status"} {
+			details[namespace+"/"+name] = health.WorkloadDetails{
+				OK:               false,
+				Status:           health.StatusNotOK,
+				Namespace:        namespace,
+				AuthoritativePod: "kuberhealthy-1",
+				Errors:           []string{namespace + " " + name + " second", namespace + " " + name + " first"},
+			}
+		}
+	}
+	namespaces := []string{"team-b", "team", "kuberhealthy"}
+
+	var first []byte
+	for i := 0; i < 20; i++ {
+		state := validateCurrentStatusForNamespaces(details, namespaces, health.NewState(), health.KHCheck)
+		b, err := json.Marshal(state)
+		if err != nil {
+			t.Fatal("failed to marshal state:", err)
+		}
+		if i == 0 {
+			first = b
+			if state.Errors[0] != "kuberhealthy daemonset second" || state.Errors[1] != "kuberhealthy daemonset first" {
+				t.Fatalf("expected errors sorted by namespace and then name in reported order but got %v", state.Errors)
+			}
+			continue
+		}
+		if !bytes.Equal(first, b) {
+			t.Fatalf("serialization %d differs from the first:\n%s\n%s", i, first, b)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+)
+
+// defaultWriteBackoffMin is the shortest wait between khstate write retries when adaptive backoff is enabled and no
+// minimum is configured.  It is the same as the static wait used when adaptive backoff is disabled.
+var defaultWriteBackoffMin = retry.DefaultRetry.Duration
+
+// defaultWriteBackoffMax is the longest wait between khstate write retries when adaptive backoff is enabled and no
+// maximum is configured
+const defaultWriteBackoffMax = time.Second
+
+// writeBackoffs holds the current wait between khstate write retries of every khstate, keyed by namespace/name
+var writeBackoffs = struct {
+	sync.Mutex
+	backoffs map[string]time.Duration
+}{backoffs: make(map[string]time.Duration)}
+
+// writeBackoffBounds returns the configured bounds of the adaptive write backoff
+func writeBackoffBounds() (time.Duration, time.Duration) {
+	min := cfg.WriteBackoffMin
+	if min <= 0 {
+		min = defaultWriteBackoffMin
+	}
+	max := cfg.WriteBackoffMax
+	if max <= 0 {
+		max = defaultWriteBackoffMax
+	}
+	if max < min {
+		max = min
+	}
+	return min, max
+}
+
+// currentWriteBackoff returns the current wait between write retries of a khstate, within the configured bounds
+func currentWriteBackoff(name string, stateNamespace string) time.Duration {
+	min, max := writeBackoffBounds()
+	writeBackoffs.Lock()
+	backoff, exists := writeBackoffs.backoffs[stateNamespace+"/"+name]
+	writeBackoffs.Unlock()
+	switch {
+	case !exists || backoff < min:
+		return min
+	case backoff > max:
+		return max
+	}
+	return backoff
+}
+
+// stateWriteBackoff returns the backoff that a write of a khstate is retried with.  The static default retry is
+// used unless adaptive backoff is enabled, in which case the wait between retries is the current backoff of the
+// khstate.
+func stateWriteBackoff(name string, stateNamespace string) wait.Backoff {
+	backoff := retry.DefaultRetry
+	if !cfg.AdaptiveWriteBackoff {
+		return backoff
+	}
+	backoff.Duration = currentWriteBackoff(name, stateNamespace)
+	return backoff
+}
+
+// recordWriteConflicts adjusts the backoff of a khstate after a write, AIMD-style.  A write that ran into conflicts
+// doubles the backoff, once for every conflict, so heavily contended khstates back off quickly.  A write without
+// conflicts takes the minimum back off of it, so the backoff returns to the minimum gradually once contention drops.
+func recordWriteConflicts(name string, stateNamespace string, conflicts int) {
+	if !cfg.AdaptiveWriteBackoff {
+		return
+	}
+	min, max := writeBackoffBounds()
+	backoff := currentWriteBackoff(name, stateNamespace)
+	if conflicts > 0 {
+		for i := 0; i < conflicts && backoff < max; i++ {
+			backoff *= 2
+		}
+		if backoff > max {
+			backoff = max
+		}
+	} else {
+		backoff -= min
+		if backoff < min {
+			backoff = min
+		}
+	}
+
+	writeBackoffs.Lock()
+	writeBackoffs.backoffs[stateNamespace+"/"+name] = backoff
+	writeBackoffs.Unlock()
+	stateWriteBackoffSeconds.Set(backoff.Seconds(), name, stateNamespace)
+}
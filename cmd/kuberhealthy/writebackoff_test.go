@@ -0,0 +1,70 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/client-go/util/retry"
+)
+
+// TestAdaptiveWriteBackoff ensures that conflicts grow the write backoff of a khstate multiplicatively up to the
+// maximum, that writes without conflicts shrink it additively down to the minimum and that the static retry is
+// used while adaptive backoff is disabled
+func TestAdaptiveWriteBackoff(t *testing.T) {
+	originalEnabled, originalMin, originalMax := cfg.AdaptiveWriteBackoff, cfg.WriteBackoffMin, cfg.WriteBackoffMax
+	defer func() {
+		cfg.AdaptiveWriteBackoff, cfg.WriteBackoffMin, cfg.WriteBackoffMax = originalEnabled, originalMin, originalMax
+		writeBackoffs.Lock()
+		delete(writeBackoffs.backoffs, "kuberhealthy/backoff-check")
+		writeBackoffs.Unlock()
+	}()
+
+	// disabled, conflicts change nothing
+	cfg.AdaptiveWriteBackoff = false
+	recordWriteConflicts("backoff-check", "kuberhealthy", 3)
+	if backoff := stateWriteBackoff("backoff-check", "kuberhealthy"); backoff != retry.DefaultRetry {
+		t.Fatal("expected the default retry while adaptive backoff is disabled, got", backoff)
+	}
+
+	cfg.AdaptiveWriteBackoff = true
+	cfg.WriteBackoffMin = time.Millisecond * 10
+	cfg.WriteBackoffMax = time.Millisecond * 100
+	for i, test := range []struct {
+		conflicts int
+		expected  time.Duration
+	}{
+		{conflicts: 0, expected: time.Millisecond * 10},
+		{conflicts: 1, expected: time.Millisecond * 20},
+		{conflicts: 2, expected: time.Millisecond * 80},
+		{conflicts: 1, expected: time.Millisecond * 100},
+		{conflicts: 0, expected: time.Millisecond * 90},
+		{conflicts: 0, expected: time.Millisecond * 80},
+		{conflicts: 1, expected: time.Millisecond * 100},
+	} {
+		recordWriteConflicts("backoff-check", "kuberhealthy", test.conflicts)
+		backoff := stateWriteBackoff("backoff-check", "kuberhealthy")
+		if backoff.Duration != test.expected {
+			t.Fatalf("write %d: expected a backoff of %s, got %s", i, test.expected, backoff.Duration)
+		}
+		if backoff.Steps != retry.DefaultRetry.Steps {
+			t.Fatalf("write %d: expected %d retry steps, got %d", i, retry.DefaultRetry.Steps, backoff.Steps)
+		}
+	}
+
+	// a lower maximum takes effect right away
+	cfg.WriteBackoffMax = time.Millisecond * 50
+	if backoff := stateWriteBackoff("backoff-check", "kuberhealthy"); backoff.Duration != time.Millisecond*50 {
+		t.Fatal("expected the backoff to be capped by the new maximum, got", backoff.Duration)
+	}
+}
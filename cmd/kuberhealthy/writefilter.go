@@ -0,0 +1,90 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// ErrWriteSuppressed is returned when the write filter of a check decided that a result should not be recorded.
+// Only the time of the last run was written, so the check is not seen as silent.
+var ErrWriteSuppressed = errors.New("check result was not recorded because of the write filter of the check")
+
+// The write filters that can be configured for a check
+const (
+	writeFilterAll      = "all"      // record every result
+	writeFilterFailures = "failures" // only record results that are not OK
+	writeFilterChanges  = "changes"  // only record results that differ from the recorded result
+)
+
+// writeFilter decides if a reported result should be recorded over the result currently stored in the khstate
+type writeFilter func(existing health.WorkloadDetails, state health.WorkloadDetails) bool
+
+// writeFilters holds the write filters by the name they are configured with
+var writeFilters = map[string]writeFilter{
+	writeFilterAll: func(existing health.WorkloadDetails, state health.WorkloadDetails) bool {
+		return true
+	},
+	writeFilterFailures: func(existing health.WorkloadDetails, state health.WorkloadDetails) bool {
+		return state.GetStatus() == health.StatusNotOK
+	},
+	writeFilterChanges: func(existing health.WorkloadDetails, state health.WorkloadDetails) bool {
+		return existing.GetStatus() == health.StatusUnknown || state.ResultChangedFrom(existing)
+	},
+}
+
+// unknownWriteFilters remembers write filter names that were already logged as unknown, so that they are only
+// logged once
+var unknownWriteFilters = struct {
+	sync.Mutex
+	names map[string]bool
+}{names: make(map[string]bool)}
+
+// writeFilterForCheck returns the write filter of a check.  Unknown filter names are logged and record every
+// result, so that a typo in the configuration never loses results.
+func writeFilterForCheck(checkName string, checkNamespace string) writeFilter {
+	name := cfg.WriteFilter
+	if override, ok := cfg.WriteFilterOverrides[checkNamespace+"/"+checkName]; ok {
+		name = override
+	}
+	if name == "" {
+		name = writeFilterAll
+	}
+
+	filter, ok := writeFilters[name]
+	if !ok {
+		unknownWriteFilters.Lock()
+		if !unknownWriteFilters.names[name] {
+			log.Errorln("Unknown write filter", name, "configured for", checkNamespace+"/"+checkName+". Every result will be recorded.")
+			unknownWriteFilters.names[name] = true
+		}
+		unknownWriteFilters.Unlock()
+		return writeFilters[writeFilterAll]
+	}
+	return filter
+}
+
+// recordHeartbeat updates only the fields of a stored result that show the check is still running, so that a
+// check whose results are suppressed by its write filter is not seen as silent
+func recordHeartbeat(details *health.WorkloadDetails, state health.WorkloadDetails) {
+	details.LastRun = state.LastRun
+	details.AuthoritativePod = state.AuthoritativePod
+	details.AuthoritativeNamespace = state.AuthoritativeNamespace
+	if len(state.RunID) > 0 {
+		details.RunID = state.RunID
+	}
+}
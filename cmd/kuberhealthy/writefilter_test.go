@@ -0,0 +1,85 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// TestWriteFilterSuppressesResults ensures that results held back by the write filter of a check only record the
+// last run, and that results the filter lets through are written as usual
+func TestWriteFilterSuppressesResults(t *testing.T) {
+	originalFilter, originalOverrides := cfg.WriteFilter, cfg.WriteFilterOverrides
+	defer func() { cfg.WriteFilter, cfg.WriteFilterOverrides = originalFilter, originalOverrides }()
+	cfg.WriteFilter = writeFilterAll
+	cfg.WriteFilterOverrides = stringMap{"kuberhealthy/noisy-check": writeFilterFailures}
+
+	lastFailure := time.Now().Add(-time.Hour)
+	stored := khstatecrd.NewKuberhealthyState("noisy-check", health.WorkloadDetails{OK: false, Errors: []string{"timed out"}, LastRun: lastFailure})
+	stored.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+	stored.Kind = "KuberhealthyState"
+	stored.Namespace = "kuberhealthy"
+
+	var written khstatecrd.KuberhealthyState
+	useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodGet:
+			json.NewEncoder(w).Encode(stored)
+		case http.MethodPut:
+			json.NewDecoder(r.Body).Decode(&written)
+			json.NewEncoder(w).Encode(written)
+		}
+	})
+
+	_, err := setCheckStateResourceIf("noisy-check", "kuberhealthy", health.WorkloadDetails{OK: true}, nil)
+	if !errors.Is(err, ErrWriteSuppressed) {
+		t.Fatal("expected the OK result to be suppressed, got", err)
+	}
+	if written.Spec.OK || len(written.Spec.Errors) != 1 || !written.Spec.LastRun.After(lastFailure) {
+		t.Fatalf("expected only the last run of the stored failure to be updated, got %+v", written.Spec)
+	}
+
+	_, err = setCheckStateResourceIf("noisy-check", "kuberhealthy", health.WorkloadDetails{OK: false, Errors: []string{"connection refused"}}, nil)
+	if err != nil {
+		t.Fatal("unexpected error writing a failure:", err)
+	}
+	if len(written.Spec.Errors) != 1 || written.Spec.Errors[0] != "connection refused" {
+		t.Fatalf("expected the failure to be recorded, got %+v", written.Spec)
+	}
+}
+
+// TestWriteFilterForCheck ensures that filters are chosen per check and that unknown filters record every result
+func TestWriteFilterForCheck(t *testing.T) {
+	originalFilter, originalOverrides := cfg.WriteFilter, cfg.WriteFilterOverrides
+	defer func() { cfg.WriteFilter, cfg.WriteFilterOverrides = originalFilter, originalOverrides }()
+	cfg.WriteFilter = writeFilterChanges
+	cfg.WriteFilterOverrides = stringMap{"kuberhealthy/typo-check": "failurs"}
+
+	failing := health.WorkloadDetails{OK: false, Errors: []string{"broken"}, Status: health.StatusNotOK}
+	if writeFilterForCheck("check", "kuberhealthy")(failing, failing) {
+		t.Fatal("expected the changes filter to suppress a result that did not change")
+	}
+	if !writeFilterForCheck("check", "kuberhealthy")(health.WorkloadDetails{}, failing) {
+		t.Fatal("expected the changes filter to record the first result of a check")
+	}
+	if !writeFilterForCheck("typo-check", "kuberhealthy")(failing, failing) {
+		t.Fatal("expected an unknown filter to record every result")
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "time"
+
+// defaultStateWriteTimeout is how long a khstate write, including its retries, may take when no timeout is configured
+const defaultStateWriteTimeout = time.Second * 30
+
+// stateWriteTimeout returns the configured khstate write timeout.  Zero means writes never time out.
+func stateWriteTimeout() time.Duration {
+	if cfg.StateWriteTimeout == 0 {
+		return defaultStateWriteTimeout
+	}
+	if cfg.StateWriteTimeout < 0 {
+		return 0
+	}
+	return cfg.StateWriteTimeout
+}
+
+// stateWriteTimeoutForCheck returns the khstate write timeout of a check.  Per-check overrides take precedence over
+// the global setting.
+func stateWriteTimeoutForCheck(checkName string, checkNamespace string) time.Duration {
+	if timeout, ok := cfg.WriteTimeoutOverrides[checkNamespace+"/"+checkName]; ok {
+		if timeout < 0 {
+			return 0
+		}
+		return timeout
+	}
+	return stateWriteTimeout()
+}
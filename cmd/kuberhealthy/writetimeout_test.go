@@ -0,0 +1,64 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
+)
+
+// TestStateWriteTimeout ensures that khstate writes that take longer than their check's write timeout fail and
+// that checks with a longer timeout are still written
+func TestStateWriteTimeout(t *testing.T) {
+	originalTimeout, originalOverrides := cfg.StateWriteTimeout, cfg.WriteTimeoutOverrides
+	defer func() { cfg.StateWriteTimeout, cfg.WriteTimeoutOverrides = originalTimeout, originalOverrides }()
+	cfg.StateWriteTimeout = time.Second * 10
+	cfg.WriteTimeoutOverrides = durationMap{"kuberhealthy/slow-write-check": time.Millisecond * 50}
+
+	useFakeKHStateHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond * 200)
+		stored := khstatecrd.NewKuberhealthyState("write-check", health.WorkloadDetails{Status: health.StatusUnknown, Errors: []string{}})
+		stored.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+		stored.Kind = "KuberhealthyState"
+		stored.Namespace = "kuberhealthy"
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stored)
+	})
+
+	start := time.Now()
+	err := setCheckStateResource("slow-write-check", "kuberhealthy", health.WorkloadDetails{OK: true, Errors: []string{}})
+	if err == nil {
+		t.Fatal("expected the write to time out")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second*5 {
+		t.Fatal("expected the write to give up after its timeout, took", elapsed)
+	}
+
+	err = setCheckStateResource("write-check", "kuberhealthy", health.WorkloadDetails{OK: true, Errors: []string{}})
+	if err != nil {
+		t.Fatal("expected the write within the global timeout to succeed:", err)
+	}
+
+	cfg.StateWriteTimeout = -1
+	if stateWriteTimeoutForCheck("write-check", "kuberhealthy") != 0 {
+		t.Fatal("expected a negative write timeout to disable the timeout")
+	}
+	cfg.StateWriteTimeout = 0
+	if stateWriteTimeoutForCheck("write-check", "kuberhealthy") != defaultStateWriteTimeout {
+		t.Fatal("expected the default write timeout when none is configured")
+	}
+}
@@ -27,11 +27,13 @@ type KuberhealthyJob struct {
 // endpoint.
 // +k8s:openapi-gen=true
 type JobConfig struct {
-	Phase            JobPhase          `json:"phase"`            // the state or phase of the job
-	Timeout          string            `json:"timeout"`          // the maximum time the pod is allowed to run before a failure is assumed
-	PodSpec          apiv1.PodSpec     `json:"podSpec"`          // a spec for the external job
-	ExtraAnnotations map[string]string `json:"extraAnnotations"` // a map of extra annotations that will be applied to the pod
-	ExtraLabels      map[string]string `json:"extraLabels"`      // a map of extra labels that will be applied to the pod
+	Phase            JobPhase          `json:"phase"`             // the state or phase of the job
+	Timeout          string            `json:"timeout"`           // the maximum time the pod is allowed to run before a failure is assumed
+	PodSpec          apiv1.PodSpec     `json:"podSpec"`           // a spec for the external job
+	ExtraAnnotations map[string]string `json:"extraAnnotations"`  // a map of extra annotations that will be applied to the pod
+	ExtraLabels      map[string]string `json:"extraLabels"`       // a map of extra labels that will be applied to the pod
+	Reason           JobReason         `json:"reason,omitempty"`  // a machine-readable reason for the phase, set when the job errored
+	Message          string            `json:"message,omitempty"` // a human-readable explanation of the reason
 }
 
 // JobPhase is a label for the condition of the job at the current time.
@@ -39,8 +41,22 @@ type JobPhase string
 
 // These are the valid phases of jobs.
 const (
-	JobRunning JobPhase = "Running"
+	JobRunning   JobPhase = "Running"
 	JobCompleted JobPhase = "Completed"
+	JobErrored   JobPhase = "Errored"
+)
+
+// JobReason is a machine-readable code for why a job is in its phase, so that tooling can branch on it without
+// parsing the message.
+type JobReason string
+
+// These are the standard reasons of errored jobs.
+const (
+	ReasonPodCreationFailed JobReason = "PodCreationFailed" // the checker pod could not be created
+	ReasonPodStartFailed    JobReason = "PodStartFailed"    // the checker pod was created but did not start, such as when its image could not be pulled
+	ReasonPodDeleted        JobReason = "PodDeleted"        // the checker pod was deleted before the job finished
+	ReasonTimeout           JobReason = "Timeout"           // the job did not finish within its timeout
+	ReasonRunFailed         JobReason = "RunFailed"         // the job failed for any other reason
 )
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -15,6 +15,7 @@ import (
 	"time"
 
 	"github.com/cenkalti/backoff"
+	"github.com/google/uuid"
 
 	"github.com/Comcast/kuberhealthy/v2/pkg/checks/external"
 	"github.com/Comcast/kuberhealthy/v2/pkg/checks/external/status"
@@ -31,6 +32,10 @@ func init() {
 	exponentialBackoff.MaxElapsedTime = maxElapsedTime
 }
 
+// runID identifies this run of the checker.  Every report sent by the checker pod carries it so that Kuberhealthy
+// only records the result of a run once, even when the report is retried or processed by more than one replica.
+var runID = uuid.New().String()
+
 // ReportSuccess reports a successful check run to the Kuberhealthy service. We
 // do not return an error here because failures will cause the managing
 // instance of Kuberhealthy to time out and show an error.
@@ -59,6 +64,34 @@ func ReportFailure(errorMessages []string) error {
 	return sendReport(newReport)
 }
 
+// ReportFailureWithArtifacts reports that the external checker has found
+// problems along with links to artifacts of the run, such as test reports,
+// that are shown with the failure in the Kuberhealthy status page.  Artifact
+// URLs must be absolute http or https URLs.
+func ReportFailureWithArtifacts(errorMessages []string, artifactURLs []string) error {
+	writeLog("DEBUG: Reporting FAILURE with ", len(artifactURLs), " artifacts")
+
+	// make a new report with errors and the artifacts of the run
+	newReport := status.NewReport(errorMessages)
+	newReport.ArtifactURLs = artifactURLs
+
+	// send it
+	return sendReport(newReport)
+}
+
+// ReportWarning reports that the external checker has found a degradation that
+// is not severe enough to fail the check.  The error messages surface as
+// warnings in the Kuberhealthy status page while the check stays OK.
+func ReportWarning(errorMessages []string) error {
+	writeLog("DEBUG: Reporting WARNING with ", len(errorMessages), " warnings")
+
+	// make a new report that is OK but carries the warnings
+	newReport := status.NewWarningReport(errorMessages)
+
+	// send it
+	return sendReport(newReport)
+}
+
 // writeLog writes a log entry if debugging is enabled
 func writeLog(i ...interface{}) {
 	if Debug {
@@ -70,6 +103,13 @@ func writeLog(i ...interface{}) {
 // as shown in the environment variables.
 func sendReport(s status.Report) error {
 
+	if len(s.RunID) == 0 {
+		s.RunID = runID
+	}
+	if s.Timestamp.IsZero() {
+		s.Timestamp = time.Now()
+	}
+
 	writeLog("DEBUG: Sending report with error length of:", len(s.Errors))
 	writeLog("DEBUG: Sending report with ok state of:", s.OK)
 
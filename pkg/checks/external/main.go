@@ -120,6 +120,7 @@ type Checker struct {
 	hostname                 string             // hostname cache
 	checkPodName             string             // the current unique checker pod name
 	KHWorkload               health.KHWorkload
+	StateNamespace           string // the namespace of the khstate for this check.  defaults to the check namespace
 }
 
 func init() {
@@ -221,10 +222,11 @@ func (ext *Checker) CurrentStatus() (bool, []string) {
 		return false, []string{err.Error()} // any other errors in fetching state will be seen as the check being down
 	}
 
+	// warnings are reported with OK left true, so the reported OK value is kept whenever errors are present
 	ext.log("length of error message slice:", len(state.Spec.Errors), state.Spec.Errors)
 	if len(state.Spec.Errors) > 0 {
-		ext.log("reporting check as OK=FALSE due to error messages > 0")
-		return false, state.Spec.Errors
+		ext.log("reporting check as OK =", state.Spec.OK, "with severity", state.Spec.GetSeverity(), "as reported with its error messages")
+		return state.Spec.OK, state.Spec.Errors
 	}
 	ext.log("reporting OK=TRUE due to error messages NOT > 0")
 	return true, state.Spec.Errors
@@ -380,9 +382,10 @@ func (ext *Checker) setUUID(uuid string) error {
 		details.OK = true
 		details.RunDuration = time.Duration(0).String()
 		newState := khstatecrd.NewKuberhealthyState(ext.CheckName, details)
-		newState.Namespace = ext.Namespace
+		newState.Namespace = ext.stateNamespace()
+		newState.Spec.ClusterScoped = ext.stateNamespace() != ext.CheckNamespace()
 		ext.log("Creating khstate", newState.Name, newState.Namespace, "because it did not exist")
-		_, err = ext.KHStateClient.Create(&newState, stateCRDResource, ext.CheckNamespace())
+		_, err = ext.KHStateClient.Create(&newState, stateCRDResource, ext.stateNamespace())
 		if err != nil {
 			ext.log("failed to create a khstate after finding that it did not exist:", err)
 			return err
@@ -396,12 +399,13 @@ func (ext *Checker) setUUID(uuid string) error {
 		}
 	}
 
-	// assign the new uuid
+	// assign the new uuid.  every khstate write advances its generation
 	checkState.Spec.CurrentUUID = uuid
+	checkState.Spec.Generation++
 
 	// update the resource with the new values we want
 	ext.log("Updating khstate", checkState.Name, checkState.Namespace, "to setUUID:", checkState.Spec.CurrentUUID)
-	_, err = ext.KHStateClient.Update(checkState, stateCRDResource, ext.Name(), ext.CheckNamespace())
+	_, err = ext.KHStateClient.Update(checkState, stateCRDResource, ext.Name(), ext.stateNamespace())
 
 	// We commonly see a race here with the following type of error:
 	// "Check execution error: Operation cannot be fulfilled on khchecks.comcast.github.io \"pod-restarts\": the object
@@ -411,7 +415,7 @@ func (ext *Checker) setUUID(uuid string) error {
 	for err != nil && strings.Contains(err.Error(), "the object has been modified") {
 		ext.log("Failed to write new UUID for check because object was modified by another process.  Retrying in 5s")
 		time.Sleep(time.Second * 5)
-		_, err = ext.KHStateClient.Update(checkState, stateCRDResource, ext.Name(), ext.CheckNamespace())
+		_, err = ext.KHStateClient.Update(checkState, stateCRDResource, ext.Name(), ext.stateNamespace())
 	}
 
 	// Sometimes a race condition occurs when a pod has to verify uuid with kh server. If the pod happens to check the
@@ -425,7 +429,7 @@ func (ext *Checker) setUUID(uuid string) error {
 		tries++
 		ext.log("Waiting 1 second before checking " + ext.Name() + " uuid.")
 		time.Sleep(time.Second * 1)
-		extCheck, err := ext.KHStateClient.Get(metav1.GetOptions{}, stateCRDResource, ext.Name(), ext.CheckNamespace())
+		extCheck, err := ext.KHStateClient.Get(metav1.GetOptions{}, stateCRDResource, ext.Name(), ext.stateNamespace())
 		if err != nil {
 			ext.log("failed to get khstate while truing up check uuid:", err)
 			continue
@@ -770,7 +774,15 @@ func (ext *Checker) sanityCheck() error {
 // getKHState gets the khstate for this check from the resource in the API server
 func (ext *Checker) getKHState() (*khstatecrd.KuberhealthyState, error) {
 	// fetch the khstate as it exists
-	return ext.KHStateClient.Get(metav1.GetOptions{}, stateCRDResource, ext.CheckName, ext.Namespace)
+	return ext.KHStateClient.Get(metav1.GetOptions{}, stateCRDResource, ext.CheckName, ext.stateNamespace())
+}
+
+// stateNamespace returns the namespace that the khstate for this check lives in
+func (ext *Checker) stateNamespace() string {
+	if len(ext.StateNamespace) > 0 {
+		return ext.StateNamespace
+	}
+	return ext.CheckNamespace()
 }
 
 // getCheckLastUpdateTime fetches the last time the khstate custom resource for this check was updated
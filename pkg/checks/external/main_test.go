@@ -4,18 +4,23 @@ import (
 	"context"
 	"encoding/json"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 	"time"
 
 	"github.com/ghodss/yaml"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
+	"k8s.io/client-go/rest"
 
 	// "k8s.io/apimachinery/pkg/api/resource"
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
 	"github.com/Comcast/kuberhealthy/v2/pkg/khcheckcrd"
+	"github.com/Comcast/kuberhealthy/v2/pkg/khstatecrd"
 	"github.com/Comcast/kuberhealthy/v2/pkg/kubeClient"
 
 	apiv1 "k8s.io/api/core/v1"
@@ -129,3 +134,37 @@ func TestShutdown(t *testing.T) {
 		t.Log("Check shutdown properly and without error")
 	}
 }
+
+// TestCurrentStatusKeepsReportedOK ensures that the status of a check that reported warnings stays OK and that a
+// check that reported failures is not OK
+func TestCurrentStatusKeepsReportedOK(t *testing.T) {
+	tests := []struct {
+		details health.WorkloadDetails
+		ok      bool
+	}{
+		{details: health.WorkloadDetails{OK: true, Errors: []string{}}, ok: true},
+		{details: health.WorkloadDetails{OK: true, Errors: []string{"slow"}, Severity: health.SeverityWarning}, ok: true},
+		{details: health.WorkloadDetails{OK: false, Errors: []string{"down"}}, ok: false},
+	}
+
+	for _, test := range tests {
+		state := khstatecrd.NewKuberhealthyState(testCheckName, test.details)
+		state.APIVersion = stateCRDGroup + "/" + stateCRDVersion
+		state.Kind = "KuberhealthyState"
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(state)
+		}))
+		client, err := khstatecrd.ClientForConfig(stateCRDGroup, stateCRDVersion, &rest.Config{Host: server.URL})
+		if err != nil {
+			t.Fatal("failed to create khstate client for test server:", err)
+		}
+
+		checker := &Checker{CheckName: testCheckName, Namespace: defaultNamespace, KHStateClient: client}
+		ok, errs := checker.CurrentStatus()
+		server.Close()
+		if ok != test.ok || len(errs) != len(test.details.Errors) {
+			t.Fatalf("expected OK %t with %d errors for %+v, got OK %t with %v", test.ok, len(test.details.Errors), test.details, ok, errs)
+		}
+	}
+}
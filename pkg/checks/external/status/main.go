@@ -3,10 +3,27 @@
 // status reporting endpoint.
 package status
 
+import "time"
+
 // Report is the format expected by the /externalCheckStatus endpoint
 type Report struct {
-	Errors []string
-	OK     bool
+	Errors       []string
+	OK           bool
+	Annotations  map[string]string `json:",omitempty"` // optional metadata, such as a runbook or dashboard URL, that is shown with the result
+	Severity     string            `json:",omitempty"` // optional severity of the errors: info, warning or critical.  Blank is critical
+	RunID        string            `json:",omitempty"` // identifies the run that produced the report so that duplicate reports of a run are only recorded once
+	ArtifactURLs []string          `json:",omitempty"` // optional links to artifacts of the run, such as test reports or screenshots
+	Timestamp    time.Time         // the clock of the checker when it sent the report, used to detect clock skew.  Zero when not sent
+}
+
+// NewWarningReport creates a report of a degradation that is not severe enough to fail the check.  The report
+// stays OK and carries the warnings as errors with a warning severity.
+func NewWarningReport(warnings []string) Report {
+	return Report{
+		Errors:   warnings,
+		OK:       true,
+		Severity: "warning",
+	}
 }
 
 // NewReport creates a new error report to be sent to the server.  If
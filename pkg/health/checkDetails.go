@@ -12,21 +12,133 @@
 package health
 
 import (
+	"fmt"
+	"net/url"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// CheckStatus is the tri-state result of a kuberhealthy check or job.  Unknown is used before a
+// workload has reported its first result so that a fresh state is not mistaken for a failure.
+type CheckStatus string
+
+// The possible values of a CheckStatus
+const (
+	StatusOK      CheckStatus = "OK"
+	StatusNotOK   CheckStatus = "NotOK"
+	StatusUnknown CheckStatus = "Unknown"
+)
+
+// Severity is how serious the errors in a result are.  By default, only critical errors fail the overall status.
+type Severity string
+
+// The possible values of a Severity
+const (
+	SeverityInfo     Severity = "info"
+	SeverityWarning  Severity = "warning"
+	SeverityCritical Severity = "critical"
+)
+
+// Severities lists every severity from least to most serious
+var Severities = []Severity{SeverityInfo, SeverityWarning, SeverityCritical}
+
+// StatusFromOK converts an OK boolean into its matching CheckStatus
+func StatusFromOK(ok bool) CheckStatus {
+	if ok {
+		return StatusOK
+	}
+	return StatusNotOK
+}
+
+// ResourceUsage is the CPU and memory used by a checker pod as sampled by metrics-server
+type ResourceUsage struct {
+	CPU       string    // the CPU used by all containers of the pod, such as 15m
+	Memory    string    // the memory used by all containers of the pod, such as 24Mi
+	PodName   string    // the checker pod that was sampled
+	Timestamp time.Time // when metrics-server sampled the usage
+}
+
+// RunAggregate rolls up the results of the last runs of a check, so that a check that flaps can be shown as
+// "failed 3 of the last 10 runs" instead of only its latest result
+type RunAggregate struct {
+	Runs   int         // the number of runs rolled up, which is less than requested while the run history is short
+	Passed int         // the runs that were OK
+	Failed int         // the runs that were not OK
+	Status CheckStatus // NotOK when the failed runs reach the configured threshold, otherwise OK
+}
+
+// Trend is the direction the failure rate of a check is heading in
+type Trend string
+
+// The possible values of a Trend
+const (
+	TrendImproving Trend = "improving"
+	TrendStable    Trend = "stable"
+	TrendWorsening Trend = "worsening"
+	TrendUnknown   Trend = "unknown" // there are not enough runs to tell
+)
+
+// RunTrend compares the failures of the most recent runs of a check against the runs before them
+type RunTrend struct {
+	Trend        Trend // improving, stable or worsening.  unknown when the run history is too short
+	OlderRuns    int   // the runs compared against
+	OlderFailed  int   // the older runs that were not OK
+	RecentRuns   int   // the most recent runs
+	RecentFailed int   // the most recent runs that were not OK
+}
+
+// IsChanging indicates if the check is improving or worsening
+func (rt *RunTrend) IsChanging() bool {
+	return rt.Trend == TrendImproving || rt.Trend == TrendWorsening
+}
+
 // WorkloadDetails contains details about a single kuberhealthy check or job's current status
 type WorkloadDetails struct {
-	OK               bool
-	Errors           []string
-	RunDuration      string
-	Namespace        string
-	LastRun          time.Time // the time the check last was last run
-	AuthoritativePod string    // the pod that last ran the check
-	CurrentUUID      string    `json:"uuid"` // the UUID that is authorized to report statuses into the kuberhealthy endpoint
-	khWorkload       KHWorkload
+	OK                     bool
+	Status                 CheckStatus // OK, NotOK or Unknown.  Kept in sync with OK once a result has been reported
+	Errors                 []string
+	RunDuration            string
+	Namespace              string
+	LastRun                time.Time         // the time the check last was last run
+	AuthoritativePod       string            // the pod that last ran the check
+	AuthoritativeNamespace string            `json:",omitempty"` // the namespace of the Kuberhealthy instance that last ran the check
+	CurrentUUID            string            `json:"uuid"`       // the UUID that is authorized to report statuses into the kuberhealthy endpoint
+	RunID                  string            `json:",omitempty"` // the checker generated ID of the run that reported the result
+	Generation             int64             `json:",omitempty"` // incremented on every write of the khstate so that consumers can tell when it changed
+	Annotations            map[string]string `json:",omitempty"` // free-form metadata from the checker, such as a runbook URL
+	LastResultChange       time.Time         // the time the OK value or errors last changed
+	ErrorsSince            time.Time         `json:",omitempty"` // the time the check started failing.  Zero while the check is not failing
+	Static                 bool              `json:",omitempty"` // set when the result has not changed for longer than the configured static result window
+	TimedOut               bool              `json:",omitempty"` // set when the check did not report a result within its run timeout
+	ClusterScoped          bool              `json:",omitempty"` // set when the check is cluster-wide and its khstate lives in the cluster checks namespace
+	Expired                bool              `json:",omitempty"` // set when the last result is older than its result TTL
+	ResultTTL              string            `json:",omitempty"` // how long the result stays valid before it expires, from the expiry policy of the check.  Blank uses the global result TTL
+	Queued                 bool              `json:",omitempty"` // set while a run is waiting for a free slot under the concurrent check limit
+	Deferred               bool              `json:",omitempty"` // set while a queued run is waiting because runs of higher priority checks were started first
+	Created                time.Time         // the time the khstate was created, which starts the initial grace period
+	InGracePeriod          bool              `json:",omitempty"` // set when a failure is suppressed because the check is in its initial grace period
+	Skipped                bool              `json:",omitempty"` // set when the last run was skipped because a check it depends on is failing
+	Severity               Severity          `json:",omitempty"` // how serious the reported errors are.  Blank is treated as critical
+	Synthetic              bool              `json:",omitempty"` // set on khstates that Kuberhealthy derives from other results instead of a check
+	Reliability            *float64          `json:",omitempty"` // the percentage of recent runs that were OK.  Only set on the status page when there is enough run history
+	ResourceUsage          *ResourceUsage    `json:",omitempty"` // the resources used by the checker pod that reported the last result
+	LogTail                string            `json:",omitempty"` // the last lines logged by the checker pod, recorded when it reports a failure
+	ArtifactURLs           []string          `json:",omitempty"` // links to artifacts of the run, such as test reports, supplied by the checker
+	ClockSkew              string            `json:",omitempty"` // how far ahead, or behind when negative, the clock of the checker was when it reported.  Only set when more than the maximum clock skew
+	Retained               bool              `json:",omitempty"` // set on the status page when the check was deleted and its khstate is kept for the orphaned state retention window
+	Disabled               bool              `json:",omitempty"` // set when the check is disabled.  The last result is kept, but its status is unknown until the check is enabled and runs again
+	Aggregate              *RunAggregate     `json:",omitempty"` // the rolled up results of the last runs.  Only set on the status page when run aggregation is enabled
+	Trend                  *RunTrend         `json:",omitempty"` // whether recent runs fail more or less often than before.  Only set on the status page and in notifications
+	NamespaceTerminating   bool              `json:",omitempty"` // set when the namespace of the khstate is being deleted.  Results are not written until the namespace is gone or back to active
+	ConsecutiveFailures    int               `json:",omitempty"` // the number of runs in a row that failed.  Reset by the next OK run
+	Alerting               bool              `json:",omitempty"` // set on the status page when the check has failed at least as many runs in a row as its alert threshold
+	CheckUID               string            `json:",omitempty"` // the stable identity of the check.  Kept across renames when the check is annotated with it
+	LastSuccess            time.Time         `json:",omitempty"` // the time the check last reported an OK result.  Zero when it has never passed
+	ScheduleDelay          string            `json:",omitempty"` // how long after its scheduled time the run that reported the result started.  Large delays mean Kuberhealthy is overloaded
+	khWorkload             KHWorkload
+	scheduledAt            time.Time // when the run that reported the result was scheduled to start.  Not stored
+	startedAt              time.Time // when the run that reported the result started.  Not stored
 }
 
 // NewWorkloadDetails creates a new WorkloadDetails struct
@@ -49,3 +161,208 @@ func (wd *WorkloadDetails) GetKHWorkload() KHWorkload {
 	}
 	return wd.khWorkload
 }
+
+// IsKHJob indicates if the details belong to a khjob.  Details without a workload type do not.
+func (wd *WorkloadDetails) IsKHJob() bool {
+	return wd.khWorkload == KHJob
+}
+
+// GetStatus returns the tri-state status of the workload.  Details that were written before the Status
+// field existed fall back to the value of OK.
+func (wd *WorkloadDetails) GetStatus() CheckStatus {
+	if wd.Status == "" {
+		return StatusFromOK(wd.OK)
+	}
+	return wd.Status
+}
+
+// GetSeverity returns the severity of the workload's errors.  Results reported without a severity are critical.
+func (wd *WorkloadDetails) GetSeverity() Severity {
+	if wd.Severity == "" {
+		return SeverityCritical
+	}
+	return wd.Severity
+}
+
+// ResultChangedFrom indicates if the OK value or errors of this result differ from a previous result
+func (wd *WorkloadDetails) ResultChangedFrom(previous WorkloadDetails) bool {
+	if wd.OK != previous.OK || len(wd.Errors) != len(previous.Errors) {
+		return true
+	}
+	for i := range wd.Errors {
+		if wd.Errors[i] != previous.Errors[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// IsStatic indicates if the result has not changed for longer than the supplied window.  A window of zero
+// disables detection.  Workloads that have not reported a result are never considered static.
+func (wd *WorkloadDetails) IsStatic(window time.Duration, now time.Time) bool {
+	if window <= 0 || wd.LastResultChange.IsZero() || wd.GetStatus() == StatusUnknown {
+		return false
+	}
+	return now.Sub(wd.LastResultChange) > window
+}
+
+// Metadata returns a copy of the details trimmed down to the status and timestamps.  This is used to reduce
+// the size of responses for callers that do not need errors, annotations or other bulky fields.
+func (wd *WorkloadDetails) Metadata() WorkloadDetails {
+	return WorkloadDetails{
+		OK:               wd.OK,
+		Status:           wd.GetStatus(),
+		Namespace:        wd.Namespace,
+		LastRun:          wd.LastRun,
+		LastResultChange: wd.LastResultChange,
+		LastSuccess:      wd.LastSuccess,
+		ClusterScoped:    wd.ClusterScoped,
+		Synthetic:        wd.Synthetic,
+		Reliability:      wd.Reliability,
+		Aggregate:        wd.Aggregate,
+		Trend:            wd.Trend,
+		CheckUID:         wd.CheckUID,
+		ResultTTL:        wd.ResultTTL,
+		khWorkload:       wd.khWorkload,
+	}
+}
+
+// SetSchedule records when the run that reported the result was scheduled to start and when it actually started, so
+// that its scheduling delay can be written with the result
+func (wd *WorkloadDetails) SetSchedule(scheduled time.Time, started time.Time) {
+	wd.scheduledAt = scheduled
+	wd.startedAt = started
+}
+
+// Schedule returns when the run that reported the result was scheduled to start and when it actually started.  Both
+// are zero for results that were not reported by a scheduled run.
+func (wd *WorkloadDetails) Schedule() (time.Time, time.Time) {
+	return wd.scheduledAt, wd.startedAt
+}
+
+// EffectiveResultTTL returns the result TTL stored with the result, or the supplied default TTL when none is stored
+func (wd *WorkloadDetails) EffectiveResultTTL(defaultTTL time.Duration) time.Duration {
+	if len(wd.ResultTTL) == 0 {
+		return defaultTTL
+	}
+	ttl, err := time.ParseDuration(wd.ResultTTL)
+	if err != nil || ttl <= 0 {
+		return defaultTTL
+	}
+	return ttl
+}
+
+// IsExpired indicates if the last result is older than its result TTL, which means the check has stopped
+// reporting.  The supplied TTL is used for results that have no result TTL stored with them.  A TTL of zero
+// disables expiry.  Workloads that have never run or are disabled are never expired.
+func (wd *WorkloadDetails) IsExpired(ttl time.Duration, now time.Time) bool {
+	ttl = wd.EffectiveResultTTL(ttl)
+	if ttl <= 0 || wd.LastRun.IsZero() || wd.Disabled {
+		return false
+	}
+	return now.After(wd.LastRun.Add(ttl))
+}
+
+// IsRetained indicates if the last result of a workload that no longer exists is recent enough to be kept for the
+// supplied retention window.  A retention of zero keeps nothing.
+func (wd *WorkloadDetails) IsRetained(retention time.Duration, now time.Time) bool {
+	if retention <= 0 || wd.LastRun.IsZero() {
+		return false
+	}
+	return now.Sub(wd.LastRun) <= retention
+}
+
+// InInitialGracePeriod indicates if the workload was created less than the supplied grace period ago.  A grace
+// period of zero disables it.  Workloads created before creation times were recorded are never in it.
+func (wd *WorkloadDetails) InInitialGracePeriod(gracePeriod time.Duration, now time.Time) bool {
+	if gracePeriod <= 0 || wd.Created.IsZero() {
+		return false
+	}
+	return now.Before(wd.Created.Add(gracePeriod))
+}
+
+// ValidationError describes a single field of a workload result that is invalid and why
+type ValidationError struct {
+	Field  string
+	Reason string
+}
+
+// Validate checks the invariants of a reported workload result and returns every field that breaks them.  A
+// valid result returns no validation errors.
+func (wd *WorkloadDetails) Validate() []ValidationError {
+	var validationErrors []ValidationError
+
+	if !wd.OK && len(wd.Errors) == 0 {
+		validationErrors = append(validationErrors, ValidationError{
+			Field:  "Errors",
+			Reason: "at least one error must be supplied when OK is false",
+		})
+	}
+
+	// an OK result may only carry errors as warnings, which must say that they are not critical
+	if wd.OK && len(wd.Errors) > 0 && wd.GetSeverity() == SeverityCritical {
+		validationErrors = append(validationErrors, ValidationError{
+			Field:  "Severity",
+			Reason: fmt.Sprintf("errors reported with OK true must have a severity of %s or %s", SeverityInfo, SeverityWarning),
+		})
+	}
+	for i, e := range wd.Errors {
+		if len(e) == 0 {
+			validationErrors = append(validationErrors, ValidationError{
+				Field:  fmt.Sprintf("Errors[%d]", i),
+				Reason: "error strings must not be blank",
+			})
+		}
+	}
+
+	switch wd.Status {
+	case "":
+	case StatusOK, StatusNotOK:
+		if wd.Status != StatusFromOK(wd.OK) {
+			validationErrors = append(validationErrors, ValidationError{
+				Field:  "Status",
+				Reason: fmt.Sprintf("status %s does not match OK %t", wd.Status, wd.OK),
+			})
+		}
+	case StatusUnknown:
+		validationErrors = append(validationErrors, ValidationError{
+			Field:  "Status",
+			Reason: "a reported result can not have an Unknown status",
+		})
+	default:
+		validationErrors = append(validationErrors, ValidationError{
+			Field:  "Status",
+			Reason: fmt.Sprintf("status %s is not one of %s or %s", wd.Status, StatusOK, StatusNotOK),
+		})
+	}
+
+	switch wd.Severity {
+	case "", SeverityInfo, SeverityWarning, SeverityCritical:
+	default:
+		validationErrors = append(validationErrors, ValidationError{
+			Field:  "Severity",
+			Reason: fmt.Sprintf("severity %s is not one of %s, %s or %s", wd.Severity, SeverityInfo, SeverityWarning, SeverityCritical),
+		})
+	}
+
+	for k := range wd.Annotations {
+		if len(k) == 0 {
+			validationErrors = append(validationErrors, ValidationError{
+				Field:  "Annotations",
+				Reason: "annotation keys must not be blank",
+			})
+		}
+	}
+
+	for i, artifactURL := range wd.ArtifactURLs {
+		u, err := url.Parse(artifactURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			validationErrors = append(validationErrors, ValidationError{
+				Field:  fmt.Sprintf("ArtifactURLs[%d]", i),
+				Reason: "artifact URLs must be absolute http or https URLs",
+			})
+		}
+	}
+
+	return validationErrors
+}
@@ -0,0 +1,95 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"testing"
+	"time"
+)
+
+// TestValidate ensures that each broken invariant of a result is reported against the right field
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		details WorkloadDetails
+		fields  []string
+	}{
+		{name: "ok", details: WorkloadDetails{OK: true}},
+		{name: "failure with errors", details: WorkloadDetails{OK: false, Errors: []string{"broken"}}},
+		{name: "failure without errors", details: WorkloadDetails{OK: false}, fields: []string{"Errors"}},
+		{name: "blank error", details: WorkloadDetails{OK: false, Errors: []string{"broken", ""}}, fields: []string{"Errors[1]"}},
+		{name: "mismatched status", details: WorkloadDetails{OK: true, Status: StatusNotOK}, fields: []string{"Status"}},
+		{name: "unknown status", details: WorkloadDetails{OK: true, Status: StatusUnknown}, fields: []string{"Status"}},
+		{name: "warning", details: WorkloadDetails{OK: true, Errors: []string{"slow"}, Severity: SeverityWarning}},
+		{name: "ok with critical errors", details: WorkloadDetails{OK: true, Errors: []string{"broken"}, Severity: SeverityCritical}, fields: []string{"Severity"}},
+		{name: "ok with errors without a severity", details: WorkloadDetails{OK: true, Errors: []string{"broken"}}, fields: []string{"Severity"}},
+		{name: "failure with a warning severity", details: WorkloadDetails{OK: false, Errors: []string{"slow"}, Severity: SeverityWarning}},
+		{name: "unknown severity", details: WorkloadDetails{OK: false, Errors: []string{"broken"}, Severity: "fatal"}, fields: []string{"Severity"}},
+		{name: "blank annotation key", details: WorkloadDetails{OK: true, Annotations: map[string]string{"": "x"}}, fields: []string{"Annotations"}},
+		{name: "artifact urls", details: WorkloadDetails{OK: false, Errors: []string{"broken"}, ArtifactURLs: []string{"https://ci.example.com/runs/1/report.html"}}},
+		{name: "relative artifact url", details: WorkloadDetails{OK: false, Errors: []string{"broken"}, ArtifactURLs: []string{"https://ci.example.com/ok", "/report.html"}}, fields: []string{"ArtifactURLs[1]"}},
+	}
+
+	for _, tc := range tests {
+		validationErrors := tc.details.Validate()
+		if len(validationErrors) != len(tc.fields) {
+			t.Fatalf("%s: expected %d validation errors but got %d: %v", tc.name, len(tc.fields), len(validationErrors), validationErrors)
+		}
+		for i, field := range tc.fields {
+			if validationErrors[i].Field != field {
+				t.Fatalf("%s: expected validation error for field %s but got %s", tc.name, field, validationErrors[i].Field)
+			}
+		}
+	}
+}
+
+// TestMetadata ensures that bulky fields are trimmed from a result while its status is kept
+func TestMetadata(t *testing.T) {
+	details := WorkloadDetails{
+		OK:          false,
+		Status:      StatusNotOK,
+		Errors:      []string{"broken"},
+		Annotations: map[string]string{"owner": "team"},
+		Namespace:   "kuberhealthy",
+		LastRun:     time.Now(),
+	}
+
+	trimmed := details.Metadata()
+	if len(trimmed.Errors) != 0 || len(trimmed.Annotations) != 0 {
+		t.Fatalf("expected errors and annotations to be trimmed but got %+v", trimmed)
+	}
+	if trimmed.OK != details.OK || trimmed.Status != details.Status || trimmed.Namespace != details.Namespace || !trimmed.LastRun.Equal(details.LastRun) {
+		t.Fatalf("expected status fields to be kept but got %+v", trimmed)
+	}
+}
+
+// TestIsExpiredWithStoredTTL ensures that a result TTL stored with a result takes precedence over the supplied TTL
+func TestIsExpiredWithStoredTTL(t *testing.T) {
+	now := time.Now()
+	details := WorkloadDetails{OK: true, LastRun: now.Add(-time.Hour)}
+	if !details.IsExpired(time.Minute*30, now) {
+		t.Fatal("expected the result to expire with the supplied TTL")
+	}
+
+	details.ResultTTL = "2h0m0s"
+	if details.IsExpired(time.Minute*30, now) {
+		t.Fatal("expected the stored result TTL to keep the result from expiring")
+	}
+	if !details.IsExpired(0, now.Add(time.Hour*2)) {
+		t.Fatal("expected the stored result TTL to expire the result when no TTL is supplied")
+	}
+
+	details.ResultTTL = "soon"
+	if details.EffectiveResultTTL(time.Minute) != time.Minute {
+		t.Fatal("expected an invalid stored result TTL to fall back to the supplied TTL")
+	}
+}
@@ -21,11 +21,41 @@ import (
 // State represents the results of all checks being managed along with a top-level OK and Error state. This is displayed
 // on the kuberhealthy status page as JSON
 type State struct {
-	OK            bool
-	Errors        []string
-	CheckDetails  map[string]WorkloadDetails // map of check names to last run timestamp
-	JobDetails    map[string]WorkloadDetails  // map of job names to last run timestamp
-	CurrentMaster string
+	OK                  bool
+	Errors              []string
+	Warnings            []string                   `json:",omitempty"` // errors of checks whose severity does not fail the overall status
+	CheckDetails        map[string]WorkloadDetails // map of check names to last run timestamp
+	JobDetails          map[string]WorkloadDetails // map of job names to last run timestamp
+	ClusterCheckDetails map[string]WorkloadDetails `json:",omitempty"` // map of cluster-scoped check names to last run timestamp
+	CurrentMaster       string
+	WritesPaused        bool // indicates that check results are not being recorded
+	StateWritesFailing  bool `json:",omitempty"` // indicates that check results are not being recorded because the API server keeps failing
+}
+
+// Metadata returns a copy of the state with every check and job trimmed down to its status and timestamps
+func (h *State) Metadata() State {
+	trimmed := *h
+	trimmed.CheckDetails = trimDetailsToMetadata(h.CheckDetails)
+	trimmed.JobDetails = trimDetailsToMetadata(h.JobDetails)
+	trimmed.ClusterCheckDetails = trimDetailsToMetadata(h.ClusterCheckDetails)
+	return trimmed
+}
+
+// trimDetailsToMetadata trims every entry in a map of workload details down to its status and timestamps
+func trimDetailsToMetadata(details map[string]WorkloadDetails) map[string]WorkloadDetails {
+	if details == nil {
+		return nil
+	}
+	trimmed := make(map[string]WorkloadDetails, len(details))
+	for k, d := range details {
+		trimmed[k] = d.Metadata()
+	}
+	return trimmed
+}
+
+// AddWarning adds new warnings to State
+func (h *State) AddWarning(s ...string) {
+	h.Warnings = append(h.Warnings, s...)
 }
 
 // AddError adds new errors to State
@@ -71,5 +101,6 @@ func NewState() State {
 	s.Errors = []string{}
 	s.CheckDetails = make(map[string]WorkloadDetails)
 	s.JobDetails = make(map[string]WorkloadDetails)
+	s.ClusterCheckDetails = make(map[string]WorkloadDetails)
 	return s
 }
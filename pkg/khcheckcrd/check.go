@@ -11,11 +11,19 @@ import (
 // the whitelisted UUID that is currently allowed to report-in to
 // the status reporting endpoint.
 type CheckConfig struct {
-	RunInterval      string            `json:"runInterval"`      // the interval at which the check runs
-	Timeout          string            `json:"timeout"`          // the maximum time the pod is allowed to run before a failure is assumed
-	PodSpec          apiv1.PodSpec     `json:"podSpec"`          // a spec for the external checker
-	ExtraAnnotations map[string]string `json:"extraAnnotations"` // a map of extra annotations that will be applied to the pod
-	ExtraLabels      map[string]string `json:"extraLabels"`      // a map of extra labels that will be applied to the pod
+	RunInterval      string            `json:"runInterval"`        // the interval at which the check runs
+	Timeout          string            `json:"timeout"`            // the maximum time the pod is allowed to run before a failure is assumed
+	PodSpec          apiv1.PodSpec     `json:"podSpec"`            // a spec for the external checker
+	ExtraAnnotations map[string]string `json:"extraAnnotations"`   // a map of extra annotations that will be applied to the pod
+	ExtraLabels      map[string]string `json:"extraLabels"`        // a map of extra labels that will be applied to the pod
+	DependsOn        []string          `json:"dependsOn"`          // checks, as name or namespace/name, that must not be failing for this check to run
+	Enabled          *bool             `json:"enabled,omitempty"`  // set to false to stop running the check without deleting it.  Checks are enabled when unset
+	Priority         int               `json:"priority,omitempty"` // checks with a higher priority get a run slot first when the concurrent check limit is reached
+}
+
+// IsEnabled indicates if the check should be run.  Checks that do not set enabled are enabled.
+func (c CheckConfig) IsEnabled() bool {
+	return c.Enabled == nil || *c.Enabled
 }
 
 // DefaultTimeout is the default timeout for external checks
@@ -44,8 +44,15 @@ func Client(GroupName string, GroupVersion string, kubeConfig string, namespace
 		return &KuberhealthyStateClient{}, err
 	}
 
+	return ClientForConfig(GroupName, GroupVersion, c)
+}
+
+// ClientForConfig creates a rest client to use for interacting with CRDs from the supplied rest config.
+// This allows callers to talk to an API endpoint other than the one found in-cluster.
+func ClientForConfig(GroupName string, GroupVersion string, c *rest.Config) (*KuberhealthyStateClient, error) {
+
 	// log.Println("Configuring scheme")
-	err = ConfigureScheme(GroupName, GroupVersion)
+	err := ConfigureScheme(GroupName, GroupVersion)
 	if err != nil {
 		return &KuberhealthyStateClient{}, err
 	}
@@ -16,6 +16,7 @@ import (
 	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
@@ -45,6 +46,20 @@ func (c *KuberhealthyStateClient) Create(state *KuberhealthyState, resource stri
 	return &result, err
 }
 
+// CreateWithOptions creates a new resource for this CRD with the supplied create options, such as a dry run
+func (c *KuberhealthyStateClient) CreateWithOptions(opts metav1.CreateOptions, state *KuberhealthyState, resource string, namespace string) (*KuberhealthyState, error) {
+	result := KuberhealthyState{}
+	err := c.restClient.
+		Post().
+		Namespace(namespace).
+		Resource(resource).
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(state).
+		Do(context.TODO()).
+		Into(&result)
+	return &result, err
+}
+
 // Delete deletes a resource for this CRD
 func (c *KuberhealthyStateClient) Delete(state *KuberhealthyState, resource string, name string, namespace string) (*KuberhealthyState, error) {
 	result := KuberhealthyState{}
@@ -60,6 +75,11 @@ func (c *KuberhealthyStateClient) Delete(state *KuberhealthyState, resource stri
 
 // Update updates a resource for this CRD
 func (c *KuberhealthyStateClient) Update(state *KuberhealthyState, resource string, name string, namespace string) (*KuberhealthyState, error) {
+	return c.UpdateContext(context.TODO(), state, resource, name, namespace)
+}
+
+// UpdateContext updates a resource for this CRD within the supplied context
+func (c *KuberhealthyStateClient) UpdateContext(ctx context.Context, state *KuberhealthyState, resource string, name string, namespace string) (*KuberhealthyState, error) {
 	result := KuberhealthyState{}
 	// err := c.restClient.Verb("update").Namespace(c.ns).Resource(resource).Name(name).Do().Into(&result)
 	err := c.restClient.
@@ -68,13 +88,37 @@ func (c *KuberhealthyStateClient) Update(state *KuberhealthyState, resource stri
 		Resource(resource).
 		Body(state).
 		Name(name).
-		Do(context.TODO()).
+		Do(ctx).
+		Into(&result)
+	return &result, err
+}
+
+// Patch applies a patch of the supplied type to a resource of this CRD
+func (c *KuberhealthyStateClient) Patch(patchType types.PatchType, data []byte, resource string, name string, namespace string) (*KuberhealthyState, error) {
+	return c.PatchContext(context.TODO(), patchType, data, resource, name, namespace)
+}
+
+// PatchContext applies a patch of the supplied type to a resource of this CRD within the supplied context
+func (c *KuberhealthyStateClient) PatchContext(ctx context.Context, patchType types.PatchType, data []byte, resource string, name string, namespace string) (*KuberhealthyState, error) {
+	result := KuberhealthyState{}
+	err := c.restClient.
+		Patch(patchType).
+		Namespace(namespace).
+		Resource(resource).
+		Name(name).
+		Body(data).
+		Do(ctx).
 		Into(&result)
 	return &result, err
 }
 
 // Get fetches a resource of this CRD
 func (c *KuberhealthyStateClient) Get(opts metav1.GetOptions, resource string, name string, namespace string) (*KuberhealthyState, error) {
+	return c.GetContext(context.TODO(), opts, resource, name, namespace)
+}
+
+// GetContext fetches a resource of this CRD within the supplied context
+func (c *KuberhealthyStateClient) GetContext(ctx context.Context, opts metav1.GetOptions, resource string, name string, namespace string) (*KuberhealthyState, error) {
 	result := KuberhealthyState{}
 	err := c.restClient.
 		Get().
@@ -82,7 +126,7 @@ func (c *KuberhealthyStateClient) Get(opts metav1.GetOptions, resource string, n
 		Resource(resource).
 		Name(name).
 		VersionedParams(&opts, scheme.ParameterCodec).
-		Do(context.TODO()).
+		Do(ctx).
 		Into(&result)
 	return &result, err
 }
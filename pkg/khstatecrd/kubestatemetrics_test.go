@@ -0,0 +1,132 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package khstatecrd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// kubeStateMetricsConfigFile is the kube-state-metrics custom resource state config shipped for khstates
+const kubeStateMetricsConfigFile = "../../deploy/kube-state-metrics/khstate-custom-resource-state.yaml"
+
+// kubeStateMetricsConfig is the part of a kube-state-metrics custom resource state config that references fields
+type kubeStateMetricsConfig struct {
+	Spec struct {
+		Resources []struct {
+			LabelsFromPath map[string][]string `json:"labelsFromPath"`
+			Metrics        []struct {
+				Name string `json:"name"`
+				Each struct {
+					Gauge    *kubeStateMetricsField `json:"gauge"`
+					StateSet *kubeStateMetricsField `json:"stateSet"`
+				} `json:"each"`
+			} `json:"metrics"`
+		} `json:"resources"`
+	} `json:"spec"`
+}
+
+// kubeStateMetricsField is a gauge or state set metric that reads a field of a khstate
+type kubeStateMetricsField struct {
+	Path           []string            `json:"path"`
+	ValueFrom      []string            `json:"valueFrom"`
+	LabelsFromPath map[string][]string `json:"labelsFromPath"`
+}
+
+// lookupPath follows a kube-state-metrics field path through a decoded JSON object
+func lookupPath(obj interface{}, path []string) (interface{}, bool) {
+	for _, p := range path {
+		m, ok := obj.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		obj, ok = m[p]
+		if !ok {
+			return nil, false
+		}
+	}
+	return obj, true
+}
+
+// TestKubeStateMetricsConfigPaths ensures that every field path in the kube-state-metrics config exists on a khstate
+func TestKubeStateMetricsConfigPaths(t *testing.T) {
+	b, err := ioutil.ReadFile(kubeStateMetricsConfigFile)
+	if err != nil {
+		t.Fatal("failed to read kube-state-metrics config:", err)
+	}
+	config := kubeStateMetricsConfig{}
+	err = yaml.Unmarshal(b, &config)
+	if err != nil {
+		t.Fatal("failed to parse kube-state-metrics config:", err)
+	}
+
+	state := NewKuberhealthyState("example", health.WorkloadDetails{OK: true, Status: health.StatusOK, LastRun: time.Now()})
+	state.Namespace = "kuberhealthy"
+	state.SyncReadyCondition()
+	b, err = json.Marshal(state)
+	if err != nil {
+		t.Fatal("failed to marshal khstate:", err)
+	}
+	var obj interface{}
+	err = json.Unmarshal(b, &obj)
+	if err != nil {
+		t.Fatal("failed to unmarshal khstate:", err)
+	}
+
+	if len(config.Spec.Resources) == 0 {
+		t.Fatal("kube-state-metrics config has no resources")
+	}
+	for _, resource := range config.Spec.Resources {
+		for label, path := range resource.LabelsFromPath {
+			if _, ok := lookupPath(obj, path); !ok {
+				t.Fatalf("label %s references path %v which does not exist on a khstate", label, path)
+			}
+		}
+
+		for _, metric := range resource.Metrics {
+			field := metric.Each.Gauge
+			if field == nil {
+				field = metric.Each.StateSet
+			}
+			if field == nil {
+				t.Fatalf("metric %s is not a gauge or state set", metric.Name)
+			}
+			value, ok := lookupPath(obj, field.Path)
+			if !ok {
+				t.Fatalf("metric %s references path %v which does not exist on a khstate", metric.Name, field.Path)
+			}
+
+			// paths to lists are read relative to every item in the list
+			items, isList := value.([]interface{})
+			if !isList {
+				continue
+			}
+			if len(items) == 0 {
+				t.Fatalf("metric %s references list %v which is empty on a khstate", metric.Name, field.Path)
+			}
+			if _, ok := lookupPath(items[0], field.ValueFrom); !ok {
+				t.Fatalf("metric %s reads its value from %v which does not exist in %v", metric.Name, field.ValueFrom, field.Path)
+			}
+			for label, path := range field.LabelsFromPath {
+				if _, ok := lookupPath(items[0], path); !ok {
+					t.Fatalf("metric %s label %s references %v which does not exist in %v", metric.Name, label, path, field.Path)
+				}
+			}
+		}
+	}
+}
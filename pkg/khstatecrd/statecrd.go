@@ -13,19 +13,64 @@ package khstatecrd
 
 import (
 	"encoding/json"
+	"strings"
 
 	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 
 	"github.com/Comcast/kuberhealthy/v2/pkg/health"
 )
 
+// ReadyConditionType is the type of the condition that mirrors the check's result, which allows tools such as
+// `kubectl wait --for=condition=Ready` to be used on khstate resources
+const ReadyConditionType = "Ready"
+
+// Reasons set on the Ready condition
+const (
+	ReasonCheckPassed = "CheckPassed"
+	ReasonCheckFailed = "CheckFailed"
+	ReasonNoResultYet = "NoResultYet"
+)
+
 // KuberhealthyState struct containing meta objects and health state in spec
 type KuberhealthyState struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
-	Spec              health.WorkloadDetails `json:"spec"`
+	Spec              health.WorkloadDetails  `json:"spec"`
+	Status            KuberhealthyStateStatus `json:"status,omitempty"`
+}
+
+// KuberhealthyStateStatus holds the conditions of a khstate that are derived from its spec
+type KuberhealthyStateStatus struct {
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// SyncReadyCondition sets the Ready condition from the current result in the spec.  The transition time of the
+// condition only changes when its status changes.
+func (h *KuberhealthyState) SyncReadyCondition() {
+	condition := metav1.Condition{
+		Type:               ReadyConditionType,
+		ObservedGeneration: h.GetGeneration(),
+	}
+
+	switch h.Spec.GetStatus() {
+	case health.StatusUnknown:
+		condition.Status = metav1.ConditionUnknown
+		condition.Reason = ReasonNoResultYet
+		condition.Message = "The check has not reported a result yet"
+	case health.StatusOK:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = ReasonCheckPassed
+		condition.Message = "The check passed"
+	default:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = ReasonCheckFailed
+		condition.Message = strings.Join(h.Spec.Errors, "; ")
+	}
+
+	meta.SetStatusCondition(&h.Status.Conditions, condition)
 }
 
 // String satisfies the stringer interface for cleaner output when printing
@@ -43,6 +88,10 @@ func (h KuberhealthyState) DeepCopyInto(out *KuberhealthyState) {
 	out.TypeMeta = h.TypeMeta
 	out.ObjectMeta = h.ObjectMeta
 	out.Spec = h.Spec
+	if h.Status.Conditions != nil {
+		out.Status.Conditions = make([]metav1.Condition, len(h.Status.Conditions))
+		copy(out.Status.Conditions, h.Status.Conditions)
+	}
 }
 
 // DeepCopyObject returns a generically typed copy of an object
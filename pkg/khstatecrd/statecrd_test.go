@@ -0,0 +1,62 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package khstatecrd
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Comcast/kuberhealthy/v2/pkg/health"
+)
+
+// TestSyncReadyCondition ensures the Ready condition follows the check result and only moves its transition
+// time when the status changes
+func TestSyncReadyCondition(t *testing.T) {
+	details := health.NewWorkloadDetails(health.KHCheck)
+	details.Status = health.StatusUnknown
+	state := NewKuberhealthyState("test", details)
+
+	state.SyncReadyCondition()
+	if !meta.IsStatusConditionPresentAndEqual(state.Status.Conditions, ReadyConditionType, metav1.ConditionUnknown) {
+		t.Fatal("Expected a new khstate to have an unknown Ready condition. Got:", state.Status.Conditions)
+	}
+
+	state.Spec.Status = health.StatusOK
+	state.Spec.OK = true
+	state.SyncReadyCondition()
+	if !meta.IsStatusConditionTrue(state.Status.Conditions, ReadyConditionType) {
+		t.Fatal("Expected an OK khstate to have a true Ready condition. Got:", state.Status.Conditions)
+	}
+
+	// make the last transition look old so we can tell if it moves
+	oldTransition := metav1.NewTime(time.Now().Add(-time.Hour))
+	state.Status.Conditions[0].LastTransitionTime = oldTransition
+	state.SyncReadyCondition()
+	if !meta.FindStatusCondition(state.Status.Conditions, ReadyConditionType).LastTransitionTime.Equal(&oldTransition) {
+		t.Fatal("Expected the Ready condition transition time to stay the same when the status did not change")
+	}
+
+	state.Spec.Status = health.StatusNotOK
+	state.Spec.OK = false
+	state.Spec.Errors = []string{"something broke"}
+	state.SyncReadyCondition()
+	ready := meta.FindStatusCondition(state.Status.Conditions, ReadyConditionType)
+	if ready.Status != metav1.ConditionFalse || ready.Message != "something broke" {
+		t.Fatal("Expected a failing khstate to have a false Ready condition with its error. Got:", ready)
+	}
+	if ready.LastTransitionTime.Equal(&oldTransition) {
+		t.Fatal("Expected the Ready condition transition time to change when the status changed")
+	}
+}
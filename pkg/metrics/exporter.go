@@ -24,6 +24,35 @@ import (
 
 //GenerateMetrics takes the state and returns it in the Prometheus format
 func GenerateMetrics(state health.State) string {
+	return generateMetrics(state, false)
+}
+
+// GenerateOpenMetrics takes the state and returns it in the OpenMetrics format.  The status of every check that
+// recorded a run ID carries an exemplar linking it to that run.  The output is not terminated with # EOF, so that
+// registered metrics can be appended to it.
+func GenerateOpenMetrics(state health.State) string {
+	return generateMetrics(state, true)
+}
+
+// maxExemplarRunes is the maximum combined length of the label names and values of an OpenMetrics exemplar
+const maxExemplarRunes = 128
+
+// runExemplar returns the OpenMetrics exemplar that links the status of a check to the run that reported it.  It
+// is blank when the check did not record a run ID or the run ID is too long for an exemplar.
+func runExemplar(d health.WorkloadDetails, value string) string {
+	if len(d.RunID) == 0 || len([]rune("run_id"+d.RunID)) > maxExemplarRunes {
+		return ""
+	}
+	runID := strings.NewReplacer(`\`, `\\`, "\"", `\"`, "\n", `\n`).Replace(d.RunID)
+	exemplar := fmt.Sprintf(" # {run_id=\"%s\"} %s", runID, value)
+	if !d.LastRun.IsZero() {
+		exemplar += fmt.Sprintf(" %d", d.LastRun.Unix())
+	}
+	return exemplar
+}
+
+// generateMetrics renders the state in the Prometheus format, or with exemplars in the OpenMetrics format
+func generateMetrics(state health.State, exemplars bool) string {
 	metricsOutput := ""
 	healthStatus := "0"
 	if state.OK {
@@ -41,9 +70,39 @@ func GenerateMetrics(state health.State) string {
 	metricCheckDuration := make(map[string]string)
 	metricJobState := make(map[string]string)
 	metricJobDuration := make(map[string]string)
+	metricCheckUnknown := make(map[string]string)
+	metricJobUnknown := make(map[string]string)
+	metricCheckStatic := make(map[string]string)
+	metricCheckAlerting := make(map[string]string)
+	metricCheckConsecutiveFailures := make(map[string]string)
+	checksBySeverity := make(map[health.Severity]int)
 
-	// Parse through all check details and append to metricState
+	// cluster-scoped checks are grouped separately on the status page, but are metrics like any other check
+	allCheckDetails := make(map[string]health.WorkloadDetails, len(state.CheckDetails)+len(state.ClusterCheckDetails))
 	for c, d := range state.CheckDetails {
+		allCheckDetails[c] = d
+	}
+	for c, d := range state.ClusterCheckDetails {
+		allCheckDetails[c] = d
+	}
+
+	// Parse through all check details and append to metricState
+	for c, d := range allCheckDetails {
+		// checks that have not reported yet are neither up nor down, so they get their own metric
+		if d.GetStatus() == health.StatusUnknown {
+			metricCheckUnknown[fmt.Sprintf("kuberhealthy_check_unknown{check=\"%s\",namespace=\"%s\"}", c, d.Namespace)] = "1"
+			continue
+		}
+		if d.Static {
+			metricCheckStatic[fmt.Sprintf("kuberhealthy_check_static{check=\"%s\",namespace=\"%s\"}", c, d.Namespace)] = "1"
+		}
+		if d.Alerting {
+			metricCheckAlerting[fmt.Sprintf("kuberhealthy_check_alerting{check=\"%s\",namespace=\"%s\"}", c, d.Namespace)] = "1"
+		}
+		metricCheckConsecutiveFailures[fmt.Sprintf("kuberhealthy_check_consecutive_failures{check=\"%s\",namespace=\"%s\"}", c, d.Namespace)] = fmt.Sprintf("%d", d.ConsecutiveFailures)
+		if len(d.Errors) > 0 {
+			checksBySeverity[d.GetSeverity()]++
+		}
 		checkStatus := "0"
 		if d.OK {
 			checkStatus = "1"
@@ -58,6 +117,9 @@ func GenerateMetrics(state health.State) string {
 		metricName := fmt.Sprintf("kuberhealthy_check{check=\"%s\",namespace=\"%s\",status=\"%s\",error=\"%s\"}", c, d.Namespace, checkStatus, errors)
 		metricDurationName := fmt.Sprintf("kuberhealthy_check_duration_seconds{check=\"%s\",namespace=\"%s\"}", c, d.Namespace)
 		metricCheckState[metricName] = checkStatus
+		if exemplars {
+			metricCheckState[metricName] += runExemplar(d, checkStatus)
+		}
 		runDuration, err := time.ParseDuration(d.RunDuration)
 		if err != nil {
 			log.Errorln("Error parsing run duration:", d.RunDuration, "for metric:", metricName, "error:", err)
@@ -68,6 +130,10 @@ func GenerateMetrics(state health.State) string {
 
 	// Parse through all job details and append to metricState
 	for c, d := range state.JobDetails {
+		if d.GetStatus() == health.StatusUnknown {
+			metricJobUnknown[fmt.Sprintf("kuberhealthy_job_unknown{check=\"%s\",namespace=\"%s\"}", c, d.Namespace)] = "1"
+			continue
+		}
 		jobStatus := "0"
 		if d.OK {
 			jobStatus = "1"
@@ -102,6 +168,31 @@ func GenerateMetrics(state health.State) string {
 	for m, v := range metricCheckDuration {
 		metricsOutput += fmt.Sprintf("%s %s\n", m, v)
 	}
+	metricsOutput += "# HELP kuberhealthy_check_unknown Shows Kuberhealthy checks that have not reported a result yet\n"
+	metricsOutput += "# TYPE kuberhealthy_check_unknown gauge\n"
+	for m, v := range metricCheckUnknown {
+		metricsOutput += fmt.Sprintf("%s %s\n", m, v)
+	}
+	metricsOutput += "# HELP kuberhealthy_check_static Shows Kuberhealthy checks whose result has not changed in a suspiciously long time\n"
+	metricsOutput += "# TYPE kuberhealthy_check_static gauge\n"
+	for m, v := range metricCheckStatic {
+		metricsOutput += fmt.Sprintf("%s %s\n", m, v)
+	}
+	metricsOutput += "# HELP kuberhealthy_check_consecutive_failures Shows the number of runs in a row that a Kuberhealthy check has failed\n"
+	metricsOutput += "# TYPE kuberhealthy_check_consecutive_failures gauge\n"
+	for m, v := range metricCheckConsecutiveFailures {
+		metricsOutput += fmt.Sprintf("%s %s\n", m, v)
+	}
+	metricsOutput += "# HELP kuberhealthy_check_alerting Shows Kuberhealthy checks that have failed at least as many runs in a row as their alert threshold\n"
+	metricsOutput += "# TYPE kuberhealthy_check_alerting gauge\n"
+	for m, v := range metricCheckAlerting {
+		metricsOutput += fmt.Sprintf("%s %s\n", m, v)
+	}
+	metricsOutput += "# HELP kuberhealthy_checks_with_errors Shows the number of Kuberhealthy checks reporting errors at each severity\n"
+	metricsOutput += "# TYPE kuberhealthy_checks_with_errors gauge\n"
+	for _, severity := range health.Severities {
+		metricsOutput += fmt.Sprintf("kuberhealthy_checks_with_errors{severity=\"%s\"} %d\n", severity, checksBySeverity[severity])
+	}
 	// Kuberhealthy job metrics
 	metricsOutput += "# HELP kuberhealthy_job Shows the status of a Kuberhealthy job\n"
 	metricsOutput += "# TYPE kuberhealthy_job gauge\n"
@@ -113,6 +204,11 @@ func GenerateMetrics(state health.State) string {
 	for m, v := range metricJobDuration {
 		metricsOutput += fmt.Sprintf("%s %s\n", m, v)
 	}
+	metricsOutput += "# HELP kuberhealthy_job_unknown Shows Kuberhealthy jobs that have not reported a result yet\n"
+	metricsOutput += "# TYPE kuberhealthy_job_unknown gauge\n"
+	for m, v := range metricJobUnknown {
+		metricsOutput += fmt.Sprintf("%s %s\n", m, v)
+	}
 
 	return metricsOutput
 }
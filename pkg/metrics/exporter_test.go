@@ -15,6 +15,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/Comcast/kuberhealthy/v2/pkg/health"
 )
@@ -111,6 +112,52 @@ func TestGenerateMetrics(t *testing.T) {
 	}
 }
 
+func TestGenerateMetricsUnknownStatus(t *testing.T) {
+	state := health.State{
+		CheckDetails: map[string]health.WorkloadDetails{
+			"new": {
+				Namespace: "kuberhealthy",
+				Status:    health.StatusUnknown,
+			},
+		},
+	}
+	result := GenerateMetrics(state)
+	metrics := parseMetrics(result)
+	if metrics[`kuberhealthy_check_unknown{check="new",namespace="kuberhealthy"}`] != "1" {
+		t.Fatal("Kuberhealthy check with no results is not shown as unknown")
+	}
+	for m := range metrics {
+		if strings.HasPrefix(m, `kuberhealthy_check{check="new"`) {
+			t.Fatal("Kuberhealthy check with no results was reported as up or down:", m)
+		}
+	}
+}
+
+func TestGenerateMetricsStaticCheck(t *testing.T) {
+	state := health.State{
+		CheckDetails: map[string]health.WorkloadDetails{
+			"stuck": {
+				OK:          true,
+				Namespace:   "kuberhealthy",
+				RunDuration: "1s",
+				Static:      true,
+			},
+			"fine": {
+				OK:          true,
+				Namespace:   "kuberhealthy",
+				RunDuration: "1s",
+			},
+		},
+	}
+	metrics := parseMetrics(GenerateMetrics(state))
+	if metrics[`kuberhealthy_check_static{check="stuck",namespace="kuberhealthy"}`] != "1" {
+		t.Fatal("Kuberhealthy check with a static result is not shown as static")
+	}
+	if _, ok := metrics[`kuberhealthy_check_static{check="fine",namespace="kuberhealthy"}`]; ok {
+		t.Fatal("Kuberhealthy check with a changing result was shown as static")
+	}
+}
+
 func TestErrorStateMetrics(t *testing.T) {
 	state := health.State{
 		CurrentMaster: "testMaster",
@@ -157,3 +204,22 @@ func TestWriteMetricError(t *testing.T) {
 		t.Fatal("Error Metric does not match actual error metric function")
 	}
 }
+
+// TestGenerateOpenMetrics ensures that check statuses link to the run that reported them with an exemplar
+func TestGenerateOpenMetrics(t *testing.T) {
+	state := health.NewState()
+	state.CheckDetails["kuberhealthy/dns"] = health.WorkloadDetails{OK: true, Namespace: "kuberhealthy", RunID: "4bf92f3577b34da6", LastRun: time.Unix(1605020645, 0), RunDuration: "1s"}
+	state.CheckDetails["kuberhealthy/daemonset"] = health.WorkloadDetails{OK: true, Namespace: "kuberhealthy", RunDuration: "1s"}
+
+	output := GenerateOpenMetrics(state)
+	expected := `kuberhealthy_check{check="kuberhealthy/dns",namespace="kuberhealthy",status="1",error=""} 1 # {run_id="4bf92f3577b34da6"} 1 1605020645` + "\n"
+	if !strings.Contains(output, expected) {
+		t.Fatalf("Expected an exemplar with the run ID but got:\n%s", output)
+	}
+	if !strings.Contains(output, `kuberhealthy_check{check="kuberhealthy/daemonset",namespace="kuberhealthy",status="1",error=""} 1`+"\n") {
+		t.Fatalf("Expected no exemplar for a check without a run ID but got:\n%s", output)
+	}
+	if strings.Contains(GenerateMetrics(state), "run_id") {
+		t.Fatal("Expected no exemplars in the Prometheus format")
+	}
+}
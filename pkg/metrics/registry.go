@@ -0,0 +1,257 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// labelSeparator joins label values into a single map key.  It can not appear in valid UTF-8 label values.
+const labelSeparator = "\xff"
+
+// metricVec holds the values of a single metric for every combination of label values
+type metricVec struct {
+	sync.Mutex
+	name       string
+	help       string
+	metricType string
+	labels     []string
+	values     map[string]float64
+	buckets    []float64                   // the upper bounds of the buckets of a histogram
+	histograms map[string]*histogramSeries // the observations of a histogram
+}
+
+// histogramSeries holds the observations of a histogram for a single combination of label values
+type histogramSeries struct {
+	counts []uint64 // observations in each bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// CounterVec is a Prometheus counter that is broken out by a set of labels
+type CounterVec struct {
+	*metricVec
+}
+
+// GaugeVec is a Prometheus gauge that is broken out by a set of labels
+type GaugeVec struct {
+	*metricVec
+}
+
+// HistogramVec is a Prometheus histogram that is broken out by a set of labels
+type HistogramVec struct {
+	*metricVec
+}
+
+// registry holds every metric that has been registered for output on the metrics endpoint
+var registry = struct {
+	sync.Mutex
+	metrics []*metricVec
+}{}
+
+// newMetricVec registers a metric.  Registering a metric that is already registered with the same type and labels
+// returns the registered metric, so that code paths that initialize twice do not output it twice.  Registering a
+// name again with a different type or labels is a programming error and panics.
+func newMetricVec(name string, help string, metricType string, labels []string) *metricVec {
+	registry.Lock()
+	defer registry.Unlock()
+
+	for _, m := range registry.metrics {
+		if m.name != name {
+			continue
+		}
+		if m.metricType != metricType || strings.Join(m.labels, labelSeparator) != strings.Join(labels, labelSeparator) {
+			panic(fmt.Sprintf("metric %s is already registered as a %s with labels %v", name, m.metricType, m.labels))
+		}
+		return m
+	}
+
+	m := &metricVec{
+		name:       name,
+		help:       help,
+		metricType: metricType,
+		labels:     labels,
+		values:     make(map[string]float64),
+		histograms: make(map[string]*histogramSeries),
+	}
+	registry.metrics = append(registry.metrics, m)
+	return m
+}
+
+// NewCounterVec creates a counter and registers it for output on the metrics endpoint
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	return &CounterVec{newMetricVec(name, help, "counter", labels)}
+}
+
+// NewGaugeVec creates a gauge and registers it for output on the metrics endpoint
+func NewGaugeVec(name string, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{newMetricVec(name, help, "gauge", labels)}
+}
+
+// NewHistogramVec creates a histogram with the supplied bucket upper bounds, in ascending order, and registers it
+// for output on the metrics endpoint
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{newMetricVec(name, help, "histogram", labels)}
+	h.Lock()
+	defer h.Unlock()
+	if h.buckets == nil {
+		h.buckets = buckets
+	}
+	return h
+}
+
+// Inc increments the counter for the supplied label values by one
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increases the counter for the supplied label values
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	c.Lock()
+	defer c.Unlock()
+	c.values[strings.Join(labelValues, labelSeparator)] += v
+}
+
+// Set sets the gauge for the supplied label values
+func (g *GaugeVec) Set(v float64, labelValues ...string) {
+	g.Lock()
+	defer g.Unlock()
+	g.values[strings.Join(labelValues, labelSeparator)] = v
+}
+
+// Delete removes the gauge for the supplied label values so that it is no longer output
+func (g *GaugeVec) Delete(labelValues ...string) {
+	g.Lock()
+	defer g.Unlock()
+	delete(g.values, strings.Join(labelValues, labelSeparator))
+}
+
+// Observe records an observation in the histogram for the supplied label values
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	h.Lock()
+	defer h.Unlock()
+	key := strings.Join(labelValues, labelSeparator)
+	series, exists := h.histograms[key]
+	if !exists {
+		series = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.histograms[key] = series
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			series.counts[i]++
+			break
+		}
+	}
+	series.sum += v
+	series.count++
+}
+
+// write renders the metric in the Prometheus text format with its series sorted by labels.  In the OpenMetrics
+// format, the family name of a counter does not include the _total suffix of its samples.
+func (m *metricVec) write(openMetrics bool) string {
+	m.Lock()
+	defer m.Unlock()
+
+	keys := make([]string, 0, len(m.values))
+	for k := range m.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	family := m.name
+	if openMetrics && m.metricType == "counter" {
+		family = strings.TrimSuffix(family, "_total")
+	}
+	output := fmt.Sprintf("# HELP %s %s\n", family, m.help)
+	output += fmt.Sprintf("# TYPE %s %s\n", family, m.metricType)
+	for _, k := range keys {
+		output += fmt.Sprintf("%s%s %v\n", m.name, m.formatLabels(k), m.values[k])
+	}
+	return output + m.writeHistograms()
+}
+
+// writeHistograms renders the bucket, sum and count series of every observed combination of label values of a
+// histogram, sorted by labels
+func (m *metricVec) writeHistograms() string {
+	keys := make([]string, 0, len(m.histograms))
+	for k := range m.histograms {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var output string
+	for _, k := range keys {
+		series := m.histograms[k]
+		pairs := m.labelPairs(k)
+		bucketLabels := func(le string) string {
+			return "{" + strings.Join(append(append([]string{}, pairs...), fmt.Sprintf("le=\"%s\"", le)), ",") + "}"
+		}
+		var cumulative uint64
+		for i, bound := range m.buckets {
+			cumulative += series.counts[i]
+			output += fmt.Sprintf("%s_bucket%s %d\n", m.name, bucketLabels(fmt.Sprint(bound)), cumulative)
+		}
+		output += fmt.Sprintf("%s_bucket%s %d\n", m.name, bucketLabels("+Inf"), series.count)
+		output += fmt.Sprintf("%s_sum%s %v\n", m.name, m.formatLabels(k), series.sum)
+		output += fmt.Sprintf("%s_count%s %d\n", m.name, m.formatLabels(k), series.count)
+	}
+	return output
+}
+
+// formatLabels turns a joined set of label values into a Prometheus label set
+func (m *metricVec) formatLabels(key string) string {
+	if len(m.labels) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(m.labelPairs(key), ",") + "}"
+}
+
+// labelPairs turns a joined set of label values into Prometheus label pairs
+func (m *metricVec) labelPairs(key string) []string {
+	values := strings.Split(key, labelSeparator)
+	var pairs []string
+	for i, label := range m.labels {
+		value := ""
+		if i < len(values) {
+			value = strings.ReplaceAll(values[i], "\"", "'")
+		}
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", label, value))
+	}
+	return pairs
+}
+
+// GenerateRegisteredMetrics returns every registered counter, gauge and histogram in the Prometheus format
+func GenerateRegisteredMetrics() string {
+	registry.Lock()
+	defer registry.Unlock()
+
+	output := ""
+	for _, m := range registry.metrics {
+		output += m.write(false)
+	}
+	return output
+}
+
+// GenerateRegisteredOpenMetrics returns every registered counter, gauge and histogram in the OpenMetrics format
+func GenerateRegisteredOpenMetrics() string {
+	registry.Lock()
+	defer registry.Unlock()
+
+	output := ""
+	for _, m := range registry.metrics {
+		output += m.write(true)
+	}
+	return output
+}
@@ -0,0 +1,100 @@
+// Copyright 2018 Comcast Cable Communications Management, LLC
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegisteredMetrics(t *testing.T) {
+	counter := NewCounterVec("kuberhealthy_test_total", "A test counter", "store", "result")
+	counter.Inc("remote", "success")
+	counter.Inc("remote", "success")
+	counter.Inc("remote", "fail\"ure")
+
+	gauge := NewGaugeVec("kuberhealthy_test_gauge", "A test gauge")
+	gauge.Set(3)
+
+	output := GenerateRegisteredMetrics()
+	expected := []string{
+		"# TYPE kuberhealthy_test_total counter\n",
+		`kuberhealthy_test_total{store="remote",result="fail'ure"} 1` + "\n",
+		`kuberhealthy_test_total{store="remote",result="success"} 2` + "\n",
+		"# TYPE kuberhealthy_test_gauge gauge\n",
+		"kuberhealthy_test_gauge 3\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(output, e) {
+			t.Fatalf("Expected registered metrics to contain %q but got:\n%s", e, output)
+		}
+	}
+
+	gauge.Delete()
+	if strings.Contains(GenerateRegisteredMetrics(), "kuberhealthy_test_gauge 3") {
+		t.Fatal("Deleted gauge was still output")
+	}
+}
+
+// TestRegisterTwice ensures that registering a metric again returns the registered metric instead of outputting
+// it twice
+func TestRegisterTwice(t *testing.T) {
+	first := NewCounterVec("kuberhealthy_test_twice_total", "A counter registered twice", "result")
+	second := NewCounterVec("kuberhealthy_test_twice_total", "A counter registered twice", "result")
+	first.Inc("success")
+	second.Inc("success")
+
+	output := GenerateRegisteredMetrics()
+	if strings.Count(output, "# TYPE kuberhealthy_test_twice_total counter") != 1 {
+		t.Fatalf("Expected the counter to be output once but got:\n%s", output)
+	}
+	if !strings.Contains(output, `kuberhealthy_test_twice_total{result="success"} 2`) {
+		t.Fatalf("Expected both registrations to share values but got:\n%s", output)
+	}
+}
+
+// TestRegisteredOpenMetrics ensures that counter families are named without their _total suffix in the
+// OpenMetrics format
+func TestRegisteredOpenMetrics(t *testing.T) {
+	counter := NewCounterVec("kuberhealthy_test_openmetrics_total", "A counter in the OpenMetrics format")
+	counter.Inc()
+
+	output := GenerateRegisteredOpenMetrics()
+	for _, e := range []string{"# TYPE kuberhealthy_test_openmetrics counter\n", "kuberhealthy_test_openmetrics_total 1\n"} {
+		if !strings.Contains(output, e) {
+			t.Fatalf("Expected registered metrics to contain %q but got:\n%s", e, output)
+		}
+	}
+}
+
+// TestRegisteredHistogram ensures that histogram observations are output as cumulative buckets with a sum and count
+func TestRegisteredHistogram(t *testing.T) {
+	histogram := NewHistogramVec("kuberhealthy_test_seconds", "A test histogram", []float64{1, 5}, "check")
+	histogram.Observe(0.5, "dns")
+	histogram.Observe(3, "dns")
+	histogram.Observe(10, "dns")
+
+	output := GenerateRegisteredMetrics()
+	expected := []string{
+		"# TYPE kuberhealthy_test_seconds histogram\n",
+		`kuberhealthy_test_seconds_bucket{check="dns",le="1"} 1` + "\n",
+		`kuberhealthy_test_seconds_bucket{check="dns",le="5"} 2` + "\n",
+		`kuberhealthy_test_seconds_bucket{check="dns",le="+Inf"} 3` + "\n",
+		`kuberhealthy_test_seconds_sum{check="dns"} 13.5` + "\n",
+		`kuberhealthy_test_seconds_count{check="dns"} 3` + "\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(output, e) {
+			t.Fatalf("Expected registered metrics to contain %q but got:\n%s", e, output)
+		}
+	}
+}